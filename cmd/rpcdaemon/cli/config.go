@@ -39,6 +39,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/kv/remotedb"
 	"github.com/ledgerwatch/erigon-lib/kv/remotedbserver"
 	libstate "github.com/ledgerwatch/erigon-lib/state"
+	txpool2 "github.com/ledgerwatch/erigon-lib/txpool"
 	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/cli/httpcfg"
 	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/graphql"
 	"github.com/ledgerwatch/erigon/cmd/rpcdaemon/health"
@@ -287,7 +288,7 @@ func EmbeddedServices(ctx context.Context,
 
 	eth = rpcservices.NewRemoteBackend(directClient, erigonDB, blockReader)
 
-	txPool = direct.NewTxPoolClient(txPoolServer)
+	txPool = txpool2.WithDirectExt(direct.NewTxPoolClient(txPoolServer), txPoolServer)
 	mining = direct.NewMiningClient(miningServer)
 	ff = rpchelper.New(ctx, eth, txPool, mining, func() {}, logger)
 
@@ -472,7 +473,7 @@ func RemoteServices(ctx context.Context, cfg *httpcfg.HttpCfg, logger log.Logger
 
 	mining = txpool.NewMiningClient(txpoolConn)
 	miningService := rpcservices.NewMiningService(mining)
-	txPool = txpool.NewTxpoolClient(txpoolConn)
+	txPool = txpool2.WithExt(txpool.NewTxpoolClient(txpoolConn), txpool2.NewTxpoolExtClient(txpoolConn))
 	txPoolService := rpcservices.NewTxPoolService(txPool)
 
 	if !cfg.WithDatadir {
@@ -38,6 +38,7 @@ import (
 var (
 	sentryAddr     []string // Address of the sentry <host>:<port>
 	traceSenders   []string
+	locals         []string
 	privateApiAddr string
 	txpoolApiAddr  string
 	datadirCli     string // Path to td working dir
@@ -54,12 +55,19 @@ var (
 	accountSlots       uint64
 	blobSlots          uint64
 	totalBlobPoolLimit uint64
+	totalPoolSize      string
+	maxNonceGap        uint64
+	maxAccountTxs      uint64
+	minTip             uint64
+	peerTxsRate        uint64
+	peerBytesRate      string
 	priceBump          uint64
 	blobPriceBump      uint64
 
 	noTxGossip bool
 
 	commitEvery time.Duration
+	lifetime    time.Duration
 )
 
 func init() {
@@ -82,11 +90,19 @@ func init() {
 	rootCmd.PersistentFlags().Uint64Var(&accountSlots, "txpool.accountslots", txpoolcfg.DefaultConfig.AccountSlots, "Minimum number of executable transaction slots guaranteed per account")
 	rootCmd.PersistentFlags().Uint64Var(&blobSlots, "txpool.blobslots", txpoolcfg.DefaultConfig.BlobSlots, "Max allowed total number of blobs (within type-3 txs) per account")
 	rootCmd.PersistentFlags().Uint64Var(&totalBlobPoolLimit, "txpool.totalblobpoollimit", txpoolcfg.DefaultConfig.TotalBlobPoolLimit, "Total limit of number of all blobs in txs within the txpool")
+	rootCmd.PersistentFlags().StringVar(&totalPoolSize, utils.TxPoolTotalSizeFlag.Name, utils.TxPoolTotalSizeFlag.Value, utils.TxPoolTotalSizeFlag.Usage)
+	rootCmd.PersistentFlags().Uint64Var(&maxNonceGap, utils.TxPoolMaxNonceGapFlag.Name, utils.TxPoolMaxNonceGapFlag.Value, utils.TxPoolMaxNonceGapFlag.Usage)
+	rootCmd.PersistentFlags().Uint64Var(&maxAccountTxs, utils.TxPoolMaxAccountTxsFlag.Name, utils.TxPoolMaxAccountTxsFlag.Value, utils.TxPoolMaxAccountTxsFlag.Usage)
+	rootCmd.PersistentFlags().Uint64Var(&minTip, utils.TxPoolMinTipFlag.Name, utils.TxPoolMinTipFlag.Value, utils.TxPoolMinTipFlag.Usage)
+	rootCmd.PersistentFlags().Uint64Var(&peerTxsRate, utils.TxPoolPeerTxsRateFlag.Name, utils.TxPoolPeerTxsRateFlag.Value, utils.TxPoolPeerTxsRateFlag.Usage)
+	rootCmd.PersistentFlags().StringVar(&peerBytesRate, utils.TxPoolPeerBytesRateFlag.Name, utils.TxPoolPeerBytesRateFlag.Value, utils.TxPoolPeerBytesRateFlag.Usage)
 	rootCmd.PersistentFlags().Uint64Var(&priceBump, "txpool.pricebump", txpoolcfg.DefaultConfig.PriceBump, "Price bump percentage to replace an already existing transaction")
 	rootCmd.PersistentFlags().Uint64Var(&blobPriceBump, "txpool.blobpricebump", txpoolcfg.DefaultConfig.BlobPriceBump, "Price bump percentage to replace an existing blob (type-3) transaction")
+	rootCmd.PersistentFlags().DurationVar(&lifetime, utils.TxPoolLifetimeFlag.Name, txpoolcfg.DefaultConfig.Lifetime, utils.TxPoolLifetimeFlag.Usage)
 	rootCmd.PersistentFlags().DurationVar(&commitEvery, utils.TxPoolCommitEveryFlag.Name, utils.TxPoolCommitEveryFlag.Value, utils.TxPoolCommitEveryFlag.Usage)
 	rootCmd.PersistentFlags().BoolVar(&noTxGossip, utils.TxPoolGossipDisableFlag.Name, utils.TxPoolGossipDisableFlag.Value, utils.TxPoolGossipDisableFlag.Usage)
 	rootCmd.Flags().StringSliceVar(&traceSenders, utils.TxPoolTraceSendersFlag.Name, []string{}, utils.TxPoolTraceSendersFlag.Usage)
+	rootCmd.Flags().StringSliceVar(&locals, utils.TxPoolLocalsFlag.Name, []string{}, utils.TxPoolLocalsFlag.Usage)
 }
 
 var rootCmd = &cobra.Command{
@@ -151,9 +167,20 @@ func doTxpool(ctx context.Context, logger log.Logger) error {
 	cfg.AccountSlots = accountSlots
 	cfg.BlobSlots = blobSlots
 	cfg.TotalBlobPoolLimit = totalBlobPoolLimit
+	cfg.MaxNonceGap = maxNonceGap
+	cfg.MaxAccountTxs = maxAccountTxs
+	cfg.MinTip = minTip
+	if err := cfg.TotalPoolSize.UnmarshalText([]byte(totalPoolSize)); err != nil {
+		return fmt.Errorf("invalid --%s: %w", utils.TxPoolTotalSizeFlag.Name, err)
+	}
+	cfg.PeerTxsRate = peerTxsRate
+	if err := cfg.PeerBytesRate.UnmarshalText([]byte(peerBytesRate)); err != nil {
+		return fmt.Errorf("invalid --%s: %w", utils.TxPoolPeerBytesRateFlag.Name, err)
+	}
 	cfg.PriceBump = priceBump
 	cfg.BlobPriceBump = blobPriceBump
 	cfg.NoGossip = noTxGossip
+	cfg.Lifetime = lifetime
 
 	cacheConfig := kvcache.DefaultCoherentConfig
 	cacheConfig.MetricsLabel = "txpool"
@@ -163,6 +190,12 @@ func doTxpool(ctx context.Context, logger log.Logger) error {
 		sender := common.HexToAddress(senderHex)
 		cfg.TracedSenders[i] = string(sender[:])
 	}
+	for _, account := range locals {
+		if !common.IsHexAddress(account) {
+			return fmt.Errorf("invalid account in --%s: %s", utils.TxPoolLocalsFlag.Name, account)
+		}
+		cfg.Locals = append(cfg.Locals, common.HexToAddress(account))
+	}
 
 	newTxs := make(chan types.Announcements, 1024)
 	defer close(newTxs)
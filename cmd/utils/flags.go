@@ -193,6 +193,36 @@ var (
 		Usage: "Total limit of number of all blobs in txs within the txpool",
 		Value: txpoolcfg.DefaultConfig.TotalBlobPoolLimit,
 	}
+	TxPoolTotalSizeFlag = cli.StringFlag{
+		Name:  "txpool.totalsize",
+		Usage: "Total size of all transactions within the txpool, worst non-local transactions are evicted above it. 0 means unlimited",
+		Value: txpoolcfg.DefaultConfig.TotalPoolSize.String(),
+	}
+	TxPoolMaxNonceGapFlag = cli.Uint64Flag{
+		Name:  "txpool.maxnoncegap",
+		Usage: "How far ahead of the account nonce non-local transactions may be, 0 means unlimited",
+		Value: txpoolcfg.DefaultConfig.MaxNonceGap,
+	}
+	TxPoolMaxAccountTxsFlag = cli.Uint64Flag{
+		Name:  "txpool.maxaccounttxs",
+		Usage: "Maximum number of transactions of a non-local account within the txpool, 0 means unlimited",
+		Value: txpoolcfg.DefaultConfig.MaxAccountTxs,
+	}
+	TxPoolMinTipFlag = cli.Uint64Flag{
+		Name:  "txpool.mintip",
+		Usage: "Minimum effective tip (priority fee at the pending base fee, in wei) to enforce for acceptance of remote transactions into the pool",
+		Value: txpoolcfg.DefaultConfig.MinTip,
+	}
+	TxPoolPeerTxsRateFlag = cli.Uint64Flag{
+		Name:  "txpool.peertxsrate",
+		Usage: "Transactions per second accepted from a single peer, peers sending more are dropped. 0 means unlimited",
+		Value: txpoolcfg.DefaultConfig.PeerTxsRate,
+	}
+	TxPoolPeerBytesRateFlag = cli.StringFlag{
+		Name:  "txpool.peerbytesrate",
+		Usage: "Bytes of transactions per second accepted from a single peer, peers sending more are dropped. 0 means unlimited",
+		Value: txpoolcfg.DefaultConfig.PeerBytesRate.String(),
+	}
 	TxPoolGlobalSlotsFlag = cli.Uint64Flag{
 		Name:  "txpool.globalslots",
 		Usage: "Maximum number of executable transaction slots for all accounts",
@@ -215,7 +245,7 @@ var (
 	}
 	TxPoolLifetimeFlag = cli.DurationFlag{
 		Name:  "txpool.lifetime",
-		Usage: "Maximum amount of time non-executable transaction are queued",
+		Usage: "Maximum amount of time non-local transactions stay in the pool, 0 means forever. Restarting the node resets it",
 		Value: ethconfig.Defaults.DeprecatedTxPool.Lifetime,
 	}
 	TxPoolTraceSendersFlag = cli.StringFlag{
@@ -1399,6 +1429,28 @@ func setTxPool(ctx *cli.Context, fullCfg *ethconfig.Config) {
 	if ctx.IsSet(TxPoolTotalBlobPoolLimit.Name) {
 		fullCfg.TxPool.TotalBlobPoolLimit = ctx.Uint64(TxPoolTotalBlobPoolLimit.Name)
 	}
+	if ctx.IsSet(TxPoolMinTipFlag.Name) {
+		fullCfg.TxPool.MinTip = ctx.Uint64(TxPoolMinTipFlag.Name)
+	}
+	if ctx.IsSet(TxPoolMaxNonceGapFlag.Name) {
+		fullCfg.TxPool.MaxNonceGap = ctx.Uint64(TxPoolMaxNonceGapFlag.Name)
+	}
+	if ctx.IsSet(TxPoolMaxAccountTxsFlag.Name) {
+		fullCfg.TxPool.MaxAccountTxs = ctx.Uint64(TxPoolMaxAccountTxsFlag.Name)
+	}
+	if ctx.IsSet(TxPoolTotalSizeFlag.Name) {
+		if err := fullCfg.TxPool.TotalPoolSize.UnmarshalText([]byte(ctx.String(TxPoolTotalSizeFlag.Name))); err != nil {
+			Fatalf("Invalid --%s: %v", TxPoolTotalSizeFlag.Name, err)
+		}
+	}
+	if ctx.IsSet(TxPoolPeerTxsRateFlag.Name) {
+		fullCfg.TxPool.PeerTxsRate = ctx.Uint64(TxPoolPeerTxsRateFlag.Name)
+	}
+	if ctx.IsSet(TxPoolPeerBytesRateFlag.Name) {
+		if err := fullCfg.TxPool.PeerBytesRate.UnmarshalText([]byte(ctx.String(TxPoolPeerBytesRateFlag.Name))); err != nil {
+			Fatalf("Invalid --%s: %v", TxPoolPeerBytesRateFlag.Name, err)
+		}
+	}
 	if ctx.IsSet(TxPoolGlobalSlotsFlag.Name) {
 		cfg.GlobalSlots = ctx.Uint64(TxPoolGlobalSlotsFlag.Name)
 	}
@@ -29,18 +29,32 @@ var (
 	ErrBase   = fmt.Errorf("rlp")
 	ErrParse  = fmt.Errorf("%w parse", ErrBase)
 	ErrDecode = fmt.Errorf("%w decode", ErrBase)
+
+	// ErrNonCanonicalRLP is returned when the length of an element is not encoded minimally,
+	// which would allow the same data to have multiple encodings
+	ErrNonCanonicalRLP = fmt.Errorf("%w: non-canonical size information", ErrParse)
+
+	// The errors below let callers tell a payload that was cut short from one that is malformed.
+	// They are wrapped together with the position at which the problem was found
+	ErrTruncated        = fmt.Errorf("%w: unexpected end of payload", ErrParse)
+	ErrLeadingZero      = fmt.Errorf("%w: integer encoding for RLP must not have leading zeros", ErrNonCanonicalRLP)
+	ErrWrongFieldLength = fmt.Errorf("%w: wrong field length", ErrParse)
+	ErrExpectedList     = fmt.Errorf("%w: must be a list", ErrParse)
+	ErrExpectedString   = fmt.Errorf("%w: must be a string, instead of a list", ErrParse)
 )
 
 func IsRLPError(err error) bool { return errors.Is(err, ErrBase) }
 
 // BeInt parses Big Endian representation of an integer from given payload at given position
-func BeInt(payload []byte, pos, length int) (int, error) {
+func BeInt(payload []byte, pos, length int) (int, error) { return beInt(payload, pos, length, true) }
+
+func beInt(payload []byte, pos, length int, strict bool) (int, error) {
 	var r int
 	if pos+length >= len(payload) {
-		return 0, fmt.Errorf("%w: unexpected end of payload", ErrParse)
+		return 0, fmt.Errorf("%w at %d", ErrTruncated, pos)
 	}
-	if length > 0 && payload[pos] == 0 {
-		return 0, fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: %x", ErrParse, payload[pos:pos+length])
+	if strict && length > 0 && payload[pos] == 0 {
+		return 0, fmt.Errorf("%w at %d: %x", ErrLeadingZero, pos, payload[pos:pos+length])
 	}
 	for _, b := range payload[pos : pos+length] {
 		r = (r << 8) | int(b)
@@ -51,11 +65,22 @@ func BeInt(payload []byte, pos, length int) (int, error) {
 // Prefix parses RLP Prefix from given payload at given position. It returns the offset and length of the RLP element
 // as well as the indication of whether it is a list of string
 func Prefix(payload []byte, pos int) (dataPos int, dataLen int, isList bool, err error) {
+	return prefix(payload, pos, true)
+}
+
+// PrefixLenient is the same as Prefix, but tolerates non-minimal lengths: leading zeros in the length of long
+// strings and lists, the long form used for short elements, and single bytes below 0x80 encoded as strings.
+// It must only be used to decode historical data produced by non-conforming encoders
+func PrefixLenient(payload []byte, pos int) (dataPos int, dataLen int, isList bool, err error) {
+	return prefix(payload, pos, false)
+}
+
+func prefix(payload []byte, pos int, strict bool) (dataPos int, dataLen int, isList bool, err error) {
 	if pos < 0 {
 		return 0, 0, false, fmt.Errorf("%w: negative position not allowed", ErrParse)
 	}
 	if pos >= len(payload) {
-		return 0, 0, false, fmt.Errorf("%w: unexpected end of payload", ErrParse)
+		return 0, 0, false, fmt.Errorf("%w at %d", ErrTruncated, pos)
 	}
 	switch first := payload[pos]; {
 	case first < 128:
@@ -70,8 +95,8 @@ func Prefix(payload []byte, pos int) (dataPos int, dataLen int, isList bool, err
 		dataPos = pos + 1
 		dataLen = int(first) - 128
 		isList = false
-		if dataLen == 1 && dataPos < len(payload) && payload[dataPos] < 128 {
-			err = fmt.Errorf("%w: non-canonical size information", ErrParse)
+		if strict && dataLen == 1 && dataPos < len(payload) && payload[dataPos] < 128 {
+			err = ErrNonCanonicalRLP
 		}
 	case first < 192:
 		// If a string is more than 55 bytes long, the
@@ -82,10 +107,10 @@ func Prefix(payload []byte, pos int) (dataPos int, dataLen int, isList bool, err
 		// the first byte is thus [0xB8, 0xBF].
 		beLen := int(first) - 183
 		dataPos = pos + 1 + beLen
-		dataLen, err = BeInt(payload, pos+1, beLen)
+		dataLen, err = beInt(payload, pos+1, beLen, strict)
 		isList = false
-		if dataLen < 56 {
-			err = fmt.Errorf("%w: non-canonical size information", ErrParse)
+		if strict && err == nil && dataLen < 56 {
+			err = ErrNonCanonicalRLP
 		}
 	case first < 248:
 		// isList of len < 56
@@ -106,15 +131,15 @@ func Prefix(payload []byte, pos int) (dataPos int, dataLen int, isList bool, err
 		// range of the first byte is thus [0xF8, 0xFF].
 		beLen := int(first) - 247
 		dataPos = pos + 1 + beLen
-		dataLen, err = BeInt(payload, pos+1, beLen)
+		dataLen, err = beInt(payload, pos+1, beLen, strict)
 		isList = true
-		if dataLen < 56 {
-			err = fmt.Errorf("%w: : non-canonical size information", ErrParse)
+		if strict && err == nil && dataLen < 56 {
+			err = ErrNonCanonicalRLP
 		}
 	}
 	if err == nil {
 		if dataPos+dataLen > len(payload) {
-			err = fmt.Errorf("%w: unexpected end of payload", ErrParse)
+			err = fmt.Errorf("%w at %d: need %d bytes, have %d", ErrTruncated, pos, dataPos+dataLen-pos, len(payload)-pos)
 		} else if dataPos+dataLen < 0 {
 			err = fmt.Errorf("%w: found too big len", ErrParse)
 		}
@@ -122,24 +147,40 @@ func Prefix(payload []byte, pos int) (dataPos int, dataLen int, isList bool, err
 	return
 }
 
-func List(payload []byte, pos int) (dataPos, dataLen int, err error) {
-	dataPos, dataLen, isList, err := Prefix(payload, pos)
+func List(payload []byte, pos int) (dataPos, dataLen int, err error) { return list(payload, pos, true) }
+
+// ListLenient is the same as List, but tolerates leading zeros in the length, see PrefixLenient
+func ListLenient(payload []byte, pos int) (dataPos, dataLen int, err error) {
+	return list(payload, pos, false)
+}
+
+func list(payload []byte, pos int, strict bool) (dataPos, dataLen int, err error) {
+	dataPos, dataLen, isList, err := prefix(payload, pos, strict)
 	if err != nil {
 		return 0, 0, err
 	}
 	if !isList {
-		return 0, 0, fmt.Errorf("%w: must be a list", ErrParse)
+		return 0, 0, fmt.Errorf("%w at %d", ErrExpectedList, pos)
 	}
 	return
 }
 
 func String(payload []byte, pos int) (dataPos, dataLen int, err error) {
-	dataPos, dataLen, isList, err := Prefix(payload, pos)
+	return str(payload, pos, true)
+}
+
+// StringLenient is the same as String, but tolerates leading zeros in the length, see PrefixLenient
+func StringLenient(payload []byte, pos int) (dataPos, dataLen int, err error) {
+	return str(payload, pos, false)
+}
+
+func str(payload []byte, pos int, strict bool) (dataPos, dataLen int, err error) {
+	dataPos, dataLen, isList, err := prefix(payload, pos, strict)
 	if err != nil {
 		return 0, 0, err
 	}
 	if isList {
-		return 0, 0, fmt.Errorf("%w: must be a string, instead of a list", ErrParse)
+		return 0, 0, fmt.Errorf("%w at %d", ErrExpectedString, pos)
 	}
 	return
 }
@@ -149,31 +190,43 @@ func StringOfLen(payload []byte, pos, expectedLen int) (dataPos int, err error)
 		return 0, err
 	}
 	if dataLen != expectedLen {
-		return 0, fmt.Errorf("%w: expected string of len %d, got %d", ErrParse, expectedLen, dataLen)
+		return 0, fmt.Errorf("%w at %d: expected string of len %d, got %d", ErrWrongFieldLength, pos, expectedLen, dataLen)
 	}
 	return
 }
 
 // U64 parses uint64 number from given payload at given position
-func U64(payload []byte, pos int) (int, uint64, error) {
-	dataPos, dataLen, isList, err := Prefix(payload, pos)
+func U64(payload []byte, pos int) (int, uint64, error) { return u64(payload, pos, true) }
+
+// U64Lenient is the same as U64, but tolerates leading zeros in the integer encoding and its length, see PrefixLenient
+func U64Lenient(payload []byte, pos int) (int, uint64, error) { return u64(payload, pos, false) }
+
+func u64(payload []byte, pos int, strict bool) (int, uint64, error) {
+	dataPos, dataLen, isList, err := prefix(payload, pos, strict)
 	if err != nil {
 		return 0, 0, err
 	}
 	if isList {
-		return 0, 0, fmt.Errorf("%w: uint64 must be a string, not isList", ErrParse)
+		return 0, 0, fmt.Errorf("%w at %d: uint64", ErrExpectedString, pos)
+	}
+	end := dataPos + dataLen
+	if !strict {
+		for dataLen > 0 && payload[dataPos] == 0 {
+			dataPos++
+			dataLen--
+		}
 	}
 	if dataLen > 8 {
-		return 0, 0, fmt.Errorf("%w: uint64 must not be more than 8 bytes long, got %d", ErrParse, dataLen)
+		return 0, 0, fmt.Errorf("%w at %d: uint64 must not be more than 8 bytes long, got %d", ErrWrongFieldLength, pos, dataLen)
 	}
 	if dataLen > 0 && payload[dataPos] == 0 {
-		return 0, 0, fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: %x", ErrParse, payload[dataPos:dataPos+dataLen])
+		return 0, 0, fmt.Errorf("%w at %d: %x", ErrLeadingZero, pos, payload[dataPos:dataPos+dataLen])
 	}
 	var r uint64
 	for _, b := range payload[dataPos : dataPos+dataLen] {
 		r = (r << 8) | uint64(b)
 	}
-	return dataPos + dataLen, r, nil
+	return end, r, nil
 }
 
 // U32 parses uint64 number from given payload at given position
@@ -183,13 +236,13 @@ func U32(payload []byte, pos int) (int, uint32, error) {
 		return 0, 0, err
 	}
 	if isList {
-		return 0, 0, fmt.Errorf("%w: uint32 must be a string, not isList", ErrParse)
+		return 0, 0, fmt.Errorf("%w at %d: uint32", ErrExpectedString, pos)
 	}
 	if dataLen > 4 {
-		return 0, 0, fmt.Errorf("%w: uint32 must not be more than 4 bytes long, got %d", ErrParse, dataLen)
+		return 0, 0, fmt.Errorf("%w at %d: uint32 must not be more than 4 bytes long, got %d", ErrWrongFieldLength, pos, dataLen)
 	}
 	if dataLen > 0 && payload[dataPos] == 0 {
-		return 0, 0, fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: %x", ErrParse, payload[dataPos:dataPos+dataLen])
+		return 0, 0, fmt.Errorf("%w at %d: %x", ErrLeadingZero, pos, payload[dataPos:dataPos+dataLen])
 	}
 	var r uint32
 	for _, b := range payload[dataPos : dataPos+dataLen] {
@@ -199,19 +252,58 @@ func U32(payload []byte, pos int) (int, uint32, error) {
 }
 
 // U256 parses uint256 number from given payload at given position
-func U256(payload []byte, pos int, x *uint256.Int) (int, error) {
-	dataPos, dataLen, err := String(payload, pos)
+func U256(payload []byte, pos int, x *uint256.Int) (int, error) { return u256(payload, pos, x, true) }
+
+// U256Lenient is the same as U256, but tolerates leading zeros in the integer encoding and its length, see PrefixLenient
+func U256Lenient(payload []byte, pos int, x *uint256.Int) (int, error) {
+	return u256(payload, pos, x, false)
+}
+
+func u256(payload []byte, pos int, x *uint256.Int, strict bool) (int, error) {
+	dataPos, dataLen, err := str(payload, pos, strict)
 	if err != nil {
 		return 0, err
 	}
+	end := dataPos + dataLen
+	if !strict {
+		for dataLen > 0 && payload[dataPos] == 0 {
+			dataPos++
+			dataLen--
+		}
+	}
 	if dataLen > 32 {
-		return 0, fmt.Errorf("%w: uint256 must not be more than 32 bytes long, got %d", ErrParse, dataLen)
+		return 0, fmt.Errorf("%w at %d: uint256 must not be more than 32 bytes long, got %d", ErrWrongFieldLength, pos, dataLen)
 	}
 	if dataLen > 0 && payload[dataPos] == 0 {
-		return 0, fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: %x", ErrParse, payload[dataPos:dataPos+dataLen])
+		return 0, fmt.Errorf("%w at %d: %x", ErrLeadingZero, pos, payload[dataPos:dataPos+dataLen])
 	}
 	x.SetBytes(payload[dataPos : dataPos+dataLen])
-	return dataPos + dataLen, nil
+	return end, nil
+}
+
+// Iterate calls f for every element of the list at given position, with the position of the element
+// and the position and length of its content, as returned by Prefix. It stops at the first error returned by f.
+// It returns the position right after the list
+func Iterate(payload []byte, pos int, f func(elemPos, dataPos, dataLen int, isList bool) error) (int, error) {
+	p, listLen, err := List(payload, pos)
+	if err != nil {
+		return 0, err
+	}
+	end := p + listLen
+	for p < end {
+		dataPos, dataLen, isList, err := Prefix(payload, p)
+		if err != nil {
+			return 0, err
+		}
+		if dataPos+dataLen > end {
+			return 0, fmt.Errorf("%w at %d: element exceeds the list", ErrParse, p)
+		}
+		if err = f(p, dataPos, dataLen, isList); err != nil {
+			return 0, err
+		}
+		p = dataPos + dataLen
+	}
+	return end, nil
 }
 
 func U256Len(z *uint256.Int) int {
@@ -241,6 +333,8 @@ const ParseHashErrorPrefix = "parse hash payload"
 
 const ParseAnnouncementsErrorPrefix = "parse announcement payload"
 
+// ParseAnnouncements parses the types, sizes and hashes of an eth/68 NewPooledTransactionHashes message. Lists of
+// different lengths are rejected with an ErrParse error, so that len(types) == len(sizes) == len(hashes)/32
 func ParseAnnouncements(payload []byte, pos int) ([]byte, []uint32, []byte, int, error) {
 	pos, totalLen, err := List(payload, pos)
 	if err != nil {
@@ -265,11 +359,17 @@ func ParseAnnouncements(payload []byte, pos int) ([]byte, []uint32, []byte, int,
 	if pos+sizesLen > len(payload) {
 		return nil, nil, nil, pos, fmt.Errorf("%s: sizesLen %d is beyond the end of payload", ParseAnnouncementsErrorPrefix, sizesLen)
 	}
-	sizes := make([]uint32, typesLen)
-	for i := 0; i < len(sizes); i++ {
-		if pos, sizes[i], err = U32(payload, pos); err != nil {
+	sizesEnd := pos + sizesLen
+	sizes := make([]uint32, 0, typesLen)
+	for pos < sizesEnd {
+		var size uint32
+		if pos, size, err = U32(payload, pos); err != nil {
 			return nil, nil, nil, pos, err
 		}
+		sizes = append(sizes, size)
+	}
+	if pos != sizesEnd || len(sizes) != len(types) {
+		return nil, nil, nil, pos, fmt.Errorf("%w: %s: %d sizes for %d types", ErrParse, ParseAnnouncementsErrorPrefix, len(sizes), len(types))
 	}
 	pos, hashesLen, err := List(payload, pos)
 	if err != nil {
@@ -278,6 +378,9 @@ func ParseAnnouncements(payload []byte, pos int) ([]byte, []uint32, []byte, int,
 	if pos+hashesLen > len(payload) {
 		return nil, nil, nil, pos, fmt.Errorf("%s: hashesLen %d is beyond the end of payload", ParseAnnouncementsErrorPrefix, hashesLen)
 	}
+	if hashesLen%33 != 0 || hashesLen/33 != len(types) {
+		return nil, nil, nil, pos, fmt.Errorf("%w: %s: hashesLen %d for %d types", ErrParse, ParseAnnouncementsErrorPrefix, hashesLen, len(types))
+	}
 	hashes := make([]byte, 32*(hashesLen/33))
 	for i := 0; i < len(hashes); i += 32 {
 		if pos, err = ParseHash(payload, pos, hashes[i:]); err != nil {
@@ -0,0 +1,80 @@
+//go:build !nofuzz
+
+package rlp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+)
+
+func FuzzPrefix(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(hexutility.MustDecodeHex("c80783646f67c0c101"))
+	f.Add(hexutility.MustDecodeHex("b90038"))
+	f.Add(hexutility.MustDecodeHex("f90000"))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		t.Parallel()
+		for _, parse := range []func([]byte, int) (int, int, bool, error){Prefix, PrefixLenient} {
+			dataPos, dataLen, _, err := parse(in, 0)
+			if err != nil {
+				continue
+			}
+			if dataPos < 1 && (len(in) == 0 || in[0] >= 0x80) || dataLen < 0 || dataPos+dataLen > len(in) {
+				t.Fatalf("element out of bounds: dataPos=%d, dataLen=%d, len=%d", dataPos, dataLen, len(in))
+			}
+		}
+	})
+}
+
+func FuzzIterate(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(hexutility.MustDecodeHex("c80783646f67c0c101"))
+	f.Add(hexutility.MustDecodeHex("c3078201"))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		t.Parallel()
+		next := -1
+		end, err := Iterate(in, 0, func(elemPos, dataPos, dataLen int, isList bool) error {
+			if next >= 0 && elemPos != next {
+				t.Fatalf("gap before element at %d, expected %d", elemPos, next)
+			}
+			next = dataPos + dataLen
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		if next >= 0 && next != end {
+			t.Fatalf("elements end at %d, list ends at %d", next, end)
+		}
+	})
+}
+
+// Whatever is parsed as integer must be encoded back into the same bytes
+func FuzzIntegers(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(hexutility.MustDecodeHex("80"))
+	f.Add(hexutility.MustDecodeHex("7f"))
+	f.Add(hexutility.MustDecodeHex("8180"))
+	f.Add(hexutility.MustDecodeHex("88ffffffffffffffff"))
+	f.Fuzz(func(t *testing.T, in []byte) {
+		t.Parallel()
+		if end, x, err := U64(in, 0); err == nil {
+			var buf [9]byte
+			if n := EncodeU64(x, buf[:]); !bytes.Equal(buf[:n], in[:end]) {
+				t.Fatalf("U64: %x re-encoded as %x", in[:end], buf[:n])
+			}
+		}
+		var x uint256.Int
+		if end, err := U256(in, 0, &x); err == nil {
+			b := x.Bytes()
+			buf := make([]byte, StringLen(b)+9)
+			if n := EncodeString(b, buf); !bytes.Equal(buf[:n], in[:end]) {
+				t.Fatalf("U256: %x re-encoded as %x", in[:end], buf[:n])
+			}
+		}
+	})
+}
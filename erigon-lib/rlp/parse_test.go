@@ -2,6 +2,7 @@ package rlp
 
 import (
 	"fmt"
+	"math"
 	"testing"
 
 	"github.com/holiman/uint256"
@@ -20,9 +21,9 @@ var parseU64Tests = []struct {
 	{payload: hexutility.MustDecodeHex("07"), expectPos: 1, expectRes: 7},
 	{payload: hexutility.MustDecodeHex("8107"), expectErr: fmt.Errorf("%w: non-canonical size information", ErrParse)},
 	{payload: hexutility.MustDecodeHex("B8020004"), expectErr: fmt.Errorf("%w: non-canonical size information", ErrParse)},
-	{payload: hexutility.MustDecodeHex("C0"), expectErr: fmt.Errorf("%w: uint64 must be a string, not isList", ErrParse)},
-	{payload: hexutility.MustDecodeHex("00"), expectErr: fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: 00", ErrParse)},
-	{payload: hexutility.MustDecodeHex("8AFFFFFFFFFFFFFFFFFF7C"), expectErr: fmt.Errorf("%w: uint64 must not be more than 8 bytes long, got 10", ErrParse)},
+	{payload: hexutility.MustDecodeHex("C0"), expectErr: fmt.Errorf("%w at %d: uint64", ErrExpectedString, 0)},
+	{payload: hexutility.MustDecodeHex("00"), expectErr: fmt.Errorf("%w at %d: %x", ErrLeadingZero, 0, []byte{0})},
+	{payload: hexutility.MustDecodeHex("8AFFFFFFFFFFFFFFFFFF7C"), expectErr: fmt.Errorf("%w at %d: uint64 must not be more than 8 bytes long, got %d", ErrWrongFieldLength, 0, 10)},
 }
 
 var parseU32Tests = []struct {
@@ -35,9 +36,9 @@ var parseU32Tests = []struct {
 	{payload: hexutility.MustDecodeHex("07"), expectPos: 1, expectRes: 7},
 	{payload: hexutility.MustDecodeHex("8107"), expectErr: fmt.Errorf("%w: non-canonical size information", ErrParse)},
 	{payload: hexutility.MustDecodeHex("B8020004"), expectErr: fmt.Errorf("%w: non-canonical size information", ErrParse)},
-	{payload: hexutility.MustDecodeHex("C0"), expectErr: fmt.Errorf("%w: uint32 must be a string, not isList", ErrParse)},
-	{payload: hexutility.MustDecodeHex("00"), expectErr: fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: 00", ErrParse)},
-	{payload: hexutility.MustDecodeHex("85FF6738FF7C"), expectErr: fmt.Errorf("%w: uint32 must not be more than 4 bytes long, got 5", ErrParse)},
+	{payload: hexutility.MustDecodeHex("C0"), expectErr: fmt.Errorf("%w at %d: uint32", ErrExpectedString, 0)},
+	{payload: hexutility.MustDecodeHex("00"), expectErr: fmt.Errorf("%w at %d: %x", ErrLeadingZero, 0, []byte{0})},
+	{payload: hexutility.MustDecodeHex("85FF6738FF7C"), expectErr: fmt.Errorf("%w at %d: uint32 must not be more than 4 bytes long, got %d", ErrWrongFieldLength, 0, 5)},
 }
 
 var parseU256Tests = []struct {
@@ -46,16 +47,16 @@ var parseU256Tests = []struct {
 	payload   []byte
 	expectPos int
 }{
-	{payload: hexutility.MustDecodeHex("8BFFFFFFFFFFFFFFFFFF7C"), expectErr: fmt.Errorf("%w: unexpected end of payload", ErrParse)},
+	{payload: hexutility.MustDecodeHex("8BFFFFFFFFFFFFFFFFFF7C"), expectErr: fmt.Errorf("%w at %d: need %d bytes, have %d", ErrTruncated, 0, 12, 11)},
 	{payload: hexutility.MustDecodeHex("8AFFFFFFFFFFFFFFFFFF7C"), expectPos: 11, expectRes: new(uint256.Int).SetBytes(hexutility.MustDecodeHex("FFFFFFFFFFFFFFFFFF7C"))},
 	{payload: hexutility.MustDecodeHex("85CE05050505"), expectPos: 6, expectRes: new(uint256.Int).SetUint64(0xCE05050505)},
 	{payload: hexutility.MustDecodeHex("820400"), expectPos: 3, expectRes: new(uint256.Int).SetUint64(1024)},
 	{payload: hexutility.MustDecodeHex("07"), expectPos: 1, expectRes: new(uint256.Int).SetUint64(7)},
 	{payload: hexutility.MustDecodeHex("8107"), expectErr: fmt.Errorf("%w: non-canonical size information", ErrParse)},
 	{payload: hexutility.MustDecodeHex("B8020004"), expectErr: fmt.Errorf("%w: non-canonical size information", ErrParse)},
-	{payload: hexutility.MustDecodeHex("C0"), expectErr: fmt.Errorf("%w: must be a string, instead of a list", ErrParse)},
-	{payload: hexutility.MustDecodeHex("00"), expectErr: fmt.Errorf("%w: integer encoding for RLP must not have leading zeros: 00", ErrParse)},
-	{payload: hexutility.MustDecodeHex("A101000000000000000000000000000000000000008B000000000000000000000000"), expectErr: fmt.Errorf("%w: uint256 must not be more than 32 bytes long, got 33", ErrParse)},
+	{payload: hexutility.MustDecodeHex("C0"), expectErr: fmt.Errorf("%w at %d", ErrExpectedString, 0)},
+	{payload: hexutility.MustDecodeHex("00"), expectErr: fmt.Errorf("%w at %d: %x", ErrLeadingZero, 0, []byte{0})},
+	{payload: hexutility.MustDecodeHex("A101000000000000000000000000000000000000008B000000000000000000000000"), expectErr: fmt.Errorf("%w at %d: uint256 must not be more than 32 bytes long, got %d", ErrWrongFieldLength, 0, 33)},
 }
 
 func TestPrimitives(t *testing.T) {
@@ -90,3 +91,133 @@ func TestPrimitives(t *testing.T) {
 		})
 	}
 }
+
+func TestNonCanonicalPrefix(t *testing.T) {
+	for i, payload := range []string{
+		"8107",                            // single byte < 0x80 wrapped into a string
+		"b80107",                          // short string using the long form
+		"b90037" + "00",                   // long form length with a leading zero
+		"f80107",                          // short list using the long form
+		"f9000401020304",                  // long list length with a leading zero
+		"b837" + fmt.Sprintf("%0110x", 0), // 55 bytes string using the long form
+	} {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			_, _, _, err := Prefix(hexutility.MustDecodeHex(payload), 0)
+			assert.ErrorIs(t, err, ErrNonCanonicalRLP)
+			assert.ErrorIs(t, err, ErrParse)
+		})
+	}
+	_, dataLen, _, err := Prefix(hexutility.MustDecodeHex("b838"+fmt.Sprintf("%0112x", 0)), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 56, dataLen)
+}
+
+func TestLenient(t *testing.T) {
+	payload := hexutility.MustDecodeHex("820001")
+	_, _, err := U64(payload, 0)
+	assert.ErrorIs(t, err, ErrParse)
+	p, x, err := U64Lenient(payload, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, p)
+	assert.Equal(t, uint64(1), x)
+
+	payload = hexutility.MustDecodeHex("8900" + "ffffffffffffffff")
+	p, x, err = U64Lenient(payload, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, p)
+	assert.Equal(t, uint64(math.MaxUint64), x)
+
+	var u uint256.Int
+	payload = hexutility.MustDecodeHex("a100" + fmt.Sprintf("%064x", 1))
+	_, err = U256(payload, 0, &u)
+	assert.ErrorIs(t, err, ErrParse)
+	p, err = U256Lenient(payload, 0, &u)
+	assert.NoError(t, err)
+	assert.Equal(t, 34, p)
+	assert.Equal(t, uint64(1), u.Uint64())
+
+	_, dataLen, _, err := PrefixLenient(hexutility.MustDecodeHex("b90038"+fmt.Sprintf("%0112x", 0)), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 56, dataLen)
+	_, _, _, err = Prefix(hexutility.MustDecodeHex("b837"+fmt.Sprintf("%0110x", 0)), 0)
+	assert.ErrorIs(t, err, ErrNonCanonicalRLP)
+	dataPos, dataLen, _, err := PrefixLenient(hexutility.MustDecodeHex("b90037"+fmt.Sprintf("%0110x", 0)), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, dataPos)
+	assert.Equal(t, 55, dataLen)
+	p, x, err = U64Lenient(hexutility.MustDecodeHex("8107"), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, p)
+	assert.Equal(t, uint64(7), x)
+
+	// lengths pointing beyond the payload are never tolerated
+	_, _, _, err = PrefixLenient(hexutility.MustDecodeHex("b90038"), 0)
+	assert.ErrorIs(t, err, ErrTruncated)
+}
+
+func TestErrorKinds(t *testing.T) {
+	_, err := StringOfLen(hexutility.MustDecodeHex("83010203"), 0, 2)
+	assert.ErrorIs(t, err, ErrWrongFieldLength)
+	_, err = StringOfLen(hexutility.MustDecodeHex("830102"), 0, 3)
+	assert.ErrorIs(t, err, ErrTruncated)
+	_, _, err = List(hexutility.MustDecodeHex("8100"), 0)
+	assert.ErrorIs(t, err, ErrNonCanonicalRLP)
+	assert.NotErrorIs(t, err, ErrLeadingZero)
+	_, _, err = List(hexutility.MustDecodeHex("820102"), 0)
+	assert.ErrorIs(t, err, ErrExpectedList)
+	_, _, err = U64(hexutility.MustDecodeHex("820001"), 0)
+	assert.ErrorIs(t, err, ErrLeadingZero)
+	assert.ErrorIs(t, err, ErrNonCanonicalRLP)
+	assert.Contains(t, err.Error(), "at 0")
+}
+
+func TestIterate(t *testing.T) {
+	// [0x07, "dog", [], [0x01]] followed by some garbage
+	payload := hexutility.MustDecodeHex("c80783646f67c0c101" + "ff")
+	var elems []int
+	var lists []bool
+	end, err := Iterate(payload, 0, func(elemPos, dataPos, dataLen int, isList bool) error {
+		elems = append(elems, elemPos)
+		lists = append(lists, isList)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, end)
+	assert.Equal(t, []int{1, 2, 6, 7}, elems)
+	assert.Equal(t, []bool{false, false, true, true}, lists)
+
+	stop := fmt.Errorf("stop")
+	_, err = Iterate(payload, 0, func(elemPos, dataPos, dataLen int, isList bool) error { return stop })
+	assert.ErrorIs(t, err, stop)
+
+	// the last element claims 2 bytes, but only 1 is left in the list
+	_, err = Iterate(hexutility.MustDecodeHex("c3078201"+"ff"), 0, func(elemPos, dataPos, dataLen int, isList bool) error { return nil })
+	assert.ErrorIs(t, err, ErrParse)
+	_, err = Iterate(hexutility.MustDecodeHex("83010203"), 0, func(elemPos, dataPos, dataLen int, isList bool) error { return nil })
+	assert.ErrorIs(t, err, ErrExpectedList)
+}
+
+func TestParseAnnouncements(t *testing.T) {
+	txTypes, sizes, hashes := []byte{0, 2}, []uint32{100, 200}, make([]byte, 64)
+	hashes[0], hashes[32] = 1, 2
+	encode := func(txTypes []byte, sizes []uint32, hashes []byte) []byte {
+		buf := make([]byte, AnnouncementsLen(txTypes, sizes, hashes))
+		EncodeAnnouncements(txTypes, sizes, hashes, buf)
+		return buf
+	}
+	gotTypes, gotSizes, gotHashes, _, err := ParseAnnouncements(encode(txTypes, sizes, hashes), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, txTypes, gotTypes)
+	assert.Equal(t, sizes, gotSizes)
+	assert.Equal(t, hashes, gotHashes)
+
+	for name, payload := range map[string][]byte{
+		"fewer sizes":  encode(txTypes, sizes[:1], hashes),
+		"more sizes":   encode(txTypes[:1], sizes, hashes[:32]),
+		"fewer hashes": encode(txTypes, sizes, hashes[:32]),
+		"more hashes":  encode(txTypes[:1], sizes[:1], hashes),
+	} {
+		_, _, _, _, err = ParseAnnouncements(payload, 0)
+		assert.ErrorIs(t, err, ErrParse, name)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"fmt"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// AdmissionState is what the pool knows about the sender of a tx being admitted
+type AdmissionState struct {
+	Sender  common.Address
+	Nonce   uint64      // of the sender's account
+	Balance uint256.Int // of the sender's account
+	IsLocal bool
+}
+
+// AdmissionFilter implements a custom admission policy of the pool. It returns txpoolcfg.Success to admit the tx, or
+// the reason to reject it, txpoolcfg.Filtered unless a more specific one applies. Filters are called under the pool
+// lock, and must be fast
+type AdmissionFilter func(txn *types.TxSlot, state *AdmissionState) txpoolcfg.DiscardReason
+
+// AddAdmissionFilters appends the filters to the chain of the pool. The chain is evaluated in order, after the sender
+// is recovered and the tx passed the checks of the pool, before it is inserted. The first rejection wins. Txs already
+// in the pool are only filtered when validated again, e.g. when their block is unwound
+func (p *TxPool) AddAdmissionFilters(filters ...AdmissionFilter) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.admissionFilters = append(p.admissionFilters, filters...)
+}
+
+func (p *TxPool) admitLocked(txn *types.TxSlot, isLocal bool, senderNonce uint64, senderBalance uint256.Int) txpoolcfg.DiscardReason {
+	if len(p.admissionFilters) == 0 {
+		return txpoolcfg.Success
+	}
+	state := &AdmissionState{Sender: p.senders.senderID2Addr[txn.SenderID], Nonce: senderNonce, Balance: senderBalance, IsLocal: isLocal}
+	for _, filter := range p.admissionFilters {
+		if reason := filter(txn, state); reason != txpoolcfg.Success {
+			if txn.Traced {
+				p.logger.Info(fmt.Sprintf("TX TRACING: validateTx rejected by admission filter idHash=%x reason=%s", txn.IDHash, reason))
+			}
+			return reason
+		}
+	}
+	return txpoolcfg.Success
+}
+
+// BlockRecipients rejects the txs calling, or transferring to, the addresses, e.g. the sanctioned ones
+func BlockRecipients(addrs ...common.Address) AdmissionFilter {
+	blocked := make(map[common.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		blocked[addr] = struct{}{}
+	}
+	return func(txn *types.TxSlot, _ *AdmissionState) txpoolcfg.DiscardReason {
+		if _, ok := blocked[txn.To()]; ok && !txn.Creation {
+			return txpoolcfg.Filtered
+		}
+		return txpoolcfg.Success
+	}
+}
+
+// BlockSelectors rejects the message calls of the methods with the 4-byte selectors
+func BlockSelectors(selectors ...[4]byte) AdmissionFilter {
+	blocked := make(map[[4]byte]struct{}, len(selectors))
+	for _, selector := range selectors {
+		blocked[selector] = struct{}{}
+	}
+	return func(txn *types.TxSlot, _ *AdmissionState) txpoolcfg.DiscardReason {
+		if _, ok := blocked[txn.DataPrefix]; ok && txn.DataLen >= 4 && !txn.Creation {
+			return txpoolcfg.Filtered
+		}
+		return txpoolcfg.Success
+	}
+}
+
+// AllowSenders only admits the txs of the senders, local ones included
+func AllowSenders(addrs ...common.Address) AdmissionFilter {
+	allowed := make(map[common.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		allowed[addr] = struct{}{}
+	}
+	return func(_ *types.TxSlot, state *AdmissionState) txpoolcfg.DiscardReason {
+		if _, ok := allowed[state.Sender]; !ok {
+			return txpoolcfg.Filtered
+		}
+		return txpoolcfg.Success
+	}
+}
@@ -0,0 +1,152 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"fmt"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// maxDelegatedTxs is the number of non-local txs an account with an EIP-7702 delegation, pending in the pool or just
+// landed on-chain, may have in the pool. The delegated code can move the balance and bump the nonce of the account at
+// any time, invalidating its txs, so that only the next one is worth keeping
+const maxDelegatedTxs = 1
+
+// authorities indexes the EIP-7702 authorizations of the set code txs in the pool. Not goroutine-safe, guarded by
+// the pool lock
+type authorities struct {
+	byAuth      map[types.Authority]*metaTx // authority and nonce => the tx carrying the authorization
+	delegations map[common.Address]int      // authority => number of its authorizations in the pool
+}
+
+func newAuthorities() *authorities {
+	return &authorities{byAuth: map[types.Authority]*metaTx{}, delegations: map[common.Address]int{}}
+}
+
+func (a *authorities) add(mt *metaTx) {
+	for _, auth := range mt.Tx.Authorities {
+		if a.byAuth[auth] == mt {
+			continue // the same authorization twice in the tx
+		}
+		a.byAuth[auth] = mt
+		a.delegations[auth.Address]++
+	}
+}
+
+func (a *authorities) remove(mt *metaTx) {
+	for _, auth := range mt.Tx.Authorities {
+		if a.byAuth[auth] != mt {
+			continue
+		}
+		delete(a.byAuth, auth)
+		if a.delegations[auth.Address] > 1 {
+			a.delegations[auth.Address]--
+		} else {
+			delete(a.delegations, auth.Address)
+		}
+	}
+}
+
+// conflict reports whether an authorization of txn is carried by another tx in the pool, other than replaced
+func (a *authorities) conflict(txn *types.TxSlot, replaced *metaTx) bool {
+	for _, auth := range txn.Authorities {
+		if mt, ok := a.byAuth[auth]; ok && mt != replaced {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAuthoritiesLocked applies the pool rules of EIP-7702: an authorization of an authority and nonce can only be
+// in one tx, and accounts with a pending delegation can only have maxDelegatedTxs (non-local) txs in the pool, which
+// also holds for the authorities of the new set code txs
+func (p *TxPool) validateAuthoritiesLocked(txn *types.TxSlot, isLocal bool) txpoolcfg.DiscardReason {
+	replaced := p.all.get(txn.SenderID, txn.Nonce)
+	if p.auths.conflict(txn, replaced) {
+		if txn.Traced {
+			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx authorization conflict idHash=%x", txn.IDHash))
+		}
+		return txpoolcfg.AuthConflict
+	}
+	if isLocal {
+		return txpoolcfg.Success
+	}
+	sender := p.senders.senderID2Addr[txn.SenderID]
+	if replaced == nil && p.auths.delegations[sender] > 0 && p.all.count(txn.SenderID) >= maxDelegatedTxs {
+		if txn.Traced {
+			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx delegated sender idHash=%x txs=%d", txn.IDHash, p.all.count(txn.SenderID)))
+		}
+		return txpoolcfg.DelegatedTxLimit
+	}
+	for _, auth := range txn.Authorities {
+		if auth.Address == sender {
+			continue
+		}
+		if id, ok := p.senders.getID(auth.Address); ok && p.all.count(id) > maxDelegatedTxs {
+			if txn.Traced {
+				p.logger.Info(fmt.Sprintf("TX TRACING: validateTx authority has too many txs idHash=%x authority=%x", txn.IDHash, auth.Address))
+			}
+			return txpoolcfg.DelegatedTxLimit
+		}
+	}
+	return txpoolcfg.Success
+}
+
+// evictDelegatedLocked discards the non-local txs of the authorities of mined set code txs, above the first
+// maxDelegatedTxs of each: the delegations landed on-chain
+func (p *TxPool) evictDelegatedLocked(minedTxs []*types.TxSlot) {
+	var toDiscard []*metaTx
+	for _, txn := range minedTxs {
+		for _, auth := range txn.Authorities {
+			id, ok := p.senders.getID(auth.Address)
+			if !ok {
+				continue
+			}
+			kept := 0
+			p.all.ascend(id, func(mt *metaTx) bool {
+				if mt.subPool&IsLocal != 0 {
+					return true
+				}
+				if kept < maxDelegatedTxs {
+					kept++
+					return true
+				}
+				toDiscard = append(toDiscard, mt)
+				return true
+			})
+		}
+	}
+	for _, mt := range toDiscard {
+		if p.byHash[string(mt.Tx.IDHash[:])] != mt {
+			continue // authority of several mined txs
+		}
+		switch mt.currentSubPool {
+		case PendingSubPool:
+			p.pending.Remove(mt, "evictDelegated", p.logger)
+		case BaseFeeSubPool:
+			p.baseFee.Remove(mt, "evictDelegated", p.logger)
+		case QueuedSubPool:
+			p.queued.Remove(mt, "evictDelegated", p.logger)
+		default:
+			//already removed
+		}
+		p.discardLocked(mt, txpoolcfg.DelegatedTxLimit)
+	}
+}
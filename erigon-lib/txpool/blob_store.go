@@ -0,0 +1,146 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"fmt"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// hotBlobs is the number of blobs (128KB each) kept in RAM for GetBlobsByHash
+const hotBlobs = 256
+
+// BlobAndProof is a blob of a pooled transaction with its KZG proof, as returned by engine_getBlobsV1
+type BlobAndProof struct {
+	Blob  []byte
+	Proof gokzg4844.KZGProof
+}
+
+type blobRef struct {
+	txHash [32]byte
+	index  int // of the blob in the transaction
+}
+
+// blobStore indexes the blobs of the pooled transactions by versioned hash. Blob data is not kept in RAM: once the
+// transaction is flushed, its blobs are read back from its network form in kv.PoolTransaction, and the most recently
+// requested ones are cached. Not goroutine-safe, guarded by the pool lock
+type blobStore struct {
+	refs map[common.Hash]blobRef
+	hot  *simplelru.LRU[common.Hash, *BlobAndProof]
+}
+
+func newBlobStore(size int) *blobStore {
+	hot, err := simplelru.NewLRU[common.Hash, *BlobAndProof](size, nil)
+	if err != nil {
+		panic(err)
+	}
+	return &blobStore{refs: map[common.Hash]blobRef{}, hot: hot}
+}
+
+func (s *blobStore) add(txn *types.TxSlot) {
+	for i, h := range txn.BlobHashes {
+		s.refs[h] = blobRef{txHash: txn.IDHash, index: i}
+	}
+}
+
+// remove forgets the blobs of txn, unless another transaction (e.g. its replacement) carries them
+func (s *blobStore) remove(txn *types.TxSlot) {
+	for _, h := range txn.BlobHashes {
+		if ref, ok := s.refs[h]; ok && ref.txHash == txn.IDHash {
+			delete(s.refs, h)
+			s.hot.Remove(h)
+		}
+	}
+}
+
+// GetBlobsByHash returns the blobs, and their proofs, with the given versioned hashes, for engine_getBlobsV1.
+// The result has an entry for each hash, nil when no pooled transaction carries the blob. The pool lock is held to
+// look the blobs up only, the transactions are read from the db and parsed without it
+func (p *TxPool) GetBlobsByHash(tx kv.Tx, blobHashes []common.Hash) ([]*BlobAndProof, error) {
+	res := make([]*BlobAndProof, len(blobHashes))
+	refs := make([]blobRef, len(blobHashes))
+	rlps := map[[32]byte][]byte{} // of the transactions carrying the missing blobs, nil when flushed
+	p.lock.Lock()
+	for i, h := range blobHashes {
+		if blob, ok := p.blobs.hot.Get(h); ok {
+			res[i] = blob
+			continue
+		}
+		ref, ok := p.blobs.refs[h]
+		if !ok {
+			continue
+		}
+		refs[i] = ref
+		if _, ok := rlps[ref.txHash]; !ok {
+			var rlpTxn []byte
+			if mt, ok := p.byHash[string(ref.txHash[:])]; ok {
+				rlpTxn = mt.Tx.Rlp // never modified, only dropped once flushed
+			}
+			rlps[ref.txHash] = rlpTxn
+		}
+	}
+	p.lock.Unlock()
+	if len(rlps) == 0 {
+		return res, nil
+	}
+
+	parseCtx := types.NewTxParseContext(p.chainID)
+	parseCtx.WithSender(false)
+	sidecars := make(map[[32]byte]types.BlobSidecar, len(rlps))
+	for txHash, rlpTxn := range rlps {
+		if rlpTxn == nil {
+			v, err := tx.GetOne(kv.PoolTransaction, txHash[:])
+			if err != nil {
+				return nil, err
+			}
+			if len(v) <= 20 {
+				continue // removed from the pool in the meantime
+			}
+			rlpTxn = v[20:]
+		}
+		var slot types.TxSlot
+		sidecar, _, err := parseCtx.ParseBlobTransactionWrapped(rlpTxn, 0, &slot, nil, false /* hasEnvelope */, nil)
+		if err != nil {
+			p.logger.Debug("[txpool] blobs of pooled tx not found", "hash", fmt.Sprintf("%x", txHash), "err", err)
+			continue
+		}
+		sidecars[txHash] = sidecar
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for i, h := range blobHashes {
+		if res[i] != nil {
+			continue
+		}
+		sidecar, ok := sidecars[refs[i].txHash]
+		if !ok || refs[i].index >= len(sidecar.Blobs) {
+			continue
+		}
+		res[i] = &BlobAndProof{Blob: common.Copy(sidecar.Blobs[refs[i].index]), Proof: sidecar.Proofs[refs[i].index]}
+		if ref, ok := p.blobs.refs[h]; ok && ref == refs[i] {
+			p.blobs.hot.Add(h, res[i]) // unless the tx left the pool in the meantime
+		}
+	}
+	return res, nil
+}
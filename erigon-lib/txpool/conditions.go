@@ -0,0 +1,259 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/hexutil"
+	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// MaxKnownAccountsCost is the limit of the number of the known slots of the accounts of TxConditions
+const MaxKnownAccountsCost = 1000
+
+var (
+	ErrConditionsInvalid = errors.New("invalid transaction conditions")
+	ErrConditionsNotMet  = errors.New("transaction conditions not met")
+	ErrConditionsNotYet  = errors.New("transaction conditions not met yet") // can be met by later blocks
+)
+
+// KnownAccount is the expected storage of an account of TxConditions: either its storage root, given as a hash, or the
+// values of some of its slots, given as an object
+type KnownAccount struct {
+	StorageRoot *common.Hash
+	Slots       map[common.Hash]common.Hash
+}
+
+func (a *KnownAccount) UnmarshalJSON(data []byte) error {
+	var root common.Hash
+	if err := json.Unmarshal(data, &root); err == nil {
+		a.StorageRoot, a.Slots = &root, nil
+		return nil
+	}
+	a.StorageRoot = nil
+	return json.Unmarshal(data, &a.Slots)
+}
+
+func (a KnownAccount) MarshalJSON() ([]byte, error) {
+	if a.StorageRoot != nil {
+		return json.Marshal(a.StorageRoot)
+	}
+	return json.Marshal(a.Slots)
+}
+
+// TxConditions are the conditions of a transaction submitted with eth_sendRawTransactionConditional (the extension of
+// Arbitrum and Optimism): the transaction can only be included into a block within the block number and timestamp
+// ranges, and on top of a state with the known storage of the accounts. It is dropped from the pool once they can't
+// be met anymore, or fail at block building
+type TxConditions struct {
+	KnownAccounts  map[common.Address]KnownAccount `json:"knownAccounts,omitempty"`
+	BlockNumberMin *hexutil.Uint64                 `json:"blockNumberMin,omitempty"`
+	BlockNumberMax *hexutil.Uint64                 `json:"blockNumberMax,omitempty"`
+	TimestampMin   *hexutil.Uint64                 `json:"timestampMin,omitempty"`
+	TimestampMax   *hexutil.Uint64                 `json:"timestampMax,omitempty"`
+}
+
+// Validate checks that the conditions can be met, and checked. Conditions on storage roots are not supported: the
+// plain state has no storage roots of the accounts
+func (c *TxConditions) Validate() error {
+	if c.BlockNumberMin != nil && c.BlockNumberMax != nil && *c.BlockNumberMin > *c.BlockNumberMax {
+		return fmt.Errorf("%w: blockNumberMin %d > blockNumberMax %d", ErrConditionsInvalid, *c.BlockNumberMin, *c.BlockNumberMax)
+	}
+	if c.TimestampMin != nil && c.TimestampMax != nil && *c.TimestampMin > *c.TimestampMax {
+		return fmt.Errorf("%w: timestampMin %d > timestampMax %d", ErrConditionsInvalid, *c.TimestampMin, *c.TimestampMax)
+	}
+	cost := 0
+	for addr, account := range c.KnownAccounts {
+		if account.StorageRoot != nil {
+			return fmt.Errorf("%w: storage root of %x: not supported, use slots", ErrConditionsInvalid, addr)
+		}
+		cost += len(account.Slots)
+	}
+	if cost > MaxKnownAccountsCost {
+		return fmt.Errorf("%w: %d known slots, limit %d", ErrConditionsInvalid, cost, MaxKnownAccountsCost)
+	}
+	return nil
+}
+
+// CheckBlock checks the block number and timestamp ranges against the block the transaction is included into. The
+// error is ErrConditionsNotYet when a later block can meet them
+func (c *TxConditions) CheckBlock(number, timestamp uint64) error {
+	if c.BlockNumberMax != nil && number > uint64(*c.BlockNumberMax) {
+		return fmt.Errorf("%w: block number %d > blockNumberMax %d", ErrConditionsNotMet, number, *c.BlockNumberMax)
+	}
+	if c.TimestampMax != nil && timestamp > uint64(*c.TimestampMax) {
+		return fmt.Errorf("%w: timestamp %d > timestampMax %d", ErrConditionsNotMet, timestamp, *c.TimestampMax)
+	}
+	if c.BlockNumberMin != nil && number < uint64(*c.BlockNumberMin) {
+		return fmt.Errorf("%w: block number %d < blockNumberMin %d", ErrConditionsNotYet, number, *c.BlockNumberMin)
+	}
+	if c.TimestampMin != nil && timestamp < uint64(*c.TimestampMin) {
+		return fmt.Errorf("%w: timestamp %d < timestampMin %d", ErrConditionsNotYet, timestamp, *c.TimestampMin)
+	}
+	return nil
+}
+
+// CheckStorage checks the known slots of the accounts against the state, readStorage returns the value of the slot
+func (c *TxConditions) CheckStorage(readStorage func(addr common.Address, slot common.Hash) (common.Hash, error)) error {
+	for addr, account := range c.KnownAccounts {
+		if account.StorageRoot != nil {
+			return fmt.Errorf("%w: storage root of %x: not supported", ErrConditionsNotMet, addr)
+		}
+		for slot, expected := range account.Slots {
+			value, err := readStorage(addr, slot)
+			if err != nil {
+				return err
+			}
+			if value != expected {
+				return fmt.Errorf("%w: slot %x of %x is %x, expected %x", ErrConditionsNotMet, slot, addr, value, expected)
+			}
+		}
+	}
+	return nil
+}
+
+// expired reports whether the conditions can't be met by the blocks following the one of the given number, with
+// timestamps after the given one
+func (c *TxConditions) expired(number, timestamp uint64) bool {
+	return (c.BlockNumberMax != nil && uint64(*c.BlockNumberMax) <= number) ||
+		(c.TimestampMax != nil && uint64(*c.TimestampMax) <= timestamp)
+}
+
+// conditionsMetadataKey is the gRPC metadata key of the conditions of the transactions of AddRequest: the proto of
+// the request has no field for them
+const conditionsMetadataKey = "txpool-conditions"
+
+// WithConditions attaches the conditions of the transactions of the following TxpoolClient.Add to ctx, one per
+// transaction of the request, nil for unconditional ones
+func WithConditions(ctx context.Context, conditions []*TxConditions) (context.Context, error) {
+	encoded, err := json.Marshal(conditions)
+	if err != nil {
+		return ctx, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, conditionsMetadataKey, string(encoded)), nil
+}
+
+// conditionsFromContext returns the conditions attached with WithConditions, received over gRPC or passed with the
+// context of an in-process call
+func conditionsFromContext(ctx context.Context) ([]*TxConditions, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(conditionsMetadataKey)) == 0 {
+		md, _ = metadata.FromOutgoingContext(ctx)
+	}
+	values := md.Get(conditionsMetadataKey)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	var conditions []*TxConditions
+	if err := json.Unmarshal([]byte(values[0]), &conditions); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConditionsInvalid, err)
+	}
+	return conditions, nil
+}
+
+// Conditions returns the conditions of the transaction in the pool, nil for unconditional transactions
+func (p *TxPool) Conditions(hash []byte) *TxConditions {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.conditions[string(hash)]
+}
+
+// DropConditional discards the conditional transaction, whose conditions failed at block building
+func (p *TxPool) DropConditional(hash []byte) {
+	if err := p.dropConditional(context.Background(), hash); err != nil {
+		p.logger.Warn("[txpool] dropConditional", "err", err)
+	}
+}
+
+func (p *TxPool) dropConditional(ctx context.Context, hash []byte) error {
+	if !p.Started() {
+		return nil
+	}
+	coreDB, cache := p.coreDBWithCache()
+	coreTx, err := coreDB.BeginRo(ctx)
+	if err != nil {
+		return err
+	}
+	defer coreTx.Rollback()
+	cacheView, err := cache.View(ctx, coreTx)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	mt, ok := p.byHash[string(hash)]
+	if !ok {
+		return nil
+	}
+	if _, conditional := p.conditions[string(hash)]; !conditional {
+		return nil
+	}
+	p.discardConditionalLocked(mt)
+
+	// The later txs of the sender are behind a nonce gap now, addTxs demotes them
+	announcements, _, err := p.addTxs(p.lastSeenBlock.Load(), cacheView, p.senders, types.TxSlots{},
+		p.pendingBaseFee.Load(), p.pendingBlobFee.Load(), p.blockGasLimit.Load(), true, p.logger)
+	if err != nil {
+		return err
+	}
+	if announcements.Len() > 0 {
+		select {
+		case p.newPendingTxs <- announcements:
+		default:
+		}
+	}
+	return nil
+}
+
+// dropExpiredConditionalLocked discards the conditional transactions which can't be included anymore after the block
+// of the given number, the timestamp is the current time
+func (p *TxPool) dropExpiredConditionalLocked(number, timestamp uint64) {
+	for hash, c := range p.conditions {
+		if !c.expired(number, timestamp) {
+			continue
+		}
+		if mt, ok := p.byHash[hash]; ok {
+			p.discardConditionalLocked(mt)
+		} else {
+			delete(p.conditions, hash)
+		}
+	}
+}
+
+func (p *TxPool) discardConditionalLocked(mt *metaTx) {
+	switch mt.currentSubPool {
+	case PendingSubPool:
+		p.pending.Remove(mt, "dropConditional", p.logger)
+	case BaseFeeSubPool:
+		p.baseFee.Remove(mt, "dropConditional", p.logger)
+	case QueuedSubPool:
+		p.queued.Remove(mt, "dropConditional", p.logger)
+	default:
+		//already removed
+	}
+	p.discardLocked(mt, txpoolcfg.ConditionsNotMet)
+	p.markNonceGapLocked(mt)
+}
@@ -0,0 +1,84 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
+)
+
+type TxEventKind uint8
+
+const (
+	TxAdded     TxEventKind = iota // Transaction entered the pool (queued sub pool, promoted from there if it qualifies)
+	TxPromoted                     // Transaction entered the pending sub pool
+	TxDemoted                      // Transaction left the pending sub pool, but stays in the pool
+	TxDiscarded                    // Transaction left the pool, TxEvent.Reason tells why (Mined, ReplacedByHigherTip, overflows, ...)
+)
+
+func (k TxEventKind) String() string {
+	switch k {
+	case TxAdded:
+		return "added"
+	case TxPromoted:
+		return "promoted"
+	case TxDemoted:
+		return "demoted"
+	case TxDiscarded:
+		return "discarded"
+	default:
+		return "unknown"
+	}
+}
+
+// TxEvent describes a change of the pool, see TxPool.SubscribeEvents
+type TxEvent struct {
+	Kind   TxEventKind
+	IDHash [32]byte // Hash of the transaction
+	Sender common.Address
+	Nonce  uint64
+	Reason txpoolcfg.DiscardReason // Set for TxDiscarded only
+}
+
+// SubscribeEvents registers f to be called on every change of the pool, until the returned function is called.
+// f is called with the pool lock held: it must not block, nor call methods of the pool. Subscribers that need to do
+// more work (e.g. RPC filters) are expected to hand the events over to their own goroutine
+func (p *TxPool) SubscribeEvents(f func(TxEvent)) (unsubscribe func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	id := p.nextEventSubID
+	p.nextEventSubID++
+	if p.eventSubs == nil {
+		p.eventSubs = map[uint64]func(TxEvent){}
+	}
+	p.eventSubs[id] = f
+	return func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		delete(p.eventSubs, id)
+	}
+}
+
+func (p *TxPool) emitLocked(kind TxEventKind, mt *metaTx, reason txpoolcfg.DiscardReason) {
+	if len(p.eventSubs) == 0 {
+		return
+	}
+	ev := TxEvent{Kind: kind, IDHash: mt.Tx.IDHash, Sender: p.senders.senderID2Addr[mt.Tx.SenderID], Nonce: mt.Tx.Nonce, Reason: reason}
+	for _, f := range p.eventSubs {
+		f(ev)
+	}
+}
@@ -25,9 +25,11 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/common/cmp"
 
+	"github.com/c2h5oh/datasize"
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/common/dbg"
 	"github.com/ledgerwatch/erigon-lib/direct"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/grpcutil"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
@@ -35,6 +37,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/rlp"
 	types2 "github.com/ledgerwatch/erigon-lib/types"
 	"github.com/ledgerwatch/log/v3"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
@@ -55,6 +58,10 @@ type Fetch struct {
 	sentryClients            []direct.SentryClient // sentry clients that will be used for accessing the network
 	stateChangesParseCtxLock sync.Mutex
 	pooledTxsParseCtxLock    sync.Mutex
+	knownTxs                 *KnownTxs // transactions announced or sent by each peer, nil when not tracked
+	penalties                peerPenalties
+	rateLimits               peerRateLimits
+	scheduler                *fetchScheduler // which peer each announced transaction is requested from
 	logger                   log.Logger
 }
 
@@ -76,10 +83,14 @@ func NewFetch(ctx context.Context, sentryClients []direct.SentryClient, pool Poo
 		stateChangesClient:   stateChangesClient,
 		stateChangesParseCtx: types2.NewTxParseContext(chainID).ChainIDRequired(), //TODO: change ctx if rules changed
 		pooledTxsParseCtx:    types2.NewTxParseContext(chainID).ChainIDRequired(),
+		scheduler:            newFetchScheduler(fetchTimeout),
 		logger:               logger,
 	}
 	f.pooledTxsParseCtx.ValidateRLP(f.pool.ValidateSerializedTxn)
 	f.stateChangesParseCtx.ValidateRLP(f.pool.ValidateSerializedTxn)
+	// the method selector is needed by the admission filters
+	f.pooledTxsParseCtx.WithDataPrefix(true)
+	f.stateChangesParseCtx.WithDataPrefix(true)
 
 	return f
 }
@@ -88,6 +99,25 @@ func (f *Fetch) SetWaitGroup(wg *sync.WaitGroup) {
 	f.wg = wg
 }
 
+// SetPeerRateLimits limits the transactions accepted from a single peer, per second, see txpoolcfg.Config.PeerTxsRate
+// and PeerBytesRate. Messages above the limits are dropped, and the peer is penalized
+func (f *Fetch) SetPeerRateLimits(txsPerSecond uint64, bytesPerSecond datasize.ByteSize) {
+	f.rateLimits.txsPerSecond, f.rateLimits.bytesPerSecond = txsPerSecond, bytesPerSecond
+}
+
+// SetMinFees rejects the transactions received from the peers under the pool's price floor already at parsing, see
+// txpoolcfg.Config.MinTip and MinFeeCap. The peers sending them are penalized
+func (f *Fetch) SetMinFees(minTip, minFeeCap uint64) {
+	f.pooledTxsParseCtxLock.Lock()
+	defer f.pooledTxsParseCtxLock.Unlock()
+	f.pooledTxsParseCtx.WithMinFees(minTip, minFeeCap)
+}
+
+// SetKnownTxs makes the fetcher record which transactions each peer knows about, see KnownTxs
+func (f *Fetch) SetKnownTxs(knownTxs *KnownTxs) {
+	f.knownTxs = knownTxs
+}
+
 func (f *Fetch) threadSafeParsePooledTxn(cb func(*types2.TxParseContext) error) error {
 	f.pooledTxsParseCtxLock.Lock()
 	defer f.pooledTxsParseCtxLock.Unlock()
@@ -110,6 +140,46 @@ func (f *Fetch) ConnectSentries() {
 			f.receivePeerLoop(f.sentryClients[i])
 		}(i)
 	}
+	go f.refetchLoop()
+}
+
+// refetchLoop requests the transactions, which were not delivered in time, from other peers announcing them
+func (f *Fetch) refetchLoop() {
+	refetchEvery := time.NewTicker(time.Second)
+	defer refetchEvery.Stop()
+	for {
+		select {
+		case <-f.ctx.Done():
+			return
+		case now := <-refetchEvery.C:
+			f.refetch(f.scheduler.expired(now))
+		}
+	}
+}
+
+// refetch requests the reassigned transactions from their new peers
+func (f *Fetch) refetch(assignments []fetchAssignment) {
+	for _, a := range assignments {
+		if err := f.requestPooledTxs(a.peer.client, a.peer.id, a.hashes); err != nil {
+			f.logger.Debug("[txpool.fetch] Refetch", "err", err)
+		}
+	}
+}
+
+// requestPooledTxs sends GetPooledTransactions for the hashes to the peer
+func (f *Fetch) requestPooledTxs(sentryClient sentry.SentryClient, peer types2.PeerID, hashes []byte) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+	encodedRequest, err := types2.EncodeGetPooledTransactions66(hashes, uint64(1), nil)
+	if err != nil {
+		return err
+	}
+	_, err = sentryClient.SendMessageById(f.ctx, &sentry.SendMessageByIdRequest{
+		Data:   &sentry.OutboundMessageData{Id: sentry.MessageId_GET_POOLED_TRANSACTIONS_66, Data: encodedRequest},
+		PeerId: peer,
+	}, &grpc.EmptyCallOption{})
+	return err
 }
 
 func (f *Fetch) ConnectCore() {
@@ -196,6 +266,7 @@ func (f *Fetch) receiveMessage(ctx context.Context, sentryClient sentry.SentryCl
 				continue
 			}
 			f.logger.Debug("[txpool.fetch] Handling incoming message", "msg", req.Id.String(), "err", err)
+			f.penalize(streamCtx, sentryClient, req.PeerId, err)
 		}
 		if f.wg != nil {
 			f.wg.Done()
@@ -231,47 +302,29 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 				return err
 			}
 		}
+		f.knownTxs.Add(req.PeerId, hashes)
 		unknownHashes, err := f.pool.FilterKnownIdHashes(tx, hashes)
 		if err != nil {
 			return err
 		}
-		if len(unknownHashes) > 0 {
-			var encodedRequest []byte
-			var messageID sentry.MessageId
-			if encodedRequest, err = types2.EncodeGetPooledTransactions66(unknownHashes, uint64(1), nil); err != nil {
-				return err
-			}
-			messageID = sentry.MessageId_GET_POOLED_TRANSACTIONS_66
-			if _, err = sentryClient.SendMessageById(f.ctx, &sentry.SendMessageByIdRequest{
-				Data:   &sentry.OutboundMessageData{Id: messageID, Data: encodedRequest},
-				PeerId: req.PeerId,
-			}, &grpc.EmptyCallOption{}); err != nil {
-				return err
-			}
+		unknownHashes = f.scheduler.schedule(fetchPeer{id: req.PeerId, client: sentryClient}, unknownHashes, time.Now())
+		if err = f.requestPooledTxs(sentryClient, req.PeerId, unknownHashes); err != nil {
+			return err
 		}
 	case sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_68:
-		_, _, hashes, _, err := rlp.ParseAnnouncements(req.Data, 0)
+		txTypes, sizes, hashes, _, err := rlp.ParseAnnouncements(req.Data, 0)
 		if err != nil {
 			return fmt.Errorf("parsing NewPooledTransactionHashes88: %w", err)
 		}
+		f.knownTxs.Add(req.PeerId, hashes)
+		hashes = filterAnnouncements(txTypes, sizes, hashes)
 		unknownHashes, err := f.pool.FilterKnownIdHashes(tx, hashes)
 		if err != nil {
 			return err
 		}
-
-		if len(unknownHashes) > 0 {
-			var encodedRequest []byte
-			var messageID sentry.MessageId
-			if encodedRequest, err = types2.EncodeGetPooledTransactions66(unknownHashes, uint64(1), nil); err != nil {
-				return err
-			}
-			messageID = sentry.MessageId_GET_POOLED_TRANSACTIONS_66
-			if _, err = sentryClient.SendMessageById(f.ctx, &sentry.SendMessageByIdRequest{
-				Data:   &sentry.OutboundMessageData{Id: messageID, Data: encodedRequest},
-				PeerId: req.PeerId,
-			}, &grpc.EmptyCallOption{}); err != nil {
-				return err
-			}
+		unknownHashes = f.scheduler.schedule(fetchPeer{id: req.PeerId, client: sentryClient}, unknownHashes, time.Now())
+		if err = f.requestPooledTxs(sentryClient, req.PeerId, unknownHashes); err != nil {
+			return err
 		}
 	case sentry.MessageId_GET_POOLED_TRANSACTIONS_66:
 		//TODO: handleInboundMessage is single-threaded - means it can accept as argument couple buffers (or analog of txParseContext). Protobuf encoding will copy data anyway, but DirectClient doesn't
@@ -282,39 +335,11 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 		if err != nil {
 			return err
 		}
-
-		// limit to max 256 transactions in a reply
-		const hashSize = 32
-		hashes = hashes[:cmp.Min(len(hashes), 256*hashSize)]
-
-		var txs [][]byte
-		responseSize := 0
-		processed := len(hashes)
-
-		for i := 0; i < len(hashes); i += hashSize {
-			if responseSize >= p2pTxPacketLimit {
-				processed = i
-				log.Debug("txpool.Fetch.handleInboundMessage PooledTransactions reply truncated to fit p2pTxPacketLimit", "requested", len(hashes), "processed", processed)
-				break
-			}
-
-			txnHash := hashes[i:cmp.Min(i+hashSize, len(hashes))]
-			txn, err := f.pool.GetRlp(tx, txnHash)
-			if err != nil {
-				return err
-			}
-			if txn == nil {
-				continue
-			}
-
-			txs = append(txs, txn)
-			responseSize += len(txn)
+		txs, err := f.pooledTxsReply(tx, hashes)
+		if err != nil {
+			return err
 		}
-
 		encodedRequest = types2.EncodePooledTransactions66(txs, requestID, nil)
-		if len(encodedRequest) > p2pTxPacketLimit {
-			log.Debug("txpool.Fetch.handleInboundMessage PooledTransactions reply exceeds p2pTxPacketLimit", "requested", len(hashes), "processed", processed)
-		}
 
 		if _, err := sentryClient.SendMessageById(f.ctx, &sentry.SendMessageByIdRequest{
 			Data:   &sentry.OutboundMessageData{Id: messageID, Data: encodedRequest},
@@ -324,45 +349,46 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 		}
 	case sentry.MessageId_POOLED_TRANSACTIONS_66, sentry.MessageId_TRANSACTIONS_66:
 		txs := types2.TxSlots{}
+		now := time.Now()
+		limiter := f.rateLimits.get(req.PeerId)
+		if !limiter.allowBytes(now, len(req.Data)) {
+			return fmt.Errorf("%w: %d bytes", errRateLimited, len(req.Data))
+		}
 		if err := f.threadSafeParsePooledTxn(func(parseContext *types2.TxParseContext) error {
 			return nil
 		}); err != nil {
 			return err
 		}
 
+		// the parsed txs are marked as known to, and delivered by, the peer once the message is parsed, as the parsing
+		// of a tx can still fail after validateHash. The ones already in the pool are marked right away
+		validateHash := func(hash []byte) error {
+			if !limiter.allowTx(now) {
+				return errRateLimited
+			}
+			known, err := f.pool.IdHashKnown(tx, hash)
+			if err != nil {
+				return err
+			}
+			if known {
+				f.knownTxs.Add(req.PeerId, hash)
+				f.scheduler.delivered(req.PeerId, hash, now)
+				return types2.ErrRejected
+			}
+			return nil
+		}
+		var parseErr error
 		switch req.Id {
 		case sentry.MessageId_TRANSACTIONS_66:
 			if err := f.threadSafeParsePooledTxn(func(parseContext *types2.TxParseContext) error {
-				if _, err := types2.ParseTransactions(req.Data, 0, parseContext, &txs, func(hash []byte) error {
-					known, err := f.pool.IdHashKnown(tx, hash)
-					if err != nil {
-						return err
-					}
-					if known {
-						return types2.ErrRejected
-					}
-					return nil
-				}); err != nil {
-					return err
-				}
+				_, parseErr = types2.ParseTransactions(req.Data, 0, parseContext, &txs, validateHash)
 				return nil
 			}); err != nil {
 				return err
 			}
 		case sentry.MessageId_POOLED_TRANSACTIONS_66:
 			if err := f.threadSafeParsePooledTxn(func(parseContext *types2.TxParseContext) error {
-				if _, _, err := types2.ParsePooledTransactions66(req.Data, 0, parseContext, &txs, func(hash []byte) error {
-					known, err := f.pool.IdHashKnown(tx, hash)
-					if err != nil {
-						return err
-					}
-					if known {
-						return types2.ErrRejected
-					}
-					return nil
-				}); err != nil {
-					return err
-				}
+				_, _, parseErr = types2.ParsePooledTransactions66(req.Data, 0, parseContext, &txs, validateHash)
 				return nil
 			}); err != nil {
 				return err
@@ -370,10 +396,15 @@ func (f *Fetch) handleInboundMessage(ctx context.Context, req *sentry.InboundMes
 		default:
 			return fmt.Errorf("unexpected message: %s", req.Id.String())
 		}
-		if len(txs.Txs) == 0 {
-			return nil
+		// the txs parsed before a malformed or rate limited one are kept
+		for _, txn := range txs.Txs {
+			f.knownTxs.Add(req.PeerId, txn.IDHash[:])
+			f.scheduler.delivered(req.PeerId, txn.IDHash[:], now)
 		}
-		f.pool.AddRemoteTxs(ctx, txs)
+		if len(txs.Txs) > 0 {
+			f.pool.AddRemoteTxs(ctx, txs)
+		}
+		return parseErr
 	default:
 		defer f.logger.Trace("[txpool] dropped p2p message", "id", req.Id)
 	}
@@ -446,7 +477,13 @@ func (f *Fetch) handleNewPeer(req *sentry.PeerEvent) error {
 	}
 	switch req.EventId {
 	case sentry.PeerEvent_Connect:
+		f.knownTxs.AddPeer(req.PeerId)
 		f.pool.AddNewGoodPeer(req.PeerId)
+	case sentry.PeerEvent_Disconnect:
+		f.knownTxs.RemovePeer(req.PeerId)
+		f.penalties.remove(req.PeerId)
+		f.rateLimits.remove(req.PeerId)
+		f.refetch(f.scheduler.removePeer(req.PeerId, time.Now()))
 	}
 
 	return nil
@@ -478,13 +515,24 @@ func (f *Fetch) handleStateChanges(ctx context.Context, client StateChangesClien
 
 func (f *Fetch) handleStateChangesRequest(ctx context.Context, req *remote.StateChangeBatch) error {
 	var unwindTxs, unwindBlobTxs, minedTxs types2.TxSlots
+	// the mined txs are dropped together once OnNewBlock returns, so they are allocated at once. The pool copies out
+	// the ones it keeps, see processMinedFinalizedBlobs
+	forwardTxs := 0
+	for _, change := range req.ChangeBatch {
+		if change.Direction == remote.Direction_FORWARD {
+			forwardTxs += len(change.Txs)
+		}
+	}
+	slab := types2.NewTxSlotSlab(forwardTxs)
 	for _, change := range req.ChangeBatch {
 		if change.Direction == remote.Direction_FORWARD {
-			minedTxs.Resize(uint(len(change.Txs)))
+			// the txs of the blocks of the batch follow each other, in order
+			offset := len(minedTxs.Txs)
+			minedTxs.Resize(uint(offset + len(change.Txs)))
 			for i := range change.Txs {
-				minedTxs.Txs[i] = &types2.TxSlot{}
+				minedTxs.Txs[offset+i] = slab.New()
 				if err := f.threadSafeParseStateChangeTxn(func(parseContext *types2.TxParseContext) error {
-					_, err := parseContext.ParseTransaction(change.Txs[i], 0, minedTxs.Txs[i], minedTxs.Senders.At(i), false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+					_, err := parseContext.ParseTransaction(change.Txs[i], 0, minedTxs.Txs[offset+i], minedTxs.Senders.At(offset+i), false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
 					return err
 				}); err != nil && !errors.Is(err, context.Canceled) {
 					f.logger.Warn("[txpool.fetch] stream.Recv", "err", err)
@@ -521,3 +569,165 @@ func (f *Fetch) handleStateChangesRequest(ctx context.Context, req *remote.State
 	}
 	return nil
 }
+
+const (
+	// Penalties of the misbehaving peers, they are kicked once their penalties add up to kickPenalty
+	underpricedPenalty = 1  // spam: transactions under the minimal fees
+	rateLimitedPenalty = 5  // flood: transactions above the rate limits
+	malformedPenalty   = 10 // malformed RLP, invalid signature or fields
+	kickPenalty        = 100
+)
+
+// peerPenalties accumulates the penalties of the connected peers
+type peerPenalties struct {
+	lock   sync.Mutex
+	scores map[[64]byte]int
+}
+
+// add adds the penalty to the peer's score and reports whether the peer has to be kicked, which resets the score
+func (pp *peerPenalties) add(peer types2.PeerID, penalty int) (kick bool) {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	if pp.scores == nil {
+		pp.scores = map[[64]byte]int{}
+	}
+	id := gointerfaces.ConvertH512ToHash(peer)
+	pp.scores[id] += penalty
+	if pp.scores[id] < kickPenalty {
+		return false
+	}
+	delete(pp.scores, id)
+	return true
+}
+
+func (pp *peerPenalties) remove(peer types2.PeerID) {
+	pp.lock.Lock()
+	defer pp.lock.Unlock()
+	delete(pp.scores, gointerfaces.ConvertH512ToHash(peer))
+}
+
+func penaltyFor(err error) int {
+	switch {
+	case errors.Is(err, types2.ErrUnderpriced):
+		return underpricedPenalty
+	case errors.Is(err, errRateLimited):
+		return rateLimitedPenalty
+	case errors.Is(err, rlp.ErrParse):
+		return malformedPenalty
+	default:
+		return 0 // not the peer's fault, e.g. a db error
+	}
+}
+
+// penalize accounts for the peer's message which failed to be handled with err, and asks the sentry to kick the peer
+// once it has sent too many bad messages
+func (f *Fetch) penalize(ctx context.Context, sentryClient sentry.SentryClient, peer types2.PeerID, err error) {
+	penalty := penaltyFor(err)
+	if penalty == 0 || peer == nil || !f.penalties.add(peer, penalty) {
+		return
+	}
+	f.logger.Debug("[txpool.fetch] Kicking misbehaving peer", "peer", fmt.Sprintf("%x", gointerfaces.ConvertH512ToHash(peer)), "err", err)
+	if _, err := sentryClient.PenalizePeer(ctx, &sentry.PenalizePeerRequest{PeerId: peer, Penalty: sentry.PenaltyKind_Kick}, &grpc.EmptyCallOption{}); err != nil {
+		f.logger.Debug("[txpool.fetch] PenalizePeer", "err", err)
+	}
+}
+
+var errRateLimited = errors.New("peer rate limit exceeded")
+
+// peerRateLimits are token buckets limiting the transactions, and their bytes, accepted from each peer
+type peerRateLimits struct {
+	lock           sync.Mutex
+	txsPerSecond   uint64
+	bytesPerSecond datasize.ByteSize
+	limiters       map[[64]byte]*peerRateLimiter
+}
+
+type peerRateLimiter struct {
+	txs   *rate.Limiter // nil when unlimited
+	bytes *rate.Limiter
+}
+
+// get returns the limiter of the peer, nil when there are no limits
+func (l *peerRateLimits) get(peer types2.PeerID) *peerRateLimiter {
+	if (l.txsPerSecond == 0 && l.bytesPerSecond == 0) || peer == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limiters == nil {
+		l.limiters = map[[64]byte]*peerRateLimiter{}
+	}
+	id := gointerfaces.ConvertH512ToHash(peer)
+	limiter, ok := l.limiters[id]
+	if !ok {
+		limiter = &peerRateLimiter{}
+		// bursts of 2 seconds, but always enough for a full PooledTransactions reply
+		if l.txsPerSecond > 0 {
+			limiter.txs = rate.NewLimiter(rate.Limit(l.txsPerSecond), int(cmp.Max(2*l.txsPerSecond, maxPooledTxsServe)))
+		}
+		if l.bytesPerSecond > 0 {
+			limiter.bytes = rate.NewLimiter(rate.Limit(l.bytesPerSecond), int(cmp.Max(2*l.bytesPerSecond.Bytes(), softResponseLimit+blobTxMaxSize)))
+		}
+		l.limiters[id] = limiter
+	}
+	return limiter
+}
+
+func (l *peerRateLimits) remove(peer types2.PeerID) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.limiters, gointerfaces.ConvertH512ToHash(peer))
+}
+
+func (l *peerRateLimiter) allowTx(now time.Time) bool {
+	return l == nil || l.txs == nil || l.txs.AllowN(now, 1)
+}
+
+func (l *peerRateLimiter) allowBytes(now time.Time, n int) bool {
+	return l == nil || l.bytes == nil || l.bytes.AllowN(now, n)
+}
+
+const (
+	// maxPooledTxsServe is the maximum number of transactions in a PooledTransactions reply
+	maxPooledTxsServe = 256
+	// softResponseLimit is the target size of a PooledTransactions reply, it can get larger by one transaction
+	softResponseLimit = 2 * 1024 * 1024
+)
+
+// pooledTxsReply collects the RLPs of the requested transactions which are in the pool, in the order of the request,
+// until maxPooledTxsServe or softResponseLimit is reached
+func (f *Fetch) pooledTxsReply(tx kv.Tx, hashes []byte) (txs [][]byte, err error) {
+	const hashSize = 32
+	hashes = hashes[:cmp.Min(len(hashes), maxPooledTxsServe*hashSize)]
+	responseSize := 0
+	for i := 0; i+hashSize <= len(hashes); i += hashSize {
+		if responseSize >= softResponseLimit {
+			f.logger.Debug("[txpool.fetch] PooledTransactions reply truncated", "requested", len(hashes)/hashSize, "processed", i/hashSize)
+			break
+		}
+		txn, err := f.pool.GetRlp(tx, hashes[i:i+hashSize])
+		if err != nil {
+			return nil, err
+		}
+		if txn == nil {
+			continue
+		}
+		txs = append(txs, txn)
+		responseSize += len(txn)
+	}
+	return txs, nil
+}
+
+// filterAnnouncements drops eth/68 announcements of transactions which would be rejected anyway,
+// because of their type or size, so that they are not requested. The hashes are compacted in place
+func filterAnnouncements(txTypes []byte, sizes []uint32, hashes []byte) []byte {
+	kept := 0
+	for i := range txTypes {
+		if !types2.IsKnownTxType(txTypes[i]) || int(sizes[i]) > maxTxSize(txTypes[i]) {
+			continue
+		}
+		copy(hashes[kept*32:], hashes[i*32:(i+1)*32])
+		kept++
+	}
+	return hashes[:kept*32]
+}
@@ -0,0 +1,274 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
+	"github.com/ledgerwatch/erigon-lib/metrics"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+var (
+	announcedTxsCounter          = metrics.GetOrCreateCounter(`pool_announced_txs`)           // announced and not in the pool
+	duplicateAnnouncedTxsCounter = metrics.GetOrCreateCounter(`pool_announced_txs_duplicate`) // already requested from another peer, or in the window
+	refetchedTxsCounter          = metrics.GetOrCreateCounter(`pool_refetched_txs`)           // requested from another peer after a timeout
+	throttledAnnouncedTxsCounter = metrics.GetOrCreateCounter(`pool_announced_txs_throttled`) // not requested, the peer has too many requests in flight
+)
+
+const (
+	fetchTimeout     = 5 * time.Second // how long the peer has to deliver the requested transactions
+	maxFetchRequests = 32 * 1024       // in flight requests tracked, above it announcements are requested as they come
+	maxAlternates    = 8               // other announcers of a transaction remembered to fall back to
+	maxPeerRequests  = 4 * 1024        // in flight requests tracked per peer, above it the peer's announcements are dropped
+)
+
+// recentlyRequested remembers the announced transactions which were requested in the last window, so that a
+// transaction announced by many peers is fetched, and parsed, once. When the requested peer doesn't deliver, the
+// transaction is requested again from the next peer announcing it after the window
+type recentlyRequested struct {
+	window time.Duration
+	lru    *simplelru.LRU[[32]byte, time.Time]
+}
+
+func newRecentlyRequested(limit int, window time.Duration) *recentlyRequested {
+	lru, _ := simplelru.NewLRU[[32]byte, time.Time](limit, nil) // err only when limit is less than 1
+	return &recentlyRequested{window: window, lru: lru}
+}
+
+// filter drops the hashes requested in the window before now, and marks the rest as requested at now.
+// The hashes are compacted in place
+func (r *recentlyRequested) filter(hashes []byte, now time.Time) []byte {
+	kept := 0
+	for i := 0; i+32 <= len(hashes); i += 32 {
+		hash := [32]byte(hashes[i : i+32])
+		if at, ok := r.lru.Get(hash); ok && now.Sub(at) < r.window {
+			continue
+		}
+		r.lru.Add(hash, now)
+		copy(hashes[kept*32:], hash[:])
+		kept++
+	}
+	return hashes[:kept*32]
+}
+
+// fetchPeer is a peer and the sentry it is connected to
+type fetchPeer struct {
+	id     types.PeerID
+	client sentry.SentryClient
+}
+
+type fetchRequest struct {
+	peer       fetchPeer
+	at         time.Time
+	alternates []fetchPeer
+}
+
+// announcedBy reports whether the request is assigned to the peer, or the peer is one of its alternates
+func (r *fetchRequest) announcedBy(peer types.PeerID) bool {
+	id := gointerfaces.ConvertH512ToHash(peer)
+	if gointerfaces.ConvertH512ToHash(r.peer.id) == id {
+		return true
+	}
+	for _, alternate := range r.alternates {
+		if gointerfaces.ConvertH512ToHash(alternate.id) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchAssignment is the transactions (concatenated hashes) to request from the peer
+type fetchAssignment struct {
+	peer   fetchPeer
+	hashes []byte
+}
+
+// fetchScheduler assigns the fetching of each announced transaction to one of the peers which announced it, on top of
+// the recentlyRequested window. When the peer doesn't deliver within the timeout, the transaction is requested from
+// the fastest of the other announcers, according to their delivery latencies
+type fetchScheduler struct {
+	lock     sync.Mutex
+	timeout  time.Duration
+	recent   *recentlyRequested // dedups the announcements of the transactions whose requests aren't tracked
+	requests map[[32]byte]*fetchRequest
+	inFlight map[[64]byte]int           // tracked requests assigned to each peer, see maxPeerRequests
+	latency  map[[64]byte]time.Duration // moving average of the delivery latency of each peer
+}
+
+func newFetchScheduler(timeout time.Duration) *fetchScheduler {
+	return &fetchScheduler{
+		timeout:  timeout,
+		recent:   newRecentlyRequested(maxFetchRequests, timeout),
+		requests: map[[32]byte]*fetchRequest{},
+		inFlight: map[[64]byte]int{},
+		latency:  map[[64]byte]time.Duration{},
+	}
+}
+
+// schedule is called for the transactions announced by the peer, and not in the pool. It returns the ones to request
+// from the peer now: the ones not requested yet from another peer, as many as the peer can have in flight. The hashes
+// are compacted in place
+func (s *fetchScheduler) schedule(peer fetchPeer, hashes []byte, now time.Time) []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	kept := 0
+	for i := 0; i+32 <= len(hashes); i += 32 {
+		hash := [32]byte(hashes[i : i+32])
+		if req, ok := s.requests[hash]; ok {
+			if len(req.alternates) < maxAlternates && !req.announcedBy(peer.id) {
+				req.alternates = append(req.alternates, peer)
+			}
+			continue
+		}
+		copy(hashes[kept*32:], hash[:])
+		kept++
+	}
+	// the rest is left unrequested, for the other announcers
+	id := gointerfaces.ConvertH512ToHash(peer.id)
+	throttled := 0
+	if room := maxPeerRequests - s.inFlight[id]; kept > room {
+		throttled, kept = kept-room, room
+	}
+	// the transactions requested in the window without a tracked request, e.g. above maxFetchRequests, are skipped too
+	unrequested := s.recent.filter(hashes[:kept*32], now)
+	for i := 0; i+32 <= len(unrequested) && len(s.requests) < maxFetchRequests; i += 32 {
+		s.requests[[32]byte(unrequested[i:i+32])] = &fetchRequest{peer: peer, at: now}
+		s.inFlight[id]++
+	}
+	announcedTxsCounter.AddInt(len(hashes) / 32)
+	duplicateAnnouncedTxsCounter.AddInt((len(hashes)-len(unrequested))/32 - throttled)
+	throttledAnnouncedTxsCounter.AddInt(throttled)
+	return unrequested
+}
+
+// releaseLocked accounts for a tracked request of the peer which is not in flight anymore
+func (s *fetchScheduler) releaseLocked(peer types.PeerID) {
+	id := gointerfaces.ConvertH512ToHash(peer)
+	if s.inFlight[id] <= 1 {
+		delete(s.inFlight, id)
+		return
+	}
+	s.inFlight[id]--
+}
+
+// delivered is called for every transaction received from the peer, requested or broadcast
+func (s *fetchScheduler) delivered(peer types.PeerID, hash []byte, now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	req, ok := s.requests[[32]byte(hash)]
+	if !ok {
+		return
+	}
+	delete(s.requests, [32]byte(hash))
+	s.releaseLocked(req.peer.id)
+	if id := gointerfaces.ConvertH512ToHash(peer); id == gointerfaces.ConvertH512ToHash(req.peer.id) {
+		s.observeLatency(id, now.Sub(req.at))
+	}
+}
+
+func (s *fetchScheduler) observeLatency(id [64]byte, d time.Duration) {
+	if prev, ok := s.latency[id]; ok {
+		d = (7*prev + d) / 8
+	}
+	s.latency[id] = d
+}
+
+// expired reassigns the requests which timed out to the fastest alternate peer, and returns the new assignments.
+// Requests without alternates are dropped: the transaction is requested again when announced again
+func (s *fetchScheduler) expired(now time.Time) []fetchAssignment {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var assignments []fetchAssignment
+	for hash, req := range s.requests {
+		if now.Sub(req.at) < s.timeout {
+			continue
+		}
+		s.observeLatency(gointerfaces.ConvertH512ToHash(req.peer.id), s.timeout)
+		assignments = s.reassignLocked(hash, req, now, assignments)
+	}
+	return assignments
+}
+
+// reassignLocked assigns the request to its fastest alternate peer, with room for more requests, and adds the hash to
+// the assignments, or drops the request when it has no such alternates
+func (s *fetchScheduler) reassignLocked(hash [32]byte, req *fetchRequest, now time.Time, assignments []fetchAssignment) []fetchAssignment {
+	s.releaseLocked(req.peer.id)
+	best := -1
+	for i := range req.alternates {
+		if s.inFlight[gointerfaces.ConvertH512ToHash(req.alternates[i].id)] >= maxPeerRequests {
+			continue
+		}
+		if best < 0 || s.peerLatency(req.alternates[i].id) < s.peerLatency(req.alternates[best].id) {
+			best = i
+		}
+	}
+	if best < 0 {
+		delete(s.requests, hash)
+		return assignments
+	}
+	req.peer, req.at = req.alternates[best], now
+	req.alternates = append(req.alternates[:best], req.alternates[best+1:]...)
+	s.inFlight[gointerfaces.ConvertH512ToHash(req.peer.id)]++
+	refetchedTxsCounter.Inc()
+
+	id := gointerfaces.ConvertH512ToHash(req.peer.id)
+	for i := range assignments {
+		if gointerfaces.ConvertH512ToHash(assignments[i].peer.id) == id {
+			assignments[i].hashes = append(assignments[i].hashes, hash[:]...)
+			return assignments
+		}
+	}
+	return append(assignments, fetchAssignment{peer: req.peer, hashes: hash[:]})
+}
+
+// peerLatency returns the delivery latency of the peer, peers which haven't delivered anything yet are assumed to
+// be as slow as the timeout
+func (s *fetchScheduler) peerLatency(peer types.PeerID) time.Duration {
+	if d, ok := s.latency[gointerfaces.ConvertH512ToHash(peer)]; ok {
+		return d
+	}
+	return s.timeout
+}
+
+// removePeer forgets a disconnected peer: it is removed from the alternates, and its requests are reassigned to the
+// alternates right away. It returns the new assignments
+func (s *fetchScheduler) removePeer(peer types.PeerID, now time.Time) []fetchAssignment {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := gointerfaces.ConvertH512ToHash(peer)
+	delete(s.latency, id)
+	var assignments []fetchAssignment
+	for hash, req := range s.requests {
+		alternates := req.alternates[:0]
+		for _, alternate := range req.alternates {
+			if gointerfaces.ConvertH512ToHash(alternate.id) != id {
+				alternates = append(alternates, alternate)
+			}
+		}
+		req.alternates = alternates
+		if gointerfaces.ConvertH512ToHash(req.peer.id) == id {
+			assignments = s.reassignLocked(hash, req, now, assignments)
+		}
+	}
+	return assignments
+}
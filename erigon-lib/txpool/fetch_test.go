@@ -17,25 +17,35 @@
 package txpool
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/c2h5oh/datasize"
+	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/direct"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/sentry"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
+	"github.com/ledgerwatch/erigon-lib/rlp"
 	types3 "github.com/ledgerwatch/erigon-lib/types"
+	"github.com/ledgerwatch/erigon-lib/types/testutil"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestFetch(t *testing.T) {
@@ -68,13 +78,310 @@ func TestFetch(t *testing.T) {
 
 }
 
+// The txs of a message parsed before a malformed one are added, and only they are marked as delivered
+func TestPartialTransactions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var added types3.TxSlots
+	pool := &PoolMock{
+		StartedFunc:     func() bool { return true },
+		IdHashKnownFunc: func(tx kv.Tx, hash []byte) (bool, error) { return false, nil },
+		AddRemoteTxsFunc: func(ctx context.Context, newTxs types3.TxSlots) {
+			added = newTxs
+		},
+	}
+	fetch := NewFetch(ctx, nil, pool, &remote.KVClientMock{}, nil, memdb.NewTestPoolDB(t), *u256.N1, log.New())
+	knownTxs := NewKnownTxs(DefaultKnownTxsPerPeer)
+	fetch.SetKnownTxs(knownTxs)
+
+	var rlps [][]byte
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		params := testutil.TxParams{Type: testutil.DynamicFeeTxType, ChainID: *u256.N1, Nonce: nonce, Gas: 21_000, To: &[20]byte{0x01}}
+		payload, err := testutil.BuildSignedTx(params, bytes.Repeat([]byte{1}, 32))
+		require.NoError(t, err)
+		rlps = append(rlps, payload)
+	}
+	rlps = append(rlps, []byte{types3.DynamicFeeTxType, 0xc1, 0x01})
+	err := fetch.handleInboundMessage(ctx, &sentry.InboundMessage{
+		Id:     sentry.MessageId_TRANSACTIONS_66,
+		Data:   types3.EncodeTransactions(rlps, nil),
+		PeerId: peerID,
+	}, nil)
+	assert.ErrorIs(t, err, rlp.ErrParse)
+	require.Equal(t, 2, len(added.Txs))
+	for _, txn := range added.Txs {
+		assert.True(t, knownTxs.Has(peerID, txn.IDHash[:]))
+	}
+}
+
+func TestUnderpricedTransactions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var added types3.TxSlots
+	pool := &PoolMock{
+		StartedFunc:     func() bool { return true },
+		IdHashKnownFunc: func(tx kv.Tx, hash []byte) (bool, error) { return false, nil },
+		AddRemoteTxsFunc: func(ctx context.Context, newTxs types3.TxSlots) {
+			added = newTxs
+		},
+	}
+	fetch := NewFetch(ctx, nil, pool, &remote.KVClientMock{}, nil, memdb.NewTestPoolDB(t), *u256.N1, log.New())
+	fetch.SetMinFees(2, 0)
+
+	var rlps [][]byte
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		params := testutil.TxParams{Type: testutil.DynamicFeeTxType, ChainID: *u256.N1, Nonce: nonce, Tip: *uint256.NewInt(2 - nonce), FeeCap: *uint256.NewInt(2), Gas: 21_000, To: &[20]byte{0x01}}
+		payload, err := testutil.BuildSignedTx(params, bytes.Repeat([]byte{1}, 32))
+		require.NoError(t, err)
+		rlps = append(rlps, payload)
+	}
+	err := fetch.handleInboundMessage(ctx, &sentry.InboundMessage{
+		Id:     sentry.MessageId_TRANSACTIONS_66,
+		Data:   types3.EncodeTransactions(rlps, nil),
+		PeerId: peerID,
+	}, nil)
+	assert.ErrorIs(t, err, types3.ErrUnderpriced)
+	assert.Equal(t, underpricedPenalty, penaltyFor(err))
+	assert.Equal(t, 1, len(added.Txs))
+}
+
+func TestPooledTxsReply(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const txSize = 100 * 1024
+	pool := &PoolMock{
+		GetRlpFunc: func(tx kv.Tx, hash []byte) ([]byte, error) {
+			if hash[0]%2 == 0 { // not in the pool
+				return nil, nil
+			}
+			rlp := make([]byte, txSize)
+			rlp[0] = hash[0]
+			return rlp, nil
+		},
+	}
+	fetch := NewFetch(ctx, nil, pool, &remote.KVClientMock{}, nil, nil, *u256.N1, log.New())
+
+	txs, err := fetch.pooledTxsReply(nil, toHashes(5, 2, 3, 1))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(txs))
+	assert.Equal(t, []byte{5, 3, 1}, []byte{txs[0][0], txs[1][0], txs[2][0]})
+
+	var hashes []byte
+	for i := 0; i < 2*maxPooledTxsServe; i++ {
+		hashes = append(hashes, toHashes(1)...)
+	}
+	txs, err = fetch.pooledTxsReply(nil, hashes)
+	require.NoError(t, err)
+	assert.Equal(t, softResponseLimit/txSize+1, len(txs))
+}
+
+func TestKnownTxsPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &PoolMock{AddNewGoodPeerFunc: func(peerID types3.PeerID) {}}
+	fetch := NewFetch(ctx, nil, pool, &remote.KVClientMock{}, nil, nil, *u256.N1, log.New())
+	knownTxs := NewKnownTxs(DefaultKnownTxsPerPeer)
+	fetch.SetKnownTxs(knownTxs)
+	peers := toPeerIDs(1, 2, 3)
+
+	// connected, and announcing without a connect event seen
+	require.NoError(t, fetch.handleNewPeer(&sentry.PeerEvent{PeerId: peers[0], EventId: sentry.PeerEvent_Connect}))
+	knownTxs.Add(peers[1], toHashes(1))
+	assert.ElementsMatch(t, peers[:2], knownTxs.Peers())
+
+	// a send racing with the disconnect doesn't track the peer again
+	require.NoError(t, fetch.handleNewPeer(&sentry.PeerEvent{PeerId: peers[0], EventId: sentry.PeerEvent_Disconnect}))
+	knownTxs.AddSent(peers[0], toHashes(2))
+	knownTxs.AddSent(peers[2], toHashes(2))
+	assert.Equal(t, []types3.PeerID{peers[1]}, knownTxs.Peers())
+	assert.False(t, knownTxs.Has(peers[0], toHashes(2)))
+}
+
+func TestPenalizePeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewMockSentry(ctx)
+	m.PenalizePeerFunc = func(contextMoqParam context.Context, penalizePeerRequest *sentry.PenalizePeerRequest) (*emptypb.Empty, error) {
+		return &emptypb.Empty{}, nil
+	}
+	sentryClient := direct.NewSentryClientDirect(direct.ETH68, m)
+	fetch := NewFetch(ctx, []direct.SentryClient{sentryClient}, &PoolMock{}, &remote.KVClientMock{}, nil, nil, *u256.N1, log.New())
+	peers := toPeerIDs(1, 2)
+
+	malformed := fmt.Errorf("%w: bad rlp", types3.ErrParseTxn)
+	for i := 0; i < kickPenalty/malformedPenalty-1; i++ {
+		fetch.penalize(ctx, sentryClient, peers[0], malformed)
+	}
+	fetch.penalize(ctx, sentryClient, peers[0], errors.New("not the peer's fault"))
+	require.Equal(t, 0, len(m.PenalizePeerCalls()))
+	fetch.penalize(ctx, sentryClient, peers[0], malformed)
+	require.Equal(t, 1, len(m.PenalizePeerCalls()))
+	assert.Equal(t, peers[0], types3.PeerID(m.PenalizePeerCalls()[0].PenalizePeerRequest.PeerId))
+
+	// underpriced transactions are penalized less
+	underpriced := fmt.Errorf("%w: tip 0", types3.ErrUnderpriced)
+	for i := 0; i < kickPenalty/underpricedPenalty-1; i++ {
+		fetch.penalize(ctx, sentryClient, peers[1], underpriced)
+	}
+	require.Equal(t, 1, len(m.PenalizePeerCalls()))
+	fetch.penalize(ctx, sentryClient, peers[1], underpriced)
+	require.Equal(t, 2, len(m.PenalizePeerCalls()))
+}
+
+func TestRecentlyRequested(t *testing.T) {
+	r := newRecentlyRequested(10, time.Second)
+	now := time.Now()
+
+	assert.Equal(t, []byte(toHashes(1, 2)), r.filter(toHashes(1, 2), now))
+	// announced by another peer
+	assert.Equal(t, []byte(toHashes(3)), r.filter(toHashes(1, 3, 2), now.Add(time.Millisecond)))
+	// not delivered in the window, requested again
+	assert.Equal(t, []byte(toHashes(3)), r.filter(toHashes(3), now.Add(2*time.Second)))
+}
+
+func TestFetchScheduler(t *testing.T) {
+	s := newFetchScheduler(time.Second)
+	peers := toPeerIDs(1, 2, 3)
+	now := time.Now()
+
+	assert.Equal(t, []byte(toHashes(1, 2)), s.schedule(fetchPeer{id: peers[0]}, toHashes(1, 2), now))
+	// announced by other peers, requested from the first one only
+	assert.Equal(t, []byte(toHashes(3)), s.schedule(fetchPeer{id: peers[1]}, toHashes(1, 3, 2), now))
+	assert.Equal(t, 0, len(s.schedule(fetchPeer{id: peers[2]}, toHashes(1, 2), now)))
+
+	// peer 3 has proven faster than peer 2
+	s.delivered(peers[0], toHashes(2), now.Add(100*time.Millisecond))
+	s.latency[gointerfaces.ConvertH512ToHash(peers[2])] = 10 * time.Millisecond
+	s.delivered(peers[1], toHashes(3), now.Add(200*time.Millisecond))
+	assert.Equal(t, 100*time.Millisecond, s.peerLatency(peers[0]))
+	assert.Equal(t, 200*time.Millisecond, s.peerLatency(peers[1]))
+
+	assert.Equal(t, 0, len(s.expired(now.Add(500*time.Millisecond))))
+	// peer 1 didn't deliver 1 in time
+	assignments := s.expired(now.Add(time.Second))
+	require.Equal(t, 1, len(assignments))
+	assert.Equal(t, peers[2], assignments[0].peer.id)
+	assert.Equal(t, []byte(toHashes(1)), assignments[0].hashes)
+	assert.True(t, s.peerLatency(peers[0]) > 100*time.Millisecond)
+
+	// then peer 2 is the last resort, and the request is dropped after it
+	assignments = s.expired(now.Add(2 * time.Second))
+	require.Equal(t, 1, len(assignments))
+	assert.Equal(t, peers[1], assignments[0].peer.id)
+	assert.Equal(t, 0, len(s.expired(now.Add(3*time.Second))))
+	assert.Equal(t, []byte(toHashes(1)), s.schedule(fetchPeer{id: peers[0]}, toHashes(1), now.Add(3*time.Second)))
+
+	// the same peer is an alternate once, and not of its own request
+	later := now.Add(4 * time.Second)
+	assert.Equal(t, []byte(toHashes(4, 5)), s.schedule(fetchPeer{id: peers[0]}, toHashes(4, 5), later))
+	s.schedule(fetchPeer{id: peers[1]}, toHashes(4), later)
+	s.schedule(fetchPeer{id: peers[1]}, toHashes(4), later)
+	s.schedule(fetchPeer{id: peers[0]}, toHashes(5), later)
+	s.schedule(fetchPeer{id: peers[2]}, toHashes(5), later)
+	assert.Equal(t, 1, len(s.requests[[32]byte(toHashes(4))].alternates))
+	assert.Equal(t, 1, len(s.requests[[32]byte(toHashes(5))].alternates))
+
+	// the requests of a departed peer go to the alternates right away
+	assignments = s.removePeer(peers[0], later)
+	require.Equal(t, 2, len(assignments))
+	byPeer := map[[64]byte][]byte{}
+	for _, a := range assignments {
+		byPeer[gointerfaces.ConvertH512ToHash(a.peer.id)] = a.hashes
+	}
+	assert.Equal(t, []byte(toHashes(4)), byPeer[gointerfaces.ConvertH512ToHash(peers[1])])
+	assert.Equal(t, []byte(toHashes(5)), byPeer[gointerfaces.ConvertH512ToHash(peers[2])])
+	// and a departed alternate isn't requested
+	s.removePeer(peers[1], later)
+	_, ok := s.requests[[32]byte(toHashes(4))]
+	assert.False(t, ok)
+	assert.Equal(t, peers[2], s.requests[[32]byte(toHashes(5))].peer.id)
+}
+
+func TestFetchSchedulerPeerRequests(t *testing.T) {
+	s := newFetchScheduler(time.Second)
+	peers := toPeerIDs(1, 2)
+	now := time.Now()
+	hashes := func(from, to int) []byte {
+		var out []byte
+		for i := from; i < to; i++ {
+			var hash [32]byte
+			binary.BigEndian.PutUint32(hash[:], uint32(i))
+			out = append(out, hash[:]...)
+		}
+		return out
+	}
+
+	// the announcements above the limit are left to the other peers
+	assert.Equal(t, maxPeerRequests*32, len(s.schedule(fetchPeer{id: peers[0]}, hashes(0, maxPeerRequests+2), now)))
+	assert.Equal(t, hashes(maxPeerRequests, maxPeerRequests+2), s.schedule(fetchPeer{id: peers[1]}, hashes(0, maxPeerRequests+2), now))
+	assert.Equal(t, 0, len(s.schedule(fetchPeer{id: peers[0]}, hashes(maxPeerRequests+2, maxPeerRequests+3), now)))
+
+	// the delivered requests make room again
+	s.delivered(peers[1], hashes(maxPeerRequests, maxPeerRequests+1), now)
+	assert.Equal(t, hashes(maxPeerRequests+2, maxPeerRequests+3), s.schedule(fetchPeer{id: peers[1]}, hashes(maxPeerRequests+2, maxPeerRequests+3), now))
+
+	// the requests are not reassigned to a peer at the limit
+	s.inFlight[gointerfaces.ConvertH512ToHash(peers[1])] = maxPeerRequests
+	assert.Equal(t, 0, len(s.removePeer(peers[0], now)))
+	assert.Equal(t, 2, len(s.requests))
+}
+
+func TestPeerRateLimits(t *testing.T) {
+	l := peerRateLimits{txsPerSecond: 300, bytesPerSecond: 4 * datasize.MB}
+	peers := toPeerIDs(1, 2)
+	now := time.Now()
+
+	limiter := l.get(peers[0])
+	for i := 0; i < 600; i++ { // burst of 2 seconds
+		require.True(t, limiter.allowTx(now))
+	}
+	assert.False(t, limiter.allowTx(now))
+	assert.True(t, l.get(peers[1]).allowTx(now))
+	assert.True(t, limiter.allowTx(now.Add(10*time.Millisecond)))
+
+	assert.True(t, limiter.allowBytes(now, int(8*datasize.MB)))
+	assert.False(t, limiter.allowBytes(now, 1024))
+
+	l.remove(peers[0])
+	assert.True(t, l.get(peers[0]).allowTx(now))
+
+	var unlimited peerRateLimits
+	assert.Nil(t, unlimited.get(peers[0]))
+	assert.True(t, unlimited.get(peers[0]).allowBytes(now, int(datasize.GB)))
+}
+
+func TestFilterAnnouncements(t *testing.T) {
+	hash := func(b byte) []byte {
+		h := make([]byte, 32)
+		h[0] = b
+		return h
+	}
+	var hashes []byte
+	for i := byte(1); i <= 5; i++ {
+		hashes = append(hashes, hash(i)...)
+	}
+	txTypes := []byte{types3.LegacyTxType, types3.DynamicFeeTxType, 0x42, types3.DynamicFeeTxType, types3.BlobTxType}
+	sizes := []uint32{100, txMaxSize + 1, 100, txMaxSize, txMaxSize + 1}
+
+	filtered := filterAnnouncements(txTypes, sizes, hashes)
+	require.Equal(t, 3*32, len(filtered))
+	assert.Equal(t, hash(1), filtered[:32])
+	assert.Equal(t, hash(4), filtered[32:64])
+	assert.Equal(t, hash(5), filtered[64:])
+}
+
 func TestSendTxPropagate(t *testing.T) {
 	ctx, cancelFn := context.WithCancel(context.Background())
 	defer cancelFn()
 	t.Run("few remote byHash", func(t *testing.T) {
 		m := NewMockSentry(ctx)
 		send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH68, m)}, nil, log.New())
-		send.BroadcastPooledTxs(testRlps(2), 100)
+		send.BroadcastPooledTxs(testRlps(2), toHashes(1, 42), 100)
 		send.AnnouncePooledTxs([]byte{0, 1}, []uint32{10, 15}, toHashes(1, 42), 100)
 
 		calls := m.SendMessageToRandomPeersCalls()
@@ -96,7 +403,7 @@ func TestSendTxPropagate(t *testing.T) {
 			b := []byte(fmt.Sprintf("%x", i))
 			copy(list[i:i+32], b)
 		}
-		send.BroadcastPooledTxs(testRlps(len(list)/32), 100)
+		send.BroadcastPooledTxs(testRlps(len(list)/32), list, 100)
 		send.AnnouncePooledTxs([]byte{0, 1, 2}, []uint32{10, 12, 14}, list, 100)
 
 		calls := m.SendMessageToRandomPeersCalls()
@@ -116,7 +423,7 @@ func TestSendTxPropagate(t *testing.T) {
 			return &sentry.SentPeers{Peers: make([]*types.H512, 5)}, nil
 		}
 		send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH68, m)}, nil, log.New())
-		send.BroadcastPooledTxs(testRlps(2), 100)
+		send.BroadcastPooledTxs(testRlps(2), toHashes(1, 42), 100)
 		send.AnnouncePooledTxs([]byte{0, 1}, []uint32{10, 15}, toHashes(1, 42), 100)
 
 		calls := m.SendMessageToRandomPeersCalls()
@@ -130,6 +437,15 @@ func TestSendTxPropagate(t *testing.T) {
 		assert.Equal(t, sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_68, txnHashesMessage.Id)
 		assert.Equal(t, 76, len(txnHashesMessage.Data))
 	})
+	t.Run("broadcast to sqrt of peers", func(t *testing.T) {
+		m := NewMockSentry(ctx)
+		m.PeerCountFunc = func(contextMoqParam context.Context, peerCountRequest *sentry.PeerCountRequest) (*sentry.PeerCountReply, error) {
+			return &sentry.PeerCountReply{Count: 50}, nil
+		}
+		send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH68, m)}, nil, log.New())
+		assert.Equal(t, uint64(7), send.BroadcastMaxPeers(3))
+		assert.Equal(t, uint64(10), send.BroadcastMaxPeers(10))
+	})
 	t.Run("sync with new peer", func(t *testing.T) {
 		m := NewMockSentry(ctx)
 
@@ -149,6 +465,73 @@ func TestSendTxPropagate(t *testing.T) {
 			assert.True(t, len(req.Data.Data) > 0)
 		}
 	})
+	t.Run("bodies and announcements to tracked peers", func(t *testing.T) {
+		m := NewMockSentry(ctx)
+		send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH68, m)}, nil, log.New())
+		knownTxs := NewKnownTxs(DefaultKnownTxsPerPeer)
+		send.SetKnownTxs(knownTxs)
+		peers := toPeerIDs(1, 2, 3)
+		hashes := toHashes(1, 2)
+		knownTxs.Add(peers[0], hashes[:32]) // sent us the first one
+		knownTxs.Add(peers[1], hashes)
+		knownTxs.AddPeer(peers[2])
+
+		// the second peer knows both, so the bodies go to the other two
+		txSentTo := send.BroadcastPooledTxs([][]byte{{1}, {2}}, hashes, 2)
+		assert.Equal(t, []int{1, 2}, txSentTo)
+		calls := m.SendMessageByIdCalls()
+		require.Equal(t, 2, len(calls))
+		for _, call := range calls {
+			req := call.SendMessageByIdRequest
+			assert.Equal(t, sentry.MessageId_TRANSACTIONS_66, req.Data.Id)
+			switch {
+			case assert.ObjectsAreEqual(peers[0], types3.PeerID(req.PeerId)):
+				assert.Equal(t, types3.EncodeTransactions([][]byte{{2}}, nil), req.Data.Data)
+			case assert.ObjectsAreEqual(peers[2], types3.PeerID(req.PeerId)):
+				assert.Equal(t, types3.EncodeTransactions([][]byte{{1}, {2}}, nil), req.Data.Data)
+			default:
+				t.Errorf("unexpected peer %x", gointerfaces.ConvertH512ToHash(req.PeerId))
+			}
+		}
+
+		// and no peer is left to announce them to
+		hashSentTo := send.AnnouncePooledTxs([]byte{0, 0}, []uint32{10, 10}, hashes, 2)
+		assert.Equal(t, []int{0, 0}, hashSentTo)
+		require.Equal(t, 2, len(m.SendMessageByIdCalls()))
+
+		// a transaction without body broadcast is announced to all of them
+		hashSentTo = send.AnnouncePooledTxs([]byte{0}, []uint32{10}, toHashes(3), 2)
+		assert.Equal(t, []int{3}, hashSentTo)
+		require.Equal(t, 5, len(m.SendMessageByIdCalls()))
+		assert.Empty(t, m.SendMessageToRandomPeersCalls())
+	})
+	t.Run("skip transactions known to peer", func(t *testing.T) {
+		m := NewMockSentry(ctx)
+		send := NewSend(ctx, []direct.SentryClient{direct.NewSentryClientDirect(direct.ETH68, m)}, nil, log.New())
+		knownTxs := NewKnownTxs(DefaultKnownTxsPerPeer)
+		send.SetKnownTxs(knownTxs)
+		peers := toPeerIDs(1, 2)
+		hashes := toHashes(1, 42)
+		knownTxs.Add(peers[0], hashes)
+		knownTxs.Add(peers[1], hashes[:32])
+
+		send.PropagatePooledTxsToPeersList(peers, []byte{0, 1}, []uint32{10, 15}, hashes)
+		calls := m.SendMessageByIdCalls()
+		require.Equal(t, 1, len(calls))
+		req := calls[0].SendMessageByIdRequest
+		assert.Equal(t, peers[1], types3.PeerID(req.PeerId))
+		_, _, announced, _, err := rlp.ParseAnnouncements(req.Data.Data, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(hashes[32:]), announced)
+
+		// what was sent is known now
+		send.PropagatePooledTxsToPeersList(peers, []byte{0, 1}, []uint32{10, 15}, hashes)
+		require.Equal(t, 1, len(m.SendMessageByIdCalls()))
+
+		knownTxs.RemovePeer(peers[0])
+		assert.False(t, knownTxs.Has(peers[0], hashes[:32]))
+		assert.Equal(t, 1, knownTxs.Len())
+	})
 }
 
 func decodeHex(in string) []byte {
@@ -174,6 +557,7 @@ func TestOnNewBlock(t *testing.T) {
 				StateVersionId: 1,
 				ChangeBatch: []*remote.StateChange{
 					{Txs: [][]byte{decodeHex(types3.TxParseMainnetTests[0].PayloadStr), decodeHex(types3.TxParseMainnetTests[1].PayloadStr), decodeHex(types3.TxParseMainnetTests[2].PayloadStr)}, BlockHeight: 1, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+					{Txs: [][]byte{decodeHex(types3.TxParseMainnetTests[3].PayloadStr)}, BlockHeight: 2, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
 				},
 			}, nil
 		},
@@ -188,5 +572,10 @@ func TestOnNewBlock(t *testing.T) {
 	err := fetch.handleStateChanges(ctx, stateChanges)
 	assert.ErrorIs(t, io.EOF, err)
 	assert.Equal(t, 1, len(pool.OnNewBlockCalls()))
-	assert.Equal(t, 3, len(pool.OnNewBlockCalls()[0].MinedTxs.Txs))
+	// the txs of both blocks, in order
+	minedTxs := pool.OnNewBlockCalls()[0].MinedTxs
+	require.Equal(t, 4, len(minedTxs.Txs))
+	for i, txn := range minedTxs.Txs {
+		assert.Equal(t, types3.TxParseMainnetTests[i].IdHashStr, hex.EncodeToString(txn.IDHash[:]))
+	}
 }
@@ -0,0 +1,140 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/ledgerwatch/erigon-lib/gointerfaces"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// DefaultKnownTxsPerPeer is the number of hashes remembered for each peer, the same as geth's maxKnownTxs
+const DefaultKnownTxsPerPeer = 32768
+
+// KnownTxs remembers, for every connected peer, the hashes of the transactions received from or sent to the peer,
+// so that they are not announced (back) to it. Memory is bounded per peer, least recently seen hashes are evicted
+// first, and everything about a peer is forgotten when it disconnects. The tracked peers are the ones Send
+// propagates the transactions to
+type KnownTxs struct {
+	lock    sync.Mutex
+	perPeer int
+	peers   map[[64]byte]*simplelru.LRU[[32]byte, struct{}]
+}
+
+func NewKnownTxs(perPeer int) *KnownTxs {
+	return &KnownTxs{perPeer: perPeer, peers: map[[64]byte]*simplelru.LRU[[32]byte, struct{}]{}}
+}
+
+// AddPeer starts tracking a connected peer, see Peers. The peers connected before the pool subscribed to the peer
+// events are tracked from their first transaction message
+func (k *KnownTxs) AddPeer(peer types.PeerID) {
+	if k == nil {
+		return
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.trackLocked(gointerfaces.ConvertH512ToHash(peer))
+}
+
+// Add marks the transactions, given as concatenated 32-byte hashes, as received from the peer, and tracks the peer
+func (k *KnownTxs) Add(peer types.PeerID, hashes []byte) {
+	if k == nil || len(hashes) == 0 {
+		return
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if known := k.trackLocked(gointerfaces.ConvertH512ToHash(peer)); known != nil {
+		addHashes(known, hashes)
+	}
+}
+
+// AddSent marks the transactions as sent to the peer. Unlike Add it doesn't track the peer again, when the send
+// raced with the disconnect of the peer
+func (k *KnownTxs) AddSent(peer types.PeerID, hashes []byte) {
+	if k == nil || len(hashes) == 0 {
+		return
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if known, ok := k.peers[gointerfaces.ConvertH512ToHash(peer)]; ok {
+		addHashes(known, hashes)
+	}
+}
+
+func (k *KnownTxs) trackLocked(id [64]byte) *simplelru.LRU[[32]byte, struct{}] {
+	known, ok := k.peers[id]
+	if !ok {
+		known, _ = simplelru.NewLRU[[32]byte, struct{}](k.perPeer, nil) // err only when size is less than 1
+		if known == nil {
+			return nil
+		}
+		k.peers[id] = known
+	}
+	return known
+}
+
+func addHashes(known *simplelru.LRU[[32]byte, struct{}], hashes []byte) {
+	for i := 0; i+32 <= len(hashes); i += 32 {
+		known.Add([32]byte(hashes[i:i+32]), struct{}{})
+	}
+}
+
+// Peers returns the tracked peers, in random order
+func (k *KnownTxs) Peers() []types.PeerID {
+	if k == nil {
+		return nil
+	}
+	k.lock.Lock()
+	peers := make([]types.PeerID, 0, len(k.peers))
+	for id := range k.peers {
+		peers = append(peers, gointerfaces.ConvertHashToH512(id))
+	}
+	k.lock.Unlock()
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers
+}
+
+// Has reports whether the transaction is known to the peer
+func (k *KnownTxs) Has(peer types.PeerID, hash []byte) bool {
+	if k == nil {
+		return false
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	known, ok := k.peers[gointerfaces.ConvertH512ToHash(peer)]
+	return ok && known.Contains([32]byte(hash))
+}
+
+// RemovePeer forgets the transactions known to a disconnected peer
+func (k *KnownTxs) RemovePeer(peer types.PeerID) {
+	if k == nil {
+		return
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	delete(k.peers, gointerfaces.ConvertH512ToHash(peer))
+}
+
+// Len returns the number of the peers tracked
+func (k *KnownTxs) Len() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return len(k.peers)
+}
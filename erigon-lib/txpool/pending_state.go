@@ -0,0 +1,124 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"context"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// PendingState is the account of a sender as projected on the pending block: the latest state, with the sender's pool
+// txs applied in nonce order, up to the first nonce gap
+type PendingState struct {
+	Nonce   uint64      // next nonce of the sender
+	Balance uint256.Int // left once the applied txs paid their value and gas at the fee cap, 0 if they can't
+	Txs     int         // pool txs applied, the queued ones without nonce gap included
+}
+
+// PendingState returns the projection of the sender's account, see NonceFromPool for the cheaper nonce only
+func (p *TxPool) PendingState(ctx context.Context, addr common.Address) (PendingState, error) {
+	coreDB, cache := p.coreDBWithCache()
+	coreTx, err := coreDB.BeginRo(ctx)
+	if err != nil {
+		return PendingState{}, err
+	}
+	defer coreTx.Rollback()
+
+	cacheView, err := cache.View(ctx, coreTx)
+	if err != nil {
+		return PendingState{}, err
+	}
+	var state PendingState
+	encoded, err := cacheView.Get(addr.Bytes())
+	if err != nil {
+		return PendingState{}, err
+	}
+	if len(encoded) > 0 {
+		if state.Nonce, state.Balance, err = types.DecodeSender(encoded); err != nil {
+			return PendingState{}, err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	senderID, ok := p.senders.getID(addr)
+	if !ok {
+		return state, nil
+	}
+	p.all.ascend(senderID, func(mt *metaTx) bool {
+		if mt.Tx.Nonce < state.Nonce {
+			// mined, the pool didn't see the block yet
+			return true
+		}
+		if mt.Tx.Nonce != state.Nonce {
+			return false
+		}
+		state.Nonce++
+		state.Txs++
+		if cost := requiredBalance(mt.Tx); state.Balance.Lt(cost) {
+			state.Balance.Clear()
+		} else {
+			state.Balance.Sub(&state.Balance, cost)
+		}
+		return true
+	})
+	return state, nil
+}
+
+// nonceRun is a sender's run of pool txs with consecutive nonces, from the state nonce to next, excluded
+type nonceRun struct {
+	stateNonce uint64
+	next       uint64
+}
+
+// NonceFromPool returns the highest nonce of the run of consecutive pool txs on top of the sender's state nonce, false
+// if the sender has none. Unlike PendingState it doesn't scan the txs nor read the state, the runs are kept up to
+// date as txs are added and removed
+func (p *TxPool) NonceFromPool(addr common.Address) (nonce uint64, inPool bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	senderID, ok := p.senders.getID(addr)
+	if !ok {
+		return 0, false
+	}
+	run, ok := p.nonceRuns[senderID]
+	if !ok {
+		return 0, false
+	}
+	return run.next - 1, true
+}
+
+func (p *TxPool) setNonceRunLocked(senderID, stateNonce, next uint64) {
+	if next == stateNonce {
+		delete(p.nonceRuns, senderID)
+		return
+	}
+	p.nonceRuns[senderID] = nonceRun{stateNonce: stateNonce, next: next}
+}
+
+// breakNonceRunLocked ends the run of the sender at the discarded txn. Replaced txs are restored by the sender's state
+// change following the replacement
+func (p *TxPool) breakNonceRunLocked(mt *metaTx) {
+	run, ok := p.nonceRuns[mt.Tx.SenderID]
+	if ok && mt.Tx.Nonce >= run.stateNonce && mt.Tx.Nonce < run.next {
+		p.setNonceRunLocked(mt.Tx.SenderID, run.stateNonce, mt.Tx.Nonce)
+	}
+}
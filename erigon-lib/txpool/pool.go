@@ -23,10 +23,8 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math"
-	"math/big"
 	"runtime"
 	"sort"
 	"sync"
@@ -40,6 +38,7 @@ import (
 	"github.com/hashicorp/golang-lru/v2/simplelru"
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/log/v3"
+	"golang.org/x/sync/errgroup"
 
 	"github.com/ledgerwatch/erigon-lib/chain"
 	"github.com/ledgerwatch/erigon-lib/common"
@@ -104,7 +103,7 @@ var _ Pool = (*TxPool)(nil) // compile-time interface check
 // 1. Absence of nonce gaps. Set to 1 for transactions whose nonce is N, state nonce for the sender is M, and there are transactions for all nonces between M and N from the same sender. Set to 0 is the transaction's nonce is divided from the state nonce by one or more nonce gaps.
 // 2. Sufficient balance for gas. Set to 1 if the balance of sender's account in the state is B, nonce of the sender in the state is M, nonce of the transaction is N, and the sum of feeCap x gasLimit + transferred_value of all transactions from this sender with nonces N+1 ... M is no more than B. Set to 0 otherwise. In other words, this bit is set if there is currently a guarantee that the transaction and all its required prior transactions will be able to pay for gas.
 // 3. Not too much gas: Set to 1 if the transaction doesn't use too much gas
-// 4. Dynamic fee requirement. Set to 1 if feeCap of the transaction is no less than baseFee of the currently pending block. Set to 0 otherwise.
+// 4. Dynamic fee requirement. Set to 1 if feeCap of the transaction is no less than baseFee of the currently pending block, and, for blob transactions, blobFeeCap is no less than its blob base fee. Set to 0 otherwise.
 // 5. Local transaction. Set to 1 if transaction is local.
 type SubPoolMarker uint8
 
@@ -128,6 +127,7 @@ type metaTx struct {
 	bestIndex                 int
 	worstIndex                int
 	timestamp                 uint64 // when it was added to pool
+	addedAt                   int64  // unix time when it was added to pool, for the lifetime expiry; not persisted, fromDB sets it anew
 	subPool                   SubPoolMarker
 	currentSubPool            SubPoolType
 	minedBlockNum             uint64
@@ -206,7 +206,20 @@ type TxPool struct {
 	queued                  *SubPool
 	minedBlobTxsByBlock     map[uint64][]*metaTx             // (blockNum => slice): cache of recently mined blobs
 	minedBlobTxsByHash      map[string]*metaTx               // (hash => mt): map of recently mined blobs
+	blobTxs                 map[*metaTx]struct{}             // blob txs in the pool, to evict the cheapest at TotalBlobPoolLimit
+	kzgVerifier             KZGVerifier                      // nil means the go-kzg-4844 context of libkzg.Ctx()
+	blobs                   *blobStore                       // versioned hash => blob of a pooled tx, see GetBlobsByHash
+	auths                   *authorities                     // EIP-7702 authorizations of the set code txs in the pool
+	conditions              map[string]*TxConditions         // hash => conditions of the conditional txs, see AddLocalTxsWithConditions
+	admissionFilters        []AdmissionFilter                // custom admission policies, see AddAdmissionFilters
+	nonceRuns               map[uint64]nonceRun              // senderID => consecutive pool nonces on top of the state, see NonceFromPool
+	nonceGaps               map[uint64]struct{}              // senderIDs whose txs were discarded out of nonce order, see markNonceGapLocked
+	tips                    *tipOracle                       // effective tips of the recent blocks, see SuggestedTip
 	isLocalLRU              *simplelru.LRU[string, struct{}] // tx_hash => is_local : to restore isLocal flag of unwinded transactions
+	localSenders            map[common.Address]struct{}      // senders from txpoolcfg.Config.Locals
+	byArrival               []*metaTx                        // non-local txs in the order they were added, may contain already removed ones
+	eventSubs               map[uint64]func(TxEvent)         // see SubscribeEvents
+	nextEventSubID          uint64                           // id of the next subscriber
 	newPendingTxs           chan types.Announcements         // notifications about new txs in Pending sub-pool
 	all                     *BySenderAndNonce                // senderID => (sorted map of tx nonce => *metaTx)
 	deletedTxs              []*metaTx                        // list of discarded txs since last db commit
@@ -221,12 +234,8 @@ type TxPool struct {
 	pendingBlobFee          atomic.Uint64 // For gas accounting for blobs, which has its own dimension
 	blockGasLimit           atomic.Uint64
 	totalBlobsInPool        atomic.Uint64
-	shanghaiTime            *uint64
-	isPostShanghai          atomic.Bool
-	agraBlock               *uint64
-	isPostAgra              atomic.Bool
-	cancunTime              *uint64
-	isPostCancun            atomic.Bool
+	rules                   txpoolcfg.ChainRules
+	activeForks             [txpoolcfg.Prague + 1]atomic.Bool // once a fork is active, the rules are not checked anymore
 	maxBlobsPerBlock        uint64
 	feeCalculator           FeeCalculator
 	logger                  log.Logger
@@ -237,7 +246,7 @@ type FeeCalculator interface {
 }
 
 func New(newTxs chan types.Announcements, coreDB kv.RoDB, cfg txpoolcfg.Config, cache kvcache.Cache,
-	chainID uint256.Int, shanghaiTime, agraBlock, cancunTime *big.Int, maxBlobsPerBlock uint64,
+	chainID uint256.Int, rules txpoolcfg.ChainRules, maxBlobsPerBlock uint64,
 	feeCalculator FeeCalculator, logger log.Logger,
 ) (*TxPool, error) {
 	localsHistory, err := simplelru.NewLRU[string, struct{}](10_000, nil)
@@ -260,6 +269,11 @@ func New(newTxs chan types.Announcements, coreDB kv.RoDB, cfg txpoolcfg.Config,
 		tracedSenders[common.BytesToAddress([]byte(sender))] = struct{}{}
 	}
 
+	localSenders := make(map[common.Address]struct{}, len(cfg.Locals))
+	for _, sender := range cfg.Locals {
+		localSenders[sender] = struct{}{}
+	}
+
 	lock := &sync.Mutex{}
 
 	res := &TxPool{
@@ -267,6 +281,7 @@ func New(newTxs chan types.Announcements, coreDB kv.RoDB, cfg txpoolcfg.Config,
 		lastSeenCond:            sync.NewCond(lock),
 		byHash:                  map[string]*metaTx{},
 		isLocalLRU:              localsHistory,
+		localSenders:            localSenders,
 		discardReasonsLRU:       discardHistory,
 		all:                     byNonce,
 		recentlyConnectedPeers:  &recentlyConnectedPeers{},
@@ -283,33 +298,19 @@ func New(newTxs chan types.Announcements, coreDB kv.RoDB, cfg txpoolcfg.Config,
 		unprocessedRemoteByHash: map[string]int{},
 		minedBlobTxsByBlock:     map[uint64][]*metaTx{},
 		minedBlobTxsByHash:      map[string]*metaTx{},
+		blobTxs:                 map[*metaTx]struct{}{},
+		blobs:                   newBlobStore(hotBlobs),
+		auths:                   newAuthorities(),
+		conditions:              map[string]*TxConditions{},
+		nonceRuns:               map[uint64]nonceRun{},
+		nonceGaps:               map[uint64]struct{}{},
+		tips:                    newTipOracle(DefaultTipOracleConfig),
+		rules:                   rules,
 		maxBlobsPerBlock:        maxBlobsPerBlock,
 		feeCalculator:           feeCalculator,
 		logger:                  logger,
 	}
 
-	if shanghaiTime != nil {
-		if !shanghaiTime.IsUint64() {
-			return nil, errors.New("shanghaiTime overflow")
-		}
-		shanghaiTimeU64 := shanghaiTime.Uint64()
-		res.shanghaiTime = &shanghaiTimeU64
-	}
-	if agraBlock != nil {
-		if !agraBlock.IsUint64() {
-			return nil, errors.New("agraBlock overflow")
-		}
-		agraBlockU64 := agraBlock.Uint64()
-		res.agraBlock = &agraBlockU64
-	}
-	if cancunTime != nil {
-		if !cancunTime.IsUint64() {
-			return nil, errors.New("cancunTime overflow")
-		}
-		cancunTimeU64 := cancunTime.Uint64()
-		res.cancunTime = &cancunTimeU64
-	}
-
 	return res, nil
 }
 
@@ -388,6 +389,9 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 		}
 	}
 
+	// the pending base fee is still the one of the first mined block
+	p.tips.onNewBlocks(stateChanges.ChangeBatch, p.pendingBaseFee.Load(), baseFee, minedTxs.Txs)
+
 	pendingBaseFee, baseFeeChanged := p.setBaseFee(baseFee)
 	// Update pendingBase for all pool queues and slices
 	if baseFeeChanged {
@@ -399,8 +403,16 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 		p.queued.worst.pendingBaseFee = pendingBaseFee
 	}
 
-	pendingBlobFee := stateChanges.PendingBlobFeePerGas
-	p.setBlobFee(pendingBlobFee)
+	pendingBlobFee, blobFeeChanged := p.setBlobFee(stateChanges.PendingBlobFeePerGas)
+	// Update pendingBlobFee for all pool queues and slices, blob txs not paying it can't be in the pending pool
+	if blobFeeChanged {
+		p.pending.best.pendingBlobFee = pendingBlobFee
+		p.pending.worst.pendingBlobFee = pendingBlobFee
+		p.baseFee.best.pendingBlobFee = pendingBlobFee
+		p.baseFee.worst.pendingBlobFee = pendingBlobFee
+		p.queued.best.pendingBlobFee = pendingBlobFee
+		p.queued.worst.pendingBlobFee = pendingBlobFee
+	}
 
 	oldGasLimit := p.blockGasLimit.Swap(stateChanges.BlockGasLimit)
 	if oldGasLimit != stateChanges.BlockGasLimit {
@@ -475,6 +487,9 @@ func (p *TxPool) OnNewBlock(ctx context.Context, stateChanges *remote.StateChang
 	if err = p.removeMined(p.all, minedTxs.Txs); err != nil {
 		return err
 	}
+	p.evictDelegatedLocked(minedTxs.Txs)
+	p.expireLocked(time.Now())
+	p.dropExpiredConditionalLocked(block, uint64(time.Now().Unix()))
 
 	var announcements types.Announcements
 
@@ -534,10 +549,18 @@ func (p *TxPool) processRemoteTxs(ctx context.Context) error {
 		return err
 	}
 
-	_, newTxs, err := p.validateTxs(p.unprocessedRemoteTxs, cacheView)
+	reasons, newTxs, err := p.validateTxs(p.unprocessedRemoteTxs, cacheView)
 	if err != nil {
 		return err
 	}
+	// Remember underpriced transactions, so that the same transaction announced by other peers is neither requested
+	// nor parsed again. Other reasons are not remembered, they depend on the state and may not hold after the next block,
+	// e.g. TipTooLow on the base fee
+	for i, reason := range reasons {
+		if reason == txpoolcfg.UnderPriced {
+			p.discardReasonsLRU.Add(string(p.unprocessedRemoteTxs.Txs[i].IDHash[:]), reason)
+		}
+	}
 
 	announcements, _, err := p.addTxs(p.lastSeenBlock.Load(), cacheView, p.senders, newTxs,
 		p.pendingBaseFee.Load(), p.pendingBlobFee.Load(), p.blockGasLimit.Load(), true, p.logger)
@@ -589,6 +612,9 @@ func (p *TxPool) AppendLocalAnnouncements(types []byte, sizes []uint32, hashes [
 		if txn.subPool&IsLocal == 0 {
 			continue
 		}
+		if _, ok := p.conditions[hash]; ok {
+			continue // peers would include it regardless of the conditions
+		}
 		types = append(types, txn.Tx.Type)
 		sizes = append(sizes, txn.Tx.Size)
 		hashes = append(hashes, hash...)
@@ -714,10 +740,11 @@ func (p *TxPool) best(n uint16, txs *types.TxsRlp, tx kv.Tx, onTopOf, availableG
 
 	best := p.pending.best
 
-	isShanghai := p.isShanghai() || p.isAgra()
+	isShanghai := p.isActive(txpoolcfg.Shanghai)
 
 	txs.Resize(uint(cmp.Min(int(n), len(best.ms))))
 	var toRemove []*metaTx
+	skipped := map[uint64]struct{}{} // senders of the skipped txs, their later nonces can't be included either
 	count := 0
 	i := 0
 
@@ -736,9 +763,13 @@ func (p *TxPool) best(n uint16, txs *types.TxsRlp, tx kv.Tx, onTopOf, availableG
 		if yielded.Contains(mt.Tx.IDHash) {
 			continue
 		}
+		if _, ok := skipped[mt.Tx.SenderID]; ok {
+			continue
+		}
 
 		if mt.Tx.Gas >= p.blockGasLimit.Load() {
 			// Skip transactions with very large gas limit
+			skipped[mt.Tx.SenderID] = struct{}{}
 			continue
 		}
 
@@ -754,6 +785,7 @@ func (p *TxPool) best(n uint16, txs *types.TxsRlp, tx kv.Tx, onTopOf, availableG
 		// Skip transactions that require more blob gas than is available
 		blobCount := uint64(len(mt.Tx.BlobHashes))
 		if blobCount*fixedgas.BlobGasPerBlob > availableBlobGas {
+			skipped[mt.Tx.SenderID] = struct{}{}
 			continue
 		}
 		availableBlobGas -= blobCount * fixedgas.BlobGasPerBlob
@@ -764,6 +796,7 @@ func (p *TxPool) best(n uint16, txs *types.TxsRlp, tx kv.Tx, onTopOf, availableG
 		intrinsicGas, _ := txpoolcfg.CalcIntrinsicGas(uint64(mt.Tx.DataLen), uint64(mt.Tx.DataNonZeroLen), nil, mt.Tx.Creation, true, true, isShanghai)
 		if intrinsicGas > availableGas {
 			// we might find another TX with a low enough intrinsic gas to include so carry on
+			skipped[mt.Tx.SenderID] = struct{}{}
 			continue
 		}
 		availableGas -= intrinsicGas
@@ -821,6 +854,57 @@ func (p *TxPool) AddRemoteTxs(_ context.Context, newTxs types.TxSlots) {
 	}
 }
 
+// KZGVerifier verifies the KZG proofs of blobs, see SetKZGVerifier. It is implemented by *gokzg4844.Context, which is
+// the default, and allows to plug in another backend (e.g. c-kzg bindings)
+type KZGVerifier interface {
+	VerifyBlobKZGProofBatch(blobs []gokzg4844.Blob, commitments []gokzg4844.KZGCommitment, proofs []gokzg4844.KZGProof) error
+}
+
+// SetKZGVerifier replaces the backend verifying the KZG proofs of blob transactions, must be called before Start
+func (p *TxPool) SetKZGVerifier(v KZGVerifier) { p.kzgVerifier = v }
+
+func (p *TxPool) verifyKZGProofs(txn *types.TxSlot) error {
+	// https://github.com/ethereum/consensus-specs/blob/017a8495f7671f5fff2075a9bfc9238c1a0982f8/specs/deneb/polynomial-commitments.md#verify_blob_kzg_proof_batch
+	var v KZGVerifier = p.kzgVerifier
+	if v == nil {
+		v = libkzg.Ctx()
+	}
+	return v.VerifyBlobKZGProofBatch(toBlobs(txn.Blobs), txn.Commitments, txn.Proofs)
+}
+
+// proofsCheck is the result of verifying the KZG proofs of a blob txn ahead of validateTx
+type proofsCheck uint8
+
+const (
+	proofsNotChecked proofsCheck = iota
+	proofsValid
+	proofsInvalid
+)
+
+// checkKZGProofs verifies the KZG proofs of the blob txs among txs, in parallel because the verification is CPU heavy.
+// Txs rejected by validateBlobFields are left for validateTx to reject, without verifying their proofs
+func (p *TxPool) checkKZGProofs(txs []*types.TxSlot) []proofsCheck {
+	checks := make([]proofsCheck, len(txs))
+	var g errgroup.Group
+	g.SetLimit(runtime.GOMAXPROCS(0))
+	for i, txn := range txs {
+		if txn.Type != types.BlobTxType || p.validateBlobFields(txn) != txpoolcfg.Success {
+			continue
+		}
+		i, txn := i, txn
+		g.Go(func() error {
+			if p.verifyKZGProofs(txn) != nil {
+				checks[i] = proofsInvalid
+			} else {
+				checks[i] = proofsValid
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return checks
+}
+
 func toBlobs(_blobs [][]byte) []gokzg4844.Blob {
 	blobs := make([]gokzg4844.Blob, len(_blobs))
 	for i, _blob := range _blobs {
@@ -831,45 +915,68 @@ func toBlobs(_blobs [][]byte) []gokzg4844.Blob {
 	return blobs
 }
 
+// validateBlobFields checks the blob txn against the rules and its blob fields against each other, without the pool
+// state, so that checkKZGProofs doesn't verify the proofs of the txs rejected anyway
+func (p *TxPool) validateBlobFields(txn *types.TxSlot) txpoolcfg.DiscardReason {
+	if fork, ok := txpoolcfg.TxTypeFork(txn.Type); !ok || !p.isActive(fork) {
+		return txpoolcfg.TypeNotActivated
+	}
+	if txn.Creation {
+		return txpoolcfg.CreateBlobTxn
+	}
+	blobCount := uint64(len(txn.BlobHashes))
+	if blobCount == 0 {
+		return txpoolcfg.NoBlobs
+	}
+	if blobCount > p.maxBlobsPerBlock {
+		return txpoolcfg.TooManyBlobs
+	}
+	equalNumber := len(txn.BlobHashes) == len(txn.Blobs) &&
+		len(txn.Blobs) == len(txn.Commitments) &&
+		len(txn.Commitments) == len(txn.Proofs)
+
+	if !equalNumber {
+		return txpoolcfg.UnequalBlobTxExt
+	}
+
+	for i := 0; i < len(txn.Commitments); i++ {
+		if libkzg.KZGToVersionedHash(txn.Commitments[i]) != libkzg.VersionedHash(txn.BlobHashes[i]) {
+			return txpoolcfg.BlobHashCheckFail
+		}
+	}
+	return txpoolcfg.Success
+}
+
 func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.CacheView) txpoolcfg.DiscardReason {
-	isShanghai := p.isShanghai() || p.isAgra()
-	if isShanghai {
+	return p.validateTxWithProofs(txn, isLocal, stateCache, proofsNotChecked)
+}
+
+// validateTxWithProofs is validateTx of a txn whose KZG proofs may have been verified already, see checkKZGProofs
+func (p *TxPool) validateTxWithProofs(txn *types.TxSlot, isLocal bool, stateCache kvcache.CacheView, proofs proofsCheck) txpoolcfg.DiscardReason {
+	if fork, ok := txpoolcfg.TxTypeFork(txn.Type); !ok || !p.isActive(fork) {
+		if txn.Traced {
+			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx type not activated idHash=%x type=%d", txn.IDHash, txn.Type))
+		}
+		return txpoolcfg.TypeNotActivated
+	}
+	isShanghai := p.isActive(txpoolcfg.Shanghai)
+	// EIP-3860 only limits initcode, data of message calls is bounded by the gas limit instead
+	if isShanghai && txn.Creation {
 		if txn.DataLen > fixedgas.MaxInitCodeSize {
 			return txpoolcfg.InitCodeTooLarge
 		}
 	}
 	if txn.Type == types.BlobTxType {
-		if !p.isCancun() {
-			return txpoolcfg.TypeNotActivated
+		if reason := p.validateBlobFields(txn); reason != txpoolcfg.Success {
+			return reason
 		}
-		if txn.Creation {
-			return txpoolcfg.CreateBlobTxn
-		}
-		blobCount := uint64(len(txn.BlobHashes))
-		if blobCount == 0 {
-			return txpoolcfg.NoBlobs
-		}
-		if blobCount > p.maxBlobsPerBlock {
-			return txpoolcfg.TooManyBlobs
-		}
-		equalNumber := len(txn.BlobHashes) == len(txn.Blobs) &&
-			len(txn.Blobs) == len(txn.Commitments) &&
-			len(txn.Commitments) == len(txn.Proofs)
-
-		if !equalNumber {
-			return txpoolcfg.UnequalBlobTxExt
-		}
-
-		for i := 0; i < len(txn.Commitments); i++ {
-			if libkzg.KZGToVersionedHash(txn.Commitments[i]) != libkzg.VersionedHash(txn.BlobHashes[i]) {
-				return txpoolcfg.BlobHashCheckFail
+		if proofs == proofsNotChecked {
+			proofs = proofsInvalid
+			if p.verifyKZGProofs(txn) == nil {
+				proofs = proofsValid
 			}
 		}
-
-		// https://github.com/ethereum/consensus-specs/blob/017a8495f7671f5fff2075a9bfc9238c1a0982f8/specs/deneb/polynomial-commitments.md#verify_blob_kzg_proof_batch
-		kzgCtx := libkzg.Ctx()
-		err := kzgCtx.VerifyBlobKZGProofBatch(toBlobs(txn.Blobs), txn.Commitments, txn.Proofs)
-		if err != nil {
+		if proofs != proofsValid {
 			return txpoolcfg.UnmatchedBlobTxExt
 		}
 
@@ -879,13 +986,21 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 			}
 			return txpoolcfg.Spammer
 		}
-		if p.totalBlobsInPool.Load() >= p.cfg.TotalBlobPoolLimit {
+		if p.totalBlobsInPool.Load() >= p.cfg.TotalBlobPoolLimit && p.cheapestBlobTxnLocked(txn) == nil {
 			if txn.Traced {
 				p.logger.Info(fmt.Sprintf("TX TRACING: validateTx total blobs limit reached in pool limit=%x current blobs=%d", p.cfg.TotalBlobPoolLimit, p.totalBlobsInPool.Load()))
 			}
 			return txpoolcfg.BlobPoolOverflow
 		}
 	}
+	if txn.Type == types.SetCodeTxType {
+		if txn.Creation {
+			return txpoolcfg.CreateSetCodeTxn
+		}
+		if txn.AuthCount == 0 {
+			return txpoolcfg.NoAuthorizations
+		}
+	}
 
 	// Drop non-local transactions under our own minimal accepted gas price or tip
 	if !isLocal && uint256.NewInt(p.cfg.MinFeeCap).Cmp(&txn.FeeCap) == 1 {
@@ -894,6 +1009,13 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 		}
 		return txpoolcfg.UnderPriced
 	}
+	// the tip paid at the pending base fee, min(tip, feeCap - baseFee), a high tip doesn't make up for a low fee cap
+	if effectiveTip := txEffectiveTip(txn, p.pendingBaseFee.Load()); !isLocal && effectiveTip.LtUint64(p.cfg.MinTip) {
+		if txn.Traced {
+			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx underpriced idHash=%x local=%t, tip=%d, effectiveTip=%d, cfg.MinTip=%d", txn.IDHash, isLocal, txn.Tip, &effectiveTip, p.cfg.MinTip))
+		}
+		return txpoolcfg.TipTooLow
+	}
 	gas, reason := txpoolcfg.CalcIntrinsicGas(uint64(txn.DataLen), uint64(txn.DataNonZeroLen), nil, txn.Creation, true, true, isShanghai)
 	if txn.Traced {
 		p.logger.Info(fmt.Sprintf("TX TRACING: validateTx intrinsic gas idHash=%x gas=%d", txn.IDHash, gas))
@@ -916,6 +1038,16 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 		}
 		return txpoolcfg.Spammer
 	}
+	// a replacement doesn't add to the sender's txs
+	if !isLocal && p.cfg.MaxAccountTxs > 0 && uint64(p.all.count(txn.SenderID)) >= p.cfg.MaxAccountTxs && p.all.get(txn.SenderID, txn.Nonce) == nil {
+		if txn.Traced {
+			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx account tx limit idHash=%x txs=%d, limit=%d", txn.IDHash, p.all.count(txn.SenderID), p.cfg.MaxAccountTxs))
+		}
+		return txpoolcfg.AccountTxLimit
+	}
+	if reason := p.validateAuthoritiesLocked(txn, isLocal); reason != txpoolcfg.Success {
+		return reason
+	}
 
 	// Check nonce and balance
 	senderNonce, senderBalance, _ := p.senders.info(stateCache, txn.SenderID)
@@ -925,6 +1057,12 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 		}
 		return txpoolcfg.NonceTooLow
 	}
+	if !isLocal && p.cfg.MaxNonceGap > 0 && txn.Nonce-senderNonce > p.cfg.MaxNonceGap {
+		if txn.Traced {
+			p.logger.Info(fmt.Sprintf("TX TRACING: validateTx nonce too distant idHash=%x nonce in state=%d, txn.nonce=%d", txn.IDHash, senderNonce, txn.Nonce))
+		}
+		return txpoolcfg.NonceTooDistant
+	}
 	// Transactor should have enough funds to cover the costs
 	total := requiredBalance(txn)
 	if senderBalance.Cmp(total) < 0 {
@@ -933,7 +1071,7 @@ func (p *TxPool) validateTx(txn *types.TxSlot, isLocal bool, stateCache kvcache.
 		}
 		return txpoolcfg.InsufficientFunds
 	}
-	return txpoolcfg.Success
+	return p.admitLocked(txn, isLocal, senderNonce, senderBalance)
 }
 
 var maxUint256 = new(uint256.Int).SetAllOne()
@@ -941,147 +1079,57 @@ var maxUint256 = new(uint256.Int).SetAllOne()
 // Sender should have enough balance for: gasLimit x feeCap + blobGas x blobFeeCap + transferred_value
 // See YP, Eq (61) in Section 6.2 "Execution"
 func requiredBalance(txn *types.TxSlot) *uint256.Int {
-	// See https://github.com/ethereum/EIPs/pull/3594
-	total := uint256.NewInt(txn.Gas)
-	_, overflow := total.MulOverflow(total, &txn.FeeCap)
-	if overflow {
-		return maxUint256
-	}
-	// and https://eips.ethereum.org/EIPS/eip-4844#gas-accounting
-	blobCount := uint64(len(txn.BlobHashes))
-	if blobCount != 0 {
-		maxBlobGasCost := uint256.NewInt(fixedgas.BlobGasPerBlob)
-		maxBlobGasCost.Mul(maxBlobGasCost, uint256.NewInt(blobCount))
-		_, overflow = maxBlobGasCost.MulOverflow(maxBlobGasCost, &txn.BlobFeeCap)
-		if overflow {
-			return maxUint256
-		}
-		_, overflow = total.AddOverflow(total, maxBlobGasCost)
-		if overflow {
-			return maxUint256
-		}
-	}
-
-	_, overflow = total.AddOverflow(total, &txn.Value)
-	if overflow {
+	total, err := types.Cost(txn)
+	if err != nil {
 		return maxUint256
 	}
-	return total
+	return &total
 }
 
-func (p *TxPool) isShanghai() bool {
-	// once this flag has been set for the first time we no longer need to check the timestamp
-	set := p.isPostShanghai.Load()
-	if set {
-		return true
-	}
-	if p.shanghaiTime == nil {
-		return false
-	}
-	shanghaiTime := *p.shanghaiTime
-
-	// a zero here means Shanghai is always active
-	if shanghaiTime == 0 {
-		p.isPostShanghai.Swap(true)
+// isActive reports whether the fork is active in the next block, built on top of the last seen one
+func (p *TxPool) isActive(fork txpoolcfg.Fork) bool {
+	if p.activeForks[fork].Load() {
 		return true
 	}
-
-	now := time.Now().Unix()
-	activated := uint64(now) >= shanghaiTime
-	if activated {
-		p.isPostShanghai.Swap(true)
+	active := p.rules.IsActive(fork, p.lastSeenBlock.Load()+1, uint64(time.Now().Unix()))
+	if active {
+		p.activeForks[fork].Store(true)
 	}
-	return activated
+	return active
 }
 
-func (p *TxPool) isAgra() bool {
-	// once this flag has been set for the first time we no longer need to check the block
-	set := p.isPostAgra.Load()
-	if set {
-		return true
-	}
-	if p.agraBlock == nil {
-		return false
-	}
-	agraBlock := *p.agraBlock
-
-	// a zero here means Agra is always active
-	if agraBlock == 0 {
-		p.isPostAgra.Swap(true)
-		return true
-	}
-
-	tx, err := p._chainDB.BeginRo(context.Background())
-	if err != nil {
-		return false
-	}
-	defer tx.Rollback()
-
-	head_block, err := chain.CurrentBlockNumber(tx)
-	if head_block == nil || err != nil {
-		return false
-	}
-	// A new block is built on top of the head block, so when the head is agraBlock-1,
-	// the new block should use the Agra rules.
-	activated := (*head_block + 1) >= agraBlock
-	if activated {
-		p.isPostAgra.Swap(true)
-	}
-	return activated
-}
-
-func (p *TxPool) isCancun() bool {
-	// once this flag has been set for the first time we no longer need to check the timestamp
-	set := p.isPostCancun.Load()
-	if set {
-		return true
-	}
-	if p.cancunTime == nil {
-		return false
-	}
-	cancunTime := *p.cancunTime
-
-	// a zero here means Cancun is always active
-	if cancunTime == 0 {
-		p.isPostCancun.Swap(true)
-		return true
-	}
+const (
+	// txSlotSize is used to calculate how many data slots a single transaction
+	// takes up based on its size. The slots are used as DoS protection, ensuring
+	// that validating a new transaction remains a constant operation (in reality
+	// O(maxslots), where max slots are 4 currently).
+	txSlotSize = 32 * 1024
+
+	// txMaxSize is the maximum size a single transaction can have. This field has
+	// non-trivial consequences: larger transactions are significantly harder and
+	// more expensive to propagate; larger transactions also take more resources
+	// to validate whether they fit into the pool or not.
+	txMaxSize = 4 * txSlotSize // 128KB
+
+	// Should be enough for a transaction with 6 blobs
+	blobTxMaxSize = 800_000
+)
 
-	now := time.Now().Unix()
-	activated := uint64(now) >= cancunTime
-	if activated {
-		p.isPostCancun.Swap(true)
+// maxTxSize returns the maximum size of the serialized transaction of the given type
+func maxTxSize(txType byte) int {
+	if txType == types.BlobTxType {
+		return blobTxMaxSize
 	}
-	return activated
+	return txMaxSize
 }
 
 // Check that that the serialized txn should not exceed a certain max size
 func (p *TxPool) ValidateSerializedTxn(serializedTxn []byte) error {
-	const (
-		// txSlotSize is used to calculate how many data slots a single transaction
-		// takes up based on its size. The slots are used as DoS protection, ensuring
-		// that validating a new transaction remains a constant operation (in reality
-		// O(maxslots), where max slots are 4 currently).
-		txSlotSize = 32 * 1024
-
-		// txMaxSize is the maximum size a single transaction can have. This field has
-		// non-trivial consequences: larger transactions are significantly harder and
-		// more expensive to propagate; larger transactions also take more resources
-		// to validate whether they fit into the pool or not.
-		txMaxSize = 4 * txSlotSize // 128KB
-
-		// Should be enough for a transaction with 6 blobs
-		blobTxMaxSize = 800_000
-	)
 	txType, err := types.PeekTransactionType(serializedTxn)
 	if err != nil {
 		return err
 	}
-	maxSize := txMaxSize
-	if txType == types.BlobTxType {
-		maxSize = blobTxMaxSize
-	}
-	if len(serializedTxn) > maxSize {
+	if len(serializedTxn) > maxTxSize(txType) {
 		return types.ErrRlpTooBig
 	}
 	return nil
@@ -1096,9 +1144,13 @@ func (p *TxPool) validateTxs(txs *types.TxSlots, stateCache kvcache.CacheView) (
 		return reasons, goodTxs, err
 	}
 
+	proofs := p.checkKZGProofs(txs.Txs)
 	goodCount := 0
 	for i, txn := range txs.Txs {
-		reason := p.validateTx(txn, txs.IsLocal[i], stateCache)
+		if !txs.IsLocal[i] && len(p.localSenders) > 0 {
+			_, txs.IsLocal[i] = p.localSenders[common.BytesToAddress(txs.Senders.At(i))]
+		}
+		reason := p.validateTxWithProofs(txn, txs.IsLocal[i], stateCache, proofs[i])
 		if reason == txpoolcfg.Success {
 			goodCount++
 			// Success here means no DiscardReason yet, so leave it NotSet
@@ -1152,6 +1204,64 @@ func (p *TxPool) punishSpammer(spammer uint64) {
 	}
 }
 
+// expireLocked discards non-local transactions which were added to the pool longer than cfg.Lifetime ago.
+// byArrival is ordered by the time of adding, so only the expired transactions are visited
+func (p *TxPool) expireLocked(now time.Time) {
+	if p.cfg.Lifetime <= 0 {
+		return
+	}
+	deadline := now.Add(-p.cfg.Lifetime).Unix()
+	var expired int
+	for ; expired < len(p.byArrival) && p.byArrival[expired].addedAt <= deadline; expired++ {
+		mt := p.byArrival[expired]
+		p.byArrival[expired] = nil
+		if p.byHash[string(mt.Tx.IDHash[:])] != mt {
+			continue // already removed or replaced
+		}
+		switch mt.currentSubPool {
+		case PendingSubPool:
+			p.pending.Remove(mt, "expire", p.logger)
+		case BaseFeeSubPool:
+			p.baseFee.Remove(mt, "expire", p.logger)
+		case QueuedSubPool:
+			p.queued.Remove(mt, "expire", p.logger)
+		default:
+			//already removed
+		}
+		p.discardLocked(mt, txpoolcfg.Expired)
+		p.markNonceGapLocked(mt)
+	}
+	p.byArrival = p.byArrival[expired:]
+
+	// Drop the transactions removed for other reasons, so that they don't accumulate until their lifetime passes
+	if len(p.byArrival) > 2*len(p.byHash) {
+		alive := p.byArrival[:0]
+		for _, mt := range p.byArrival {
+			if p.byHash[string(mt.Tx.IDHash[:])] == mt {
+				alive = append(alive, mt)
+			}
+		}
+		for i := len(alive); i < len(p.byArrival); i++ {
+			p.byArrival[i] = nil
+		}
+		p.byArrival = alive
+	}
+}
+
+// markNonceGapLocked schedules the sender of the discarded mt for onSenderStateChange by the next addTxs or
+// addTxsOnNewBlock: mt may be not the highest nonce of the sender, then its later txs don't have NoNonceGaps anymore
+func (p *TxPool) markNonceGapLocked(mt *metaTx) {
+	p.nonceGaps[mt.Tx.SenderID] = struct{}{}
+}
+
+// takeNonceGapsLocked moves the senders scheduled by markNonceGapLocked to sendersWithChangedState
+func (p *TxPool) takeNonceGapsLocked(sendersWithChangedState map[uint64]struct{}) {
+	for senderID := range p.nonceGaps {
+		sendersWithChangedState[senderID] = struct{}{}
+		delete(p.nonceGaps, senderID)
+	}
+}
+
 func fillDiscardReasons(reasons []txpoolcfg.DiscardReason, newTxs types.TxSlots, discardReasonsLRU *simplelru.LRU[string, txpoolcfg.DiscardReason]) []txpoolcfg.DiscardReason {
 	for i := range reasons {
 		if reasons[i] != txpoolcfg.NotSet {
@@ -1168,6 +1278,20 @@ func fillDiscardReasons(reasons []txpoolcfg.DiscardReason, newTxs types.TxSlots,
 }
 
 func (p *TxPool) AddLocalTxs(ctx context.Context, newTransactions types.TxSlots, tx kv.Tx) ([]txpoolcfg.DiscardReason, error) {
+	return p.addLocalTxs(ctx, newTransactions, nil)
+}
+
+// AddLocalTxsWithConditions adds local txs, the ones with non-nil conditions (validated by the caller) are conditional:
+// they are not propagated to peers, which would include them regardless of the conditions, nor persisted, and are
+// dropped once the conditions can't be met anymore. Block building checks them with Conditions and DropConditional
+func (p *TxPool) AddLocalTxsWithConditions(ctx context.Context, newTransactions types.TxSlots, conditions []*TxConditions, tx kv.Tx) ([]txpoolcfg.DiscardReason, error) {
+	if len(conditions) != len(newTransactions.Txs) {
+		return nil, fmt.Errorf("%w: %d conditions for %d txs", ErrConditionsInvalid, len(conditions), len(newTransactions.Txs))
+	}
+	return p.addLocalTxs(ctx, newTransactions, conditions)
+}
+
+func (p *TxPool) addLocalTxs(ctx context.Context, newTransactions types.TxSlots, conditions []*TxConditions) ([]txpoolcfg.DiscardReason, error) {
 	coreDb, cache := p.coreDBWithCache()
 	coreTx, err := coreDb.BeginRo(ctx)
 	if err != nil {
@@ -1210,6 +1334,11 @@ func (p *TxPool) AddLocalTxs(ctx context.Context, newTransactions types.TxSlots,
 	for i, reason := range reasons {
 		if reason == txpoolcfg.Success {
 			txn := newTxs.Txs[i]
+			if conditions != nil && conditions[i] != nil {
+				if _, ok := p.byHash[string(txn.IDHash[:])]; ok {
+					p.conditions[string(txn.IDHash[:])] = conditions[i]
+				}
+			}
 			if txn.Traced {
 				p.logger.Info(fmt.Sprintf("TX TRACING: AddLocalTxs promotes idHash=%x, senderId=%d", txn.IDHash, txn.SenderID))
 			}
@@ -1272,6 +1401,7 @@ func (p *TxPool) addTxs(blockNum uint64, cacheView kvcache.CacheView, senders *s
 		}
 		sendersWithChangedState[mt.Tx.SenderID] = struct{}{}
 	}
+	p.takeNonceGapsLocked(sendersWithChangedState)
 
 	for senderID := range sendersWithChangedState {
 		nonce, balance, err := senders.info(cacheView, senderID)
@@ -1336,6 +1466,7 @@ func (p *TxPool) addTxsOnNewBlock(blockNum uint64, cacheView kvcache.CacheView,
 			}
 		}
 	}
+	p.takeNonceGapsLocked(sendersWithChangedState)
 
 	for senderID := range sendersWithChangedState {
 		nonce, balance, err := senders.info(cacheView, senderID)
@@ -1357,10 +1488,13 @@ func (p *TxPool) setBaseFee(baseFee uint64) (uint64, bool) {
 	return p.pendingBaseFee.Load(), changed
 }
 
-func (p *TxPool) setBlobFee(blobFee uint64) {
+func (p *TxPool) setBlobFee(blobFee uint64) (uint64, bool) {
+	changed := false
 	if blobFee > 0 {
+		changed = blobFee != p.pendingBlobFee.Load()
 		p.pendingBlobFee.Store(blobFee)
 	}
+	return p.pendingBlobFee.Load(), changed
 }
 
 func (p *TxPool) addLocked(mt *metaTx, announcements *types.Announcements) txpoolcfg.DiscardReason {
@@ -1425,6 +1559,26 @@ func (p *TxPool) addLocked(mt *metaTx, announcements *types.Announcements) txpoo
 	if mt.Tx.Type == types.BlobTxType && mt.Tx.BlobFeeCap.LtUint64(p.pendingBlobFee.Load()) {
 		return txpoolcfg.FeeTooLow
 	}
+	// Make room for the blobs by evicting the blob txs paying less for blob gas
+	if mt.Tx.Type == types.BlobTxType {
+		for p.totalBlobsInPool.Load()+uint64(len(mt.Tx.BlobHashes)) > p.cfg.TotalBlobPoolLimit {
+			cheapest := p.cheapestBlobTxnLocked(mt.Tx)
+			if cheapest == nil {
+				return txpoolcfg.BlobPoolOverflow
+			}
+			switch cheapest.currentSubPool {
+			case PendingSubPool:
+				p.pending.Remove(cheapest, "evictBlobs", p.logger)
+			case BaseFeeSubPool:
+				p.baseFee.Remove(cheapest, "evictBlobs", p.logger)
+			case QueuedSubPool:
+				p.queued.Remove(cheapest, "evictBlobs", p.logger)
+			default:
+				//already removed
+			}
+			p.discardLocked(cheapest, txpoolcfg.BlobPoolOverflow)
+		}
+	}
 
 	hashStr := string(mt.Tx.IDHash[:])
 	p.byHash[hashStr] = mt
@@ -1437,13 +1591,20 @@ func (p *TxPool) addLocked(mt *metaTx, announcements *types.Announcements) txpoo
 
 	if mt.subPool&IsLocal != 0 {
 		p.isLocalLRU.Add(hashStr, struct{}{})
+	} else if p.cfg.Lifetime > 0 {
+		mt.addedAt = time.Now().Unix()
+		p.byArrival = append(p.byArrival, mt)
 	}
 	// All transactions are first added to the queued pool and then immediately promoted from there if required
 	p.queued.Add(mt, "addLocked", p.logger)
+	p.emitLocked(TxAdded, mt, txpoolcfg.NotSet)
 	if mt.Tx.Type == types.BlobTxType {
 		t := p.totalBlobsInPool.Load()
 		p.totalBlobsInPool.Store(t + (uint64(len(mt.Tx.BlobHashes))))
+		p.blobTxs[mt] = struct{}{}
+		p.blobs.add(mt.Tx)
 	}
+	p.auths.add(mt)
 
 	// Remove from mined cache as we are now "resurrecting" it to a sub-pool
 	p.deleteMinedBlobTxn(hashStr)
@@ -1458,10 +1619,35 @@ func (p *TxPool) discardLocked(mt *metaTx, reason txpoolcfg.DiscardReason) {
 	p.deletedTxs = append(p.deletedTxs, mt)
 	p.all.delete(mt, reason, p.logger)
 	p.discardReasonsLRU.Add(hashStr, reason)
-	if mt.Tx.Type == types.BlobTxType {
+	if _, ok := p.blobTxs[mt]; ok { // not when mt was rejected by addLocked
 		t := p.totalBlobsInPool.Load()
 		p.totalBlobsInPool.Store(t - uint64(len(mt.Tx.BlobHashes)))
+		delete(p.blobTxs, mt)
+		p.blobs.remove(mt.Tx)
+	}
+	p.auths.remove(mt)
+	delete(p.conditions, hashStr)
+	p.breakNonceRunLocked(mt)
+	p.emitLocked(TxDiscarded, mt, reason)
+}
+
+// cheapestBlobTxnLocked returns the non-local blob txn with the lowest blob fee cap, lower than the one of txn, which
+// can be evicted to make room for txn. Only the highest nonce txs of the senders are evicted, so as not to open nonce
+// gaps, and txs of the sender of txn are not
+func (p *TxPool) cheapestBlobTxnLocked(txn *types.TxSlot) *metaTx {
+	var cheapest *metaTx
+	for mt := range p.blobTxs {
+		if mt.subPool&IsLocal != 0 || mt.Tx.SenderID == txn.SenderID || !mt.Tx.BlobFeeCap.Lt(&txn.BlobFeeCap) {
+			continue
+		}
+		if nonce, _ := p.all.nonce(mt.Tx.SenderID); nonce != mt.Tx.Nonce {
+			continue
+		}
+		if cheapest == nil || mt.Tx.BlobFeeCap.Lt(&cheapest.Tx.BlobFeeCap) {
+			cheapest = mt
+		}
 	}
+	return cheapest
 }
 
 // Cache recently mined blobs in anticipation of reorg, delete finalized ones
@@ -1484,7 +1670,8 @@ func (p *TxPool) processMinedFinalizedBlobs(coreTx kv.Tx, minedTxs []*types.TxSl
 	p.minedBlobTxsByBlock[minedBlock] = make([]*metaTx, 0)
 	for _, txn := range minedTxs {
 		if txn.Type == types.BlobTxType {
-			mt := &metaTx{Tx: txn, minedBlockNum: minedBlock}
+			blobTxn := *txn // not to keep the slab of the mined txs alive, see Fetch.handleStateChangesRequest
+			mt := &metaTx{Tx: &blobTxn, minedBlockNum: minedBlock}
 			p.minedBlobTxsByBlock[minedBlock] = append(p.minedBlobTxsByBlock[minedBlock], mt)
 			mt.bestIndex = len(p.minedBlobTxsByBlock[minedBlock]) - 1
 			p.minedBlobTxsByHash[string(txn.IDHash[:])] = mt
@@ -1692,6 +1879,7 @@ func (p *TxPool) onSenderStateChange(senderID uint64, senderNonce uint64, sender
 	for _, mt := range toDel {
 		p.discardLocked(mt, txpoolcfg.NonceTooLow)
 	}
+	p.setNonceRunLocked(senderID, senderNonce, noGapsNonce)
 
 	logger.Trace("[txpool] onSenderStateChange", "sender", senderID, "count", p.all.count(senderID), "pending", p.pending.Len(), "baseFee", p.baseFee.Len(), "queued", p.queued.Len())
 }
@@ -1700,21 +1888,25 @@ func (p *TxPool) onSenderStateChange(senderID uint64, senderNonce uint64, sender
 // being promoted to the pending or basefee pool, for re-broadcasting
 func (p *TxPool) promote(pendingBaseFee uint64, pendingBlobFee uint64, announcements *types.Announcements, logger log.Logger) {
 	// Demote worst transactions that do not qualify for pending sub pool anymore, to other sub pools, or discard
-	for worst := p.pending.Worst(); p.pending.Len() > 0 && (worst.subPool < BaseFeePoolBits || worst.minFeeCap.LtUint64(pendingBaseFee) || (worst.Tx.Type == types.BlobTxType && worst.Tx.BlobFeeCap.LtUint64(pendingBlobFee))); worst = p.pending.Worst() {
+	for worst := p.pending.Worst(); p.pending.Len() > 0 && (worst.subPool < BaseFeePoolBits || !worst.enoughFeeCap(uint256.NewInt(pendingBaseFee), pendingBlobFee)); worst = p.pending.Worst() {
 		if worst.subPool >= BaseFeePoolBits {
 			tx := p.pending.PopWorst()
 			announcements.Append(tx.Tx.Type, tx.Tx.Size, tx.Tx.IDHash[:])
 			p.baseFee.Add(tx, "demote-pending", logger)
+			p.emitLocked(TxDemoted, tx, txpoolcfg.NotSet)
 		} else {
-			p.queued.Add(p.pending.PopWorst(), "demote-pending", logger)
+			tx := p.pending.PopWorst()
+			p.queued.Add(tx, "demote-pending", logger)
+			p.emitLocked(TxDemoted, tx, txpoolcfg.NotSet)
 		}
 	}
 
 	// Promote best transactions from base fee pool to pending pool while they qualify
-	for best := p.baseFee.Best(); p.baseFee.Len() > 0 && best.subPool >= BaseFeePoolBits && best.minFeeCap.CmpUint64(pendingBaseFee) >= 0 && (best.Tx.Type != types.BlobTxType || best.Tx.BlobFeeCap.CmpUint64(pendingBlobFee) >= 0); best = p.baseFee.Best() {
+	for best := p.baseFee.Best(); p.baseFee.Len() > 0 && best.subPool >= BaseFeePoolBits && best.enoughFeeCap(uint256.NewInt(pendingBaseFee), pendingBlobFee); best = p.baseFee.Best() {
 		tx := p.baseFee.PopBest()
 		announcements.Append(tx.Tx.Type, tx.Tx.Size, tx.Tx.IDHash[:])
 		p.pending.Add(tx, logger)
+		p.emitLocked(TxPromoted, tx, txpoolcfg.NotSet)
 	}
 
 	// Demote worst transactions that do not qualify for base fee pool anymore, to queued sub pool, or discard
@@ -1724,10 +1916,11 @@ func (p *TxPool) promote(pendingBaseFee uint64, pendingBlobFee uint64, announcem
 
 	// Promote best transactions from the queued pool to either pending or base fee pool, while they qualify
 	for best := p.queued.Best(); p.queued.Len() > 0 && best.subPool >= BaseFeePoolBits; best = p.queued.Best() {
-		if best.minFeeCap.Cmp(uint256.NewInt(pendingBaseFee)) >= 0 {
+		if best.enoughFeeCap(uint256.NewInt(pendingBaseFee), pendingBlobFee) {
 			tx := p.queued.PopBest()
 			announcements.Append(tx.Tx.Type, tx.Tx.Size, tx.Tx.IDHash[:])
 			p.pending.Add(tx, logger)
+			p.emitLocked(TxPromoted, tx, txpoolcfg.NotSet)
 		} else {
 			p.baseFee.Add(p.queued.PopBest(), "promote-queued", logger)
 		}
@@ -1750,6 +1943,36 @@ func (p *TxPool) promote(pendingBaseFee uint64, pendingBlobFee uint64, announcem
 	for _ = p.queued.Worst(); p.queued.Len() > p.queued.limit; _ = p.queued.Worst() {
 		p.discardLocked(p.queued.PopWorst(), txpoolcfg.QueuedPoolOverflow)
 	}
+
+	// Discard worst non-local transactions until the pool is within its size limit, starting from the least valuable
+	// sub pool. The local ones popped meanwhile are put back
+	if limit := p.cfg.TotalPoolSize.Bytes(); limit > 0 {
+		for _, mt := range p.evictRemotesLocked(limit, p.queued.Len, p.queued.PopWorst) {
+			p.queued.Add(mt, "keepLocal", logger)
+		}
+		for _, mt := range p.evictRemotesLocked(limit, p.baseFee.Len, p.baseFee.PopWorst) {
+			p.baseFee.Add(mt, "keepLocal", logger)
+		}
+		for _, mt := range p.evictRemotesLocked(limit, p.pending.Len, p.pending.PopWorst) {
+			p.pending.Add(mt, logger)
+		}
+	}
+}
+
+// evictRemotesLocked pops the worst transactions of a sub pool while the pool is over the size limit, discards the
+// non-local ones and returns the local ones. It stops once only local transactions are left in the pool, which may be
+// over the limit on their own
+func (p *TxPool) evictRemotesLocked(limit uint64, length func() int, popWorst func() *metaTx) (locals []*metaTx) {
+	for p.all.size > limit && p.all.size > p.all.localSize && length() > 0 {
+		mt := popWorst()
+		if mt.subPool&IsLocal != 0 {
+			locals = append(locals, mt)
+			continue
+		}
+		p.discardLocked(mt, txpoolcfg.PoolSizeOverflow)
+		p.markNonceGapLocked(mt)
+	}
+	return locals
 }
 
 // txMaxBroadcastSize is the max size of a transaction that will be broadcasted.
@@ -1849,7 +2072,7 @@ func MainLoop(ctx context.Context, db kv.RwDB, p *TxPool, newTxs chan types.Anno
 				var remoteTxSizes []uint32
 				var remoteTxHashes types.Hashes
 				var remoteTxRlps [][]byte
-				var broadcastHashes types.Hashes
+				var broadcastHashes, remoteBroadcastHashes types.Hashes
 				slotsRlp := make([][]byte, 0, announcements.Len())
 
 				if err := db.View(ctx, func(tx kv.Tx) error {
@@ -1870,6 +2093,9 @@ func MainLoop(ctx context.Context, db kv.RwDB, p *TxPool, newTxs chan types.Anno
 
 						// Empty rlp can happen if a transaction we want to broadcast has just been mined, for example
 						slotsRlp = append(slotsRlp, slotRlp)
+						if p.Conditions(hash) != nil {
+							continue // peers would include it regardless of the conditions
+						}
 						if p.IsLocal(hash) {
 							localTxTypes = append(localTxTypes, t)
 							localTxSizes = append(localTxSizes, size)
@@ -1888,6 +2114,7 @@ func MainLoop(ctx context.Context, db kv.RwDB, p *TxPool, newTxs chan types.Anno
 							// "Nodes MUST NOT automatically broadcast blob transactions to their peers" - EIP-4844
 							if t != types.BlobTxType && len(slotRlp) < txMaxBroadcastSize {
 								remoteTxRlps = append(remoteTxRlps, slotRlp)
+								remoteBroadcastHashes = append(remoteBroadcastHashes, hash...)
 							}
 						}
 					}
@@ -1902,7 +2129,7 @@ func MainLoop(ctx context.Context, db kv.RwDB, p *TxPool, newTxs chan types.Anno
 
 				// broadcast local transactions
 				const localTxsBroadcastMaxPeers uint64 = 10
-				txSentTo := send.BroadcastPooledTxs(localTxRlps, localTxsBroadcastMaxPeers)
+				txSentTo := send.BroadcastPooledTxs(localTxRlps, broadcastHashes, localTxsBroadcastMaxPeers)
 				for i, peer := range txSentTo {
 					p.logger.Trace("Local tx broadcast", "txHash", hex.EncodeToString(broadcastHashes.At(i)), "to peer", peer)
 				}
@@ -1912,9 +2139,10 @@ func MainLoop(ctx context.Context, db kv.RwDB, p *TxPool, newTxs chan types.Anno
 					p.logger.Trace("Local tx announced", "txHash", hex.EncodeToString(hash), "to peer", hashSentTo[i], "baseFee", p.pendingBaseFee.Load())
 				}
 
-				// broadcast remote transactions
-				const remoteTxsBroadcastMaxPeers uint64 = 3
-				send.BroadcastPooledTxs(remoteTxRlps, remoteTxsBroadcastMaxPeers)
+				// broadcast remote transactions: bodies to sqrt(peers), announcements to the rest
+				const remoteTxsBroadcastMinPeers uint64 = 3
+				remoteTxsBroadcastMaxPeers := send.BroadcastMaxPeers(remoteTxsBroadcastMinPeers)
+				send.BroadcastPooledTxs(remoteTxRlps, remoteBroadcastHashes, remoteTxsBroadcastMaxPeers)
 				send.AnnouncePooledTxs(remoteTxTypes, remoteTxSizes, remoteTxHashes, remoteTxsBroadcastMaxPeers*2)
 			}()
 		case <-syncToNewPeersEvery.C: // new peer
@@ -2015,6 +2243,9 @@ func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
 		if metaTx.Tx.Rlp == nil {
 			continue
 		}
+		if _, ok := p.conditions[txHash]; ok {
+			continue // would be restored without its conditions
+		}
 		v = common.EnsureEnoughSize(v, 20+len(metaTx.Tx.Rlp))
 
 		addr, ok := p.senders.senderID2Addr[metaTx.Tx.SenderID]
@@ -2036,6 +2267,7 @@ func (p *TxPool) flushLocked(tx kv.RwTx) (err error) {
 			}
 		}
 		metaTx.Tx.Rlp = nil
+		metaTx.Tx.Blobs = nil // sub-slices of the Rlp, read back from the db by GetBlobsByHash
 	}
 
 	binary.BigEndian.PutUint64(encID, p.pendingBaseFee.Load())
@@ -2103,6 +2335,10 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 	txs := types.TxSlots{}
 	parseCtx := types.NewTxParseContext(p.chainID)
 	parseCtx.WithSender(false)
+	// the method selector is needed by the admission filters
+	parseCtx.WithDataPrefix(true)
+	var authParseCtx *types.TxParseContext // recovers the authorities of set code txs, which come with the sender only
+	var sender [20]byte
 
 	i := 0
 	it, err = tx.Range(kv.PoolTransaction, nil, nil)
@@ -2115,7 +2351,7 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 			return err
 		}
 		addr, txRlp := *(*[20]byte)(v[:20]), v[20:]
-		txn := &types.TxSlot{}
+		txn := &types.TxSlot{} // not from a TxSlotSlab, the pool drops its txs one by one
 
 		// TODO(eip-4844) ensure wrappedWithBlobs when transactions are saved to the DB
 		_, err = parseCtx.ParseTransaction(txRlp, 0, txn, nil, false /* hasEnvelope */, true /*wrappedWithBlobs*/, nil)
@@ -2124,6 +2360,16 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 			p.logger.Warn("[txpool] fromDB: parseTransaction", "err", err)
 			continue
 		}
+		if txn.Type == types.SetCodeTxType {
+			if authParseCtx == nil {
+				authParseCtx = types.NewTxParseContext(p.chainID)
+				authParseCtx.WithDataPrefix(true)
+			}
+			if _, err = authParseCtx.ParseTransaction(txRlp, 0, txn, sender[:], false /* hasEnvelope */, true /*wrappedWithBlobs*/, nil); err != nil {
+				p.logger.Warn("[txpool] fromDB: parseTransaction", "err", fmt.Errorf("err: %w, rlp: %x", err, txRlp))
+				continue
+			}
+		}
 		txn.Rlp = nil // means that we don't need store it in db anymore
 
 		txn.SenderID, txn.Traced = p.senders.getOrCreateID(addr, p.logger)
@@ -2131,9 +2377,12 @@ func (p *TxPool) fromDB(ctx context.Context, tx kv.Tx, coreTx kv.Tx) error {
 
 		isLocalTx := p.isLocalLRU.Contains(string(k))
 
+		// e.g. rejected by the admission rules of the new config, the other pooled txs are kept
 		if reason := p.validateTx(txn, isLocalTx, cacheView); reason != txpoolcfg.NotSet && reason != txpoolcfg.Success {
-			return nil // TODO: Clarify - if one of the txs has the wrong reason, no pooled txs!
+			p.logger.Debug("[txpool] fromDB: rejected", "idHash", fmt.Sprintf("%x", txn.IDHash), "reason", reason)
+			continue
 		}
+		txn.Blobs = nil // verified, and sub-slices of the db value
 		txs.Resize(uint(i + 1))
 		txs.Txs[i] = txn
 		txs.IsLocal[i] = isLocalTx
@@ -2326,6 +2575,107 @@ func (p *TxPool) deprecatedForEach(_ context.Context, f func(rlp []byte, sender
 	})
 }
 
+// PoolTxn is a transaction of the pool, as returned by Content, ContentFrom and GetTxn
+type PoolTxn struct {
+	Slot    *types.TxSlot // shared with the pool, must not be modified
+	Sender  common.Address
+	SubPool SubPoolType
+	Rlp     []byte
+}
+
+// Summary describes the transaction in the form of txpool_inspect: "to: value wei + gas gas × feeCap wei"
+func (t PoolTxn) Summary() string {
+	to := "contract creation"
+	if !t.Slot.Creation {
+		to = t.Slot.To().Hex()
+	}
+	return fmt.Sprintf("%s: %s wei + %d gas × %s wei", to, t.Slot.Value.Dec(), t.Slot.Gas, t.Slot.FeeCap.Dec())
+}
+
+// Content returns the transactions of the pool ordered by sender and nonce, e.g. for txpool_content and txpool_inspect.
+// Senders are paginated: the first offset of them are skipped and transactions of at most limit senders are returned,
+// limit 0 means all of them. Use CountContent for the numbers of transactions in the sub pools
+func (p *TxPool) Content(tx kv.Tx, offset, limit int) (txns []PoolTxn, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	senders, lastSender := 0, uint64(0)
+	p.all.ascendAll(func(mt *metaTx) bool {
+		if senders == 0 || mt.Tx.SenderID != lastSender {
+			senders++
+			lastSender = mt.Tx.SenderID
+		}
+		if senders <= offset {
+			return true
+		}
+		if limit > 0 && senders > offset+limit {
+			return false
+		}
+		var txn PoolTxn
+		var ok bool
+		if txn, ok, err = p.poolTxnLocked(tx, mt); err != nil {
+			return false
+		}
+		if ok {
+			txns = append(txns, txn)
+		}
+		return true
+	})
+	return txns, err
+}
+
+// GetTxn returns the transaction of the pool with the given hash, together with its sender and sub pool, e.g. for
+// eth_getTransactionByHash of not yet mined transactions. Use GetRlp when only the encoding is needed
+func (p *TxPool) GetTxn(tx kv.Tx, hash []byte) (txn PoolTxn, ok bool, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	mt, ok := p.byHash[string(hash)]
+	if !ok {
+		return PoolTxn{}, false, nil
+	}
+	return p.poolTxnLocked(tx, mt)
+}
+
+// ContentFrom returns the transactions of a single sender ordered by nonce, e.g. for txpool_contentFrom.
+// Only the transactions of the sender are visited
+func (p *TxPool) ContentFrom(tx kv.Tx, addr common.Address) (txns []PoolTxn, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	senderID, ok := p.senders.getID(addr)
+	if !ok {
+		return nil, nil
+	}
+	p.all.ascend(senderID, func(mt *metaTx) bool {
+		var txn PoolTxn
+		if txn, ok, err = p.poolTxnLocked(tx, mt); err != nil {
+			return false
+		}
+		if ok {
+			txns = append(txns, txn)
+		}
+		return true
+	})
+	return txns, err
+}
+
+func (p *TxPool) poolTxnLocked(tx kv.Tx, mt *metaTx) (PoolTxn, bool, error) {
+	sender, ok := p.senders.senderID2Addr[mt.Tx.SenderID]
+	if !ok {
+		return PoolTxn{}, false, nil
+	}
+	rlpTx := mt.Tx.Rlp
+	if rlpTx == nil {
+		v, err := tx.GetOne(kv.PoolTransaction, mt.Tx.IDHash[:])
+		if err != nil {
+			return PoolTxn{}, false, err
+		}
+		if v == nil {
+			return PoolTxn{}, false, nil
+		}
+		rlpTx = v[20:]
+	}
+	return PoolTxn{Slot: mt.Tx, Sender: sender, SubPool: mt.currentSubPool, Rlp: common.Copy(rlpTx)}, true, nil
+}
+
 var PoolChainConfigKey = []byte("chain_config")
 var PoolLastSeenBlockKey = []byte("last_seen_block")
 var PoolPendingBaseFeeKey = []byte("pending_base_fee")
@@ -2454,6 +2804,8 @@ type BySenderAndNonce struct {
 	search            *metaTx
 	senderIDTxnCount  map[uint64]int    // count of sender's txns in the pool - may differ from nonce
 	senderIDBlobCount map[uint64]uint64 // count of sender's total number of blobs in the pool
+	size              uint64            // total size of the transactions in the pool, see txpoolcfg.Config.TotalPoolSize
+	localSize         uint64            // total size of the local transactions, which aren't evicted over TotalPoolSize
 }
 
 func (b *BySenderAndNonce) nonce(senderID uint64) (nonce uint64, ok bool) {
@@ -2535,6 +2887,10 @@ func (b *BySenderAndNonce) has(mt *metaTx) bool {
 
 func (b *BySenderAndNonce) delete(mt *metaTx, reason txpoolcfg.DiscardReason, logger log.Logger) {
 	if _, ok := b.tree.Delete(mt); ok {
+		b.size -= uint64(mt.Tx.Size)
+		if mt.subPool&IsLocal != 0 {
+			b.localSize -= uint64(mt.Tx.Size)
+		}
 		if mt.Tx.Traced {
 			logger.Info("TX TRACING: Deleted tx by nonce", "idHash", fmt.Sprintf("%x", mt.Tx.IDHash), "sender", mt.Tx.SenderID, "nonce", mt.Tx.Nonce, "reason", reason)
 		}
@@ -2547,11 +2903,12 @@ func (b *BySenderAndNonce) delete(mt *metaTx, reason txpoolcfg.DiscardReason, lo
 			delete(b.senderIDTxnCount, senderID)
 		}
 
-		if mt.Tx.Type == types.BlobTxType && mt.Tx.Blobs != nil {
+		if mt.Tx.Type == types.BlobTxType {
+			// counted by versioned hashes, blobs are dropped from RAM once the tx is flushed
 			accBlobCount := b.senderIDBlobCount[senderID]
-			txnBlobCount := len(mt.Tx.Blobs)
-			if txnBlobCount > 1 {
-				b.senderIDBlobCount[senderID] = accBlobCount - uint64(txnBlobCount)
+			txnBlobCount := uint64(len(mt.Tx.BlobHashes))
+			if accBlobCount > txnBlobCount {
+				b.senderIDBlobCount[senderID] = accBlobCount - txnBlobCount
 			} else {
 				delete(b.senderIDBlobCount, senderID)
 			}
@@ -2561,8 +2918,16 @@ func (b *BySenderAndNonce) delete(mt *metaTx, reason txpoolcfg.DiscardReason, lo
 
 func (b *BySenderAndNonce) replaceOrInsert(mt *metaTx, logger log.Logger) *metaTx {
 	it, ok := b.tree.ReplaceOrInsert(mt)
+	b.size += uint64(mt.Tx.Size)
+	if mt.subPool&IsLocal != 0 {
+		b.localSize += uint64(mt.Tx.Size)
+	}
 
 	if ok {
+		b.size -= uint64(it.Tx.Size)
+		if it.subPool&IsLocal != 0 {
+			b.localSize -= uint64(it.Tx.Size)
+		}
 		if mt.Tx.Traced {
 			logger.Info("TX TRACING: Replaced tx by nonce", "idHash", fmt.Sprintf("%x", mt.Tx.IDHash), "sender", mt.Tx.SenderID, "nonce", mt.Tx.Nonce)
 		}
@@ -2574,8 +2939,8 @@ func (b *BySenderAndNonce) replaceOrInsert(mt *metaTx, logger log.Logger) *metaT
 	}
 
 	b.senderIDTxnCount[mt.Tx.SenderID]++
-	if mt.Tx.Type == types.BlobTxType && mt.Tx.Blobs != nil {
-		b.senderIDBlobCount[mt.Tx.SenderID] += uint64(len(mt.Tx.Blobs))
+	if mt.Tx.Type == types.BlobTxType {
+		b.senderIDBlobCount[mt.Tx.SenderID] += uint64(len(mt.Tx.BlobHashes))
 	}
 	return nil
 }
@@ -2584,39 +2949,55 @@ func (b *BySenderAndNonce) replaceOrInsert(mt *metaTx, logger log.Logger) *metaT
 // It's more expensive to maintain "slice sort" invariant, but it allow do cheap copy of
 // pending.best slice for mining (because we consider txs and metaTx are immutable)
 type PendingPool struct {
-	best  *bestSlice
-	worst *WorstQueue
+	best  *bestSlice[*metaTx]
+	worst *WorstQueue[*metaTx]
 	limit int
 	t     SubPoolType
 }
 
 func NewPendingSubPool(t SubPoolType, limit int) *PendingPool {
-	return &PendingPool{limit: limit, t: t, best: &bestSlice{ms: []*metaTx{}}, worst: &WorstQueue{ms: []*metaTx{}}}
+	return &PendingPool{limit: limit, t: t, best: &bestSlice[*metaTx]{ms: []*metaTx{}}, worst: &WorstQueue[*metaTx]{ms: []*metaTx{}}}
 }
 
+// queueItem is an element of the queues below: metaTx, or userOpMeta of the UserOpPool. The queues order the items
+// by better and worse at the pending fees, and maintain the bestIndex and worstIndex of the items
+type queueItem[T any] interface {
+	better(than T, pendingBaseFee uint256.Int, pendingBlobFee uint64) bool
+	worse(than T, pendingBaseFee uint256.Int, pendingBlobFee uint64) bool
+	indexes() (bestIndex, worstIndex *int)
+}
+
+func (mt *metaTx) indexes() (bestIndex, worstIndex *int) { return &mt.bestIndex, &mt.worstIndex }
+
 // bestSlice - is similar to best queue, but uses a linear structure with O(n log n) sort complexity and
 // it maintains element.bestIndex field
-type bestSlice struct {
-	ms             []*metaTx
+type bestSlice[T queueItem[T]] struct {
+	ms             []T
 	pendingBaseFee uint64
+	pendingBlobFee uint64
 }
 
-func (s *bestSlice) Len() int { return len(s.ms) }
-func (s *bestSlice) Swap(i, j int) {
+func (s *bestSlice[T]) Len() int { return len(s.ms) }
+func (s *bestSlice[T]) Swap(i, j int) {
 	s.ms[i], s.ms[j] = s.ms[j], s.ms[i]
-	s.ms[i].bestIndex, s.ms[j].bestIndex = i, j
-}
-func (s *bestSlice) Less(i, j int) bool {
-	return s.ms[i].better(s.ms[j], *uint256.NewInt(s.pendingBaseFee))
-}
-func (s *bestSlice) UnsafeRemove(i *metaTx) {
-	s.Swap(i.bestIndex, len(s.ms)-1)
-	s.ms[len(s.ms)-1].bestIndex = -1
-	s.ms[len(s.ms)-1] = nil
+	bestI, _ := s.ms[i].indexes()
+	bestJ, _ := s.ms[j].indexes()
+	*bestI, *bestJ = i, j
+}
+func (s *bestSlice[T]) Less(i, j int) bool {
+	return s.ms[i].better(s.ms[j], *uint256.NewInt(s.pendingBaseFee), s.pendingBlobFee)
+}
+func (s *bestSlice[T]) UnsafeRemove(i T) {
+	bestIndex, _ := i.indexes()
+	s.Swap(*bestIndex, len(s.ms)-1)
+	*bestIndex = -1
+	var none T
+	s.ms[len(s.ms)-1] = none
 	s.ms = s.ms[:len(s.ms)-1]
 }
-func (s *bestSlice) UnsafeAdd(i *metaTx) {
-	i.bestIndex = len(s.ms)
+func (s *bestSlice[T]) UnsafeAdd(i T) {
+	bestIndex, _ := i.indexes()
+	*bestIndex = len(s.ms)
 	s.ms = append(s.ms, i)
 }
 
@@ -2644,6 +3025,7 @@ func (p *PendingPool) PopWorst() *metaTx { //nolint
 	if i.bestIndex >= 0 {
 		p.best.UnsafeRemove(i)
 	}
+	i.currentSubPool = 0 // for safety
 	return i
 }
 func (p *PendingPool) Updated(mt *metaTx) {
@@ -2682,14 +3064,14 @@ func (p *PendingPool) DebugPrint(prefix string) {
 }
 
 type SubPool struct {
-	best  *BestQueue
-	worst *WorstQueue
+	best  *BestQueue[*metaTx]
+	worst *WorstQueue[*metaTx]
 	limit int
 	t     SubPoolType
 }
 
 func NewSubPool(t SubPoolType, limit int) *SubPool {
-	return &SubPool{limit: limit, t: t, best: &BestQueue{}, worst: &WorstQueue{}}
+	return &SubPool{limit: limit, t: t, best: &BestQueue[*metaTx]{}, worst: &WorstQueue[*metaTx]{}}
 }
 
 func (p *SubPool) EnforceInvariants() {
@@ -2711,11 +3093,13 @@ func (p *SubPool) Worst() *metaTx { //nolint
 func (p *SubPool) PopBest() *metaTx { //nolint
 	i := heap.Pop(p.best).(*metaTx)
 	heap.Remove(p.worst, i.worstIndex)
+	i.currentSubPool = 0 // for safety
 	return i
 }
 func (p *SubPool) PopWorst() *metaTx { //nolint
 	i := heap.Pop(p.worst).(*metaTx)
 	heap.Remove(p.best, i.bestIndex)
+	i.currentSubPool = 0 // for safety
 	return i
 }
 func (p *SubPool) Len() int { return p.best.Len() }
@@ -2751,9 +3135,16 @@ func (p *SubPool) DebugPrint(prefix string) {
 	}
 }
 
-type BestQueue struct {
-	ms             []*metaTx
+type BestQueue[T queueItem[T]] struct {
+	ms             []T
 	pendingBastFee uint64
+	pendingBlobFee uint64
+}
+
+// enoughFeeCap reports whether the txn pays the fees of the pending block: the base fee and, for blob txs, the blob
+// base fee, see EnoughFeeCapBlock
+func (mt *metaTx) enoughFeeCap(pendingBaseFee *uint256.Int, pendingBlobFee uint64) bool {
+	return mt.minFeeCap.Cmp(pendingBaseFee) >= 0 && (mt.Tx.Type != types.BlobTxType || mt.Tx.BlobFeeCap.CmpUint64(pendingBlobFee) >= 0)
 }
 
 // Returns true if the txn "mt" is better than the parameter txn "than"
@@ -2762,13 +3153,13 @@ type BestQueue struct {
 // depending on the pool - pending (P), basefee (B), queued (Q) -
 // it compares the effective tip (for P), nonceDistance (for both P,Q)
 // minFeeCap (for B), and cumulative balance distance (for P, Q)
-func (mt *metaTx) better(than *metaTx, pendingBaseFee uint256.Int) bool {
+func (mt *metaTx) better(than *metaTx, pendingBaseFee uint256.Int, pendingBlobFee uint64) bool {
 	subPool := mt.subPool
 	thanSubPool := than.subPool
-	if mt.minFeeCap.Cmp(&pendingBaseFee) >= 0 {
+	if mt.enoughFeeCap(&pendingBaseFee, pendingBlobFee) {
 		subPool |= EnoughFeeCapBlock
 	}
-	if than.minFeeCap.Cmp(&pendingBaseFee) >= 0 {
+	if than.enoughFeeCap(&pendingBaseFee, pendingBlobFee) {
 		thanSubPool |= EnoughFeeCapBlock
 	}
 	if subPool != thanSubPool {
@@ -2824,13 +3215,13 @@ func (mt *metaTx) better(than *metaTx, pendingBaseFee uint256.Int) bool {
 	return mt.timestamp < than.timestamp
 }
 
-func (mt *metaTx) worse(than *metaTx, pendingBaseFee uint256.Int) bool {
+func (mt *metaTx) worse(than *metaTx, pendingBaseFee uint256.Int, pendingBlobFee uint64) bool {
 	subPool := mt.subPool
 	thanSubPool := than.subPool
-	if mt.minFeeCap.Cmp(&pendingBaseFee) >= 0 {
+	if mt.enoughFeeCap(&pendingBaseFee, pendingBlobFee) {
 		subPool |= EnoughFeeCapBlock
 	}
-	if than.minFeeCap.Cmp(&pendingBaseFee) >= 0 {
+	if than.enoughFeeCap(&pendingBaseFee, pendingBlobFee) {
 		thanSubPool |= EnoughFeeCapBlock
 	}
 	if subPool != thanSubPool {
@@ -2859,60 +3250,69 @@ func (mt *metaTx) worse(than *metaTx, pendingBaseFee uint256.Int) bool {
 	return mt.timestamp > than.timestamp
 }
 
-func (p BestQueue) Len() int { return len(p.ms) }
-func (p BestQueue) Less(i, j int) bool {
-	return p.ms[i].better(p.ms[j], *uint256.NewInt(p.pendingBastFee))
+func (p BestQueue[T]) Len() int { return len(p.ms) }
+func (p BestQueue[T]) Less(i, j int) bool {
+	return p.ms[i].better(p.ms[j], *uint256.NewInt(p.pendingBastFee), p.pendingBlobFee)
 }
-func (p BestQueue) Swap(i, j int) {
+func (p BestQueue[T]) Swap(i, j int) {
 	p.ms[i], p.ms[j] = p.ms[j], p.ms[i]
-	p.ms[i].bestIndex = i
-	p.ms[j].bestIndex = j
+	bestI, _ := p.ms[i].indexes()
+	bestJ, _ := p.ms[j].indexes()
+	*bestI = i
+	*bestJ = j
 }
-func (p *BestQueue) Push(x interface{}) {
+func (p *BestQueue[T]) Push(x interface{}) {
 	n := len(p.ms)
-	item := x.(*metaTx)
-	item.bestIndex = n
+	item := x.(T)
+	bestIndex, _ := item.indexes()
+	*bestIndex = n
 	p.ms = append(p.ms, item)
 }
 
-func (p *BestQueue) Pop() interface{} {
+func (p *BestQueue[T]) Pop() interface{} {
 	old := p.ms
 	n := len(old)
 	item := old[n-1]
-	old[n-1] = nil          // avoid memory leak
-	item.bestIndex = -1     // for safety
-	item.currentSubPool = 0 // for safety
+	var none T
+	old[n-1] = none // avoid memory leak
+	bestIndex, _ := item.indexes()
+	*bestIndex = -1 // for safety
 	p.ms = old[0 : n-1]
 	return item
 }
 
-type WorstQueue struct {
-	ms             []*metaTx
+type WorstQueue[T queueItem[T]] struct {
+	ms             []T
 	pendingBaseFee uint64
+	pendingBlobFee uint64
 }
 
-func (p WorstQueue) Len() int { return len(p.ms) }
-func (p WorstQueue) Less(i, j int) bool {
-	return p.ms[i].worse(p.ms[j], *uint256.NewInt(p.pendingBaseFee))
+func (p WorstQueue[T]) Len() int { return len(p.ms) }
+func (p WorstQueue[T]) Less(i, j int) bool {
+	return p.ms[i].worse(p.ms[j], *uint256.NewInt(p.pendingBaseFee), p.pendingBlobFee)
 }
-func (p WorstQueue) Swap(i, j int) {
+func (p WorstQueue[T]) Swap(i, j int) {
 	p.ms[i], p.ms[j] = p.ms[j], p.ms[i]
-	p.ms[i].worstIndex = i
-	p.ms[j].worstIndex = j
+	_, worstI := p.ms[i].indexes()
+	_, worstJ := p.ms[j].indexes()
+	*worstI = i
+	*worstJ = j
 }
-func (p *WorstQueue) Push(x interface{}) {
+func (p *WorstQueue[T]) Push(x interface{}) {
 	n := len(p.ms)
-	item := x.(*metaTx)
-	item.worstIndex = n
-	p.ms = append(p.ms, x.(*metaTx))
+	item := x.(T)
+	_, worstIndex := item.indexes()
+	*worstIndex = n
+	p.ms = append(p.ms, item)
 }
-func (p *WorstQueue) Pop() interface{} {
+func (p *WorstQueue[T]) Pop() interface{} {
 	old := p.ms
 	n := len(old)
 	item := old[n-1]
-	old[n-1] = nil          // avoid memory leak
-	item.worstIndex = -1    // for safety
-	item.currentSubPool = 0 // for safety
+	var none T
+	old[n-1] = none // avoid memory leak
+	_, worstIndex := item.indexes()
+	*worstIndex = -1 // for safety
 	p.ms = old[0 : n-1]
 	return item
 }
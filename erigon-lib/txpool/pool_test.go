@@ -19,13 +19,19 @@ package txpool
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 
 	// "crypto/rand"
 	"fmt"
 	"math"
-	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/c2h5oh/datasize"
 	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/log/v3"
@@ -34,16 +40,19 @@ import (
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/fixedgas"
+	"github.com/ledgerwatch/erigon-lib/common/hexutil"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/crypto/kzg"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/kvcache"
 	"github.com/ledgerwatch/erigon-lib/kv/memdb"
 	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
 	"github.com/ledgerwatch/erigon-lib/types"
+	"github.com/ledgerwatch/erigon-lib/types/testutil"
 )
 
 func TestNonceFromAddress(t *testing.T) {
@@ -53,7 +62,7 @@ func TestNonceFromAddress(t *testing.T) {
 
 	cfg := txpoolcfg.DefaultConfig
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, nil, nil, nil, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.True(pool != nil)
 	ctx := context.Background()
@@ -166,6 +175,81 @@ func TestNonceFromAddress(t *testing.T) {
 	}
 }
 
+func TestPendingState(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	sender, idle, unknown := common.Address{1}, common.Address{2}, common.Address{3}
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules())
+	applyBlock(t, pool, tx, testBlock{nonces: map[common.Address]uint64{sender: 2, idle: 7}})
+	ctx := context.Background()
+
+	// the 5 is queued behind a nonce gap
+	var txSlots types.TxSlots
+	for _, nonce := range []uint64{2, 3, 5} {
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(300000),
+			FeeCap: *uint256.NewInt(300000),
+			Gas:    100000,
+			Nonce:  nonce,
+		}
+		txSlot.IDHash[0] = byte(nonce)
+		txSlots.Append(txSlot, sender[:], true)
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(txpoolcfg.Success, reason, reason.String())
+	}
+
+	state, err := pool.PendingState(ctx, sender)
+	require.NoError(err)
+	var balance uint256.Int
+	balance.Sub(uint256.NewInt(1*common.Ether), uint256.NewInt(2*100000*300000))
+	assert.Equal(PendingState{Nonce: 4, Balance: balance, Txs: 2}, state)
+
+	state, err = pool.PendingState(ctx, idle)
+	require.NoError(err)
+	assert.Equal(PendingState{Nonce: 7, Balance: *uint256.NewInt(1 * common.Ether)}, state)
+
+	state, err = pool.PendingState(ctx, unknown)
+	require.NoError(err)
+	assert.Equal(PendingState{}, state)
+
+	nonce, inPool := pool.NonceFromPool(sender)
+	assert.True(inPool)
+	assert.Equal(uint64(3), nonce)
+	_, inPool = pool.NonceFromPool(idle)
+	assert.False(inPool)
+	_, inPool = pool.NonceFromPool(unknown)
+	assert.False(inPool)
+
+	// the 4 fills the gap
+	txSlots = types.TxSlots{}
+	txSlot := &types.TxSlot{Tip: *uint256.NewInt(300000), FeeCap: *uint256.NewInt(300000), Gas: 100000, Nonce: 4}
+	txSlot.IDHash[0] = 4
+	txSlots.Append(txSlot, sender[:], true)
+	reasons, err = pool.AddLocalTxs(ctx, txSlots, tx)
+	assert.NoError(err)
+	assert.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+	nonce, _ = pool.NonceFromPool(sender)
+	assert.Equal(uint64(5), nonce)
+
+	// the 2 and 3 are mined
+	applyBlock(t, pool, tx, testBlock{number: 1, nonces: map[common.Address]uint64{sender: 4}})
+	nonce, _ = pool.NonceFromPool(sender)
+	assert.Equal(uint64(5), nonce)
+
+	// discarding the 5 ends the run at the 4
+	senderID, _ := pool.senders.getID(sender)
+	pool.lock.Lock()
+	mt := pool.all.get(senderID, 5)
+	pool.pending.Remove(mt, "test", pool.logger)
+	pool.discardLocked(mt, txpoolcfg.PendingPoolOverflow)
+	pool.lock.Unlock()
+	nonce, inPool = pool.NonceFromPool(sender)
+	assert.True(inPool)
+	assert.Equal(uint64(4), nonce)
+}
+
 func TestReplaceWithHigherFee(t *testing.T) {
 	assert, require := assert.New(t), require.New(t)
 	ch := make(chan types.Announcements, 100)
@@ -173,7 +257,7 @@ func TestReplaceWithHigherFee(t *testing.T) {
 
 	cfg := txpoolcfg.DefaultConfig
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, nil, nil, nil, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.NotEqual(nil, pool)
 	ctx := context.Background()
@@ -290,7 +374,7 @@ func TestReverseNonces(t *testing.T) {
 
 	cfg := txpoolcfg.DefaultConfig
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, nil, nil, nil, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.True(pool != nil)
 	ctx := context.Background()
@@ -417,7 +501,7 @@ func TestTxPoke(t *testing.T) {
 
 	cfg := txpoolcfg.DefaultConfig
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, nil, nil, nil, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.True(pool != nil)
 	ctx := context.Background()
@@ -642,9 +726,10 @@ func TestShanghaiIntrinsicGas(t *testing.T) {
 func TestShanghaiValidateTx(t *testing.T) {
 	asrt := assert.New(t)
 	tests := map[string]struct {
-		expected   txpoolcfg.DiscardReason
-		dataLen    int
-		isShanghai bool
+		expected    txpoolcfg.DiscardReason
+		dataLen     int
+		isShanghai  bool
+		notCreation bool
 	}{
 		"no shanghai": {
 			expected:   txpoolcfg.Success,
@@ -666,6 +751,12 @@ func TestShanghaiValidateTx(t *testing.T) {
 			dataLen:    fixedgas.MaxInitCodeSize + 1,
 			isShanghai: true,
 		},
+		"shanghai over bound, not a creation": {
+			expected:    txpoolcfg.Success,
+			dataLen:     fixedgas.MaxInitCodeSize + 1,
+			isShanghai:  true,
+			notCreation: true,
+		},
 	}
 
 	logger := log.New()
@@ -676,13 +767,13 @@ func TestShanghaiValidateTx(t *testing.T) {
 			_, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
 			cfg := txpoolcfg.DefaultConfig
 
-			var shanghaiTime *big.Int
+			rules := testChainRules()
 			if test.isShanghai {
-				shanghaiTime = big.NewInt(0)
+				rules = testChainRules(txpoolcfg.Shanghai)
 			}
 
 			cache := &kvcache.DummyCache{}
-			pool, err := New(ch, coreDB, cfg, cache, *u256.N1, shanghaiTime, nil /* agraBlock */, nil /* cancunTime */, fixedgas.DefaultMaxBlobsPerBlock, nil, logger)
+			pool, err := New(ch, coreDB, cfg, cache, *u256.N1, rules, fixedgas.DefaultMaxBlobsPerBlock, nil, logger)
 			asrt.NoError(err)
 			ctx := context.Background()
 			tx, err := coreDB.BeginRw(ctx)
@@ -700,7 +791,7 @@ func TestShanghaiValidateTx(t *testing.T) {
 				FeeCap:   *uint256.NewInt(21000),
 				Gas:      500000,
 				SenderID: 0,
-				Creation: true,
+				Creation: !test.notCreation,
 			}
 
 			txns := types.TxSlots{
@@ -721,6 +812,779 @@ func TestShanghaiValidateTx(t *testing.T) {
 	}
 }
 
+// testRegisteredTxType is the proprietary tx type of a chain, see types.RegisterTxType
+const testRegisteredTxType = 0x7e
+
+var registerTestTxType sync.Once
+
+func TestForkScheduleValidateTx(t *testing.T) {
+	registerTestTxType.Do(func() {
+		types.RegisterTxType(testRegisteredTxType, func(ctx *types.TxParseContext, payload []byte, pos int, slot *types.TxSlot, sender []byte) (int, error) {
+			return len(payload), nil
+		})
+	})
+	// london by block, shanghai scheduled far in the future, berlin and cancun not scheduled
+	londonBlock, shanghaiTime := uint64(10), uint64(math.MaxUint64)
+	rules := &txpoolcfg.ChainRules{LondonBlock: &londonBlock, ShanghaiTime: &shanghaiTime}
+	runValidateTxTests(t, txpoolcfg.DefaultConfig, map[string]validateTxTest{
+		"legacy": {
+			expected: txpoolcfg.Success,
+			txn:      types.TxSlot{Type: types.LegacyTxType},
+			rules:    rules,
+		},
+		"access list before berlin": {
+			expected: txpoolcfg.TypeNotActivated,
+			txn:      types.TxSlot{Type: types.AccessListTxType},
+			rules:    rules,
+		},
+		"dynamic fee before london": {
+			expected:      txpoolcfg.TypeNotActivated,
+			txn:           types.TxSlot{Type: types.DynamicFeeTxType},
+			rules:         rules,
+			lastSeenBlock: 8,
+		},
+		"dynamic fee in the london block": {
+			expected:      txpoolcfg.Success,
+			txn:           types.TxSlot{Type: types.DynamicFeeTxType},
+			rules:         rules,
+			lastSeenBlock: 9,
+		},
+		"blob before cancun": {
+			expected:      txpoolcfg.TypeNotActivated,
+			txn:           types.TxSlot{Type: types.BlobTxType},
+			rules:         rules,
+			lastSeenBlock: 100,
+		},
+		"unknown type": {
+			expected:      txpoolcfg.TypeNotActivated,
+			txn:           types.TxSlot{Type: 0x7f},
+			rules:         rules,
+			lastSeenBlock: 100,
+		},
+		"registered type": {
+			expected:      txpoolcfg.Success,
+			txn:           types.TxSlot{Type: testRegisteredTxType},
+			rules:         rules,
+			lastSeenBlock: 100,
+		},
+		"initcode over bound before shanghai": {
+			expected:      txpoolcfg.Success,
+			txn:           types.TxSlot{Type: types.DynamicFeeTxType, Creation: true, DataLen: fixedgas.MaxInitCodeSize + 1},
+			rules:         rules,
+			lastSeenBlock: 100,
+		},
+	})
+}
+
+func TestChainRules(t *testing.T) {
+	agraBlock, cancunTime := uint64(100), uint64(1000)
+	rules := txpoolcfg.ChainRules{AgraBlock: &agraBlock, CancunTime: &cancunTime}
+	assert.False(t, rules.IsActive(txpoolcfg.Shanghai, 99, math.MaxUint64))
+	assert.True(t, rules.IsActive(txpoolcfg.Shanghai, 100, 0))
+	assert.False(t, rules.IsActive(txpoolcfg.Cancun, math.MaxUint64, 999))
+	assert.True(t, rules.IsActive(txpoolcfg.Cancun, 0, 1000))
+	assert.False(t, rules.IsActive(txpoolcfg.Prague, math.MaxUint64, math.MaxUint64))
+	assert.True(t, rules.IsActive(txpoolcfg.Frontier, 0, 0))
+
+	fork, ok := txpoolcfg.TxTypeFork(types.SetCodeTxType)
+	assert.True(t, ok)
+	assert.Equal(t, txpoolcfg.Prague, fork)
+	_, ok = txpoolcfg.TxTypeFork(0x7f)
+	assert.False(t, ok)
+}
+
+// testChainRules returns the rules of a chain with the forks up to London, and the given ones, active from genesis
+func testChainRules(forks ...txpoolcfg.Fork) txpoolcfg.ChainRules {
+	zero := uint64(0)
+	rules := txpoolcfg.ChainRules{BerlinBlock: &zero, LondonBlock: &zero}
+	for _, fork := range forks {
+		switch fork {
+		case txpoolcfg.Shanghai:
+			rules.ShanghaiTime = &zero
+		case txpoolcfg.Cancun:
+			rules.CancunTime = &zero
+		case txpoolcfg.Prague:
+			rules.PragueTime = &zero
+		}
+	}
+	return rules
+}
+
+// newTestPool returns a started pool, with an empty db, and a tx of its db
+func newTestPool(t *testing.T, cfg txpoolcfg.Config, rules txpoolcfg.ChainRules) (*TxPool, kv.RwTx) {
+	t.Helper()
+	ch := make(chan types.Announcements, 100)
+	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
+	pool, err := New(ch, coreDB, cfg, kvcache.New(kvcache.DefaultCoherentConfig), *u256.N1, rules, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	require.NoError(t, err)
+	ctx := context.Background()
+	require.NoError(t, pool.Start(ctx, db))
+	tx, err := db.BeginRw(ctx)
+	require.NoError(t, err)
+	t.Cleanup(tx.Rollback)
+	return pool, tx
+}
+
+// testBlock is the block applied to a test pool by applyBlock
+type testBlock struct {
+	number   uint64
+	baseFee  uint64                    // pending base fee, 200_000 when not set
+	blobFee  uint64                    // pending blob fee, unchanged when not set
+	gasLimit uint64                    // 1_000_000 when not set
+	nonces   map[common.Address]uint64 // of the accounts changed by the block, with a balance of 1 ether
+	mined    types.TxSlots
+}
+
+func applyBlock(t *testing.T, pool *TxPool, tx kv.Tx, block testBlock) {
+	t.Helper()
+	if block.baseFee == 0 {
+		block.baseFee = 200_000
+	}
+	if block.gasLimit == 0 {
+		block.gasLimit = 1_000_000
+	}
+	change := &remote.StateChangeBatch{
+		PendingBlockBaseFee:  block.baseFee,
+		PendingBlobFeePerGas: block.blobFee,
+		BlockGasLimit:        block.gasLimit,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: block.number, BlockHash: gointerfaces.ConvertHashToH256([32]byte{})},
+		},
+	}
+	// sender ids are assigned in the order of the changes
+	addrs := make([]common.Address, 0, len(block.nonces))
+	for addr := range block.nonces {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	for _, addr := range addrs {
+		nonce := block.nonces[addr]
+		v := make([]byte, types.EncodeSenderLengthForStorage(nonce, *uint256.NewInt(1 * common.Ether)))
+		types.EncodeSender(nonce, *uint256.NewInt(1 * common.Ether), v)
+		change.ChangeBatch[0].Changes = append(change.ChangeBatch[0].Changes, &remote.AccountChange{
+			Action:  remote.Action_UPSERT,
+			Address: gointerfaces.ConvertAddressToH160(addr),
+			Data:    v,
+		})
+	}
+	require.NoError(t, pool.OnNewBlock(context.Background(), change, types.TxSlots{}, types.TxSlots{}, block.mined, tx))
+}
+
+// fresh returns the nonces of new accounts, for testBlock
+func fresh(addrs ...common.Address) map[common.Address]uint64 {
+	nonces := make(map[common.Address]uint64, len(addrs))
+	for _, addr := range addrs {
+		nonces[addr] = 0
+	}
+	return nonces
+}
+
+// signedTxs returns the tx signed with the key, parsed for a local add
+func signedTxs(t *testing.T, params testutil.TxParams, key []byte) types.TxSlots {
+	t.Helper()
+	payload, err := testutil.BuildSignedTx(params, key)
+	require.NoError(t, err)
+	parseCtx := types.NewTxParseContext(params.ChainID)
+	parseCtx.WithDataPrefix(true)
+	var txSlots types.TxSlots
+	txSlots.Resize(1)
+	txSlots.Txs[0] = &types.TxSlot{}
+	txSlots.IsLocal[0] = true
+	_, err = parseCtx.ParseTransaction(payload, 0, txSlots.Txs[0], txSlots.Senders.At(0), false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	return txSlots
+}
+
+// validateTxTest is a case of runValidateTxTests: txn is validated for the sender 0x00..00, with the state nonce and a
+// balance of MaxUint64, by a pool with the rules on top of lastSeenBlock
+type validateTxTest struct {
+	expected      txpoolcfg.DiscardReason
+	txn           types.TxSlot // of 500_000 gas and a fee cap of 21_000 when not set
+	isLocal       bool
+	nonce         uint64
+	rules         *txpoolcfg.ChainRules // testChainRules() when not set
+	lastSeenBlock uint64
+	baseFee       uint64 // pending base fee
+}
+
+func runValidateTxTests(t *testing.T, cfg txpoolcfg.Config, tests map[string]validateTxTest) {
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules := testChainRules()
+			if test.rules != nil {
+				rules = *test.rules
+			}
+			ch := make(chan types.Announcements, 100)
+			coreDB := memdb.NewTestDB(t)
+			cache := &kvcache.DummyCache{}
+			pool, err := New(ch, coreDB, cfg, cache, *u256.N1, rules, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+			require.NoError(t, err)
+			pool.lastSeenBlock.Store(test.lastSeenBlock)
+			pool.pendingBaseFee.Store(test.baseFee)
+			ctx := context.Background()
+			tx, err := coreDB.BeginRw(ctx)
+			require.NoError(t, err)
+			defer tx.Rollback()
+
+			sndr := sender{nonce: test.nonce, balance: *uint256.NewInt(math.MaxUint64)}
+			sndrBytes := make([]byte, types.EncodeSenderLengthForStorage(sndr.nonce, sndr.balance))
+			types.EncodeSender(sndr.nonce, sndr.balance, sndrBytes)
+			require.NoError(t, tx.Put(kv.PlainState, make([]byte, 20), sndrBytes))
+
+			txn := test.txn
+			if txn.Gas == 0 {
+				txn.Gas = 500000
+			}
+			if txn.FeeCap.IsZero() {
+				txn.FeeCap = *uint256.NewInt(21000)
+			}
+			txns := types.TxSlots{
+				Txs:     []*types.TxSlot{&txn},
+				Senders: make(types.Addresses, 20),
+			}
+			require.NoError(t, pool.senders.registerNewSenders(&txns, pool.logger))
+			view, err := cache.View(ctx, tx)
+			require.NoError(t, err)
+
+			if reason := pool.validateTx(&txn, test.isLocal, view); reason != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, reason)
+			}
+		})
+	}
+}
+
+func TestSetCodeValidateTx(t *testing.T) {
+	cancun := testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun)
+	prague := testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun, txpoolcfg.Prague)
+	runValidateTxTests(t, txpoolcfg.DefaultConfig, map[string]validateTxTest{
+		"no prague": {
+			expected: txpoolcfg.TypeNotActivated,
+			txn:      types.TxSlot{Type: types.SetCodeTxType, AuthCount: 1},
+			rules:    &cancun,
+		},
+		"prague": {
+			expected: txpoolcfg.Success,
+			txn:      types.TxSlot{Type: types.SetCodeTxType, AuthCount: 1},
+			rules:    &prague,
+		},
+		"prague creation": {
+			expected: txpoolcfg.CreateSetCodeTxn,
+			txn:      types.TxSlot{Type: types.SetCodeTxType, AuthCount: 1, Creation: true},
+			rules:    &prague,
+		},
+		"prague no authorizations": {
+			expected: txpoolcfg.NoAuthorizations,
+			txn:      types.TxSlot{Type: types.SetCodeTxType},
+			rules:    &prague,
+		},
+	})
+}
+
+func TestSetCodeAuthorities(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun, txpoolcfg.Prague))
+	address := func(sender byte) (addr common.Address) {
+		addr[0] = sender
+		return addr
+	}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(address(1), address(2), address(3), address(4))})
+	ctx := context.Background()
+	coreDB, sendersCache := pool.coreDBWithCache()
+	coreTx, err := coreDB.BeginRo(ctx)
+	require.NoError(err)
+	defer coreTx.Rollback()
+	view, err := sendersCache.View(ctx, coreTx)
+	require.NoError(err)
+
+	var id byte
+	addRemote := func(sender byte, nonce uint64, authorities ...types.Authority) (*types.TxSlot, txpoolcfg.DiscardReason) {
+		id++
+		txn := &types.TxSlot{
+			Tip:    *uint256.NewInt(300_000),
+			FeeCap: *uint256.NewInt(300_000),
+			Gas:    100_000,
+			Nonce:  nonce,
+		}
+		if len(authorities) > 0 {
+			txn.Type, txn.AuthCount, txn.Authorities = types.SetCodeTxType, len(authorities), authorities
+		}
+		txn.IDHash[0] = id
+		addr := address(sender)
+		var txSlots types.TxSlots
+		txSlots.Append(txn, addr[:], false)
+		// rejected remote txs are not remembered, so check them first
+		pool.lock.Lock()
+		require.NoError(pool.senders.registerNewSenders(&txSlots, pool.logger))
+		reason := pool.validateTx(txn, false, view)
+		pool.lock.Unlock()
+		if reason != txpoolcfg.Success {
+			return txn, reason
+		}
+		pool.AddRemoteTxs(ctx, txSlots)
+		require.NoError(pool.processRemoteTxs(ctx))
+		require.Contains(pool.byHash, string(txn.IDHash[:]))
+		return txn, reason
+	}
+
+	// sender 1 delegates account 2
+	_, reason := addRemote(1, 0, types.Authority{Address: address(2), Nonce: 0})
+	assert.Equal(txpoolcfg.Success, reason, reason.String())
+	// the same authorization can't be in another tx
+	_, reason = addRemote(3, 0, types.Authority{Address: address(2), Nonce: 0})
+	assert.Equal(txpoolcfg.AuthConflict, reason, reason.String())
+
+	// account 2 with a pending delegation can have one tx only
+	_, reason = addRemote(2, 0)
+	assert.Equal(txpoolcfg.Success, reason, reason.String())
+	_, reason = addRemote(2, 1)
+	assert.Equal(txpoolcfg.DelegatedTxLimit, reason, reason.String())
+
+	// nor can account 4 be delegated while it has more
+	kept, reason := addRemote(4, 0)
+	assert.Equal(txpoolcfg.Success, reason, reason.String())
+	evicted, reason := addRemote(4, 1)
+	assert.Equal(txpoolcfg.Success, reason, reason.String())
+	_, reason = addRemote(3, 0, types.Authority{Address: address(4), Nonce: 0})
+	assert.Equal(txpoolcfg.DelegatedTxLimit, reason, reason.String())
+
+	// until its delegation lands on-chain, which evicts the txs above the limit
+	pool.lock.Lock()
+	pool.evictDelegatedLocked([]*types.TxSlot{{Type: types.SetCodeTxType, Authorities: []types.Authority{{Address: address(4), Nonce: 0}}}})
+	pool.lock.Unlock()
+	_, ok := pool.byHash[string(kept.IDHash[:])]
+	assert.True(ok)
+	_, ok = pool.byHash[string(evicted.IDHash[:])]
+	assert.False(ok)
+	reason, _ = pool.discardReasonsLRU.Get(string(evicted.IDHash[:]))
+	assert.Equal(txpoolcfg.DelegatedTxLimit, reason, reason.String())
+}
+
+func TestConditionalTxs(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(addr)})
+	ctx := context.Background()
+
+	maxBlock := hexutil.Uint64(1)
+	var txSlots types.TxSlots
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		txn := &types.TxSlot{
+			Tip:    *uint256.NewInt(300_000),
+			FeeCap: *uint256.NewInt(300_000),
+			Gas:    100_000,
+			Nonce:  nonce,
+			Rlp:    []byte{byte(nonce)},
+		}
+		txn.IDHash[0] = byte(nonce + 1)
+		txSlots.Append(txn, addr[:], true)
+	}
+	conditions := []*TxConditions{{BlockNumberMax: &maxBlock}, nil, {BlockNumberMax: &maxBlock}}
+	reasons, err := pool.AddLocalTxsWithConditions(ctx, txSlots, conditions, tx)
+	require.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(txpoolcfg.Success, reason, reason.String())
+	}
+	conditional, unconditional, expiring := txSlots.Txs[0], txSlots.Txs[1], txSlots.Txs[2]
+	assert.Equal(conditions[0], pool.Conditions(conditional.IDHash[:]))
+	assert.Nil(pool.Conditions(unconditional.IDHash[:]))
+
+	// conditional txs are not announced to peers, nor persisted
+	_, _, hashes := pool.AppendLocalAnnouncements(nil, nil, nil)
+	assert.Equal(unconditional.IDHash[:], hashes)
+	require.NoError(pool.flushLocked(tx))
+	has, err := tx.Has(kv.PoolTransaction, conditional.IDHash[:])
+	require.NoError(err)
+	assert.False(has)
+	has, err = tx.Has(kv.PoolTransaction, unconditional.IDHash[:])
+	require.NoError(err)
+	assert.True(has)
+
+	// failed at block building
+	pool.DropConditional(unconditional.IDHash[:]) // noop
+	pool.DropConditional(conditional.IDHash[:])
+	_, ok := pool.byHash[string(conditional.IDHash[:])]
+	assert.False(ok)
+	assert.Nil(pool.Conditions(conditional.IDHash[:]))
+	reason, _ := pool.discardReasonsLRU.Get(string(conditional.IDHash[:]))
+	assert.Equal(txpoolcfg.ConditionsNotMet, reason, reason.String())
+	// the later txs of the sender are behind a nonce gap
+	for _, txn := range []*types.TxSlot{unconditional, expiring} {
+		mt := pool.byHash[string(txn.IDHash[:])]
+		assert.Zero(mt.subPool & NoNonceGaps)
+		assert.Equal(QueuedSubPool, mt.currentSubPool)
+	}
+
+	// expired: the next block is above blockNumberMax
+	_, ok = pool.byHash[string(expiring.IDHash[:])]
+	assert.True(ok)
+	applyBlock(t, pool, tx, testBlock{number: 1})
+	_, ok = pool.byHash[string(expiring.IDHash[:])]
+	assert.False(ok)
+	_, ok = pool.byHash[string(unconditional.IDHash[:])]
+	assert.True(ok)
+	assert.Empty(pool.conditions)
+}
+
+func TestBestSkipsSender(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai))
+	addrs := []common.Address{{1}, {2}}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(addrs...)})
+
+	var txSlots types.TxSlots
+	for i, addr := range addrs {
+		for nonce := uint64(0); nonce < 2; nonce++ {
+			txn := &types.TxSlot{
+				Tip:    *uint256.NewInt(300_000 - uint64(i)),
+				FeeCap: *uint256.NewInt(300_000 - uint64(i)),
+				Gas:    100_000,
+				Nonce:  nonce,
+				Rlp:    []byte{byte(i), byte(nonce)},
+			}
+			txn.IDHash[0], txn.IDHash[1] = byte(i+1), byte(nonce)
+			if i == 0 && nonce == 0 {
+				txn.DataLen, txn.DataNonZeroLen = 2_000, 2_000 // intrinsic gas of 53_000
+			}
+			txSlots.Append(txn, addr[:], true)
+		}
+	}
+	reasons, err := pool.AddLocalTxs(context.Background(), txSlots, tx)
+	require.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(txpoolcfg.Success, reason, reason.String())
+	}
+
+	// the first tx of the sender doesn't fit, nor do its later ones
+	var txs types.TxsRlp
+	_, err = pool.PeekBest(10, &txs, tx, 0, 50_000, 0)
+	require.NoError(err)
+	assert.Equal([][]byte{{1, 0}, {1, 1}}, txs.Txs)
+}
+
+// addPoolMock is the txPool of GrpcServer.Add, discarding the txs with the reason
+type addPoolMock struct {
+	txPool
+	reason txpoolcfg.DiscardReason
+}
+
+func (m *addPoolMock) ValidateSerializedTxn([]byte) error      { return nil }
+func (m *addPoolMock) IdHashKnown(kv.Tx, []byte) (bool, error) { return false, nil }
+func (m *addPoolMock) AddLocalTxs(_ context.Context, newTxs types.TxSlots, _ kv.Tx) ([]txpoolcfg.DiscardReason, error) {
+	reasons := make([]txpoolcfg.DiscardReason, len(newTxs.Txs))
+	for i := range reasons {
+		reasons[i] = m.reason
+	}
+	return reasons, nil
+}
+
+func TestGrpcAddRejected(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	server := NewGrpcServer(context.Background(), &addPoolMock{reason: txpoolcfg.FeeTooLow}, memdb.NewTestPoolDB(t), *u256.N1, log.New())
+	params := testutil.TxParams{Type: testutil.DynamicFeeTxType, ChainID: *u256.N1, Gas: 21_000, To: &[20]byte{0x01}}
+	payload, err := testutil.BuildSignedTx(params, bytes.Repeat([]byte{1}, 32))
+	require.NoError(err)
+
+	// the txs rejected at parsing don't reach the pool, the discard reasons are of the other txs
+	reply, err := server.Add(context.Background(), &txpool_proto.AddRequest{RlpTxs: [][]byte{{0x02, 0xc1, 0x01}, payload}})
+	require.NoError(err)
+	assert.Equal(txpool_proto.ImportResult_INTERNAL_ERROR, reply.Imported[0])
+	assert.Equal(txpool_proto.ImportResult_FEE_TOO_LOW, reply.Imported[1])
+	assert.Equal(txpoolcfg.FeeTooLow.String(), reply.Errors[1])
+}
+
+func TestTxConditions(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+
+	var conditions TxConditions
+	require.NoError(json.Unmarshal([]byte(`{
+		"knownAccounts": {
+			"0x0000000000000000000000000000000000000001": {"0x0000000000000000000000000000000000000000000000000000000000000002": "0x0000000000000000000000000000000000000000000000000000000000000003"},
+			"0x0000000000000000000000000000000000000004": "0x0000000000000000000000000000000000000000000000000000000000000005"
+		},
+		"blockNumberMin": "0x10",
+		"blockNumberMax": "0x20",
+		"timestampMax": "0x100"
+	}`), &conditions))
+	account := conditions.KnownAccounts[common.HexToAddress("0x01")]
+	assert.Nil(account.StorageRoot)
+	assert.Equal(common.HexToHash("0x03"), account.Slots[common.HexToHash("0x02")])
+	root := common.HexToHash("0x05")
+	assert.Equal(&root, conditions.KnownAccounts[common.HexToAddress("0x04")].StorageRoot)
+
+	// storage roots are not supported
+	assert.ErrorIs(conditions.Validate(), ErrConditionsInvalid)
+	delete(conditions.KnownAccounts, common.HexToAddress("0x04"))
+	assert.NoError(conditions.Validate())
+	blockNumberMin := *conditions.BlockNumberMin
+	*conditions.BlockNumberMin = 0x21
+	assert.ErrorIs(conditions.Validate(), ErrConditionsInvalid)
+	*conditions.BlockNumberMin = blockNumberMin
+
+	assert.NoError(conditions.CheckBlock(0x10, 0x100))
+	assert.ErrorIs(conditions.CheckBlock(0x0f, 0x100), ErrConditionsNotYet)
+	assert.ErrorIs(conditions.CheckBlock(0x21, 0x100), ErrConditionsNotMet)
+	assert.ErrorIs(conditions.CheckBlock(0x10, 0x101), ErrConditionsNotMet)
+
+	storage := map[common.Hash]common.Hash{common.HexToHash("0x02"): common.HexToHash("0x03")}
+	read := func(addr common.Address, slot common.Hash) (common.Hash, error) { return storage[slot], nil }
+	assert.NoError(conditions.CheckStorage(read))
+	storage[common.HexToHash("0x02")] = common.HexToHash("0x04")
+	assert.ErrorIs(conditions.CheckStorage(read), ErrConditionsNotMet)
+
+	// passed along with the txs of TxpoolClient.Add
+	ctx, err := WithConditions(context.Background(), []*TxConditions{nil, &conditions})
+	require.NoError(err)
+	received, err := conditionsFromContext(ctx)
+	require.NoError(err)
+	assert.Equal([]*TxConditions{nil, &conditions}, received)
+	received, err = conditionsFromContext(context.Background())
+	require.NoError(err)
+	assert.Nil(received)
+}
+
+type userOpValidatorMock struct {
+	validation map[common.Address]*UserOpValidation // by sender, nil is rejected
+}
+
+func (m *userOpValidatorMock) SimulateValidation(_ context.Context, op *UserOperation, _ common.Address) (*UserOpValidation, error) {
+	if v, ok := m.validation[op.Sender]; ok && v != nil {
+		return v, nil
+	}
+	return nil, errors.New("AA23 reverted")
+}
+
+func TestUserOpPool(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	ctx := context.Background()
+	entryPoint := common.HexToAddress("0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789")
+	now := uint64(time.Now().Unix())
+	validator := &userOpValidatorMock{validation: map[common.Address]*UserOpValidation{}}
+	for i := byte(1); i <= 4; i++ {
+		validator.validation[common.Address{i}] = &UserOpValidation{}
+	}
+	validator.validation[common.Address{3}] = &UserOpValidation{ValidAfter: now + 3600}
+	validator.validation[common.Address{5}] = &UserOpValidation{ValidUntil: now + 1}
+	cfg := DefaultUserOpPoolConfig
+	cfg.EntryPoints = []common.Address{entryPoint}
+	cfg.MaxUserOps, cfg.MaxUserOpsPerSender = 4, 2
+	pool, err := NewUserOpPool(cfg, *uint256.NewInt(1), validator, log.New())
+	require.NoError(err)
+
+	userOp := func(sender byte, nonce, maxFee, tip uint64) *UserOperation {
+		op := &UserOperation{Sender: common.Address{sender}, CallData: []byte{sender}}
+		op.Nonce.SetUint64(nonce)
+		op.CallGasLimit.SetUint64(100_000)
+		op.VerificationGasLimit.SetUint64(100_000)
+		op.PreVerificationGas.SetUint64(50_000)
+		op.MaxFeePerGas.SetUint64(maxFee)
+		op.MaxPriorityFeePerGas.SetUint64(tip)
+		return op
+	}
+
+	op := userOp(1, 0, 100, 10)
+	assert.NotEqual(op.Hash(entryPoint, uint256.NewInt(1)), op.Hash(entryPoint, uint256.NewInt(5)))
+	assert.NotEqual(op.Hash(entryPoint, uint256.NewInt(1)), op.Hash(common.Address{1}, uint256.NewInt(1)))
+
+	_, err = pool.Add(ctx, op, common.Address{1})
+	assert.ErrorIs(err, ErrUserOpEntryPoint)
+	_, err = pool.Add(ctx, userOp(1, 0, 10, 100), entryPoint)
+	assert.ErrorIs(err, ErrUserOpInvalid)
+	_, err = pool.Add(ctx, userOp(6, 0, 100, 10), entryPoint)
+	assert.ErrorIs(err, ErrUserOpValidation)
+	_, err = pool.Add(ctx, userOp(5, 0, 100, 10), entryPoint)
+	assert.ErrorIs(err, ErrUserOpExpiresTooSoon)
+
+	hash, err := pool.Add(ctx, op, entryPoint)
+	require.NoError(err)
+	assert.Equal(op.Hash(entryPoint, uint256.NewInt(1)), hash)
+	_, err = pool.Add(ctx, op, entryPoint)
+	assert.ErrorIs(err, ErrUserOpKnown)
+
+	// replacement bumps both fees
+	_, err = pool.Add(ctx, userOp(1, 0, 110, 10), entryPoint)
+	assert.ErrorIs(err, ErrUserOpUnderpriced)
+	replacement, err := pool.Add(ctx, userOp(1, 0, 110, 11), entryPoint)
+	require.NoError(err)
+	_, _, ok := pool.Get(hash)
+	assert.False(ok)
+	_, ep, ok := pool.Get(replacement)
+	assert.True(ok)
+	assert.Equal(entryPoint, ep)
+
+	_, err = pool.Add(ctx, userOp(1, 1, 100, 20), entryPoint)
+	require.NoError(err)
+	_, err = pool.Add(ctx, userOp(1, 2, 100, 20), entryPoint)
+	assert.ErrorIs(err, ErrUserOpSenderLimit)
+	assert.Len(pool.BySender(common.Address{1}), 2)
+
+	// the pool is full, the last operation of the sender with the lowest priority fee is evicted: sender 1 nonce 1
+	// (tip 20), not its nonce 0 one (tip 11) which would leave a nonce gap
+	_, err = pool.Add(ctx, userOp(2, 0, 200, 50), entryPoint)
+	require.NoError(err)
+	_, err = pool.Add(ctx, userOp(3, 0, 200, 100), entryPoint)
+	require.NoError(err)
+	_, err = pool.Add(ctx, userOp(4, 0, 200, 15), entryPoint)
+	assert.ErrorIs(err, ErrUserOpUnderpriced)
+	_, err = pool.Add(ctx, userOp(4, 0, 300, 30), entryPoint)
+	require.NoError(err)
+	assert.Equal(4, pool.Len())
+	_, _, ok = pool.Get(replacement)
+	assert.True(ok)
+	require.Len(pool.BySender(common.Address{1}), 1)
+	assert.Equal(uint64(0), pool.BySender(common.Address{1})[0].Nonce.Uint64())
+	// the operation of the sender with the worst last operation isn't evicted for another one of the sender
+	_, err = pool.Add(ctx, userOp(1, 1, 300, 200), entryPoint)
+	assert.ErrorIs(err, ErrUserOpUnderpriced)
+
+	// the first operation of each sender valid now (not sender 3), paying the base fee (not sender 1 above 100),
+	// ordered by the effective priority fee
+	best := pool.Best(entryPoint, 10, 180)
+	require.Len(best, 2)
+	assert.Equal(common.Address{4}, best[0].Sender) // tip 30
+	assert.Equal(common.Address{2}, best[1].Sender) // tip 20 = 200 - 180
+	best = pool.Best(entryPoint, 10, 0)
+	require.Len(best, 3)
+	assert.Equal(common.Address{2}, best[0].Sender) // tip 50
+	assert.Equal(common.Address{4}, best[1].Sender) // tip 30
+	assert.Equal(common.Address{1}, best[2].Sender) // tip 11
+	best = pool.Best(entryPoint, 1, 0)
+	require.Len(best, 1)
+	assert.Equal(common.Address{2}, best[0].Sender)
+
+	pool.Remove(best[0].Hash(entryPoint, uint256.NewInt(1)))
+	assert.Equal(3, pool.Len())
+	validator.validation[common.Address{6}] = &UserOpValidation{ValidUntil: now + 3600}
+	expiring, err := pool.Add(ctx, userOp(6, 0, 400, 200), entryPoint)
+	require.NoError(err)
+	best = pool.Best(entryPoint, 1, 0) // sorted again for the new operation, at the same base fee
+	require.Len(best, 1)
+	assert.Equal(common.Address{6}, best[0].Sender)
+	validator.validation[common.Address{6}] = &UserOpValidation{ValidUntil: now + 7200}
+	following, err := pool.Add(ctx, userOp(6, 1, 400, 200), entryPoint) // evicts sender 1 nonce 0 (tip 11)
+	require.NoError(err)
+	assert.Equal(4, pool.Len())
+	pool.Prune(time.Unix(int64(now+3599), 0))
+	_, _, ok = pool.Get(expiring)
+	assert.True(ok)
+	// the following operation of the sender can't be bundled without the expired one
+	pool.Prune(time.Unix(int64(now+3600), 0))
+	_, _, ok = pool.Get(expiring)
+	assert.False(ok)
+	_, _, ok = pool.Get(following)
+	assert.False(ok)
+	assert.Equal(2, pool.Len())
+	best = pool.Best(entryPoint, 10, 0)
+	require.Len(best, 1)
+	assert.Equal(common.Address{4}, best[0].Sender)
+}
+
+func TestAdmissionFilters(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	allowedKey, otherKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(testutil.Address(allowedKey), testutil.Address(otherKey))})
+	ctx := context.Background()
+
+	sanctioned, recipient := [20]byte{0xde, 0xad}, [20]byte{0x01}
+	transfer := [4]byte{0xa9, 0x05, 0x9c, 0xbb} // transfer(address,uint256)
+	pool.AddAdmissionFilters(BlockRecipients(sanctioned), BlockSelectors(transfer))
+	pool.AddAdmissionFilters(AllowSenders(testutil.Address(allowedKey)))
+
+	var nonce uint64
+	add := func(key []byte, to [20]byte, data []byte) txpoolcfg.DiscardReason {
+		params := testutil.TxParams{Type: testutil.DynamicFeeTxType, ChainID: *u256.N1, Nonce: nonce, Gas: 100_000, To: &to, Data: data}
+		params.Tip.SetUint64(300_000)
+		params.FeeCap.SetUint64(300_000)
+		reasons, err := pool.AddLocalTxs(ctx, signedTxs(t, params, key), tx)
+		require.NoError(err)
+		if reasons[0] == txpoolcfg.Success {
+			nonce++
+		}
+		return reasons[0]
+	}
+
+	reason := add(allowedKey, recipient, nil)
+	assert.Equal(txpoolcfg.Success, reason, reason.String())
+	reason = add(allowedKey, sanctioned, nil)
+	assert.Equal(txpoolcfg.Filtered, reason, reason.String())
+	reason = add(allowedKey, recipient, append(transfer[:], make([]byte, 64)...))
+	assert.Equal(txpoolcfg.Filtered, reason, reason.String())
+	reason = add(allowedKey, recipient, []byte{0xa9, 0x05}) // shorter than a selector
+	assert.Equal(txpoolcfg.Success, reason, reason.String())
+	nonce = 0
+	reason = add(otherKey, recipient, nil)
+	assert.Equal(txpoolcfg.Filtered, reason, reason.String())
+}
+
+func TestTipOracle(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	var keys [][]byte
+	nonces := map[common.Address]uint64{}
+	for i := byte(1); i <= 5; i++ {
+		keys = append(keys, bytes.Repeat([]byte{i}, 32))
+		nonces[testutil.Address(keys[i-1])] = 0
+	}
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	applyBlock(t, pool, tx, testBlock{baseFee: 100, gasLimit: 100_000, nonces: nonces})
+	ctx := context.Background()
+
+	// effective tips of the block: 1 (ignored), 10, 20, 50 (capped by the fee cap) and 500
+	var minedTxs types.TxSlots
+	for i, fees := range [][2]uint64{{1000, 1}, {1000, 10}, {1000, 20}, {150, 100}, {1000, 500}} {
+		txn := &types.TxSlot{FeeCap: *uint256.NewInt(fees[0]), Tip: *uint256.NewInt(fees[1]), Gas: 21_000, IDHash: [32]byte{byte(i + 1)}}
+		minedTxs.Append(txn, []byte{0xff, byte(i + 1), 19: 0}, false)
+	}
+	applyBlock(t, pool, tx, testBlock{number: 1, baseFee: 100, gasLimit: 100_000, mined: minedTxs})
+
+	// 60th percentile of the 3 lowest tips
+	assert.Equal(uint64(20), pool.SuggestedTip())
+
+	history, err := pool.FeeHistory([]float64{0, 50, 100})
+	require.NoError(err)
+	require.Len(history, 3)
+	assert.Equal(BlockTips{Number: 1, BaseFee: 100, Gas: 105_000, Rewards: []uint64{1, 20, 500}}, history[1])
+	assert.Equal(BlockTips{Number: 2, BaseFee: 100, Pending: true, Rewards: []uint64{0, 0, 0}}, history[2])
+	_, err = pool.FeeHistory([]float64{50, 10})
+	assert.ErrorIs(err, ErrInvalidPercentile)
+
+	// pending txs needing more than a block: the 4th best doesn't make it into the next one
+	for i, key := range keys {
+		params := testutil.TxParams{Type: testutil.DynamicFeeTxType, ChainID: *u256.N1, Gas: 30_000, To: &[20]byte{0x01}}
+		params.Tip.SetUint64(uint64(i+1) * 1000)
+		params.FeeCap.SetUint64(10_000)
+		reasons, err := pool.AddLocalTxs(ctx, signedTxs(t, params, key), tx)
+		require.NoError(err)
+		require.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+	}
+	assert.Equal(uint64(2000), pool.SuggestedTip())
+
+	history, err = pool.FeeHistory([]float64{0, 50, 100})
+	require.NoError(err)
+	assert.Equal(BlockTips{Number: 2, BaseFee: 100, Gas: 150_000, Pending: true, Rewards: []uint64{1000, 3000, 5000}}, history[2])
+
+	// a batch of 2 blocks: the txs of each are recorded in its block, the later one at the pending base fee of the batch
+	batch := &remote.StateChangeBatch{
+		PendingBlockBaseFee: 300,
+		BlockGasLimit:       100_000,
+		ChangeBatch: []*remote.StateChange{
+			{BlockHeight: 2, BlockHash: gointerfaces.ConvertHashToH256([32]byte{2}), Txs: [][]byte{{}}},
+			{BlockHeight: 3, BlockHash: gointerfaces.ConvertHashToH256([32]byte{3}), Txs: [][]byte{{}, {}}},
+		},
+	}
+	var batchTxs types.TxSlots
+	for i, fees := range [][2]uint64{{1000, 7}, {1000, 70}, {350, 700}} {
+		txn := &types.TxSlot{FeeCap: *uint256.NewInt(fees[0]), Tip: *uint256.NewInt(fees[1]), Gas: 21_000, IDHash: [32]byte{0xee, byte(i)}}
+		batchTxs.Append(txn, []byte{0xee, byte(i), 19: 0}, false)
+	}
+	require.NoError(pool.OnNewBlock(ctx, batch, types.TxSlots{}, types.TxSlots{}, batchTxs, tx))
+	history, err = pool.FeeHistory([]float64{0, 100})
+	require.NoError(err)
+	require.Len(history, 5)
+	assert.Equal(BlockTips{Number: 2, BaseFee: 100, Gas: 21_000, Rewards: []uint64{7, 7}}, history[2])
+	assert.Equal(BlockTips{Number: 3, BaseFee: 300, Gas: 42_000, Rewards: []uint64{50, 70}}, history[3])
+}
+
 // Blob gas price bump + other requirements to replace existing txns in the pool
 func TestBlobTxReplacement(t *testing.T) {
 	assert, require := assert.New(t), require.New(t)
@@ -728,7 +1592,7 @@ func TestBlobTxReplacement(t *testing.T) {
 	db, coreDB := memdb.NewTestPoolDB(t), memdb.NewTestDB(t)
 	cfg := txpoolcfg.DefaultConfig
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, common.Big0, nil, common.Big0, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.True(pool != nil)
 	ctx := context.Background()
@@ -945,7 +1809,7 @@ func TestDropRemoteAtNoGossip(t *testing.T) {
 	logger := log.New()
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
 
-	txPool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, big.NewInt(0), big.NewInt(0), nil, fixedgas.DefaultMaxBlobsPerBlock, nil, logger)
+	txPool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(txpoolcfg.Shanghai), fixedgas.DefaultMaxBlobsPerBlock, nil, logger)
 	assert.NoError(err)
 	require.True(txPool != nil)
 
@@ -1051,7 +1915,7 @@ func TestBlobSlots(t *testing.T) {
 	cfg.TotalBlobPoolLimit = 20
 
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, common.Big0, nil, common.Big0, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.True(pool != nil)
 	ctx := context.Background()
@@ -1118,6 +1982,252 @@ func TestBlobSlots(t *testing.T) {
 	}
 }
 
+func TestBlobPoolEviction(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	cfg.TotalBlobPoolLimit = 4 // two txs with 2 blobs each
+	pool, tx := newTestPool(t, cfg, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	applyBlock(t, pool, tx, testBlock{blobFee: 100_000, nonces: fresh(common.Address{1}, common.Address{2}, common.Address{3}, common.Address{4})})
+	ctx := context.Background()
+
+	addRemote := func(sender byte, nonce uint64, blobFeeCap uint64) (hash [32]byte, added bool) {
+		addr := common.Address{sender}
+		blobTxn := makeBlobTx()
+		blobTxn.IDHash[0], blobTxn.IDHash[1] = sender, byte(nonce)
+		blobTxn.Nonce = nonce
+		blobTxn.BlobFeeCap = *uint256.NewInt(blobFeeCap)
+		var txSlots types.TxSlots
+		txSlots.Append(&blobTxn, addr[:], false)
+		pool.AddRemoteTxs(ctx, txSlots)
+		require.NoError(pool.processRemoteTxs(ctx))
+		_, added = pool.byHash[string(blobTxn.IDHash[:])]
+		return blobTxn.IDHash, added
+	}
+	evicted, added := addRemote(1, 0, 200_000)
+	require.True(added)
+	_, added = addRemote(2, 0, 300_000)
+	require.True(added)
+	assert.Equal(uint64(4), pool.totalBlobsInPool.Load())
+
+	// the cheapest blob tx makes room for a better paying one
+	below, added := addRemote(3, 0, 250_000)
+	assert.True(added)
+	assert.Equal(uint64(4), pool.totalBlobsInPool.Load())
+	_, ok := pool.byHash[string(evicted[:])]
+	assert.False(ok)
+	reason, _ := pool.discardReasonsLRU.Get(string(evicted[:]))
+	assert.Equal(txpoolcfg.BlobPoolOverflow, reason, reason.String())
+
+	// but not for a cheaper one
+	_, added = addRemote(4, 0, 150_000)
+	assert.False(added)
+	assert.Equal(uint64(4), pool.totalBlobsInPool.Load())
+	assert.Equal(2, len(pool.blobTxs))
+
+	// a tx below the highest nonce of its sender isn't evicted, even if it's the cheapest
+	_, added = addRemote(3, 1, 400_000)
+	assert.True(added)
+	_, added = addRemote(4, 0, 350_000)
+	assert.False(added)
+	_, ok = pool.byHash[string(below[:])]
+	assert.True(ok)
+	assert.Equal(uint64(4), pool.totalBlobsInPool.Load())
+}
+
+type kzgVerifierMock struct {
+	calls atomic.Int32
+	err   error
+}
+
+func (m *kzgVerifierMock) VerifyBlobKZGProofBatch(blobs []gokzg4844.Blob, commitments []gokzg4844.KZGCommitment, proofs []gokzg4844.KZGProof) error {
+	m.calls.Add(1)
+	return m.err
+}
+
+func TestKZGVerifier(t *testing.T) {
+	assert := assert.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	verifier := &kzgVerifierMock{err: errors.New("invalid proof")}
+	pool.SetKZGVerifier(verifier)
+	applyBlock(t, pool, tx, testBlock{blobFee: 100_000, nonces: fresh(common.Address{1}, common.Address{2}, common.Address{3}, common.Address{4})})
+	ctx := context.Background()
+
+	blobTxs := func(senders ...byte) types.TxSlots {
+		var txSlots types.TxSlots
+		for _, sender := range senders {
+			addr := common.Address{sender}
+			blobTxn := makeBlobTx()
+			blobTxn.IDHash[0] = sender
+			blobTxn.Nonce = 0
+			txSlots.Append(&blobTxn, addr[:], true)
+		}
+		return txSlots
+	}
+
+	// proofs rejected by the verifier, each tx verified once
+	reasons, err := pool.AddLocalTxs(ctx, blobTxs(1, 2), tx)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(txpoolcfg.UnmatchedBlobTxExt, reason, reason.String())
+	}
+	assert.Equal(int32(2), verifier.calls.Load())
+
+	verifier.err = nil
+	reasons, err = pool.AddLocalTxs(ctx, blobTxs(3, 4), tx)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(txpoolcfg.Success, reason, reason.String())
+	}
+	assert.Equal(int32(4), verifier.calls.Load())
+
+	// the proofs of txs with invalid blob fields are not verified
+	invalid := blobTxs(1, 2)
+	invalid.Txs[0].BlobHashes[0][1]++
+	invalid.Txs[1].Creation = true
+	reasons, err = pool.AddLocalTxs(ctx, invalid, tx)
+	assert.NoError(err)
+	assert.Equal(txpoolcfg.BlobHashCheckFail, reasons[0], reasons[0].String())
+	assert.Equal(txpoolcfg.CreateBlobTxn, reasons[1], reasons[1].String())
+	assert.Equal(int32(4), verifier.calls.Load())
+}
+
+func TestGetBlobsByHash(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	pool.chainID = *uint256.NewInt(5) // of makeBlobTx
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{blobFee: 100_000, nonces: fresh(addr)})
+	ctx := context.Background()
+
+	blobTxn := makeBlobTx()
+	blobTxn.Nonce = 0
+	expected := make([]BlobAndProof, len(blobTxn.Blobs))
+	for i := range blobTxn.Blobs {
+		expected[i] = BlobAndProof{Blob: common.Copy(blobTxn.Blobs[i]), Proof: blobTxn.Proofs[i]}
+	}
+	var txSlots types.TxSlots
+	txSlots.Append(&blobTxn, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+	assert.NoError(err)
+	assert.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+
+	hashes := append([]common.Hash{{0xff}}, blobTxn.BlobHashes...)
+	check := func() {
+		blobs, err := pool.GetBlobsByHash(tx, hashes)
+		require.NoError(err)
+		require.Equal(3, len(blobs))
+		assert.Nil(blobs[0])
+		for i, blob := range blobs[1:] {
+			require.NotNil(blob)
+			assert.Equal(expected[i], *blob)
+		}
+	}
+	check()
+
+	// served by TxpoolExt
+	ext := &TxpoolExtClientDirect{server: NewGrpcServer(ctx, pool, memdb.NewTestPoolDB(t), pool.chainID, log.New())}
+	in := &txpool_proto.TxHashes{}
+	for _, h := range hashes {
+		in.Hashes = append(in.Hashes, gointerfaces.ConvertHashToH256(h))
+	}
+	reply, err := ext.GetBlobs(ctx, in)
+	require.NoError(err)
+	assert.Equal([][]byte{{}, expected[0].Blob, expected[1].Blob}, reply.Blobs)
+	assert.Equal([][]byte{{}, expected[0].Proof[:], expected[1].Proof[:]}, reply.Proofs)
+
+	// once flushed, blobs are read back from the db
+	require.NoError(pool.flushLocked(tx))
+	mt := pool.byHash[string(blobTxn.IDHash[:])]
+	require.NotNil(mt)
+	assert.Nil(mt.Tx.Rlp)
+	assert.Nil(mt.Tx.Blobs)
+	pool.blobs.hot.Purge()
+	check()
+	assert.Equal(2, pool.blobs.hot.Len())
+
+	// the db is read without the pool lock
+	pool.blobs.hot.Purge()
+	_, err = pool.GetBlobsByHash(unlockedReadTx{RwTx: tx, t: t, pool: pool}, hashes)
+	require.NoError(err)
+
+	pool.lock.Lock()
+	pool.discardLocked(mt, txpoolcfg.Mined)
+	pool.lock.Unlock()
+	blobs, err := pool.GetBlobsByHash(tx, hashes)
+	require.NoError(err)
+	assert.Equal([]*BlobAndProof{nil, nil, nil}, blobs)
+}
+
+// unlockedReadTx fails the test when read under the pool lock
+type unlockedReadTx struct {
+	kv.RwTx
+	t    *testing.T
+	pool *TxPool
+}
+
+func (tx unlockedReadTx) GetOne(table string, key []byte) ([]byte, error) {
+	if !tx.pool.lock.TryLock() {
+		tx.t.Errorf("%s read under the pool lock", table)
+	} else {
+		tx.pool.lock.Unlock()
+	}
+	return tx.RwTx.GetOne(table, key)
+}
+
+func TestBlobFeeDemotion(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules(txpoolcfg.Shanghai, txpoolcfg.Cancun))
+	applyBlock(t, pool, tx, testBlock{blobFee: 100_000, gasLimit: 30_000_000, nonces: fresh(common.Address{1}, common.Address{2}, common.Address{3})})
+	ctx := context.Background()
+
+	addBlobTx := func(sender byte, blobFeeCap uint64) *metaTx {
+		addr := common.Address{sender}
+		blobTxn := makeBlobTx()
+		blobTxn.IDHash[0] = sender
+		blobTxn.Nonce = 0
+		blobTxn.FeeCap = *uint256.NewInt(400_000) // above the fee cap of the regular tx, so that they are not the worst
+		blobTxn.BlobFeeCap = *uint256.NewInt(blobFeeCap)
+		var txSlots types.TxSlots
+		txSlots.Append(&blobTxn, addr[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+		assert.NoError(err)
+		assert.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+		return pool.byHash[string(blobTxn.IDHash[:])]
+	}
+	cheap, expensive := addBlobTx(1, 150_000), addBlobTx(2, 300_000)
+	require.NotNil(cheap)
+	require.NotNil(expensive)
+	addr := common.Address{3}
+	regularTxn := &types.TxSlot{
+		Tip:    *uint256.NewInt(100_000),
+		FeeCap: *uint256.NewInt(200_000),
+		Gas:    100_000,
+		Nonce:  0,
+	}
+	regularTxn.IDHash[0] = 3
+	var txSlots types.TxSlots
+	txSlots.Append(regularTxn, addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+	assert.NoError(err)
+	assert.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+	regular := pool.byHash[string(regularTxn.IDHash[:])]
+	require.NotNil(regular)
+	assert.Equal(PendingSubPool, cheap.currentSubPool)
+	assert.Equal(PendingSubPool, expensive.currentSubPool)
+	assert.Equal(PendingSubPool, regular.currentSubPool)
+
+	// blob base fee rises above the blob fee cap of the cheap one only
+	applyBlock(t, pool, tx, testBlock{number: 1, blobFee: 200_000, gasLimit: 30_000_000})
+	assert.Equal(BaseFeeSubPool, cheap.currentSubPool)
+	assert.Equal(PendingSubPool, expensive.currentSubPool)
+	assert.Equal(PendingSubPool, regular.currentSubPool)
+
+	// and falls back
+	applyBlock(t, pool, tx, testBlock{number: 2, blobFee: 100_000, gasLimit: 30_000_000})
+	assert.Equal(PendingSubPool, cheap.currentSubPool)
+	assert.Equal(PendingSubPool, expensive.currentSubPool)
+}
+
 func TestGasLimitChanged(t *testing.T) {
 	assert, require := assert.New(t), require.New(t)
 	ch := make(chan types.Announcements, 100)
@@ -1125,7 +2235,7 @@ func TestGasLimitChanged(t *testing.T) {
 
 	cfg := txpoolcfg.DefaultConfig
 	sendersCache := kvcache.New(kvcache.DefaultCoherentConfig)
-	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, nil, nil, nil, fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	pool, err := New(ch, coreDB, cfg, sendersCache, *u256.N1, testChainRules(), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
 	assert.NoError(err)
 	require.True(pool != nil)
 	ctx := context.Background()
@@ -1190,3 +2300,495 @@ func TestGasLimitChanged(t *testing.T) {
 
 	assert.Zero(mtx.subPool&NotTooMuchGas, "Should now have block space (again) for the tx")
 }
+
+func TestTotalPoolSize(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	// Room for 3 transactions of 100 bytes
+	cfg.TotalPoolSize = 300 * datasize.B
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	var addrs []common.Address
+	for i := 0; i < 7; i++ {
+		addrs = append(addrs, common.Address{uint8(i + 1)})
+	}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(addrs...)})
+	ctx := context.Background()
+
+	// Senders with lower fees are added later, so that their transactions are the worst ones when the limit is reached
+	newTxs := func(i int, isLocal bool) types.TxSlots {
+		var txSlots types.TxSlots
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(uint64(300_000 - i*10_000)),
+			FeeCap: *uint256.NewInt(uint64(300_000 - i*10_000)),
+			Gas:    100000,
+			Nonce:  0,
+			Size:   100,
+		}
+		txSlot.IDHash[0] = uint8(i + 1)
+		txSlots.Append(txSlot, addrs[i][:], isLocal)
+		return txSlots
+	}
+	known := func(i int) bool {
+		hash := [32]byte{uint8(i + 1)}
+		_, ok := pool.byHash[string(hash[:])]
+		return ok
+	}
+	for i := 0; i < 5; i++ {
+		pool.AddRemoteTxs(ctx, newTxs(i, false))
+		require.NoError(pool.processRemoteTxs(ctx))
+	}
+	assert.Equal(uint64(300), pool.all.size)
+	for i := 0; i < 5; i++ {
+		assert.Equal(i < 3, known(i), i)
+	}
+	evicted := [32]byte{4}
+	reason, _ := pool.discardReasonsLRU.Get(string(evicted[:]))
+	assert.Equal(txpoolcfg.PoolSizeOverflow, reason, reason.String())
+
+	// Local transactions are kept, even if they are the worst ones, the remote ones are evicted instead
+	for i := 5; i < 7; i++ {
+		reasons, err := pool.AddLocalTxs(ctx, newTxs(i, true), tx)
+		assert.NoError(err)
+		assert.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+	}
+	assert.Equal(uint64(300), pool.all.size)
+	assert.Equal(3, pool.pending.Len())
+	for i := 0; i < 7; i++ {
+		assert.Equal(i == 0 || i >= 5, known(i), i)
+	}
+}
+
+// An evicted tx of the middle of a sender's nonces leaves the later ones behind a gap, and over the limit with local
+// txs only nothing is popped
+func TestTotalPoolSizeEviction(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	cfg.TotalPoolSize = 300 * datasize.B
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	addr, localAddr := common.Address{1}, common.Address{2}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(addr, localAddr)})
+	ctx := context.Background()
+
+	add := func(addr common.Address, id byte, nonce uint64, isLocal bool) {
+		var txSlots types.TxSlots
+		txSlot := &types.TxSlot{Tip: *uint256.NewInt(300_000), FeeCap: *uint256.NewInt(300_000), Gas: 100000, Nonce: nonce, Size: 100}
+		txSlot.IDHash[0] = id
+		txSlots.Append(txSlot, addr[:], isLocal)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+		require.NoError(err)
+		require.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+	}
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		add(addr, byte(nonce+1), nonce, false)
+	}
+	require.Equal(3, pool.pending.Len())
+	senderID, ok := pool.senders.getID(addr)
+	require.True(ok)
+
+	pool.lock.Lock()
+	mid := pool.all.get(senderID, 1)
+	popped := false
+	pool.evictRemotesLocked(200, pool.pending.Len, func() *metaTx {
+		require.False(popped)
+		popped = true
+		pool.pending.Remove(mid, "test", pool.logger)
+		return mid
+	})
+	pool.lock.Unlock()
+	add(localAddr, 4, 0, true) // the gap is applied by the next add
+	assert.Equal(QueuedSubPool, pool.all.get(senderID, 2).currentSubPool)
+
+	// the local txs alone are over the limit
+	add(localAddr, 5, 1, true)
+	add(localAddr, 6, 2, true)
+	assert.False(pool.all.hasTxs(senderID))
+	require.Equal(uint64(300), pool.all.localSize)
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	locals := pool.evictRemotesLocked(200, pool.pending.Len, func() *metaTx {
+		t.Fatal("popped with local txs only")
+		return nil
+	})
+	assert.Empty(locals)
+}
+
+func TestMaxAccountTxs(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	cfg.MaxAccountTxs = 2
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(addr)})
+	ctx := context.Background()
+
+	add := func(id byte, nonce, tip uint64, isLocal bool) txpoolcfg.DiscardReason {
+		var txSlots types.TxSlots
+		txSlot := &types.TxSlot{Tip: *uint256.NewInt(tip), FeeCap: *uint256.NewInt(tip), Gas: 100000, Nonce: nonce}
+		txSlot.IDHash[0] = id
+		txSlots.Append(txSlot, addr[:], isLocal)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+		require.NoError(err)
+		return reasons[0]
+	}
+	assert.Equal(txpoolcfg.Success, add(1, 0, 300_000, false))
+	assert.Equal(txpoolcfg.Success, add(2, 1, 300_000, false))
+	assert.Equal(txpoolcfg.AccountTxLimit, add(3, 2, 300_000, false))
+	// a replacement doesn't count, nor do local txs
+	assert.Equal(txpoolcfg.Success, add(4, 1, 400_000, false))
+	assert.Equal(txpoolcfg.Success, add(5, 2, 300_000, true))
+	assert.Equal(3, pool.all.count(pool.pending.best.ms[0].Tx.SenderID))
+}
+
+func TestLocalsFromConfig(t *testing.T) {
+	assert := assert.New(t)
+	local, remoteAddr := common.Address{1}, common.Address{2}
+	cfg := txpoolcfg.DefaultConfig
+	cfg.MinFeeCap = 10_000_000 // remote transactions below are underpriced
+	cfg.Locals = []common.Address{local}
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	applyBlock(t, pool, tx, testBlock{baseFee: 1_000_000, nonces: fresh(local, remoteAddr)})
+	ctx := context.Background()
+
+	var txSlots types.TxSlots
+	for i, addr := range []common.Address{local, remoteAddr} {
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(3_000_000),
+			FeeCap: *uint256.NewInt(3_000_000),
+			Gas:    100000,
+			Nonce:  0,
+		}
+		txSlot.IDHash[0] = uint8(i + 1)
+		txSlots.Append(txSlot, addr[:], false)
+	}
+	pool.AddRemoteTxs(ctx, txSlots)
+	assert.NoError(pool.processRemoteTxs(ctx))
+
+	localHash, remoteHash := [32]byte{1}, [32]byte{2}
+	_, ok := pool.byHash[string(localHash[:])]
+	assert.True(ok)
+	assert.True(pool.IsLocal(localHash[:]))
+	_, ok = pool.byHash[string(remoteHash[:])]
+	assert.False(ok)
+
+	// Underpriced transaction is remembered, so it is not requested again
+	known, err := pool.IdHashKnown(tx, remoteHash[:])
+	assert.NoError(err)
+	assert.True(known)
+	reason, _ := pool.discardReasonsLRU.Get(string(remoteHash[:]))
+	assert.Equal(txpoolcfg.UnderPriced, reason, reason.String())
+}
+
+func TestLifetimeExpiry(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	cfg.Lifetime = time.Hour
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{baseFee: 1_000_000, nonces: fresh(addr)})
+	ctx := context.Background()
+
+	newSlot := func(nonce uint64) *types.TxSlot {
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(3_000_000),
+			FeeCap: *uint256.NewInt(3_000_000),
+			Gas:    100000,
+			Nonce:  nonce,
+		}
+		txSlot.IDHash[0] = uint8(nonce + 1)
+		return txSlot
+	}
+	var localTxs, remoteTxs types.TxSlots
+	localTxs.Append(newSlot(0), addr[:], true)
+	reasons, err := pool.AddLocalTxs(ctx, localTxs, tx)
+	assert.NoError(err)
+	assert.Equal(txpoolcfg.Success, reasons[0], reasons[0].String())
+	remoteTxs.Append(newSlot(1), addr[:], false)
+	pool.AddRemoteTxs(ctx, remoteTxs)
+	assert.NoError(pool.processRemoteTxs(ctx))
+	require.Equal(2, pool.all.count(localTxs.Txs[0].SenderID))
+
+	pool.lock.Lock()
+	pool.expireLocked(time.Now().Add(30 * time.Minute))
+	assert.Equal(2, pool.all.count(localTxs.Txs[0].SenderID))
+	pool.expireLocked(time.Now().Add(2 * time.Hour))
+	pool.lock.Unlock()
+
+	// Only the remote transaction expires
+	assert.Equal(1, pool.all.count(localTxs.Txs[0].SenderID))
+	_, ok := pool.byHash[string(localTxs.Txs[0].IDHash[:])]
+	assert.True(ok)
+	reason, ok := pool.discardReasonsLRU.Get(string(remoteTxs.Txs[0].IDHash[:]))
+	assert.True(ok)
+	assert.Equal(txpoolcfg.Expired, reason, reason.String())
+	assert.Empty(pool.byArrival)
+}
+
+func TestLifetimeExpiryNonceGap(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	cfg.Lifetime = time.Hour
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{baseFee: 1_000_000, nonces: fresh(addr)})
+	ctx := context.Background()
+
+	var remoteTxs types.TxSlots
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(3_000_000),
+			FeeCap: *uint256.NewInt(3_000_000),
+			Gas:    100000,
+			Nonce:  nonce,
+		}
+		txSlot.IDHash[0] = uint8(nonce + 1)
+		remoteTxs.Append(txSlot, addr[:], false)
+	}
+	pool.AddRemoteTxs(ctx, remoteTxs)
+	require.NoError(pool.processRemoteTxs(ctx))
+	require.Equal(PendingSubPool, pool.byHash[string(remoteTxs.Txs[1].IDHash[:])].currentSubPool)
+
+	// Only the tx of nonce 0 expires, by a block without state changes of the sender
+	pool.byHash[string(remoteTxs.Txs[0].IDHash[:])].addedAt -= int64(2 * time.Hour / time.Second)
+	applyBlock(t, pool, tx, testBlock{number: 1, baseFee: 1_000_000})
+
+	_, ok := pool.byHash[string(remoteTxs.Txs[0].IDHash[:])]
+	assert.False(ok)
+	mt := pool.byHash[string(remoteTxs.Txs[1].IDHash[:])]
+	require.NotNil(mt)
+	assert.Zero(mt.subPool & NoNonceGaps)
+	assert.Equal(QueuedSubPool, mt.currentSubPool)
+	assert.Empty(pool.nonceGaps)
+}
+
+func TestMaxNonceGap(t *testing.T) {
+	cfg := txpoolcfg.DefaultConfig
+	cfg.MaxNonceGap = 4
+	runValidateTxTests(t, cfg, map[string]validateTxTest{
+		"state nonce":           {expected: txpoolcfg.Success, nonce: 2, txn: types.TxSlot{Nonce: 2}},
+		"on the gap limit":      {expected: txpoolcfg.Success, nonce: 2, txn: types.TxSlot{Nonce: 6}},
+		"beyond the gap":        {expected: txpoolcfg.NonceTooDistant, nonce: 2, txn: types.TxSlot{Nonce: 7}},
+		"beyond the gap, local": {expected: txpoolcfg.Success, nonce: 2, txn: types.TxSlot{Nonce: 7}, isLocal: true},
+	})
+}
+
+func TestMinTip(t *testing.T) {
+	cfg := txpoolcfg.DefaultConfig
+	cfg.MinTip = 1_000
+	tip := func(tip uint64) types.TxSlot { return types.TxSlot{Tip: *uint256.NewInt(tip)} }
+	runValidateTxTests(t, cfg, map[string]validateTxTest{
+		"above the minimum":        {expected: txpoolcfg.Success, txn: tip(2_000)},
+		"on the minimum":           {expected: txpoolcfg.Success, txn: tip(1_000)},
+		"below the minimum":        {expected: txpoolcfg.TipTooLow, txn: tip(999)},
+		"below the minimum, local": {expected: txpoolcfg.Success, txn: tip(999), isLocal: true},
+	})
+	// the tip is capped by the fee cap over the pending base fee
+	capped := func(tip, feeCap uint64) types.TxSlot {
+		return types.TxSlot{Tip: *uint256.NewInt(tip), FeeCap: *uint256.NewInt(feeCap)}
+	}
+	runValidateTxTests(t, cfg, map[string]validateTxTest{
+		"fee cap above the tip":        {expected: txpoolcfg.Success, txn: capped(1_000, 11_000), baseFee: 10_000},
+		"fee cap below the tip":        {expected: txpoolcfg.TipTooLow, txn: capped(2_000, 10_999), baseFee: 10_000},
+		"fee cap below the base fee":   {expected: txpoolcfg.TipTooLow, txn: capped(2_000, 9_000), baseFee: 10_000},
+		"fee cap below the tip, local": {expected: txpoolcfg.Success, txn: capped(2_000, 10_999), baseFee: 10_000, isLocal: true},
+	})
+}
+
+// A tx rejected for its effective tip isn't remembered as underpriced, it is accepted at a lower base fee
+func TestMinTipBaseFee(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cfg := txpoolcfg.DefaultConfig
+	cfg.MinTip = 1_000
+	pool, tx := newTestPool(t, cfg, testChainRules())
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{baseFee: 10_000, nonces: fresh(addr)})
+	ctx := context.Background()
+
+	add := func() {
+		var txSlots types.TxSlots
+		txSlot := &types.TxSlot{Tip: *uint256.NewInt(2_000), FeeCap: *uint256.NewInt(10_999), Gas: 100000}
+		txSlot.IDHash[0] = 1
+		txSlots.Append(txSlot, addr[:], false)
+		pool.AddRemoteTxs(ctx, txSlots)
+		require.NoError(pool.processRemoteTxs(ctx))
+	}
+	hash := [32]byte{1}
+	add()
+	_, ok := pool.byHash[string(hash[:])]
+	assert.False(ok)
+	known, err := pool.IdHashKnown(tx, hash[:])
+	require.NoError(err)
+	assert.False(known)
+
+	applyBlock(t, pool, tx, testBlock{number: 1, baseFee: 9_000, nonces: fresh(addr)})
+	add()
+	_, ok = pool.byHash[string(hash[:])]
+	assert.True(ok)
+}
+
+// The pooled txs rejected by the config of the restarted pool are dropped, the others are kept
+func TestFromDBRejected(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	cheapKey, key := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules())
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(testutil.Address(cheapKey), testutil.Address(key))})
+	ctx := context.Background()
+
+	var hashes [][32]byte
+	for i, k := range [][]byte{cheapKey, key} {
+		params := testutil.TxParams{Type: testutil.DynamicFeeTxType, ChainID: *u256.N1, Gas: 100_000, To: &[20]byte{0x01}}
+		params.Tip.SetUint64(uint64(i+1) * 1_000)
+		params.FeeCap.SetUint64(300_000)
+		txSlots := signedTxs(t, params, k)
+		txSlots.IsLocal[0] = false
+		pool.AddRemoteTxs(ctx, txSlots)
+		hashes = append(hashes, txSlots.Txs[0].IDHash)
+	}
+	require.NoError(pool.processRemoteTxs(ctx))
+	require.Equal(2, pool.pending.Len())
+	require.NoError(pool.flushLocked(tx))
+
+	cfg := txpoolcfg.DefaultConfig
+	cfg.MinTip = 2_000
+	coreDB, sendersCache := pool.coreDBWithCache()
+	p2, err := New(make(chan types.Announcements, 100), coreDB, cfg, sendersCache, *u256.N1, testChainRules(), fixedgas.DefaultMaxBlobsPerBlock, nil, log.New())
+	require.NoError(err)
+	p2.senders = pool.senders // senders are not persisted
+	require.NoError(coreDB.View(ctx, func(coreTx kv.Tx) error { return p2.fromDB(ctx, tx, coreTx) }))
+	_, ok := p2.byHash[string(hashes[0][:])]
+	assert.False(ok)
+	_, ok = p2.byHash[string(hashes[1][:])]
+	assert.True(ok)
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	assert := assert.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules())
+	addr := common.Address{1}
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(addr)})
+	ctx := context.Background()
+
+	var events []TxEvent
+	unsubscribe := pool.SubscribeEvents(func(ev TxEvent) { events = append(events, ev) })
+
+	add := func(id byte, fee uint64) {
+		var txSlots types.TxSlots
+		txSlot := &types.TxSlot{
+			Tip:    *uint256.NewInt(fee),
+			FeeCap: *uint256.NewInt(fee),
+			Gas:    100000,
+			Nonce:  0,
+		}
+		txSlot.IDHash[0] = id
+		txSlots.Append(txSlot, addr[:], true)
+		reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+		assert.NoError(err)
+		for _, reason := range reasons {
+			assert.Equal(txpoolcfg.Success, reason, reason.String())
+		}
+	}
+	add(1, 300_000)
+	add(2, 400_000) // replaces the first one
+
+	type event struct {
+		kind   TxEventKind
+		id     byte
+		reason txpoolcfg.DiscardReason
+	}
+	var got []event
+	for _, ev := range events {
+		assert.Equal(uint64(0), ev.Nonce)
+		assert.Equal(addr, ev.Sender)
+		got = append(got, event{ev.Kind, ev.IDHash[0], ev.Reason})
+	}
+	assert.Equal([]event{
+		{TxAdded, 1, txpoolcfg.NotSet},
+		{TxPromoted, 1, txpoolcfg.NotSet},
+		{TxDiscarded, 1, txpoolcfg.ReplacedByHigherTip},
+		{TxAdded, 2, txpoolcfg.NotSet},
+		{TxPromoted, 2, txpoolcfg.NotSet},
+	}, got)
+
+	unsubscribe()
+	events = nil
+	add(3, 500_000)
+	assert.Empty(events)
+}
+
+func TestContent(t *testing.T) {
+	assert, require := assert.New(t), require.New(t)
+	pool, tx := newTestPool(t, txpoolcfg.DefaultConfig, testChainRules())
+	applyBlock(t, pool, tx, testBlock{nonces: fresh(common.Address{1}, common.Address{2}, common.Address{3})})
+	ctx := context.Background()
+
+	// 3 senders, sender i has i+1 transactions, the last one with a nonce gap
+	var txSlots types.TxSlots
+	for i := 0; i < 3; i++ {
+		addr := common.Address{uint8(i + 1)}
+		for n := 0; n <= i; n++ {
+			txSlot := &types.TxSlot{
+				Tip:    *uint256.NewInt(300_000),
+				FeeCap: *uint256.NewInt(300_000),
+				Gas:    100000,
+				Nonce:  uint64(2 * n),
+				Value:  *uint256.NewInt(7),
+				Rlp:    []byte{uint8(i), uint8(n)},
+			}
+			txSlot.IDHash[0], txSlot.IDHash[1] = uint8(i+1), uint8(n)
+			txSlots.Append(txSlot, addr[:], true)
+		}
+	}
+	reasons, err := pool.AddLocalTxs(ctx, txSlots, tx)
+	assert.NoError(err)
+	for _, reason := range reasons {
+		assert.Equal(txpoolcfg.Success, reason, reason.String())
+	}
+
+	txns, err := pool.Content(tx, 0, 0)
+	require.NoError(err)
+	require.Len(txns, 6)
+	for _, txn := range txns {
+		i, n := txn.Rlp[0], txn.Rlp[1]
+		assert.Equal(common.Address{i + 1}, txn.Sender)
+		assert.Equal(uint64(2*n), txn.Slot.Nonce)
+		if n == 0 {
+			assert.Equal(PendingSubPool, txn.SubPool)
+		} else {
+			assert.Equal(QueuedSubPool, txn.SubPool)
+		}
+	}
+	assert.Equal("0x0000000000000000000000000000000000000000: 7 wei + 100000 gas × 300000 wei", txns[0].Summary())
+
+	txns, err = pool.Content(tx, 1, 1)
+	require.NoError(err)
+	require.Len(txns, 2)
+	assert.Equal(common.Address{2}, txns[0].Sender)
+	assert.Equal(common.Address{2}, txns[1].Sender)
+	assert.Less(txns[0].Slot.Nonce, txns[1].Slot.Nonce)
+
+	txns, err = pool.Content(tx, 3, 1)
+	require.NoError(err)
+	assert.Empty(txns)
+
+	txns, err = pool.ContentFrom(tx, common.Address{3})
+	require.NoError(err)
+	require.Len(txns, 3)
+	for n, txn := range txns {
+		assert.Equal(common.Address{3}, txn.Sender)
+		assert.Equal(uint64(2*n), txn.Slot.Nonce)
+	}
+	txns, err = pool.ContentFrom(tx, common.Address{4})
+	require.NoError(err)
+	assert.Empty(txns)
+
+	hash := [32]byte{3, 1}
+	txn, ok, err := pool.GetTxn(tx, hash[:])
+	require.NoError(err)
+	require.True(ok)
+	assert.Equal(common.Address{3}, txn.Sender)
+	assert.Equal(uint64(2), txn.Slot.Nonce)
+	assert.Equal([]byte{2, 1}, txn.Rlp)
+	assert.Equal(QueuedSubPool, txn.SubPool)
+	_, ok, err = pool.GetTxn(tx, make([]byte, 32))
+	require.NoError(err)
+	assert.False(ok)
+}
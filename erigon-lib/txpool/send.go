@@ -19,6 +19,7 @@ package txpool
 import (
 	"context"
 	"fmt"
+	"math"
 	"sync"
 
 	"github.com/ledgerwatch/erigon-lib/direct"
@@ -41,6 +42,7 @@ type Send struct {
 	pool          Pool
 	wg            *sync.WaitGroup
 	sentryClients []direct.SentryClient // sentry clients that will be used for accessing the network
+	knownTxs      *KnownTxs             // transactions known to each peer, nil when not tracked
 	logger        log.Logger
 }
 
@@ -57,6 +59,11 @@ func (f *Send) SetWaitGroup(wg *sync.WaitGroup) {
 	f.wg = wg
 }
 
+// SetKnownTxs makes the sender skip, and record, the transactions known to each peer, see KnownTxs
+func (f *Send) SetKnownTxs(knownTxs *KnownTxs) {
+	f.knownTxs = knownTxs
+}
+
 const (
 	// This is the target size for the packs of transactions or announcements. A
 	// pack can get larger than this if a single transactions exceeds this size.
@@ -69,13 +76,41 @@ func (f *Send) notifyTests() {
 	}
 }
 
-// Broadcast given RLPs to random peers
-func (f *Send) BroadcastPooledTxs(rlps [][]byte, maxPeers uint64) (txSentTo []int) {
+// BroadcastMaxPeers returns to how many peers the bodies of transactions are broadcast: the square root of the number
+// of connected peers (the rest of them get announcements only, see AnnouncePooledTxs), but at least minPeers
+func (f *Send) BroadcastMaxPeers(minPeers uint64) uint64 {
+	var count uint64
+	for _, sentryClient := range f.sentryClients {
+		if !sentryClient.Ready() {
+			continue
+		}
+		reply, err := sentryClient.PeerCount(f.ctx, &sentry.PeerCountRequest{})
+		if err != nil {
+			f.logger.Debug("[txpool.send] PeerCount", "err", err)
+			continue
+		}
+		count += reply.Count
+	}
+	if n := uint64(math.Sqrt(float64(count))); n > minPeers {
+		return n
+	}
+	return minPeers
+}
+
+// BroadcastPooledTxs sends the bodies of the transactions, given with their hashes, to maxPeers peers. With KnownTxs
+// set (see SetKnownTxs) the peers are the tracked ones, each of them gets the transactions it doesn't know about only,
+// e.g. not the ones it sent us, and they are recorded as known to it, so that AnnouncePooledTxs skips them. Without
+// KnownTxs the sentry picks maxPeers random peers, which may include the peer a transaction came from
+func (f *Send) BroadcastPooledTxs(rlps [][]byte, hashes types2.Hashes, maxPeers uint64) (txSentTo []int) {
 	defer f.notifyTests()
 	if len(rlps) == 0 {
 		return
 	}
 	txSentTo = make([]int, len(rlps))
+	if f.knownTxs != nil {
+		f.broadcastToKnownPeers(rlps, hashes, maxPeers, txSentTo)
+		return
+	}
 	var prev, size int
 	for i, l := 0, len(rlps); i < len(rlps); i++ {
 		size += len(rlps[i])
@@ -114,12 +149,73 @@ func (f *Send) BroadcastPooledTxs(rlps [][]byte, maxPeers uint64) (txSentTo []in
 	return
 }
 
+func (f *Send) broadcastToKnownPeers(rlps [][]byte, hashes types2.Hashes, maxPeers uint64, txSentTo []int) {
+	var sentTo uint64
+	for _, peer := range f.knownTxs.Peers() {
+		if sentTo == maxPeers {
+			return
+		}
+		var peerRlps [][]byte
+		var peerHashes []byte
+		for i := range rlps {
+			hash := hashes.At(i)
+			if f.knownTxs.Has(peer, hash) {
+				continue
+			}
+			peerRlps = append(peerRlps, rlps[i])
+			peerHashes = append(peerHashes, hash...)
+			txSentTo[i]++
+		}
+		if len(peerRlps) == 0 {
+			continue // doesn't count, the bodies go to other peers
+		}
+		f.sendPooledTxsToPeer(peer, peerRlps)
+		f.knownTxs.AddSent(peer, peerHashes)
+		sentTo++
+	}
+}
+
+func (f *Send) sendPooledTxsToPeer(peer types2.PeerID, rlps [][]byte) {
+	var prev, size int
+	for i := 0; i < len(rlps); i++ {
+		size += len(rlps[i])
+		if i < len(rlps)-1 && size < p2pTxPacketLimit {
+			continue
+		}
+		req := &sentry.SendMessageByIdRequest{
+			PeerId: peer,
+			Data: &sentry.OutboundMessageData{
+				Id:   sentry.MessageId_TRANSACTIONS_66,
+				Data: types2.EncodeTransactions(rlps[prev:i+1], nil),
+			},
+		}
+		for _, sentryClient := range f.sentryClients {
+			if !sentryClient.Ready() {
+				continue
+			}
+			if _, err := sentryClient.SendMessageById(f.ctx, req, &grpc.EmptyCallOption{}); err != nil {
+				f.logger.Debug("[txpool.send] BroadcastPooledTxs", "err", err)
+			}
+		}
+		prev = i + 1
+		size = 0
+	}
+}
+
+// AnnouncePooledTxs announces the transactions to the peers. With KnownTxs set (see SetKnownTxs) every tracked peer
+// gets the announcements of the transactions it doesn't know about, i.e. the ones it didn't send us nor got the body
+// of from BroadcastPooledTxs, and maxPeers is not used. Without KnownTxs the sentry picks maxPeers random peers, which
+// may include the ones which sent or got the transactions
 func (f *Send) AnnouncePooledTxs(types []byte, sizes []uint32, hashes types2.Hashes, maxPeers uint64) (hashSentTo []int) {
 	defer f.notifyTests()
 	hashSentTo = make([]int, len(types))
 	if len(types) == 0 {
 		return
 	}
+	if f.knownTxs != nil {
+		f.announceToPeers(f.knownTxs.Peers(), types, sizes, hashes, hashSentTo)
+		return
+	}
 	prevI := 0
 	prevJ := 0
 	for prevI < len(hashes) || prevJ < len(types) {
@@ -195,13 +291,46 @@ func (f *Send) AnnouncePooledTxs(types []byte, sizes []uint32, hashes types2.Has
 	return
 }
 
+// PropagatePooledTxsToPeersList announces the transactions to each of the peers, except for the ones the peer already
+// knows about (see SetKnownTxs)
 func (f *Send) PropagatePooledTxsToPeersList(peers []types2.PeerID, types []byte, sizes []uint32, hashes []byte) {
 	defer f.notifyTests()
 
 	if len(types) == 0 {
 		return
 	}
+	f.announceToPeers(peers, types, sizes, hashes, nil)
+}
 
+// announceToPeers announces to each of the peers the transactions it doesn't know about, counted in hashSentTo when
+// it's set
+func (f *Send) announceToPeers(peers []types2.PeerID, types []byte, sizes []uint32, hashes []byte, hashSentTo []int) {
+	for _, peer := range peers {
+		peerTypes, peerSizes, peerHashes := types, sizes, hashes
+		if f.knownTxs != nil {
+			peerTypes, peerSizes, peerHashes = nil, nil, nil
+			for i := range types {
+				hash := hashes[32*i : 32*i+32]
+				if f.knownTxs.Has(peer, hash) {
+					continue
+				}
+				peerTypes = append(peerTypes, types[i])
+				peerSizes = append(peerSizes, sizes[i])
+				peerHashes = append(peerHashes, hash...)
+				if hashSentTo != nil {
+					hashSentTo[i]++
+				}
+			}
+			if len(peerTypes) == 0 {
+				continue
+			}
+		}
+		f.propagatePooledTxsToPeer(peer, peerTypes, peerSizes, peerHashes)
+		f.knownTxs.AddSent(peer, peerHashes)
+	}
+}
+
+func (f *Send) propagatePooledTxsToPeer(peer types2.PeerID, types []byte, sizes []uint32, hashes []byte) {
 	prevI := 0
 	prevJ := 0
 	for prevI < len(hashes) || prevJ < len(types) {
@@ -230,37 +359,35 @@ func (f *Send) PropagatePooledTxsToPeersList(peers []types2.PeerID, types []byte
 				continue
 			}
 
-			for _, peer := range peers {
-				switch sentryClient.Protocol() {
-				case direct.ETH66, direct.ETH67:
-					if i > prevI {
-						req := &sentry.SendMessageByIdRequest{
-							PeerId: peer,
-							Data: &sentry.OutboundMessageData{
-								Id:   sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_66,
-								Data: iData,
-							},
-						}
-						if _, err := sentryClient.SendMessageById(f.ctx, req, &grpc.EmptyCallOption{}); err != nil {
-							f.logger.Debug("[txpool.send] PropagatePooledTxsToPeersList", "err", err)
-						}
+			switch sentryClient.Protocol() {
+			case direct.ETH66, direct.ETH67:
+				if i > prevI {
+					req := &sentry.SendMessageByIdRequest{
+						PeerId: peer,
+						Data: &sentry.OutboundMessageData{
+							Id:   sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_66,
+							Data: iData,
+						},
 					}
-				case direct.ETH68:
-
-					if j > prevJ {
-						req := &sentry.SendMessageByIdRequest{
-							PeerId: peer,
-							Data: &sentry.OutboundMessageData{
-								Id:   sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_68,
-								Data: jData,
-							},
-						}
-						if _, err := sentryClient.SendMessageById(f.ctx, req, &grpc.EmptyCallOption{}); err != nil {
-							f.logger.Debug("[txpool.send] PropagatePooledTxsToPeersList68", "err", err)
-						}
+					if _, err := sentryClient.SendMessageById(f.ctx, req, &grpc.EmptyCallOption{}); err != nil {
+						f.logger.Debug("[txpool.send] PropagatePooledTxsToPeersList", "err", err)
 					}
+				}
+			case direct.ETH68:
 
+				if j > prevJ {
+					req := &sentry.SendMessageByIdRequest{
+						PeerId: peer,
+						Data: &sentry.OutboundMessageData{
+							Id:   sentry.MessageId_NEW_POOLED_TRANSACTION_HASHES_68,
+							Data: jData,
+						},
+					}
+					if _, err := sentryClient.SendMessageById(f.ctx, req, &grpc.EmptyCallOption{}); err != nil {
+						f.logger.Debug("[txpool.send] PropagatePooledTxsToPeersList68", "err", err)
+					}
 				}
+
 			}
 		}
 		prevI = i
@@ -0,0 +1,257 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/gointerfaces/remote"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// TipOracleConfig configures the suggestions of the pool's tip oracle, the defaults match the ones of the block based
+// gas price oracle
+type TipOracleConfig struct {
+	Blocks       int    // recent blocks sampled
+	SampleNumber int    // lowest tips sampled per block
+	Percentile   int    // of the samples suggested
+	IgnoreTip    uint64 // tips below are not sampled, e.g. the ones of the validators' own txs
+	MaxTip       uint64 // cap of the suggestions
+}
+
+var DefaultTipOracleConfig = TipOracleConfig{
+	Blocks:       20,
+	SampleNumber: 3,
+	Percentile:   60,
+	IgnoreTip:    2,
+	MaxTip:       500_000_000_000,
+}
+
+var ErrInvalidPercentile = errors.New("invalid reward percentile")
+
+// BlockTips is the fee-history-style summary of a block: the effective tips paid at the requested percentiles,
+// weighted by the gas limit of the txs, as the pool doesn't see receipts
+type BlockTips struct {
+	Number  uint64
+	BaseFee uint64
+	Gas     uint64 // sum of the gas limits of the txs
+	Pending bool   // the block being built from the pending sub-pool
+	Rewards []uint64
+}
+
+type tipSample struct {
+	tip uint64
+	gas uint64
+}
+
+type blockSamples struct {
+	number  uint64
+	baseFee uint64
+	tips    []tipSample // sorted by tip
+}
+
+// tipOracle keeps the effective tips of the recently mined blocks, it's owned by the pool and guarded by its lock
+type tipOracle struct {
+	cfg    TipOracleConfig
+	blocks []blockSamples // oldest first
+}
+
+func newTipOracle(cfg TipOracleConfig) *tipOracle {
+	return &tipOracle{cfg: cfg}
+}
+
+// onNewBlocks records the mined txs of the batch, the txs of each forward change in its block. The base fee of the
+// first block is the pending one before the batch, the later blocks are recorded at the pending base fee of the batch
+// as theirs aren't sent. When the mined txs don't match the changes' ones, they are recorded in the last block
+func (o *tipOracle) onNewBlocks(changes []*remote.StateChange, baseFee, batchBaseFee uint64, minedTxs []*types.TxSlot) {
+	var forward, txs int
+	for _, change := range changes {
+		if change.Direction == remote.Direction_FORWARD {
+			forward++
+			txs += len(change.Txs)
+		}
+	}
+	if forward < 2 || txs != len(minedTxs) {
+		o.onNewBlock(changes[len(changes)-1].BlockHeight, baseFee, minedTxs)
+		return
+	}
+	for _, change := range changes {
+		if change.Direction != remote.Direction_FORWARD {
+			continue
+		}
+		o.onNewBlock(change.BlockHeight, baseFee, minedTxs[:len(change.Txs)])
+		minedTxs = minedTxs[len(change.Txs):]
+		baseFee = batchBaseFee
+	}
+}
+
+func (o *tipOracle) onNewBlock(number, baseFee uint64, minedTxs []*types.TxSlot) {
+	// blocks at the height and above were unwound
+	for len(o.blocks) > 0 && o.blocks[len(o.blocks)-1].number >= number {
+		o.blocks = o.blocks[:len(o.blocks)-1]
+	}
+	tips := make([]tipSample, 0, len(minedTxs))
+	for _, txn := range minedTxs {
+		tip := uint64(math.MaxUint64)
+		if txn.Tip.IsUint64() {
+			tip = txn.Tip.Uint64()
+		}
+		tips = append(tips, tipSample{tip: effectiveTip(&txn.FeeCap, tip, baseFee), gas: txn.Gas})
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].tip < tips[j].tip })
+	o.blocks = append(o.blocks, blockSamples{number: number, baseFee: baseFee, tips: tips})
+	if len(o.blocks) > o.cfg.Blocks {
+		o.blocks = append(o.blocks[:0], o.blocks[len(o.blocks)-o.cfg.Blocks:]...)
+	}
+}
+
+// suggestedTip returns the percentile of the lowest tips of the recent blocks, raised to the tip a tx needs to make
+// it into the next block when the pending sub-pool holds more than a block
+func (o *tipOracle) suggestedTip(next []tipSample, blockGasLimit uint64) uint64 {
+	var samples []uint64
+	for _, block := range o.blocks {
+		var n int
+		for _, s := range block.tips {
+			if n == o.cfg.SampleNumber {
+				break
+			}
+			if s.tip < o.cfg.IgnoreTip {
+				continue
+			}
+			samples = append(samples, s.tip)
+			n++
+		}
+	}
+	var tip uint64
+	if len(samples) > 0 {
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		tip = samples[(len(samples)-1)*o.cfg.Percentile/100]
+	}
+	if marginal := marginalTip(next, blockGasLimit); marginal > tip {
+		tip = marginal
+	}
+	if tip > o.cfg.MaxTip {
+		tip = o.cfg.MaxTip
+	}
+	return tip
+}
+
+func (o *tipOracle) feeHistory(percentiles []float64, next []tipSample, nextNumber, nextBaseFee uint64) ([]BlockTips, error) {
+	for i, p := range percentiles {
+		if p < 0 || p > 100 || (i > 0 && p < percentiles[i-1]) {
+			return nil, fmt.Errorf("%w: %f", ErrInvalidPercentile, p)
+		}
+	}
+	history := make([]BlockTips, 0, len(o.blocks)+1)
+	for _, block := range o.blocks {
+		history = append(history, blockTips(block.number, block.baseFee, block.tips, percentiles))
+	}
+	sort.Slice(next, func(i, j int) bool { return next[i].tip < next[j].tip })
+	pending := blockTips(nextNumber, nextBaseFee, next, percentiles)
+	pending.Pending = true
+	return append(history, pending), nil
+}
+
+func blockTips(number, baseFee uint64, tips []tipSample, percentiles []float64) BlockTips {
+	block := BlockTips{Number: number, BaseFee: baseFee, Rewards: make([]uint64, len(percentiles))}
+	for _, s := range tips {
+		block.Gas += s.gas
+	}
+	if len(tips) == 0 {
+		return block
+	}
+	var i int
+	cumulativeGas := tips[0].gas
+	for j, p := range percentiles {
+		threshold := uint64(float64(block.Gas) * p / 100)
+		for cumulativeGas < threshold && i < len(tips)-1 {
+			i++
+			cumulativeGas += tips[i].gas
+		}
+		block.Rewards[j] = tips[i].tip
+	}
+	return block
+}
+
+// marginalTip returns the lowest tip of the next block, 0 if all the txs fit in. The txs are sorted by tip, best first
+func marginalTip(next []tipSample, blockGasLimit uint64) uint64 {
+	var gas uint64
+	for _, s := range next {
+		gas += s.gas
+		if gas > blockGasLimit {
+			return s.tip
+		}
+	}
+	return 0
+}
+
+func effectiveTip(feeCap *uint256.Int, tip, baseFee uint64) uint64 {
+	if feeCap.LtUint64(baseFee) {
+		return 0
+	}
+	var difference uint256.Int
+	difference.SubUint64(feeCap, baseFee)
+	if difference.LtUint64(tip) {
+		return difference.Uint64()
+	}
+	return tip
+}
+
+// txEffectiveTip is effectiveTip of the tx, without truncating its fee cap and tip to uint64
+func txEffectiveTip(txn *types.TxSlot, baseFee uint64) (tip uint256.Int) {
+	if txn.FeeCap.LtUint64(baseFee) {
+		return tip
+	}
+	tip.SubUint64(&txn.FeeCap, baseFee)
+	if txn.Tip.Lt(&tip) {
+		tip.Set(&txn.Tip)
+	}
+	return tip
+}
+
+// pendingTipsLocked returns the effective tips of the pending txs, best first
+func (p *TxPool) pendingTipsLocked() []tipSample {
+	baseFee := p.pendingBaseFee.Load()
+	tips := make([]tipSample, 0, len(p.pending.best.ms))
+	for _, mt := range p.pending.best.ms {
+		tips = append(tips, tipSample{tip: effectiveTip(&mt.minFeeCap, mt.minTip, baseFee), gas: mt.Tx.Gas})
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].tip > tips[j].tip })
+	return tips
+}
+
+// SuggestedTip returns the tip to pay for a tx to be mined soon, from the tips of the recent blocks and of the pending
+// txs. It answers eth_maxPriorityFeePerGas, eth_gasPrice adds the pending base fee to it
+func (p *TxPool) SuggestedTip() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.tips.suggestedTip(p.pendingTipsLocked(), p.blockGasLimit.Load())
+}
+
+// FeeHistory returns the effective tips, at the increasing percentiles, of the recent blocks, oldest first, followed
+// by the ones of the pending sub-pool
+func (p *TxPool) FeeHistory(percentiles []float64) ([]BlockTips, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	nextNumber := p.lastSeenBlock.Load() + 1
+	return p.tips.feeHistory(percentiles, p.pendingTipsLocked(), nextNumber, p.pendingBaseFee.Load())
+}
@@ -0,0 +1,231 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	types2 "github.com/ledgerwatch/erigon-lib/gointerfaces/types"
+)
+
+// TxpoolExt is the gRPC service of the pool APIs which the Txpool service of the interfaces repo lacks. It is written
+// by hand on the messages of gointerfaces, and registered next to the Txpool service, see RegisterTxpoolExtServer.
+// RPC reaches it through the TxpoolClient returned by WithExt
+const txpoolExtServiceName = "txpool.TxpoolExt"
+
+type TxpoolExtServer interface {
+	// PendingBalance returns the balance of the account left by its pool txs, see TxPool.PendingState
+	PendingBalance(ctx context.Context, addr *types2.H160) (*types2.H256, error)
+	// GetBlobs returns the blobs and proofs of the versioned hashes, see TxPool.GetBlobsByHash. The commitments are
+	// left out, the blobs not in the pool have empty entries
+	GetBlobs(ctx context.Context, blobHashes *txpool_proto.TxHashes) (*types2.BlobsBundleV1, error)
+	// SuggestedTip returns the tip suggested by the pool, see TxPool.SuggestedTip
+	SuggestedTip(ctx context.Context, _ *emptypb.Empty) (*wrapperspb.UInt64Value, error)
+	// FeeHistory returns TxPool.FeeHistory of the percentiles. The messages of the interfaces repo have none for the
+	// fee history, the percentiles and the returned []BlockTips are JSON encoded
+	FeeHistory(ctx context.Context, percentiles *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error)
+}
+
+type TxpoolExtClient interface {
+	PendingBalance(ctx context.Context, addr *types2.H160, opts ...grpc.CallOption) (*types2.H256, error)
+	GetBlobs(ctx context.Context, blobHashes *txpool_proto.TxHashes, opts ...grpc.CallOption) (*types2.BlobsBundleV1, error)
+	SuggestedTip(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.UInt64Value, error)
+	FeeHistory(ctx context.Context, percentiles *wrapperspb.BytesValue, opts ...grpc.CallOption) (*wrapperspb.BytesValue, error)
+}
+
+var _ TxpoolExtServer = (*GrpcServer)(nil) // compile-time interface check
+
+func _TxpoolExt_PendingBalance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(types2.H160)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolExtServer).PendingBalance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + txpoolExtServiceName + "/PendingBalance"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolExtServer).PendingBalance(ctx, req.(*types2.H160))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TxpoolExt_GetBlobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(txpool_proto.TxHashes)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolExtServer).GetBlobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + txpoolExtServiceName + "/GetBlobs"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolExtServer).GetBlobs(ctx, req.(*txpool_proto.TxHashes))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TxpoolExt_SuggestedTip_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolExtServer).SuggestedTip(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + txpoolExtServiceName + "/SuggestedTip"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolExtServer).SuggestedTip(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TxpoolExt_FeeHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TxpoolExtServer).FeeHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + txpoolExtServiceName + "/FeeHistory"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TxpoolExtServer).FeeHistory(ctx, req.(*wrapperspb.BytesValue))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var txpoolExtServiceDesc = grpc.ServiceDesc{
+	ServiceName: txpoolExtServiceName,
+	HandlerType: (*TxpoolExtServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "PendingBalance", Handler: _TxpoolExt_PendingBalance_Handler},
+		{MethodName: "GetBlobs", Handler: _TxpoolExt_GetBlobs_Handler},
+		{MethodName: "SuggestedTip", Handler: _TxpoolExt_SuggestedTip_Handler},
+		{MethodName: "FeeHistory", Handler: _TxpoolExt_FeeHistory_Handler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "txpool/txpool_ext.go",
+}
+
+// RegisterTxpoolExtServer registers the TxpoolExt service of the txpool server, when it implements it
+func RegisterTxpoolExtServer(s grpc.ServiceRegistrar, txPoolServer txpool_proto.TxpoolServer) {
+	if srv, ok := txPoolServer.(TxpoolExtServer); ok {
+		s.RegisterService(&txpoolExtServiceDesc, srv)
+	}
+}
+
+type txpoolExtClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTxpoolExtClient(cc grpc.ClientConnInterface) TxpoolExtClient {
+	return &txpoolExtClient{cc}
+}
+
+func (c *txpoolExtClient) PendingBalance(ctx context.Context, addr *types2.H160, opts ...grpc.CallOption) (*types2.H256, error) {
+	out := new(types2.H256)
+	if err := c.cc.Invoke(ctx, "/"+txpoolExtServiceName+"/PendingBalance", addr, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolExtClient) GetBlobs(ctx context.Context, blobHashes *txpool_proto.TxHashes, opts ...grpc.CallOption) (*types2.BlobsBundleV1, error) {
+	out := new(types2.BlobsBundleV1)
+	if err := c.cc.Invoke(ctx, "/"+txpoolExtServiceName+"/GetBlobs", blobHashes, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolExtClient) SuggestedTip(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.UInt64Value, error) {
+	out := new(wrapperspb.UInt64Value)
+	if err := c.cc.Invoke(ctx, "/"+txpoolExtServiceName+"/SuggestedTip", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *txpoolExtClient) FeeHistory(ctx context.Context, percentiles *wrapperspb.BytesValue, opts ...grpc.CallOption) (*wrapperspb.BytesValue, error) {
+	out := new(wrapperspb.BytesValue)
+	if err := c.cc.Invoke(ctx, "/"+txpoolExtServiceName+"/FeeHistory", percentiles, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TxpoolExtClientDirect is the TxpoolExtClient of an in-process server
+type TxpoolExtClientDirect struct {
+	server TxpoolExtServer
+}
+
+func (c *TxpoolExtClientDirect) PendingBalance(ctx context.Context, addr *types2.H160, opts ...grpc.CallOption) (*types2.H256, error) {
+	return c.server.PendingBalance(ctx, addr)
+}
+
+func (c *TxpoolExtClientDirect) GetBlobs(ctx context.Context, blobHashes *txpool_proto.TxHashes, opts ...grpc.CallOption) (*types2.BlobsBundleV1, error) {
+	return c.server.GetBlobs(ctx, blobHashes)
+}
+
+func (c *TxpoolExtClientDirect) SuggestedTip(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*wrapperspb.UInt64Value, error) {
+	return c.server.SuggestedTip(ctx, in)
+}
+
+func (c *TxpoolExtClientDirect) FeeHistory(ctx context.Context, percentiles *wrapperspb.BytesValue, opts ...grpc.CallOption) (*wrapperspb.BytesValue, error) {
+	return c.server.FeeHistory(ctx, percentiles)
+}
+
+type txpoolClientWithExt struct {
+	txpool_proto.TxpoolClient
+	TxpoolExtClient
+}
+
+// WithExt returns the client with the TxpoolExt methods of ext, the callers find them with a type assertion, see
+// ExtOf
+func WithExt(client txpool_proto.TxpoolClient, ext TxpoolExtClient) txpool_proto.TxpoolClient {
+	return txpoolClientWithExt{TxpoolClient: client, TxpoolExtClient: ext}
+}
+
+// WithDirectExt is WithExt of an in-process server, the client is returned as is when the server doesn't implement
+// TxpoolExt (e.g. GrpcDisabled)
+func WithDirectExt(client txpool_proto.TxpoolClient, txPoolServer txpool_proto.TxpoolServer) txpool_proto.TxpoolClient {
+	srv, ok := txPoolServer.(TxpoolExtServer)
+	if !ok {
+		return client
+	}
+	return WithExt(client, &TxpoolExtClientDirect{server: srv})
+}
+
+// ExtOf returns the TxpoolExt methods of the client returned by WithExt, false for other clients
+func ExtOf(client txpool_proto.TxpoolClient) (TxpoolExtClient, bool) {
+	ext, ok := client.(TxpoolExtClient)
+	return ext, ok
+}
+
+// IsExtUnsupported reports whether the call failed because the txpool server doesn't serve TxpoolExt, e.g. of an
+// older version
+func IsExtUnsupported(err error) bool {
+	return status.Code(err) == codes.Unimplemented
+}
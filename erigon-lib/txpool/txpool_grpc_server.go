@@ -18,6 +18,7 @@ package txpool
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -31,12 +32,15 @@ import (
 	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
 	"github.com/ledgerwatch/log/v3"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces"
@@ -55,10 +59,15 @@ type txPool interface {
 	PeekBest(n uint16, txs *types.TxsRlp, tx kv.Tx, onTopOf, availableGas, availableBlobGas uint64) (bool, error)
 	GetRlp(tx kv.Tx, hash []byte) ([]byte, error)
 	AddLocalTxs(ctx context.Context, newTxs types.TxSlots, tx kv.Tx) ([]txpoolcfg.DiscardReason, error)
+	AddLocalTxsWithConditions(ctx context.Context, newTxs types.TxSlots, conditions []*TxConditions, tx kv.Tx) ([]txpoolcfg.DiscardReason, error)
 	deprecatedForEach(_ context.Context, f func(rlp []byte, sender common.Address, t SubPoolType), tx kv.Tx)
 	CountContent() (int, int, int)
 	IdHashKnown(tx kv.Tx, hash []byte) (bool, error)
-	NonceFromAddress(addr [20]byte) (nonce uint64, inPool bool)
+	NonceFromPool(addr common.Address) (nonce uint64, inPool bool)
+	PendingState(ctx context.Context, addr common.Address) (PendingState, error)
+	GetBlobsByHash(tx kv.Tx, blobHashes []common.Hash) ([]*BlobAndProof, error)
+	SuggestedTip() uint64
+	FeeHistory(percentiles []float64) ([]BlockTips, error)
 }
 
 var _ txpool_proto.TxpoolServer = (*GrpcServer)(nil)   // compile-time interface check
@@ -181,13 +190,32 @@ func (s *GrpcServer) Add(ctx context.Context, in *txpool_proto.AddRequest) (*txp
 	}
 	defer tx.Rollback()
 
+	// conditional txs (eth_sendRawTransactionConditional) come with their conditions in the metadata, see WithConditions
+	conditions, err := conditionsFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if conditions != nil && len(conditions) != len(in.RlpTxs) {
+		return nil, fmt.Errorf("%w: %d conditions for %d txs", ErrConditionsInvalid, len(conditions), len(in.RlpTxs))
+	}
+	var slotConditions []*TxConditions
+
 	var slots types.TxSlots
 	parseCtx := types.NewTxParseContext(s.chainID).ChainIDRequired()
 	parseCtx.ValidateRLP(s.txPool.ValidateSerializedTxn)
+	parseCtx.WithLocal(true)
+	parseCtx.WithDataPrefix(true) // for the admission filters
 
 	reply := &txpool_proto.AddReply{Imported: make([]txpool_proto.ImportResult, len(in.RlpTxs)), Errors: make([]string, len(in.RlpTxs))}
 
 	for i := 0; i < len(in.RlpTxs); i++ {
+		if conditions != nil && conditions[i] != nil {
+			if err := conditions[i].Validate(); err != nil {
+				reply.Errors[i] = err.Error()
+				reply.Imported[i] = txpool_proto.ImportResult_INVALID
+				continue
+			}
+		}
 		j := len(slots.Txs) // some incoming txs may be rejected, so - need second index
 		slots.Resize(uint(j + 1))
 		slots.Txs[j] = &types.TxSlot{}
@@ -205,14 +233,26 @@ func (s *GrpcServer) Add(ctx context.Context, in *txpool_proto.AddRequest) (*txp
 			} else if errors.Is(err, types.ErrRlpTooBig) { // Noop, but need to handle to not count these
 				reply.Errors[i] = txpoolcfg.RLPTooLong.String()
 				reply.Imported[i] = txpool_proto.ImportResult_INVALID
+			} else if errors.Is(err, types.ErrDepositTx) {
+				reply.Errors[i] = txpoolcfg.DepositTxn.String()
+				reply.Imported[i] = txpool_proto.ImportResult_INVALID
 			} else {
 				reply.Errors[i] = err.Error()
 				reply.Imported[i] = txpool_proto.ImportResult_INTERNAL_ERROR
 			}
+			continue
+		}
+		if conditions != nil {
+			slotConditions = append(slotConditions, conditions[i])
 		}
 	}
 
-	discardReasons, err := s.txPool.AddLocalTxs(ctx, slots, tx)
+	var discardReasons []txpoolcfg.DiscardReason
+	if conditions != nil {
+		discardReasons, err = s.txPool.AddLocalTxsWithConditions(ctx, slots, slotConditions, tx)
+	} else {
+		discardReasons, err = s.txPool.AddLocalTxs(ctx, slots, tx)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -220,8 +260,7 @@ func (s *GrpcServer) Add(ctx context.Context, in *txpool_proto.AddRequest) (*txp
 	j := 0
 	for i := range reply.Imported {
 		if reply.Imported[i] != txpool_proto.ImportResult_SUCCESS {
-			j++
-			continue
+			continue // rejected before reaching the pool, not in slots
 		}
 
 		reply.Imported[i] = mapDiscardReasonToProto(discardReasons[j])
@@ -237,7 +276,7 @@ func mapDiscardReasonToProto(reason txpoolcfg.DiscardReason) txpool_proto.Import
 		return txpool_proto.ImportResult_SUCCESS
 	case txpoolcfg.AlreadyKnown:
 		return txpool_proto.ImportResult_ALREADY_EXISTS
-	case txpoolcfg.UnderPriced, txpoolcfg.ReplaceUnderpriced, txpoolcfg.FeeTooLow:
+	case txpoolcfg.UnderPriced, txpoolcfg.ReplaceUnderpriced, txpoolcfg.FeeTooLow, txpoolcfg.TipTooLow:
 		return txpool_proto.ImportResult_FEE_TOO_LOW
 	case txpoolcfg.InvalidSender, txpoolcfg.NegativeValue, txpoolcfg.OversizedData, txpoolcfg.InitCodeTooLarge, txpoolcfg.RLPTooLong, txpoolcfg.CreateBlobTxn, txpoolcfg.NoBlobs, txpoolcfg.TooManyBlobs, txpoolcfg.TypeNotActivated, txpoolcfg.UnequalBlobTxExt, txpoolcfg.BlobHashCheckFail, txpoolcfg.UnmatchedBlobTxExt:
 		// TODO(eip-4844) TypeNotActivated may be transient (e.g. a blob transaction is submitted 1 sec prior to Cancun activation)
@@ -293,16 +332,71 @@ func (s *GrpcServer) Status(_ context.Context, _ *txpool_proto.StatusRequest) (*
 	}, nil
 }
 
-// returns nonce for address
+// returns the nonce of the last pool txn of the address applicable on the latest state, not found if none is
 func (s *GrpcServer) Nonce(ctx context.Context, in *txpool_proto.NonceRequest) (*txpool_proto.NonceReply, error) {
 	addr := gointerfaces.ConvertH160toAddress(in.Address)
-	nonce, inPool := s.txPool.NonceFromAddress(addr)
+	nonce, inPool := s.txPool.NonceFromPool(addr)
 	return &txpool_proto.NonceReply{
 		Nonce: nonce,
 		Found: inPool,
 	}, nil
 }
 
+func (s *GrpcServer) PendingBalance(ctx context.Context, in *types2.H160) (*types2.H256, error) {
+	state, err := s.txPool.PendingState(ctx, gointerfaces.ConvertH160toAddress(in))
+	if err != nil {
+		return nil, err
+	}
+	return gointerfaces.ConvertUint256IntToH256(&state.Balance), nil
+}
+
+// GetBlobs returns the blobs, and their proofs, with the given versioned hashes, empty for the blobs not in the pool
+func (s *GrpcServer) GetBlobs(ctx context.Context, in *txpool_proto.TxHashes) (*types2.BlobsBundleV1, error) {
+	tx, err := s.db.BeginRo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	hashes := make([]common.Hash, len(in.Hashes))
+	for i := range in.Hashes {
+		hashes[i] = gointerfaces.ConvertH256ToHash(in.Hashes[i])
+	}
+	blobs, err := s.txPool.GetBlobsByHash(tx, hashes)
+	if err != nil {
+		return nil, err
+	}
+	reply := &types2.BlobsBundleV1{Blobs: make([][]byte, len(blobs)), Proofs: make([][]byte, len(blobs))}
+	for i, blob := range blobs {
+		if blob == nil {
+			reply.Blobs[i], reply.Proofs[i] = []byte{}, []byte{}
+			continue
+		}
+		reply.Blobs[i], reply.Proofs[i] = blob.Blob, common.Copy(blob.Proof[:])
+	}
+	return reply, nil
+}
+
+func (s *GrpcServer) SuggestedTip(context.Context, *emptypb.Empty) (*wrapperspb.UInt64Value, error) {
+	return wrapperspb.UInt64(s.txPool.SuggestedTip()), nil
+}
+
+func (s *GrpcServer) FeeHistory(_ context.Context, in *wrapperspb.BytesValue) (*wrapperspb.BytesValue, error) {
+	var percentiles []float64
+	if err := json.Unmarshal(in.Value, &percentiles); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "percentiles: %s", err)
+	}
+	history, err := s.txPool.FeeHistory(percentiles)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	encoded, err := json.Marshal(history)
+	if err != nil {
+		return nil, err
+	}
+	return wrapperspb.Bytes(encoded), nil
+}
+
 // NewSlotsStreams - it's safe to use this class as non-pointer
 type NewSlotsStreams struct {
 	chans map[uint]txpool_proto.Txpool_OnAddServer
@@ -389,6 +483,7 @@ func StartGrpc(txPoolServer txpool_proto.TxpoolServer, miningServer txpool_proto
 	reflection.Register(grpcServer) // Register reflection service on gRPC server.
 	if txPoolServer != nil {
 		txpool_proto.RegisterTxpoolServer(grpcServer, txPoolServer)
+		RegisterTxpoolExtServer(grpcServer, txPoolServer)
 	}
 	if miningServer != nil {
 		txpool_proto.RegisterMiningServer(grpcServer, miningServer)
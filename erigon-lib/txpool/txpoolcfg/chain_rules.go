@@ -0,0 +1,126 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpoolcfg
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ledgerwatch/erigon-lib/chain"
+	"github.com/ledgerwatch/erigon-lib/types"
+)
+
+// Fork is a fork changing the admission rules of the pool
+type Fork int
+
+const (
+	Frontier Fork = iota // always active
+	Berlin               // EIP-2930 access list txs
+	London               // EIP-1559 dynamic fee txs
+	Shanghai             // EIP-3860 initcode limit
+	Cancun               // EIP-4844 blob txs
+	Prague               // EIP-7702 set code txs
+)
+
+var forkNames = [...]string{"Frontier", "Berlin", "London", "Shanghai", "Cancun", "Prague"}
+
+func (f Fork) String() string { return forkNames[f] }
+
+// ChainRules is the fork schedule of the chain, as far as the pool is concerned. Forks are activated by block
+// number or by timestamp, nil means the fork is not scheduled
+type ChainRules struct {
+	BerlinBlock  *uint64
+	LondonBlock  *uint64
+	ShanghaiTime *uint64
+	AgraBlock    *uint64 // Polygon activates the Shanghai rules by block
+	CancunTime   *uint64
+	PragueTime   *uint64
+}
+
+// ChainRulesFromConfig returns the rules of the chain (mainnet, Sepolia, Gnosis, Polygon...) of the config
+func ChainRulesFromConfig(cfg *chain.Config) (ChainRules, error) {
+	var rules ChainRules
+	var agraBlock *big.Int
+	if cfg.Bor != nil {
+		agraBlock = cfg.Bor.GetAgraBlock()
+	}
+	for _, fork := range []struct {
+		name string
+		v    *big.Int
+		dst  **uint64
+	}{
+		{"berlinBlock", cfg.BerlinBlock, &rules.BerlinBlock},
+		{"londonBlock", cfg.LondonBlock, &rules.LondonBlock},
+		{"shanghaiTime", cfg.ShanghaiTime, &rules.ShanghaiTime},
+		{"agraBlock", agraBlock, &rules.AgraBlock},
+		{"cancunTime", cfg.CancunTime, &rules.CancunTime},
+		{"pragueTime", cfg.PragueTime, &rules.PragueTime},
+	} {
+		if fork.v == nil {
+			continue
+		}
+		if !fork.v.IsUint64() {
+			return ChainRules{}, fmt.Errorf("%s overflow", fork.name)
+		}
+		v := fork.v.Uint64()
+		*fork.dst = &v
+	}
+	return rules, nil
+}
+
+// IsActive reports whether the fork is active in the block of the number and timestamp
+func (r *ChainRules) IsActive(fork Fork, number, time uint64) bool {
+	switch fork {
+	case Frontier:
+		return true
+	case Berlin:
+		return activeAt(r.BerlinBlock, number)
+	case London:
+		return activeAt(r.LondonBlock, number)
+	case Shanghai:
+		return activeAt(r.ShanghaiTime, time) || activeAt(r.AgraBlock, number)
+	case Cancun:
+		return activeAt(r.CancunTime, time)
+	case Prague:
+		return activeAt(r.PragueTime, time)
+	default:
+		panic(fmt.Sprintf("fork: %d", fork))
+	}
+}
+
+func activeAt(activation *uint64, v uint64) bool {
+	return activation != nil && v >= *activation
+}
+
+// TxTypeFork returns the fork introducing the tx type, false for unknown types. The types added with
+// types.RegisterTxType belong to the chain registering them and are active from genesis
+func TxTypeFork(txType byte) (Fork, bool) {
+	switch txType {
+	case types.LegacyTxType:
+		return Frontier, true
+	case types.AccessListTxType:
+		return Berlin, true
+	case types.DynamicFeeTxType:
+		return London, true
+	case types.BlobTxType:
+		return Cancun, true
+	case types.SetCodeTxType:
+		return Prague, true
+	default:
+		return Frontier, types.IsKnownTxType(txType)
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/c2h5oh/datasize"
 
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/fixedgas"
 	emath "github.com/ledgerwatch/erigon-lib/common/math"
 	"github.com/ledgerwatch/erigon-lib/types"
@@ -30,16 +31,24 @@ import (
 
 type Config struct {
 	DBDir               string
-	TracedSenders       []string // List of senders for which tx pool should print out debugging info
+	TracedSenders       []string         // List of senders for which tx pool should print out debugging info
+	Locals              []common.Address // Senders whose transactions are treated as local, even if received from peers
 	PendingSubPoolLimit int
 	BaseFeeSubPoolLimit int
 	QueuedSubPoolLimit  int
 	MinFeeCap           uint64
-	AccountSlots        uint64 // Number of executable transaction slots guaranteed per account
-	BlobSlots           uint64 // Total number of blobs (not txs) allowed per account
-	TotalBlobPoolLimit  uint64 // Total number of blobs (not txs) allowed within the txpool
-	PriceBump           uint64 // Price bump percentage to replace an already existing transaction
-	BlobPriceBump       uint64 //Price bump percentage to replace an existing 4844 blob tx (type-3)
+	MinTip              uint64            // Minimal effective tip, min(tip, feeCap - baseFee) at the pending base fee, of non-local transactions, 0 means no limit
+	AccountSlots        uint64            // Number of executable transaction slots guaranteed per account
+	MaxAccountTxs       uint64            // Maximum number of transactions of a non-local account in the pool, 0 means unlimited
+	BlobSlots           uint64            // Total number of blobs (not txs) allowed per account
+	TotalBlobPoolLimit  uint64            // Total number of blobs (not txs) allowed within the txpool
+	MaxNonceGap         uint64            // How far ahead of the sender's state nonce non-local transactions may be, 0 means unlimited
+	TotalPoolSize       datasize.ByteSize // Total size of the transactions (RLP) allowed within the txpool, local ones aren't evicted, 0 means unlimited
+	PriceBump           uint64            // Price bump percentage to replace an already existing transaction
+	BlobPriceBump       uint64            //Price bump percentage to replace an existing 4844 blob tx (type-3)
+	Lifetime            time.Duration     // Maximum time non-local transactions stay in the pool, 0 means forever, a restart starts it anew
+	PeerTxsRate         uint64            // Transactions per second accepted from a single peer, 0 means unlimited
+	PeerBytesRate       datasize.ByteSize // Bytes of transactions per second accepted from a single peer, 0 means unlimited
 
 	// regular batch tasks processing
 	SyncToNewPeersEvery   time.Duration
@@ -69,8 +78,12 @@ var DefaultConfig = Config{
 	AccountSlots:       16,  //TODO: to choose right value (16 to be compatible with Geth)
 	BlobSlots:          48,  // Default for a total of 8 txs for 6 blobs each - for hive tests
 	TotalBlobPoolLimit: 480, // Default for a total of 10 different accounts hitting the above limit
-	PriceBump:          10,  // Price bump percentage to replace an already existing transaction
+	MaxNonceGap:        64,
+	PriceBump:          10, // Price bump percentage to replace an already existing transaction
 	BlobPriceBump:      100,
+	Lifetime:           3 * time.Hour,
+	PeerTxsRate:        1_000,
+	PeerBytesRate:      4 * datasize.MB,
 
 	NoGossip: false,
 }
@@ -110,6 +123,18 @@ const (
 	UnmatchedBlobTxExt  DiscardReason = 29 // KZGcommitments must match the corresponding blobs and proofs
 	BlobTxReplace       DiscardReason = 30 // Cannot replace type-3 blob txn with another type of txn
 	BlobPoolOverflow    DiscardReason = 31 // The total number of blobs (through blob txs) in the pool has reached its limit
+	CreateSetCodeTxn    DiscardReason = 32 // EIP-7702 transactions cannot have the form of a create transaction
+	NoAuthorizations    DiscardReason = 33 // EIP-7702 transactions with an empty authorization list are invalid
+	DepositTxn          DiscardReason = 34 // OP-stack deposit transactions are derived from L1 and can't be submitted
+	PoolSizeOverflow    DiscardReason = 35 // The total size of the transactions in the pool has reached its limit
+	Expired             DiscardReason = 36 // Non-local transaction stayed in the pool longer than its lifetime
+	NonceTooDistant     DiscardReason = 37 // Nonce is too far ahead of the sender's state nonce, see Config.MaxNonceGap
+	AuthConflict        DiscardReason = 38 // EIP-7702 authorization for the same authority and nonce is carried by another tx in the pool
+	DelegatedTxLimit    DiscardReason = 39 // EIP-7702 delegated accounts (pending or just landed) can only have a few txs in the pool
+	ConditionsNotMet    DiscardReason = 40 // Conditions of the conditional transaction failed at block building, or expired
+	Filtered            DiscardReason = 41 // Rejected by a custom admission policy, see TxPool.AddAdmissionFilters
+	AccountTxLimit      DiscardReason = 42 // The account has as many transactions in the pool as allowed, see Config.MaxAccountTxs
+	TipTooLow           DiscardReason = 43 // The effective tip at the pending base fee is under Config.MinTip, unlike UnderPriced it depends on the base fee
 
 )
 
@@ -169,10 +194,40 @@ func (r DiscardReason) String() string {
 		return "blob transactions must have at least one blob"
 	case TooManyBlobs:
 		return "max number of blobs exceeded"
+	case UnequalBlobTxExt:
+		return "blob_versioned_hashes, blobs, commitments and proofs must have equal number"
+	case BlobHashCheckFail:
+		return "KZG commitment's versioned hash doesn't match blob_versioned_hash"
+	case UnmatchedBlobTxExt:
+		return "KZG commitments don't match the blobs and proofs"
 	case BlobTxReplace:
 		return "can't replace blob-txn with a non-blob-txn"
 	case BlobPoolOverflow:
 		return "blobs limit in txpool is full"
+	case CreateSetCodeTxn:
+		return "set code transactions cannot have the form of a create transaction"
+	case NoAuthorizations:
+		return "set code transactions must have at least one authorization"
+	case DepositTxn:
+		return "deposit transactions can't be submitted to the pool"
+	case PoolSizeOverflow:
+		return "total size of transactions in the pool reached its limit"
+	case Expired:
+		return "transaction stayed in the pool longer than its lifetime"
+	case NonceTooDistant:
+		return "nonce too far ahead of the sender's nonce"
+	case AuthConflict:
+		return "authorization of the same authority and nonce is already in the pool"
+	case DelegatedTxLimit:
+		return "account with a delegation has too many transactions in the pool"
+	case ConditionsNotMet:
+		return "transaction conditions not met"
+	case Filtered:
+		return "rejected by the pool's admission policy"
+	case AccountTxLimit:
+		return "account has too many transactions in the pool"
+	case TipTooLow:
+		return "effective tip too low"
 	default:
 		panic(fmt.Sprintf("discard reason: %d", r))
 	}
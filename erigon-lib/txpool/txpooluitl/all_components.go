@@ -19,7 +19,6 @@ package txpooluitl
 import (
 	"context"
 	"fmt"
-	"math/big"
 	"time"
 
 	"github.com/c2h5oh/datasize"
@@ -134,14 +133,12 @@ func AllComponents(ctx context.Context, cfg txpoolcfg.Config, cache kvcache.Cach
 	chainID, _ := uint256.FromBig(chainConfig.ChainID)
 	maxBlobsPerBlock := chainConfig.GetMaxBlobsPerBlock()
 
-	shanghaiTime := chainConfig.ShanghaiTime
-	var agraBlock *big.Int
-	if chainConfig.Bor != nil {
-		agraBlock = chainConfig.Bor.GetAgraBlock()
+	rules, err := txpoolcfg.ChainRulesFromConfig(chainConfig)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
 	}
-	cancunTime := chainConfig.CancunTime
 
-	txPool, err := txpool.New(newTxs, chainDB, cfg, cache, *chainID, shanghaiTime, agraBlock, cancunTime, maxBlobsPerBlock, feeCalculator, logger)
+	txPool, err := txpool.New(newTxs, chainDB, cfg, cache, *chainID, rules, maxBlobsPerBlock, feeCalculator, logger)
 	if err != nil {
 		return nil, nil, nil, nil, nil, err
 	}
@@ -151,6 +148,11 @@ func AllComponents(ctx context.Context, cfg txpoolcfg.Config, cache kvcache.Cach
 	//fetch.ConnectSentries()
 
 	send := txpool.NewSend(ctx, sentryClients, txPool, logger)
+	knownTxs := txpool.NewKnownTxs(txpool.DefaultKnownTxsPerPeer)
+	fetch.SetKnownTxs(knownTxs)
+	fetch.SetPeerRateLimits(cfg.PeerTxsRate, cfg.PeerBytesRate)
+	fetch.SetMinFees(cfg.MinTip, cfg.MinFeeCap)
+	send.SetKnownTxs(knownTxs)
 	txpoolGrpcServer := txpool.NewGrpcServer(ctx, txPool, txPoolDB, *chainID, logger)
 	return txPoolDB, txPool, fetch, send, txpoolGrpcServer, nil
 }
@@ -0,0 +1,452 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package txpool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/log/v3"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+)
+
+// userOpMinValidity is how long a UserOperation must stay valid, after it is added, to be worth bundling
+const userOpMinValidity = 30 * time.Second
+
+var (
+	ErrUserOpEntryPoint     = errors.New("unsupported entry point")
+	ErrUserOpInvalid        = errors.New("invalid user operation")
+	ErrUserOpKnown          = errors.New("user operation already known")
+	ErrUserOpUnderpriced    = errors.New("user operation underpriced")
+	ErrUserOpSenderLimit    = errors.New("too many user operations of the sender")
+	ErrUserOpValidation     = errors.New("user operation validation failed")
+	ErrUserOpExpiresTooSoon = errors.New("user operation expires too soon")
+)
+
+// UserOperation is an ERC-4337 user operation, as accepted by the v0.6 EntryPoint
+type UserOperation struct {
+	Sender               common.Address   `json:"sender"`
+	Nonce                uint256.Int      `json:"nonce"`
+	InitCode             hexutility.Bytes `json:"initCode"`
+	CallData             hexutility.Bytes `json:"callData"`
+	CallGasLimit         uint256.Int      `json:"callGasLimit"`
+	VerificationGasLimit uint256.Int      `json:"verificationGasLimit"`
+	PreVerificationGas   uint256.Int      `json:"preVerificationGas"`
+	MaxFeePerGas         uint256.Int      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas uint256.Int      `json:"maxPriorityFeePerGas"`
+	PaymasterAndData     hexutility.Bytes `json:"paymasterAndData"`
+	Signature            hexutility.Bytes `json:"signature"`
+}
+
+// Hash returns the userOpHash of the operation: the hash signed by the account, and identifying the operation in the
+// pool and in the events of the entry point
+func (op *UserOperation) Hash(entryPoint common.Address, chainID *uint256.Int) common.Hash {
+	keccak := sha3.NewLegacyKeccak256()
+	packed := make([]byte, 0, 10*32)
+	packed = appendAddressWord(packed, op.Sender)
+	packed = appendUintWord(packed, &op.Nonce)
+	packed = appendKeccakWord(packed, keccak, op.InitCode)
+	packed = appendKeccakWord(packed, keccak, op.CallData)
+	packed = appendUintWord(packed, &op.CallGasLimit)
+	packed = appendUintWord(packed, &op.VerificationGasLimit)
+	packed = appendUintWord(packed, &op.PreVerificationGas)
+	packed = appendUintWord(packed, &op.MaxFeePerGas)
+	packed = appendUintWord(packed, &op.MaxPriorityFeePerGas)
+	packed = appendKeccakWord(packed, keccak, op.PaymasterAndData)
+
+	enc := make([]byte, 0, 3*32)
+	enc = appendKeccakWord(enc, keccak, packed)
+	enc = appendAddressWord(enc, entryPoint)
+	enc = appendUintWord(enc, chainID)
+	var h common.Hash
+	copy(h[:], appendKeccakWord(nil, keccak, enc))
+	return h
+}
+
+// ABI encoding of the words of the userOpHash preimage
+func appendAddressWord(buf []byte, addr common.Address) []byte {
+	buf = append(buf, make([]byte, 12)...)
+	return append(buf, addr[:]...)
+}
+func appendUintWord(buf []byte, v *uint256.Int) []byte {
+	word := v.Bytes32()
+	return append(buf, word[:]...)
+}
+func appendKeccakWord(buf []byte, keccak hash.Hash, data []byte) []byte {
+	keccak.Reset()
+	keccak.Write(data)
+	return keccak.Sum(buf)
+}
+
+// UserOpValidation is the outcome of the simulated validation of a UserOperation (EntryPoint.simulateValidation)
+type UserOpValidation struct {
+	ValidAfter uint64 // the operation can be bundled from this timestamp
+	ValidUntil uint64 // and until this one, 0 is unbounded
+}
+
+// UserOpValidator simulates the validation of the operations against the latest state: the account (and paymaster)
+// must accept the operation and pay for it, following the ERC-7562 validation rules. The error rejects the operation
+type UserOpValidator interface {
+	SimulateValidation(ctx context.Context, op *UserOperation, entryPoint common.Address) (*UserOpValidation, error)
+}
+
+type UserOpPoolConfig struct {
+	EntryPoints         []common.Address // the supported entry points
+	MaxUserOps          int              // above it, the operations with the lowest priority fee are evicted
+	MaxUserOpsPerSender int
+	MaxVerificationGas  uint64
+	PriceBump           uint64 // Price bump percentage of both fees to replace an operation of the same sender and nonce
+}
+
+var DefaultUserOpPoolConfig = UserOpPoolConfig{
+	MaxUserOps:          4096,
+	MaxUserOpsPerSender: 4,
+	MaxVerificationGas:  5_000_000,
+	PriceBump:           10,
+}
+
+type userOpMeta struct {
+	op         *UserOperation
+	hash       common.Hash
+	entryPoint common.Address
+	validation UserOpValidation
+	tip        uint256.Int // effective priority fee at the base fee of the best slice, set by Best
+	bestIndex  int         // -1 unless the operation is the first one of its sender for the entry point
+	worstIndex int         // -1 unless the operation is the last one of its sender
+}
+
+// UserOpPool is the alternative mempool of ERC-4337 user operations, for the bundlers: operations are checked
+// statically and by the UserOpValidator when added, and served ordered by their priority fee. It is separate from
+// TxPool, operations are bundled into transactions of the bundler, added to TxPool as any other.
+// The node doesn't start it: there is no eth_sendUserOperation API nor UserOpValidator (the EVM simulation of the
+// entry point) in this repository, the bundler embedding the txpool creates it with its own, see NewUserOpPool
+type UserOpPool struct {
+	lock      sync.Mutex
+	cfg       UserOpPoolConfig
+	chainID   uint256.Int
+	validator UserOpValidator
+	byHash    map[common.Hash]*userOpMeta
+	bySender  map[common.Address][]*userOpMeta // ordered by nonce
+	best      *bestSlice[*userOpMeta]          // of the first operation of each sender for each entry point, see Best
+	worst     *WorstQueue[*userOpMeta]         // of the last operation of each sender, so that evictions leave no nonce gaps
+	sorted    bool                             // the best slice is sorted at its base fee
+	logger    log.Logger
+}
+
+// NewUserOpPool is the entry point of the bundlers, the validator simulates the operations against their state
+func NewUserOpPool(cfg UserOpPoolConfig, chainID uint256.Int, validator UserOpValidator, logger log.Logger) (*UserOpPool, error) {
+	if validator == nil {
+		return nil, errors.New("user operations validator is required")
+	}
+	if len(cfg.EntryPoints) == 0 {
+		return nil, errors.New("no entry points")
+	}
+	return &UserOpPool{
+		cfg:       cfg,
+		chainID:   chainID,
+		validator: validator,
+		byHash:    map[common.Hash]*userOpMeta{},
+		bySender:  map[common.Address][]*userOpMeta{},
+		best:      &bestSlice[*userOpMeta]{},
+		worst:     &WorstQueue[*userOpMeta]{},
+		logger:    logger,
+	}, nil
+}
+
+// Add validates the operation for the entry point, and adds it to the pool. It returns the userOpHash of the operation
+func (p *UserOpPool) Add(ctx context.Context, op *UserOperation, entryPoint common.Address) (common.Hash, error) {
+	if err := p.checkStatic(op, entryPoint); err != nil {
+		return common.Hash{}, err
+	}
+	hash := op.Hash(entryPoint, &p.chainID)
+	p.lock.Lock()
+	_, err := p.checkLocked(op, hash, entryPoint)
+	p.lock.Unlock()
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	// simulation runs against the state, not under the lock
+	validation, err := p.validator.SimulateValidation(ctx, op, entryPoint)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("%w: %w", ErrUserOpValidation, err)
+	}
+	if validation.ValidUntil != 0 && time.Unix(int64(validation.ValidUntil), 0).Before(time.Now().Add(userOpMinValidity)) {
+		return common.Hash{}, fmt.Errorf("%w: validUntil %d", ErrUserOpExpiresTooSoon, validation.ValidUntil)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	replaced, err := p.checkLocked(op, hash, entryPoint) // the pool could change during the simulation
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if replaced != nil {
+		p.removeLocked(replaced)
+	} else if len(p.byHash) >= p.cfg.MaxUserOps {
+		worst := p.worst.ms[0]
+		if worst.op.Sender == op.Sender || !worst.op.MaxPriorityFeePerGas.Lt(&op.MaxPriorityFeePerGas) {
+			return common.Hash{}, fmt.Errorf("%w: pool is full", ErrUserOpUnderpriced)
+		}
+		p.logger.Debug("[txpool] user operation evicted", "hash", worst.hash, "sender", worst.op.Sender)
+		p.removeLocked(worst)
+	}
+	p.addLocked(&userOpMeta{op: op, hash: hash, entryPoint: entryPoint, validation: *validation})
+	return hash, nil
+}
+
+func (p *UserOpPool) checkStatic(op *UserOperation, entryPoint common.Address) error {
+	supported := false
+	for _, ep := range p.cfg.EntryPoints {
+		supported = supported || ep == entryPoint
+	}
+	if !supported {
+		return fmt.Errorf("%w: %x", ErrUserOpEntryPoint, entryPoint)
+	}
+	if op.MaxFeePerGas.Lt(&op.MaxPriorityFeePerGas) {
+		return fmt.Errorf("%w: maxPriorityFeePerGas %d > maxFeePerGas %d", ErrUserOpInvalid, &op.MaxPriorityFeePerGas, &op.MaxFeePerGas)
+	}
+	if !op.VerificationGasLimit.IsUint64() || op.VerificationGasLimit.Uint64() > p.cfg.MaxVerificationGas {
+		return fmt.Errorf("%w: verificationGasLimit %d above %d", ErrUserOpInvalid, &op.VerificationGasLimit, p.cfg.MaxVerificationGas)
+	}
+	// the factory, and the paymaster, addresses come first
+	if len(op.InitCode) > 0 && len(op.InitCode) < 20 {
+		return fmt.Errorf("%w: initCode shorter than an address", ErrUserOpInvalid)
+	}
+	if len(op.PaymasterAndData) > 0 && len(op.PaymasterAndData) < 20 {
+		return fmt.Errorf("%w: paymasterAndData shorter than an address", ErrUserOpInvalid)
+	}
+	return nil
+}
+
+// checkLocked returns the operation of the same sender and nonce replaced by op, if any
+func (p *UserOpPool) checkLocked(op *UserOperation, hash common.Hash, entryPoint common.Address) (*userOpMeta, error) {
+	if _, ok := p.byHash[hash]; ok {
+		return nil, ErrUserOpKnown
+	}
+	ops := p.bySender[op.Sender]
+	for _, mt := range ops {
+		if mt.entryPoint != entryPoint || !mt.op.Nonce.Eq(&op.Nonce) {
+			continue
+		}
+		if !bumped(&op.MaxFeePerGas, &mt.op.MaxFeePerGas, p.cfg.PriceBump) || !bumped(&op.MaxPriorityFeePerGas, &mt.op.MaxPriorityFeePerGas, p.cfg.PriceBump) {
+			return nil, fmt.Errorf("%w: replacement must bump both fees by %d%%", ErrUserOpUnderpriced, p.cfg.PriceBump)
+		}
+		return mt, nil
+	}
+	if len(ops) >= p.cfg.MaxUserOpsPerSender {
+		return nil, fmt.Errorf("%w: %d", ErrUserOpSenderLimit, len(ops))
+	}
+	return nil, nil
+}
+
+// bumped reports whether fee is at least bump percent above old
+func bumped(fee, old *uint256.Int, bump uint64) bool {
+	var threshold, n uint256.Int
+	threshold.Mul(old, n.SetUint64(100+bump))
+	return !n.Mul(fee, n.SetUint64(100)).Lt(&threshold)
+}
+
+func (p *UserOpPool) addLocked(mt *userOpMeta) {
+	p.byHash[mt.hash] = mt
+	mt.bestIndex, mt.worstIndex = -1, -1
+	ops := append(p.bySender[mt.op.Sender], mt)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].op.Nonce.Lt(&ops[j].op.Nonce) })
+	p.bySender[mt.op.Sender] = ops
+	p.updateQueuesLocked(ops)
+}
+
+func (p *UserOpPool) removeLocked(mt *userOpMeta) {
+	delete(p.byHash, mt.hash)
+	ops := p.bySender[mt.op.Sender]
+	for i := range ops {
+		if ops[i] == mt {
+			ops = append(ops[:i], ops[i+1:]...)
+			break
+		}
+	}
+	if len(ops) == 0 {
+		delete(p.bySender, mt.op.Sender)
+	} else {
+		p.bySender[mt.op.Sender] = ops
+	}
+	if mt.bestIndex >= 0 {
+		p.best.UnsafeRemove(mt)
+		p.sorted = false
+	}
+	if mt.worstIndex >= 0 {
+		heap.Remove(p.worst, mt.worstIndex)
+	}
+	p.updateQueuesLocked(ops)
+}
+
+// updateQueuesLocked keeps the first operation of the sender for each entry point, and only them, in the best slice,
+// and the last operation of the sender, and only it, in the worst queue
+func (p *UserOpPool) updateQueuesLocked(ops []*userOpMeta) {
+	for i, mt := range ops {
+		first := true
+		for _, prev := range ops[:i] {
+			first = first && prev.entryPoint != mt.entryPoint
+		}
+		if first && mt.bestIndex < 0 {
+			p.best.UnsafeAdd(mt)
+			p.sorted = false
+		} else if !first && mt.bestIndex >= 0 {
+			p.best.UnsafeRemove(mt)
+			p.sorted = false
+		}
+		if last := i == len(ops)-1; last && mt.worstIndex < 0 {
+			heap.Push(p.worst, mt)
+		} else if !last && mt.worstIndex >= 0 {
+			heap.Remove(p.worst, mt.worstIndex)
+		}
+	}
+}
+
+// Get returns the operation with the userOpHash, and its entry point
+func (p *UserOpPool) Get(hash common.Hash) (*UserOperation, common.Address, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	mt, ok := p.byHash[hash]
+	if !ok {
+		return nil, common.Address{}, false
+	}
+	return mt.op, mt.entryPoint, true
+}
+
+// BySender returns the operations of the sender, ordered by nonce
+func (p *UserOpPool) BySender(sender common.Address) []*UserOperation {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ops := make([]*UserOperation, 0, len(p.bySender[sender]))
+	for _, mt := range p.bySender[sender] {
+		ops = append(ops, mt.op)
+	}
+	return ops
+}
+
+// Best returns up to n operations for the entry point, to bundle into a block with the base fee: the first operation
+// of each sender which pays the base fee and is valid now, ordered by the effective priority fee. The following
+// operations of the sender depend on it. The best slice is sorted again only when it or the base fee changed
+func (p *UserOpPool) Best(entryPoint common.Address, n int, baseFee uint64) []*UserOperation {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.sorted || p.best.pendingBaseFee != baseFee {
+		var fee uint256.Int
+		fee.SetUint64(baseFee)
+		for _, mt := range p.best.ms {
+			mt.tip = mt.effectiveTip(&fee)
+		}
+		p.best.pendingBaseFee = baseFee
+		sort.Sort(p.best)
+		p.sorted = true
+	}
+	now := uint64(time.Now().Unix())
+	var ops []*UserOperation
+	for _, mt := range p.best.ms {
+		if len(ops) >= n {
+			break
+		}
+		if mt.entryPoint != entryPoint || mt.op.MaxFeePerGas.LtUint64(baseFee) ||
+			mt.validation.ValidAfter > now || (mt.validation.ValidUntil != 0 && mt.validation.ValidUntil <= now) {
+			continue
+		}
+		ops = append(ops, mt.op)
+	}
+	return ops
+}
+
+// Remove drops the operations, e.g. once their bundle is included into a block
+func (p *UserOpPool) Remove(hashes ...common.Hash) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, hash := range hashes {
+		if mt, ok := p.byHash[hash]; ok {
+			p.removeLocked(mt)
+		}
+	}
+}
+
+// Prune drops the operations which are not valid anymore at the time, and the following operations of their senders
+// for the entry point, which can't be bundled without them
+func (p *UserOpPool) Prune(now time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var expired []*userOpMeta
+	for _, mt := range p.byHash {
+		if mt.validation.ValidUntil != 0 && mt.validation.ValidUntil <= uint64(now.Unix()) {
+			expired = append(expired, mt)
+		}
+	}
+	for _, mt := range expired {
+		if _, ok := p.byHash[mt.hash]; !ok {
+			continue // following an operation pruned before
+		}
+		var pruned []*userOpMeta
+		for _, other := range p.bySender[mt.op.Sender] {
+			if other.entryPoint == mt.entryPoint && !other.op.Nonce.Lt(&mt.op.Nonce) {
+				pruned = append(pruned, other)
+			}
+		}
+		for _, other := range pruned {
+			if other != mt {
+				p.logger.Debug("[txpool] user operation evicted", "hash", other.hash, "sender", other.op.Sender, "gap", mt.hash)
+			}
+			p.removeLocked(other)
+		}
+	}
+}
+
+func (p *UserOpPool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.byHash)
+}
+
+// effectiveTip is the priority fee the operation pays over the base fee, 0 when it doesn't pay the base fee
+func (mt *userOpMeta) effectiveTip(baseFee *uint256.Int) (tip uint256.Int) {
+	if mt.op.MaxFeePerGas.Lt(baseFee) {
+		return tip
+	}
+	tip.Sub(&mt.op.MaxFeePerGas, baseFee)
+	if mt.op.MaxPriorityFeePerGas.Lt(&tip) {
+		tip.Set(&mt.op.MaxPriorityFeePerGas)
+	}
+	return tip
+}
+
+// better orders the best slice by the effective priority fee, which Best computes at the base fee of the slice
+func (mt *userOpMeta) better(than *userOpMeta, _ uint256.Int, _ uint64) bool {
+	return than.tip.Lt(&mt.tip)
+}
+
+// worse orders the worst queue by the priority fee
+func (mt *userOpMeta) worse(than *userOpMeta, _ uint256.Int, _ uint64) bool {
+	return mt.op.MaxPriorityFeePerGas.Lt(&than.op.MaxPriorityFeePerGas)
+}
+
+func (mt *userOpMeta) indexes() (bestIndex, worstIndex *int) { return &mt.bestIndex, &mt.worstIndex }
@@ -0,0 +1,222 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package testutil produces signed transactions with known fields, to be used as test vectors for the transaction parser.
+// It intentionally does not depend on the types package, so that it can be used from its tests
+package testutil
+
+import (
+	"fmt"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/secp256k1"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ledgerwatch/erigon-lib/rlp"
+)
+
+const (
+	LegacyTxType     byte = 0
+	AccessListTxType byte = 1 // EIP-2930
+	DynamicFeeTxType byte = 2 // EIP-1559
+	SetCodeTxType    byte = 4 // EIP-7702
+)
+
+// AccessTuple is the element of an EIP-2930 access list
+type AccessTuple struct {
+	Address     [20]byte
+	StorageKeys [][32]byte
+}
+
+// Authorization is the element of an EIP-7702 authorization list. It is encoded as is, without signing
+type Authorization struct {
+	ChainID uint256.Int
+	Address [20]byte
+	Nonce   uint64
+	YParity uint64
+	R, S    uint256.Int
+}
+
+// TxParams contains the unsigned fields of a transaction
+type TxParams struct {
+	Type       byte
+	ChainID    uint256.Int // Zero means unprotected (pre EIP-155) legacy transaction
+	Nonce      uint64
+	Tip        uint256.Int // Gas price for legacy and access list transactions
+	FeeCap     uint256.Int // Only for dynamic fee and set code transactions
+	Gas        uint64
+	To         *[20]byte // nil for contract creation
+	Value      uint256.Int
+	Data       []byte
+	AccessList []AccessTuple // Not allowed in legacy transactions
+
+	Authorizations []Authorization // Only for set code transactions
+}
+
+// BuildSignedTx encodes the transaction described by params and signs it with the given 32-byte private key.
+// Legacy transactions are returned as RLP list, typed transactions - as type byte followed by RLP list (without the string envelope)
+func BuildSignedTx(params TxParams, privKey []byte) ([]byte, error) {
+	if params.Type > DynamicFeeTxType && params.Type != SetCodeTxType {
+		return nil, fmt.Errorf("unsupported transaction type: %d", params.Type)
+	}
+	if params.Type == LegacyTxType && len(params.AccessList) > 0 {
+		return nil, fmt.Errorf("legacy transaction can't have access list")
+	}
+	fields := unsignedFields(params)
+
+	// Signing hash
+	var sigPayload []byte
+	if params.Type == LegacyTxType {
+		if !params.ChainID.IsZero() {
+			fields = appendU256(fields, &params.ChainID)
+			fields = appendString(fields, nil)
+			fields = appendString(fields, nil)
+		}
+		sigPayload = appendList(nil, fields)
+	} else {
+		sigPayload = appendList([]byte{params.Type}, fields)
+	}
+	h := sha3.NewLegacyKeccak256()
+	h.Write(sigPayload)
+	sighash := h.Sum(nil)
+	sig, err := secp256k1.Sign(sighash, privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	fields = unsignedFields(params)
+	var v uint256.Int
+	switch {
+	case params.Type != LegacyTxType:
+		v.SetUint64(uint64(sig[64]))
+	case params.ChainID.IsZero():
+		v.SetUint64(27 + uint64(sig[64]))
+	default:
+		v.Mul(&params.ChainID, uint256.NewInt(2))
+		v.AddUint64(&v, 35+uint64(sig[64]))
+	}
+	var r, s uint256.Int
+	r.SetBytes(sig[:32])
+	s.SetBytes(sig[32:64])
+	fields = appendU256(fields, &v)
+	fields = appendU256(fields, &r)
+	fields = appendU256(fields, &s)
+	if params.Type == LegacyTxType {
+		return appendList(nil, fields), nil
+	}
+	return appendList([]byte{params.Type}, fields), nil
+}
+
+// SignAuthorization sets YParity, R and S of the authorization to its EIP-7702 signature with the given 32-byte
+// private key, over keccak(0x05 || rlp([chainId, address, nonce]))
+func SignAuthorization(auth *Authorization, privKey []byte) error {
+	var fields []byte
+	fields = appendU256(fields, &auth.ChainID)
+	fields = appendString(fields, auth.Address[:])
+	fields = appendU64(fields, auth.Nonce)
+	h := sha3.NewLegacyKeccak256()
+	h.Write(appendList([]byte{0x05}, fields))
+	sig, err := secp256k1.Sign(h.Sum(nil), privKey)
+	if err != nil {
+		return err
+	}
+	auth.R.SetBytes(sig[:32])
+	auth.S.SetBytes(sig[32:64])
+	auth.YParity = uint64(sig[64])
+	return nil
+}
+
+// Address returns the address corresponding to the given 32-byte private key
+func Address(privKey []byte) (addr [20]byte) {
+	x, y := secp256k1.S256().ScalarBaseMult(privKey)
+	pubkey := secp256k1.S256().Marshal(x, y)
+	h := sha3.NewLegacyKeccak256()
+	h.Write(pubkey[1:])
+	copy(addr[:], h.Sum(nil)[12:])
+	return addr
+}
+
+// unsignedFields encodes the content of the signed list, up to and including the access list (authorization list for set code transactions)
+func unsignedFields(params TxParams) (b []byte) {
+	if params.Type != LegacyTxType {
+		b = appendU256(b, &params.ChainID)
+	}
+	b = appendU64(b, params.Nonce)
+	b = appendU256(b, &params.Tip)
+	if params.Type == DynamicFeeTxType || params.Type == SetCodeTxType {
+		b = appendU256(b, &params.FeeCap)
+	}
+	b = appendU64(b, params.Gas)
+	if params.To != nil {
+		b = appendString(b, params.To[:])
+	} else {
+		b = appendString(b, nil)
+	}
+	b = appendU256(b, &params.Value)
+	b = appendString(b, params.Data)
+	if params.Type != LegacyTxType {
+		var al []byte
+		for _, tuple := range params.AccessList {
+			var t, keys []byte
+			t = appendString(t, tuple.Address[:])
+			for _, key := range tuple.StorageKeys {
+				keys = appendString(keys, key[:])
+			}
+			t = appendList(t, keys)
+			al = appendList(al, t)
+		}
+		b = appendList(b, al)
+	}
+	if params.Type == SetCodeTxType {
+		var auths []byte
+		for i := range params.Authorizations {
+			auth := &params.Authorizations[i]
+			var a []byte
+			a = appendU256(a, &auth.ChainID)
+			a = appendString(a, auth.Address[:])
+			a = appendU64(a, auth.Nonce)
+			a = appendU64(a, auth.YParity)
+			a = appendU256(a, &auth.R)
+			a = appendU256(a, &auth.S)
+			auths = appendList(auths, a)
+		}
+		b = appendList(b, auths)
+	}
+	return b
+}
+
+func appendU64(b []byte, x uint64) []byte {
+	var buf [9]byte
+	return append(b, buf[:rlp.EncodeU64(x, buf[:])]...)
+}
+
+func appendU256(b []byte, x *uint256.Int) []byte {
+	if x.IsZero() {
+		return appendString(b, nil)
+	}
+	return appendString(b, x.Bytes())
+}
+
+func appendString(b, s []byte) []byte {
+	buf := make([]byte, rlp.StringLen(s)+9)
+	return append(b, buf[:rlp.EncodeString(s, buf)]...)
+}
+
+func appendList(b, content []byte) []byte {
+	var buf [10]byte
+	b = append(b, buf[:rlp.EncodeListPrefix(len(content), buf[:])]...)
+	return append(b, content...)
+}
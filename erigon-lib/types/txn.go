@@ -18,11 +18,13 @@ package types
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"math"
 	"math/bits"
 	"sort"
 
@@ -36,6 +38,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/length"
 	"github.com/ledgerwatch/erigon-lib/common/u256"
 	"github.com/ledgerwatch/erigon-lib/crypto"
+	libkzg "github.com/ledgerwatch/erigon-lib/crypto/kzg"
 	"github.com/ledgerwatch/erigon-lib/gointerfaces/types"
 	"github.com/ledgerwatch/erigon-lib/rlp"
 )
@@ -48,6 +51,7 @@ type TxParseConfig struct {
 // usage of TxContext helps avoid extra memory allocations
 type TxParseContext struct {
 	Keccak2         hash.Hash
+	authKeccak      hash.Hash // for the signing hashes of EIP-7702 authorizations, parsed while Keccak1 and Keccak2 are in use
 	Keccak1         hash.Hash
 	validateRlp     func([]byte) error
 	ChainID         uint256.Int // Signature values
@@ -58,12 +62,45 @@ type TxParseContext struct {
 	DeriveChainID   uint256.Int // pre-allocated variable to calculate Sub(&ctx.v, &ctx.chainIDMul)
 	cfg             TxParseConfig
 	buf             [65]byte // buffer needs to be enough for hashes (32 bytes) and for public key (65 bytes)
-	Sig             [65]byte
-	Sighash         [32]byte
+	Sig             [65]byte // Signature [R || S || V] of the last parsed transaction, overwritten by the next parse. Use ParseTransactionWithSig to keep it
+	Sighash         [32]byte // Signing hash of the last parsed transaction, overwritten by the next parse
 	withSender      bool
+	withSighash     bool // Compute Sighash and Sig even if the sender is not recovered
 	allowPreEip2s   bool // Allow s > secp256k1n/2; see EIP-2
 	chainIDRequired bool
 	IsProtected     bool
+	minGas          uint64      // Lower bound for the gas limit, 0 means disabled
+	maxGas          uint64      // Upper bound for the gas limit, 0 means disabled
+	withDataPrefix  bool        // Capture first 4 bytes of the data (method selector) into TxSlot
+	local           bool        // Mark parsed transactions as injected locally
+	minTip          uint64      // Lower bound for the tip (gas price for legacy transactions) of non-local transactions, 0 means disabled
+	minFeeCap       uint64      // Lower bound for the fee cap of non-local transactions, 0 means disabled
+	maxValue        uint256.Int // Upper bound for the transferred value, 0 means disabled
+	expectedChainID uint256.Int // Chain ID the transactions must be signed for, 0 means disabled
+	cost            uint256.Int // pre-allocated variable to calculate gas * feeCap + value
+	maxAlAddrs      int         // Maximum number of addresses in the access list, 0 means disabled
+	maxAlKeys       int         // Maximum number of storage keys in the access list, 0 means disabled
+	withOffsets     bool        // Record byte ranges of the fields of the last parsed transaction
+	offsets         FieldOffsets
+	enveloped       bool // Whether the last parsed typed transaction was wrapped into RLP string
+	mode            ParseMode
+	unsigned        bool // Parse transactions without signature, see WithUnsigned
+	nonCanonical    bool // Set when the transaction being parsed was only accepted because of ParseLenient
+	sha256          hash.Hash
+	keepAccessList  bool // Retain the access list in TxSlot, see TxSlot.AccessList
+	secpCtx         *secp256k1.Context
+	senderCache     *SenderCache
+	retainRaw       bool // Copy the encoding of the transaction into TxSlot.Rlp, instead of referring to the payload
+	alVisitor       AccessListVisitor
+	alKeys          [][32]byte // buffer for the storage keys passed to alVisitor
+}
+
+// AccessListVisitor is notified about every tuple of the access list while the transaction is parsed,
+// e.g. to build the set of warm slots without decoding the transaction again.
+// keys are only valid during the call. Since it is called during parsing, tuples of a transaction
+// which turns out to be invalid later may also be visited
+type AccessListVisitor interface {
+	OnAccessTuple(addr [20]byte, keys [][32]byte)
 }
 
 func NewTxParseContext(chainID uint256.Int) *TxParseContext {
@@ -74,10 +111,14 @@ func NewTxParseContext(chainID uint256.Int) *TxParseContext {
 		withSender: true,
 		Keccak1:    sha3.NewLegacyKeccak256(),
 		Keccak2:    sha3.NewLegacyKeccak256(),
+		authKeccak: sha3.NewLegacyKeccak256(),
+		sha256:     sha256.New(),
+		secpCtx:    secp256k1.DefaultContext,
 	}
 
 	// behave as of London enabled
 	ctx.cfg.ChainID.Set(&chainID)
+	ctx.expectedChainID.Set(&chainID)
 	ctx.ChainIDMul.Mul(&chainID, u256.N2)
 	return ctx
 }
@@ -95,12 +136,23 @@ type TxSlot struct {
 	DataNonZeroLen int
 	AlAddrCount    int      // Number of addresses in the access list
 	AlStorCount    int      // Number of storage keys in the access list
+	AuthCount      int      // Number of authorizations in the authorization list (EIP-7702)
 	Gas            uint64   // Gas limit of the transaction
 	IDHash         [32]byte // Transaction hash for the purposes of using it as a transaction Id
 	Traced         bool     // Whether transaction needs to be traced throughout transaction pool code and generate debug printing
 	Creation       bool     // Set to true if "To" field of the transaction is not set
 	Type           byte     // Transaction type
 	Size           uint32   // Size of the payload (without the RLP string envelope for typed transactions)
+	DataPrefix     [4]byte  // First 4 bytes of the data (method selector), zero-padded. Only set when TxParseContext.WithDataPrefix is on
+	HasData        bool     // Whether data is non-empty. Only set when TxParseContext.WithDataPrefix is on
+	local          bool     // Whether transaction has been injected locally (and not received via devp2p)
+	accessList     AccessList
+	to             common.Address // Recipient, zero for contract creation
+	sig            [65]byte       // [R || S || yParity]
+	unprotected    bool           // Legacy transaction signed without chain ID (pre EIP-155)
+	ChainID        uint256.Int    // Chain ID the transaction is signed for, derived from V for legacy ones, zero when unprotected
+	NonCanonical   bool           // Set if the transaction is not canonically encoded and was only accepted in ParseLenient mode
+	Unsigned       bool           // Set if the transaction was parsed with TxParseContext.WithUnsigned and carries no signature
 
 	// EIP-4844: Shard Blob Transactions
 	BlobFeeCap  uint256.Int // max_fee_per_blob_gas
@@ -108,6 +160,15 @@ type TxSlot struct {
 	Blobs       [][]byte
 	Commitments []gokzg4844.KZGCommitment
 	Proofs      []gokzg4844.KZGProof
+
+	// EIP-7702: Set Code Transactions
+	Authorities []Authority // Signers of the authorizations with valid signatures, only set when the sender is recovered
+}
+
+// Authority is the signer of an EIP-7702 authorization, and the nonce of its account the authorization is valid for
+type Authority struct {
+	Address common.Address
+	Nonce   uint64
 }
 
 const (
@@ -115,23 +176,141 @@ const (
 	AccessListTxType byte = 1 // EIP-2930
 	DynamicFeeTxType byte = 2 // EIP-1559
 	BlobTxType       byte = 3 // EIP-4844
+	SetCodeTxType    byte = 4 // EIP-7702
 )
 
+// DepositTxType is the type of OP-stack deposit transactions. They are derived from L1 by the rollup node, are not signed,
+// and thus can never be submitted to the pool, so they are recognised only to be rejected with ErrDepositTx
+const DepositTxType byte = 0x7e
+
 var ErrParseTxn = fmt.Errorf("%w transaction", rlp.ErrParse)
 
 var ErrRejected = errors.New("rejected")
 var ErrAlreadyKnown = errors.New("already known")
 var ErrRlpTooBig = errors.New("txn rlp too big")
 
+var ErrGasTooLow = fmt.Errorf("%w: gas too low", ErrParseTxn)
+var ErrGasTooHigh = fmt.Errorf("%w: gas too high", ErrParseTxn)
+var ErrUnderpriced = fmt.Errorf("%w: underpriced", ErrParseTxn)
+var ErrValueTooHigh = fmt.Errorf("%w: value too high", ErrParseTxn)
+var ErrCostOverflow = fmt.Errorf("%w: transaction cost overflows uint256", ErrParseTxn)
+var ErrAccessListTooLarge = fmt.Errorf("%w: access list too large", ErrParseTxn)
+var ErrInvalidTxType = fmt.Errorf("%w: invalid transaction type", ErrParseTxn)
+var ErrUnknownTxType = fmt.Errorf("%w: unknown transaction type", ErrInvalidTxType)
+var ErrDepositTx = fmt.Errorf("%w: deposit transactions can't be submitted to the pool", ErrInvalidTxType)
+var ErrLegacyFieldCount = fmt.Errorf("%w: legacy transaction must have exactly 9 fields", ErrParseTxn)
+var ErrBlobSidecarMismatch = fmt.Errorf("%w: blob sidecar does not match versioned hashes", ErrParseTxn)
+var ErrBlobHashMismatch = errors.New("blob versioned hash does not match commitment")
+
 // Set the RLP validate function
 func (ctx *TxParseContext) ValidateRLP(f func(txnRlp []byte) error) { ctx.validateRlp = f }
 
 // Set the with sender flag
 func (ctx *TxParseContext) WithSender(v bool) { ctx.withSender = v }
 
+// Set the flag to compute Sighash and Sig (and validate the signature values) even when the sender is not recovered,
+// e.g. to recover senders later with BatchRecoverSenders
+func (ctx *TxParseContext) WithSighash(v bool) { ctx.withSighash = v }
+
 // Set the AllowPreEIP2s flag
 func (ctx *TxParseContext) WithAllowPreEip2s(v bool) { ctx.allowPreEip2s = v }
 
+// Set the chain ID the transactions must be signed for, the ones signed for another chain are rejected at parse time.
+// It defaults to the chain ID of NewTxParseContext, zero disables the check: the chain ID is only recorded in
+// TxSlot.ChainID
+func (ctx *TxParseContext) WithExpectedChainID(chainID uint256.Int) {
+	ctx.expectedChainID.Set(&chainID)
+}
+
+// Set the bounds for the gas limit of parsed transactions, zero disables the corresponding check.
+// Consensus code must not use it: these bounds are a policy for transactions coming from the network
+func (ctx *TxParseContext) WithGasBounds(minGas, maxGas uint64) {
+	ctx.minGas = minGas
+	ctx.maxGas = maxGas
+}
+
+// Set the flag to capture the method selector (first 4 bytes of the data) into TxSlot.DataPrefix
+func (ctx *TxParseContext) WithDataPrefix(v bool) { ctx.withDataPrefix = v }
+
+// Set the local flag: transactions parsed with this context are marked as injected locally
+// (e.g. submitted via RPC), as opposed to received from the network
+func (ctx *TxParseContext) WithLocal(v bool) { ctx.local = v }
+
+// Set the price floor for non-local transactions, zero disables the corresponding check.
+// For legacy and access list transactions both tip and fee cap are equal to the gas price
+func (ctx *TxParseContext) WithMinFees(minTip, minFeeCap uint64) {
+	ctx.minTip = minTip
+	ctx.minFeeCap = minFeeCap
+}
+
+// Set the upper bound for the transferred value (e.g. total supply), zero disables the check
+func (ctx *TxParseContext) WithMaxValue(maxValue uint256.Int) { ctx.maxValue.Set(&maxValue) }
+
+// Set the limits for the number of addresses and storage keys in the access list, zero disables the corresponding check.
+// Parsing is aborted as soon as a limit is exceeded, without walking the remainder of the list.
+// Consensus code must not use it, but it is recommended for transactions coming from the network
+func (ctx *TxParseContext) WithAccessListLimits(maxAddrs, maxKeys int) {
+	ctx.maxAlAddrs = maxAddrs
+	ctx.maxAlKeys = maxKeys
+}
+
+// Set the flag to retain the parsed access list in TxSlot, see TxSlot.AccessList.
+// When it is off, only the numbers of addresses and storage keys are counted, without allocations
+func (ctx *TxParseContext) WithKeepAccessList(v bool) { ctx.keepAccessList = v }
+
+// Set the flag to copy the encoding of parsed transactions into TxSlot.Rlp, so that it stays valid after the payload buffer is reused,
+// e.g. to answer GetPooledTransactions or to re-broadcast without re-encoding. Otherwise, TxSlot.Rlp is a sub-slice of the payload.
+// Blobs of wrapped blob transactions are not copied and still refer to the payload
+func (ctx *TxParseContext) WithRawRetention(v bool) { ctx.retainRaw = v }
+
+// Set the visitor to be notified about the access list tuples of parsed transactions, nil disables it
+func (ctx *TxParseContext) WithAccessListVisitor(v AccessListVisitor) { ctx.alVisitor = v }
+
+// Set the cache of recovered senders, nil disables it. The cache can be shared by many contexts
+func (ctx *TxParseContext) WithSenderCache(c *SenderCache) { ctx.senderCache = c }
+
+// Set the flag to record byte ranges of the fields of parsed transactions, see FieldOffsets
+func (ctx *TxParseContext) WithFieldOffsets(v bool) { ctx.withOffsets = v }
+
+// FieldOffsets returns byte ranges of the fields of the last parsed transaction, if WithFieldOffsets is on
+func (ctx *TxParseContext) FieldOffsets() FieldOffsets { return ctx.offsets }
+
+// Enveloped returns whether the last parsed transaction was a typed transaction wrapped into RLP string (as in pool messages),
+// rather than the bare type byte followed by RLP list (as in the block bodies and receipts)
+func (ctx *TxParseContext) Enveloped() bool { return ctx.enveloped }
+
+// ParseMode selects how strictly the RLP encoding of transactions is checked
+type ParseMode byte
+
+const (
+	// ParseStrict rejects any encoding that is not canonical: integers and lengths with leading zeros,
+	// lengths in the long form that fit into the short one and single bytes encoded as strings.
+	// It is the default, and the only mode suitable for consensus-critical validation
+	ParseStrict ParseMode = iota
+	// ParseLenient accepts such encodings, but flags the transaction with TxSlot.NonCanonical.
+	// The same transaction then has several encodings (and hashes), so it must only be used
+	// for forgiving ingestion, e.g. over RPC or when replaying data of non-conforming encoders
+	ParseLenient
+)
+
+// Set the parse mode, ParseStrict by default
+func (ctx *TxParseContext) WithParseMode(mode ParseMode) { ctx.mode = mode }
+
+// Set the flag to parse transactions without signature, as used for simulation (eth_call, bundles): the list ends
+// after the last unsigned field, the slot is flagged with TxSlot.Unsigned, and neither sighash nor sender is computed.
+// The RLP is checked according to the parse mode
+func (ctx *TxParseContext) WithUnsigned(v bool) { ctx.unsigned = v }
+
+// Set the flag to reject non-canonical RLP, true by default. It is a shorthand for ParseStrict and ParseLenient:
+// disabling the strictness is unsafe for new transactions, but needed to replay the historical data of mainnet
+func (ctx *TxParseContext) WithStrictRLP(v bool) {
+	if v {
+		ctx.mode = ParseStrict
+	} else {
+		ctx.mode = ParseLenient
+	}
+}
+
 // Set ChainID-Required flag in the Parse context and return it
 func (ctx *TxParseContext) ChainIDRequired() *TxParseContext {
 	ctx.chainIDRequired = true
@@ -141,7 +320,7 @@ func (ctx *TxParseContext) ChainIDRequired() *TxParseContext {
 func PeekTransactionType(serialized []byte) (byte, error) {
 	dataPos, _, legacy, err := rlp.Prefix(serialized, 0)
 	if err != nil {
-		return LegacyTxType, fmt.Errorf("%w: size Prefix: %s", ErrParseTxn, err) //nolint
+		return LegacyTxType, fmt.Errorf("%w: size Prefix: %w", ErrParseTxn, err)
 	}
 	if legacy {
 		return LegacyTxType, nil
@@ -151,21 +330,174 @@ func PeekTransactionType(serialized []byte) (byte, error) {
 
 // ParseTransaction extracts all the information from the transactions's payload (RLP) necessary to build TxSlot.
 // It also performs syntactic validation of the transactions.
+// hasEnvelope means that typed transactions must be wrapped into RLP string, otherwise both forms are accepted,
+// and Enveloped tells which one was seen.
 // wrappedWithBlobs means that for blob (type 3) transactions the full version with blobs/commitments/proofs is expected
 // (see https://eips.ethereum.org/EIPS/eip-4844#networking).
+// On success, the returned p is the absolute position in payload right after the transaction, including its
+// envelope (if any) and blobs wrapper (if any), so it can be used as pos to parse the next transaction
+// from a concatenation of transactions.
 func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlot, sender []byte, hasEnvelope, wrappedWithBlobs bool, validateHash func([]byte) error) (p int, err error) {
+	return ctx.parseTransaction(payload, pos, slot, sender, nil, hasEnvelope, wrappedWithBlobs, validateHash)
+}
+
+// ParseTransactionWithSig is the same as ParseTransaction, but also copies the 65-byte signature [R || S || V] into sig.
+// Unlike ctx.Sig, which is reused by every parse, sig is owned by the caller and is not overwritten by subsequent parses.
+// Signature is only available if either sender or Sighash is computed (see WithSender and WithSighash)
+func (ctx *TxParseContext) ParseTransactionWithSig(payload []byte, pos int, slot *TxSlot, sender, sig []byte, hasEnvelope, wrappedWithBlobs bool, validateHash func([]byte) error) (p int, err error) {
+	if len(sig) != 65 {
+		return 0, fmt.Errorf("%w: expect sig buffer of len 65", ErrParseTxn)
+	}
+	return ctx.parseTransaction(payload, pos, slot, sender, sig, hasEnvelope, wrappedWithBlobs, validateHash)
+}
+
+// TransactionHash computes the transaction hash (same as IDHash set by ParseTransaction) without parsing and validating
+// the fields, which is much faster when only the hash is needed, e.g. to match transactions against the transactions root.
+// It returns the hash and the position right after the transaction. Blob transactions wrapped with blobs/commitments/proofs
+// are recognised and hashed without the wrapper
+func (ctx *TxParseContext) TransactionHash(payload []byte, pos int) (h [32]byte, p int, err error) {
+	if len(payload) == 0 {
+		return h, 0, fmt.Errorf("%w: empty rlp", ErrParseTxn)
+	}
+	dataPos, dataLen, legacy, err := ctx.rlpPrefix(payload, pos)
+	if err != nil {
+		return h, 0, fmt.Errorf("%w: size Prefix: %w", ErrParseTxn, err)
+	}
+	if dataLen == 0 {
+		return h, 0, fmt.Errorf("%w: transaction must be either 1 list or 1 string", ErrParseTxn)
+	}
+
+	ctx.Keccak1.Reset()
+	if legacy {
+		p = dataPos + dataLen
+		if _, err = ctx.Keccak1.Write(payload[pos:p]); err != nil {
+			return h, 0, fmt.Errorf("%w: computing IdHash: %w", ErrParseTxn, err)
+		}
+		_, _ = ctx.Keccak1.(io.Reader).Read(h[:])
+		return h, p, nil
+	}
+
+	txType := payload[dataPos]
+	if err = checkTxType(txType); err != nil {
+		return h, 0, err
+	}
+	if dataPos+1 >= len(payload) {
+		return h, 0, fmt.Errorf("%w: unexpected end of payload after txType", ErrParseTxn)
+	}
+	listPos := dataPos + 1
+	bodyPos, bodyLen, err := ctx.rlpList(payload, listPos)
+	if err != nil {
+		return h, 0, fmt.Errorf("%w: envelope Prefix: %w", ErrParseTxn, err)
+	}
+	p = bodyPos + bodyLen
+	// dataLen is 1 when the type byte is not wrapped into an envelope string
+	if dataLen > 1 && p != dataPos+dataLen {
+		return h, 0, fmt.Errorf("%w: transaction does not fill the envelope", ErrParseTxn)
+	}
+	hashEnd := p
+	if txType == BlobTxType && bodyLen > 0 {
+		// In the wrapped form the first element is the list of the transaction itself, rather than the chain ID
+		if innerPos, innerLen, isList, err := ctx.rlpPrefix(payload, bodyPos); err == nil && isList {
+			listPos, hashEnd = bodyPos, innerPos+innerLen
+		}
+	}
+
+	if _, err = ctx.Keccak1.Write([]byte{txType}); err != nil {
+		return h, 0, fmt.Errorf("%w: computing IdHash (hashing type Prefix): %w", ErrParseTxn, err)
+	}
+	if _, err = ctx.Keccak1.Write(payload[listPos:hashEnd]); err != nil {
+		return h, 0, fmt.Errorf("%w: computing IdHash (hashing the envelope): %w", ErrParseTxn, err)
+	}
+	_, _ = ctx.Keccak1.(io.Reader).Read(h[:])
+	return h, p, nil
+}
+
+// BlobSidecar contains blobs, commitments and proofs carried alongside blob transaction in its network form
+// (see https://eips.ethereum.org/EIPS/eip-4844#networking)
+type BlobSidecar struct {
+	Blobs       [][]byte // Sub-slices of the parsed payload
+	Commitments []gokzg4844.KZGCommitment
+	Proofs      []gokzg4844.KZGProof
+}
+
+// ParseBlobTransactionWrapped parses blob transaction in the network form, and returns its sidecar separately,
+// so that it can be kept in a blob store rather than in TxSlot, whose Blobs, Commitments and Proofs are left empty.
+// The numbers of blobs, commitments and proofs must match the number of versioned hashes.
+// slot.Rlp still refers to the whole network form
+func (ctx *TxParseContext) ParseBlobTransactionWrapped(payload []byte, pos int, slot *TxSlot, sender []byte, hasEnvelope bool, validateHash func([]byte) error) (sidecar BlobSidecar, p int, err error) {
+	p, err = ctx.ParseTransaction(payload, pos, slot, sender, hasEnvelope, true /* wrappedWithBlobs */, validateHash)
+	if err != nil {
+		return sidecar, p, err
+	}
+	if slot.Type != BlobTxType {
+		return sidecar, 0, fmt.Errorf("%w: expected blob transaction, got type %d", ErrParseTxn, slot.Type)
+	}
+	sidecar = BlobSidecar{Blobs: slot.Blobs, Commitments: slot.Commitments, Proofs: slot.Proofs}
+	slot.Blobs, slot.Commitments, slot.Proofs = nil, nil, nil
+	if n := len(slot.BlobHashes); len(sidecar.Blobs) != n || len(sidecar.Commitments) != n || len(sidecar.Proofs) != n {
+		return BlobSidecar{}, 0, fmt.Errorf("%w: %d versioned hashes, %d blobs, %d commitments, %d proofs", ErrBlobSidecarMismatch,
+			n, len(sidecar.Blobs), len(sidecar.Commitments), len(sidecar.Proofs))
+	}
+	return sidecar, p, nil
+}
+
+// TxTypeParser parses the fields of a transaction of a chain-specific type, see RegisterTxType.
+// payload ends where the RLP list of the fields ends, and pos is where its content begins.
+// It fills slot (except for Type, Rlp, Size and IDHash, which are set for all types) and, if ctx is set up WithSender,
+// writes the sender into sender, e.g. with ctx.RecoverSenderInto. It returns the position after the last field
+type TxTypeParser func(ctx *TxParseContext, payload []byte, pos int, slot *TxSlot, sender []byte) (p int, err error)
+
+var registeredTxTypes [0x80]TxTypeParser
+
+// RegisterTxType makes ParseTransaction accept typed transactions of the given type, e.g. proprietary transactions
+// of L2 chains, without changes to the parser. Their encoding must be type || rlp([fields...]), and their hash is
+// computed as for other typed transactions. It is not goroutine-safe and must be called during initialization
+func RegisterTxType(txType byte, parse TxTypeParser) {
+	if txType == LegacyTxType || txType >= 0x80 || int(txType) < len(txFields) {
+		panic(fmt.Sprintf("RegisterTxType: type %d is reserved", txType))
+	}
+	if registeredTxTypes[txType] != nil {
+		panic(fmt.Sprintf("RegisterTxType: type %d is already registered", txType))
+	}
+	registeredTxTypes[txType] = parse
+}
+
+// IsKnownTxType reports whether transactions of the type can be parsed: legacy, the typed ones of Ethereum
+// and the ones added with RegisterTxType
+func IsKnownTxType(txType byte) bool { return txType == LegacyTxType || checkTxType(txType) == nil }
+
+// checkTxType validates the type byte of EIP-2718 transaction. Type 0 is reserved (legacy transactions are
+// RLP lists, never prefixed with a type byte), and types from 0x80 collide with RLP string prefixes
+func checkTxType(txType byte) error {
+	switch {
+	case txType == LegacyTxType:
+		return fmt.Errorf("%w: type %d is reserved for legacy transactions", ErrInvalidTxType, txType)
+	case txType >= 0x80:
+		return fmt.Errorf("%w: type %d collides with RLP prefixes", ErrInvalidTxType, txType)
+	case registeredTxTypes[txType] != nil:
+		return nil
+	case txType == DepositTxType:
+		return ErrDepositTx
+	case int(txType) >= len(txFields):
+		return fmt.Errorf("%w: %d", ErrUnknownTxType, txType)
+	}
+	return nil
+}
+
+func (ctx *TxParseContext) parseTransaction(payload []byte, pos int, slot *TxSlot, sender, sig []byte, hasEnvelope, wrappedWithBlobs bool, validateHash func([]byte) error) (p int, err error) {
 	if len(payload) == 0 {
 		return 0, fmt.Errorf("%w: empty rlp", ErrParseTxn)
 	}
 	if ctx.withSender && len(sender) != 20 {
 		return 0, fmt.Errorf("%w: expect sender buffer of len 20", ErrParseTxn)
 	}
+	ctx.nonCanonical = false
 
 	// Legacy transactions have list Prefix, whereas EIP-2718 transactions have string Prefix
 	// therefore we assign the first returned value of Prefix function (list) to legacy variable
-	dataPos, dataLen, legacy, err := rlp.Prefix(payload, pos)
+	dataPos, dataLen, legacy, err := ctx.rlpPrefix(payload, pos)
 	if err != nil {
-		return 0, fmt.Errorf("%w: size Prefix: %s", ErrParseTxn, err) //nolint
+		return 0, fmt.Errorf("%w: size Prefix: %w", ErrParseTxn, err)
 	}
 	// This handles the transactions coming from other Erigon peers of older versions, which add 0x80 (empty) transactions into packets
 	if dataLen == 0 {
@@ -176,24 +508,27 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 			return 0, fmt.Errorf("%w: expected envelope in the payload, got %x", ErrParseTxn, payload[dataPos:dataPos+dataLen])
 		}
 	}
+	ctx.enveloped = !legacy && dataLen > 1
 
 	p = dataPos
+	slot.local = ctx.local
+	slot.Blobs, slot.Commitments, slot.Proofs = nil, nil, nil
 
 	var wrapperDataPos, wrapperDataLen int
 
 	// If it is non-legacy transaction, the transaction type follows, and then the list
 	if !legacy {
 		slot.Type = payload[p]
-		if slot.Type > BlobTxType {
-			return 0, fmt.Errorf("%w: unknown transaction type: %d", ErrParseTxn, slot.Type)
+		if err = checkTxType(slot.Type); err != nil {
+			return 0, err
 		}
 		p++
 		if p >= len(payload) {
 			return 0, fmt.Errorf("%w: unexpected end of payload after txType", ErrParseTxn)
 		}
-		dataPos, dataLen, err = rlp.List(payload, p)
+		dataPos, dataLen, err = ctx.rlpList(payload, p)
 		if err != nil {
-			return 0, fmt.Errorf("%w: envelope Prefix: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: envelope Prefix: %w", ErrParseTxn, err)
 		}
 		// For legacy transaction, the entire payload in expected to be in "rlp" field
 		// whereas for non-legacy, only the content of the envelope (start with position p)
@@ -203,9 +538,9 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 			p = dataPos
 			wrapperDataPos = dataPos
 			wrapperDataLen = dataLen
-			dataPos, dataLen, err = rlp.List(payload, dataPos)
+			dataPos, dataLen, err = ctx.rlpList(payload, dataPos)
 			if err != nil {
-				return 0, fmt.Errorf("%w: wrapped blob tx: %s", ErrParseTxn, err) //nolint
+				return 0, fmt.Errorf("%w: wrapped blob tx: %w", ErrParseTxn, err)
 			}
 		}
 	} else {
@@ -213,7 +548,7 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 		slot.Rlp = payload[pos : dataPos+dataLen]
 	}
 
-	p, err = ctx.parseTransactionBody(payload, pos, p, slot, sender, validateHash)
+	p, err = ctx.parseTransactionBody(payload, pos, p, slot, sender, sig, validateHash)
 	if err != nil {
 		return p, err
 	}
@@ -223,15 +558,15 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 			return 0, fmt.Errorf("%w: unexpected leftover after blob tx body", ErrParseTxn)
 		}
 
-		dataPos, dataLen, err = rlp.List(payload, p)
+		dataPos, dataLen, err = ctx.rlpList(payload, p)
 		if err != nil {
-			return 0, fmt.Errorf("%w: blobs len: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: blobs len: %w", ErrParseTxn, err)
 		}
 		blobPos := dataPos
 		for blobPos < dataPos+dataLen {
 			blobPos, err = rlp.StringOfLen(payload, blobPos, fixedgas.BlobSize)
 			if err != nil {
-				return 0, fmt.Errorf("%w: blob: %s", ErrParseTxn, err) //nolint
+				return 0, fmt.Errorf("%w: blob: %w", ErrParseTxn, err)
 			}
 			slot.Blobs = append(slot.Blobs, payload[blobPos:blobPos+fixedgas.BlobSize])
 			blobPos += fixedgas.BlobSize
@@ -241,15 +576,15 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 		}
 		p = blobPos
 
-		dataPos, dataLen, err = rlp.List(payload, p)
+		dataPos, dataLen, err = ctx.rlpList(payload, p)
 		if err != nil {
-			return 0, fmt.Errorf("%w: commitments len: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: commitments len: %w", ErrParseTxn, err)
 		}
 		commitmentPos := dataPos
 		for commitmentPos < dataPos+dataLen {
 			commitmentPos, err = rlp.StringOfLen(payload, commitmentPos, 48)
 			if err != nil {
-				return 0, fmt.Errorf("%w: commitment: %s", ErrParseTxn, err) //nolint
+				return 0, fmt.Errorf("%w: commitment: %w", ErrParseTxn, err)
 			}
 			var commitment gokzg4844.KZGCommitment
 			copy(commitment[:], payload[commitmentPos:commitmentPos+48])
@@ -261,15 +596,15 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 		}
 		p = commitmentPos
 
-		dataPos, dataLen, err = rlp.List(payload, p)
+		dataPos, dataLen, err = ctx.rlpList(payload, p)
 		if err != nil {
-			return 0, fmt.Errorf("%w: proofs len: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: proofs len: %w", ErrParseTxn, err)
 		}
 		proofPos := dataPos
 		for proofPos < dataPos+dataLen {
 			proofPos, err = rlp.StringOfLen(payload, proofPos, 48)
 			if err != nil {
-				return 0, fmt.Errorf("%w: proof: %s", ErrParseTxn, err) //nolint
+				return 0, fmt.Errorf("%w: proof: %w", ErrParseTxn, err)
 			}
 			var proof gokzg4844.KZGProof
 			copy(proof[:], payload[proofPos:proofPos+48])
@@ -287,13 +622,23 @@ func (ctx *TxParseContext) ParseTransaction(payload []byte, pos int, slot *TxSlo
 	}
 
 	slot.Size = uint32(len(slot.Rlp))
+	slot.NonCanonical = ctx.nonCanonical
+	if ctx.retainRaw {
+		slot.Rlp = common.Copy(slot.Rlp)
+	}
 
 	return p, err
 }
 
-func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slot *TxSlot, sender []byte, validateHash func([]byte) error) (p int, err error) {
+func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slot *TxSlot, sender, sig []byte, validateHash func([]byte) error) (p int, err error) {
 	p = p0
 	legacy := slot.Type == LegacyTxType
+	// End of the list of the transaction fields, for legacy transactions it is the whole RLP
+	listEnd := pos + len(slot.Rlp)
+	// Not all transaction types have access and authorization lists, so reset what could be left from the previous use of the slot
+	slot.AlAddrCount, slot.AlStorCount, slot.accessList, slot.AuthCount, slot.Authorities = 0, 0, nil, 0, nil
+	slot.BlobHashes = nil
+	slot.Unsigned = false
 
 	// Compute transaction hash
 	ctx.Keccak1.Reset()
@@ -301,20 +646,21 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 	if !legacy {
 		typeByte := []byte{slot.Type}
 		if _, err = ctx.Keccak1.Write(typeByte); err != nil {
-			return 0, fmt.Errorf("%w: computing IdHash (hashing type Prefix): %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: computing IdHash (hashing type Prefix): %w", ErrParseTxn, err)
 		}
 		if _, err = ctx.Keccak2.Write(typeByte); err != nil {
-			return 0, fmt.Errorf("%w: computing signHash (hashing type Prefix): %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: computing signHash (hashing type Prefix): %w", ErrParseTxn, err)
 		}
-		dataPos, dataLen, err := rlp.List(payload, p)
+		dataPos, dataLen, err := ctx.rlpList(payload, p)
 		if err != nil {
-			return 0, fmt.Errorf("%w: envelope Prefix: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: envelope Prefix: %w", ErrParseTxn, err)
 		}
 		// Hash the content of envelope, not the full payload
 		if _, err = ctx.Keccak1.Write(payload[p : dataPos+dataLen]); err != nil {
-			return 0, fmt.Errorf("%w: computing IdHash (hashing the envelope): %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: computing IdHash (hashing the envelope): %w", ErrParseTxn, err)
 		}
 		p = dataPos
+		listEnd = dataPos + dataLen
 	}
 
 	if ctx.validateRlp != nil {
@@ -323,158 +669,103 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 		}
 	}
 
-	// Remember where signing hash data begins (it will need to be wrapped in an RLP list)
-	sigHashPos := p
-	if !legacy {
-		p, err = rlp.U256(payload, p, &ctx.ChainID)
-		if err != nil {
-			return 0, fmt.Errorf("%w: chainId len: %s", ErrParseTxn, err) //nolint
-		}
-		if ctx.ChainID.IsZero() { // zero indicates that the chain ID was not specified in the tx.
-			if ctx.chainIDRequired {
-				return 0, fmt.Errorf("%w: chainID is required", ErrParseTxn)
+	if parse := registeredTxTypes[slot.Type]; parse != nil {
+		_, _ = ctx.Keccak1.(io.Reader).Read(slot.IDHash[:32])
+		if validateHash != nil {
+			if err := validateHash(slot.IDHash[:32]); err != nil {
+				return p, err
 			}
-			ctx.ChainID.Set(&ctx.cfg.ChainID)
 		}
-		if !ctx.ChainID.Eq(&ctx.cfg.ChainID) {
-			return 0, fmt.Errorf("%w: %s, %d (expected %d)", ErrParseTxn, "invalid chainID", ctx.ChainID.Uint64(), ctx.cfg.ChainID.Uint64())
-		}
-	}
-	// Next follows the nonce, which we need to parse
-	p, slot.Nonce, err = rlp.U64(payload, p)
-	if err != nil {
-		return 0, fmt.Errorf("%w: nonce: %s", ErrParseTxn, err) //nolint
-	}
-	// Next follows gas price or tip
-	p, err = rlp.U256(payload, p, &slot.Tip)
-	if err != nil {
-		return 0, fmt.Errorf("%w: tip: %s", ErrParseTxn, err) //nolint
-	}
-	// Next follows feeCap, but only for dynamic fee transactions, for legacy transaction, it is
-	// equal to tip
-	if slot.Type < DynamicFeeTxType {
-		slot.FeeCap = slot.Tip
-	} else {
-		p, err = rlp.U256(payload, p, &slot.FeeCap)
-		if err != nil {
-			return 0, fmt.Errorf("%w: feeCap: %s", ErrParseTxn, err) //nolint
+		if p, err = parse(ctx, payload[:listEnd], p, slot, sender); err != nil {
+			return 0, fmt.Errorf("%w: type %d: %w", ErrParseTxn, slot.Type, err)
 		}
-	}
-	// Next follows gas
-	p, slot.Gas, err = rlp.U64(payload, p)
-	if err != nil {
-		return 0, fmt.Errorf("%w: gas: %s", ErrParseTxn, err) //nolint
-	}
-	// Next follows the destination address (if present)
-	dataPos, dataLen, err := rlp.String(payload, p)
-	if err != nil {
-		return 0, fmt.Errorf("%w: to len: %s", ErrParseTxn, err) //nolint
-	}
-	if dataLen != 0 && dataLen != 20 {
-		return 0, fmt.Errorf("%w: unexpected length of to field: %d", ErrParseTxn, dataLen)
-	}
-
-	// Only note if To field is empty or not
-	slot.Creation = dataLen == 0
-	p = dataPos + dataLen
-	// Next follows value
-	p, err = rlp.U256(payload, p, &slot.Value)
-	if err != nil {
-		return 0, fmt.Errorf("%w: value: %s", ErrParseTxn, err) //nolint
-	}
-	// Next goes data, but we are only interesting in its length
-	dataPos, dataLen, err = rlp.String(payload, p)
-	if err != nil {
-		return 0, fmt.Errorf("%w: data len: %s", ErrParseTxn, err) //nolint
-	}
-	slot.DataLen = dataLen
-
-	// Zero and non-zero bytes are priced differently
-	slot.DataNonZeroLen = 0
-	for _, byt := range payload[dataPos : dataPos+dataLen] {
-		if byt != 0 {
-			slot.DataNonZeroLen++
+		if p != listEnd {
+			return 0, fmt.Errorf("%w: type %d: extraneous fields: %d bytes", ErrParseTxn, slot.Type, listEnd-p)
 		}
+		return p, nil
 	}
 
-	p = dataPos + dataLen
-
-	// Next follows access list for non-legacy transactions, we are only interesting in number of addresses and storage keys
-	if !legacy {
-		dataPos, dataLen, err = rlp.List(payload, p)
-		if err != nil {
-			return 0, fmt.Errorf("%w: access list len: %s", ErrParseTxn, err) //nolint
-		}
-		tuplePos := dataPos
-		for tuplePos < dataPos+dataLen {
-			var tupleLen int
-			tuplePos, tupleLen, err = rlp.List(payload, tuplePos)
+	// Remember where signing hash data begins (it will need to be wrapped in an RLP list)
+	sigHashPos := p
+	// Walk the unsigned fields in the order declared for the transaction type
+	if ctx.withOffsets {
+		ctx.offsets = FieldOffsets{}
+	}
+	for _, field := range txFields[slot.Type] {
+		fieldPos := p
+		switch field {
+		case fieldChainID:
+			p, err = ctx.parseChainID(payload, p)
+			slot.ChainID.Set(&ctx.ChainID)
+		case fieldNonce:
+			p, slot.Nonce, err = ctx.rlpU64(payload, p)
 			if err != nil {
-				return 0, fmt.Errorf("%w: tuple len: %s", ErrParseTxn, err) //nolint
+				err = fmt.Errorf("%w: nonce: %w", ErrParseTxn, err)
 			}
-			var addrPos int
-			addrPos, err = rlp.StringOfLen(payload, tuplePos, 20)
+		case fieldGasPrice:
+			// For transactions without fee market, both tip and feeCap are equal to gas price
+			p, err = ctx.rlpU256(payload, p, &slot.Tip)
 			if err != nil {
-				return 0, fmt.Errorf("%w: tuple addr len: %s", ErrParseTxn, err) //nolint
+				err = fmt.Errorf("%w: tip: %w", ErrParseTxn, err)
 			}
-			slot.AlAddrCount++
-			var storagePos, storageLen int
-			storagePos, storageLen, err = rlp.List(payload, addrPos+20)
+			slot.FeeCap = slot.Tip
+		case fieldTip:
+			p, err = ctx.rlpU256(payload, p, &slot.Tip)
 			if err != nil {
-				return 0, fmt.Errorf("%w: storage key list len: %s", ErrParseTxn, err) //nolint
+				err = fmt.Errorf("%w: tip: %w", ErrParseTxn, err)
 			}
-			skeyPos := storagePos
-			for skeyPos < storagePos+storageLen {
-				skeyPos, err = rlp.StringOfLen(payload, skeyPos, 32)
-				if err != nil {
-					return 0, fmt.Errorf("%w: tuple storage key len: %s", ErrParseTxn, err) //nolint
-				}
-				slot.AlStorCount++
-				skeyPos += 32
+		case fieldFeeCap:
+			p, err = ctx.rlpU256(payload, p, &slot.FeeCap)
+			if err != nil {
+				err = fmt.Errorf("%w: feeCap: %w", ErrParseTxn, err)
 			}
-			if skeyPos != storagePos+storageLen {
-				return 0, fmt.Errorf("%w: extraneous space in the tuple after storage key list", ErrParseTxn)
+		case fieldGas:
+			p, err = ctx.parseGas(payload, p, slot)
+		case fieldTo:
+			p, err = ctx.parseTo(payload, p, slot)
+		case fieldValue:
+			p, err = ctx.parseValue(payload, p, slot)
+		case fieldData:
+			p, err = ctx.parseData(payload, p, slot)
+		case fieldAccessList:
+			p, err = ctx.parseAccessList(payload, p, slot)
+		case fieldBlobFeeCap:
+			p, err = ctx.rlpU256(payload, p, &slot.BlobFeeCap)
+			if err != nil {
+				err = fmt.Errorf("%w: blob fee cap: %w", ErrParseTxn, err)
 			}
-			tuplePos += tupleLen
-		}
-		if tuplePos != dataPos+dataLen {
-			return 0, fmt.Errorf("%w: extraneous space in the access list after all tuples", ErrParseTxn)
+		case fieldBlobHashes:
+			p, err = ctx.parseBlobHashes(payload, p, slot)
+		case fieldAuthorizations:
+			p, err = ctx.parseAuthorizations(payload, p, slot)
 		}
-		p = dataPos + dataLen
-	}
-	if slot.Type == BlobTxType {
-		p, err = rlp.U256(payload, p, &slot.BlobFeeCap)
 		if err != nil {
-			return 0, fmt.Errorf("%w: blob fee cap: %s", ErrParseTxn, err) //nolint
+			return 0, err
 		}
-		dataPos, dataLen, err = rlp.List(payload, p)
-		if err != nil {
-			return 0, fmt.Errorf("%w: blob hashes len: %s", ErrParseTxn, err) //nolint
+		if ctx.withOffsets {
+			ctx.offsets.set(field, FieldRange{Start: fieldPos, End: p})
 		}
-		hashPos := dataPos
-		for hashPos < dataPos+dataLen {
-			var hash common.Hash
-			hashPos, err = rlp.ParseHash(payload, hashPos, hash[:])
-			if err != nil {
-				return 0, fmt.Errorf("%w: blob hash: %s", ErrParseTxn, err) //nolint
-			}
-			slot.BlobHashes = append(slot.BlobHashes, hash)
-		}
-		if hashPos != dataPos+dataLen {
-			return 0, fmt.Errorf("%w: extraneous space in the blob versioned hashes", ErrParseTxn)
-		}
-		p = dataPos + dataLen
+	}
+	if ctx.unsigned {
+		slot.Unsigned = true
+		return ctx.finishUnsigned(payload, pos, p, listEnd, slot, validateHash)
 	}
 	// This is where the data for Sighash ends
 	// Next follows V of the signature
 	var vByte byte
-	sigHashEnd := p
-	sigHashLen := uint(sigHashEnd - sigHashPos)
+	sigHashFields := payload[sigHashPos:p]
+	if ctx.nonCanonical && (ctx.withSender || ctx.withSighash) {
+		// the signer signed the canonical encoding of the fields, not the one received
+		if sigHashFields, err = canonicalFields(payload, sigHashPos, p, txFields[slot.Type]); err != nil {
+			return 0, fmt.Errorf("%w: canonical encoding: %w", ErrParseTxn, err)
+		}
+	}
+	sigHashLen := uint(len(sigHashFields))
 	var chainIDBits, chainIDLen int
 	if legacy {
-		p, err = rlp.U256(payload, p, &ctx.V)
+		p, err = ctx.rlpU256(payload, p, &ctx.V)
 		if err != nil {
-			return 0, fmt.Errorf("%w: V: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: V: %w", ErrParseTxn, err)
 		}
 		ctx.IsProtected = ctx.V.Eq(u256.N27) || ctx.V.Eq(u256.N28)
 		// Compute chainId from V
@@ -482,12 +773,14 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 			// Do not add chain id and two extra zeros
 			vByte = byte(ctx.V.Uint64() - 27)
 			ctx.ChainID.Set(&ctx.cfg.ChainID)
+			slot.ChainID.Clear()
 		} else {
 			ctx.ChainID.Sub(&ctx.V, u256.N35)
 			ctx.ChainID.Rsh(&ctx.ChainID, 1)
-			if !ctx.ChainID.Eq(&ctx.cfg.ChainID) {
-				return 0, fmt.Errorf("%w: %s, %d (expected %d)", ErrParseTxn, "invalid chainID", ctx.ChainID.Uint64(), ctx.cfg.ChainID.Uint64())
+			if !ctx.expectedChainID.IsZero() && !ctx.ChainID.Eq(&ctx.expectedChainID) {
+				return 0, fmt.Errorf("%w: %s, %d (expected %d)", ErrParseTxn, "invalid chainID", ctx.ChainID.Uint64(), ctx.expectedChainID.Uint64())
 			}
+			slot.ChainID.Set(&ctx.ChainID)
 
 			chainIDBits = ctx.ChainID.BitLen()
 			if chainIDBits <= 7 {
@@ -499,37 +792,47 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 			sigHashLen += uint(chainIDLen) // For chainId
 			sigHashLen += 2                // For two extra zeros
 
-			ctx.DeriveChainID.Sub(&ctx.V, &ctx.ChainIDMul)
-			vByte = byte(ctx.DeriveChainID.Sub(&ctx.DeriveChainID, u256.N8).Uint64() - 27)
+			// V = chainId * 2 + 35 + yParity, of the chain the transaction is signed for
+			ctx.DeriveChainID.Lsh(&ctx.ChainID, 1)
+			ctx.DeriveChainID.Sub(&ctx.V, &ctx.DeriveChainID)
+			vByte = byte(ctx.DeriveChainID.Uint64() - 35)
 		}
 	} else {
 		var v uint64
-		p, v, err = rlp.U64(payload, p)
+		p, v, err = ctx.rlpU64(payload, p)
 		if err != nil {
-			return 0, fmt.Errorf("%w: V: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: V: %w", ErrParseTxn, err)
 		}
 		if v > 1 {
 			return 0, fmt.Errorf("%w: V is loo large: %d", ErrParseTxn, v)
 		}
 		vByte = byte(v)
+		ctx.V.SetUint64(v)
 		ctx.IsProtected = true
 	}
 
 	// Next follows R of the signature
-	p, err = rlp.U256(payload, p, &ctx.R)
+	p, err = ctx.rlpU256(payload, p, &ctx.R)
 	if err != nil {
-		return 0, fmt.Errorf("%w: R: %s", ErrParseTxn, err) //nolint
+		return 0, fmt.Errorf("%w: R: %w", ErrParseTxn, err)
 	}
 	// New follows S of the signature
-	p, err = rlp.U256(payload, p, &ctx.S)
+	p, err = ctx.rlpU256(payload, p, &ctx.S)
 	if err != nil {
-		return 0, fmt.Errorf("%w: S: %s", ErrParseTxn, err) //nolint
+		return 0, fmt.Errorf("%w: S: %w", ErrParseTxn, err)
+	}
+	// S must be the last field, otherwise the fields are either misplaced or extraneous
+	if p != listEnd {
+		if legacy {
+			return 0, fmt.Errorf("%w: %d bytes after S", ErrLegacyFieldCount, listEnd-p)
+		}
+		return 0, fmt.Errorf("%w: extraneous fields after S: %d bytes", ErrParseTxn, listEnd-p)
 	}
 
 	// For legacy transactions, hash the full payload
 	if legacy {
 		if _, err = ctx.Keccak1.Write(payload[pos:p]); err != nil {
-			return 0, fmt.Errorf("%w: computing IdHash: %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: computing IdHash: %w", ErrParseTxn, err)
 		}
 	}
 	//ctx.keccak1.Sum(slot.IdHash[:0])
@@ -540,7 +843,13 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 		}
 	}
 
-	if !ctx.withSender {
+	r, s := ctx.R.Bytes32(), ctx.S.Bytes32()
+	copy(slot.sig[:32], r[:])
+	copy(slot.sig[32:64], s[:])
+	slot.sig[64] = vByte
+	slot.unprotected = legacy && ctx.IsProtected // IsProtected is set when V is 27 or 28, i.e. without chain ID
+
+	if !ctx.withSender && !ctx.withSighash {
 		return p, nil
 	}
 
@@ -553,25 +862,25 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 	if sigHashLen < 56 {
 		ctx.buf[0] = byte(sigHashLen) + 192
 		if _, err := ctx.Keccak2.Write(ctx.buf[:1]); err != nil {
-			return 0, fmt.Errorf("%w: computing signHash (hashing len Prefix): %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: computing signHash (hashing len Prefix): %w", ErrParseTxn, err)
 		}
 	} else {
 		beLen := common.BitLenToByteLen(bits.Len(sigHashLen))
 		binary.BigEndian.PutUint64(ctx.buf[1:], uint64(sigHashLen))
 		ctx.buf[8-beLen] = byte(beLen) + 247
 		if _, err := ctx.Keccak2.Write(ctx.buf[8-beLen : 9]); err != nil {
-			return 0, fmt.Errorf("%w: computing signHash (hashing len Prefix): %s", ErrParseTxn, err) //nolint
+			return 0, fmt.Errorf("%w: computing signHash (hashing len Prefix): %w", ErrParseTxn, err)
 		}
 	}
-	if _, err = ctx.Keccak2.Write(payload[sigHashPos:sigHashEnd]); err != nil {
-		return 0, fmt.Errorf("%w: computing signHash: %s", ErrParseTxn, err) //nolint
+	if _, err = ctx.Keccak2.Write(sigHashFields); err != nil {
+		return 0, fmt.Errorf("%w: computing signHash: %w", ErrParseTxn, err)
 	}
 	if legacy {
 		if chainIDLen > 0 {
 			if chainIDBits <= 7 {
 				ctx.buf[0] = byte(ctx.ChainID.Uint64())
 				if _, err := ctx.Keccak2.Write(ctx.buf[:1]); err != nil {
-					return 0, fmt.Errorf("%w: computing signHash (hashing legacy chainId): %s", ErrParseTxn, err) //nolint
+					return 0, fmt.Errorf("%w: computing signHash (hashing legacy chainId): %w", ErrParseTxn, err)
 				}
 			} else {
 				binary.BigEndian.PutUint64(ctx.buf[1:9], ctx.ChainID[3])
@@ -580,14 +889,14 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 				binary.BigEndian.PutUint64(ctx.buf[25:33], ctx.ChainID[0])
 				ctx.buf[32-chainIDLen] = 128 + byte(chainIDLen)
 				if _, err = ctx.Keccak2.Write(ctx.buf[32-chainIDLen : 33]); err != nil {
-					return 0, fmt.Errorf("%w: computing signHash (hashing legacy chainId): %s", ErrParseTxn, err) //nolint
+					return 0, fmt.Errorf("%w: computing signHash (hashing legacy chainId): %w", ErrParseTxn, err)
 				}
 			}
 			// Encode two zeros
 			ctx.buf[0] = 128
 			ctx.buf[1] = 128
 			if _, err := ctx.Keccak2.Write(ctx.buf[:2]); err != nil {
-				return 0, fmt.Errorf("%w: computing signHash (hashing zeros after legacy chainId): %s", ErrParseTxn, err) //nolint
+				return 0, fmt.Errorf("%w: computing signHash (hashing zeros after legacy chainId): %w", ErrParseTxn, err)
 			}
 		}
 	}
@@ -603,22 +912,563 @@ func (ctx *TxParseContext) parseTransactionBody(payload []byte, pos, p0 int, slo
 	binary.BigEndian.PutUint64(ctx.Sig[48:56], ctx.S[1])
 	binary.BigEndian.PutUint64(ctx.Sig[56:64], ctx.S[0])
 	ctx.Sig[64] = vByte
+	if sig != nil {
+		copy(sig, ctx.Sig[:])
+	}
+	if !ctx.withSender {
+		return p, nil
+	}
+	if ctx.senderCache != nil && ctx.senderCache.get(ctx.Sighash[:], ctx.Sig[:], sender) {
+		return p, nil
+	}
 	// recover sender
-	if _, err = secp256k1.RecoverPubkeyWithContext(secp256k1.DefaultContext, ctx.Sighash[:], ctx.Sig[:], ctx.buf[:0]); err != nil {
-		return 0, fmt.Errorf("%w: recovering sender from signature: %s", ErrParseTxn, err) //nolint
+	if err = recoverSender(ctx.secpCtx, ctx.Keccak2, ctx.Sighash[:], ctx.Sig[:], &ctx.buf, sender); err != nil {
+		return 0, err
+	}
+	if ctx.senderCache != nil {
+		ctx.senderCache.add(ctx.Sighash[:], ctx.Sig[:], sender)
+	}
+
+	return p, nil
+}
+
+// FieldRange is the [Start, End) range of the RLP encoding (including the prefix) of a field within the payload
+type FieldRange struct {
+	Start, End int
+}
+
+// FieldOffsets contains the positions of the fields of a transaction within the payload.
+// Fields absent in the transaction type have zero ranges, for legacy and access list transactions Tip and FeeCap are both the gas price
+type FieldOffsets struct {
+	Nonce      FieldRange
+	Tip        FieldRange
+	FeeCap     FieldRange
+	Gas        FieldRange
+	To         FieldRange
+	Value      FieldRange
+	Data       FieldRange
+	AccessList FieldRange
+}
+
+func (o *FieldOffsets) set(field txField, r FieldRange) {
+	switch field {
+	case fieldNonce:
+		o.Nonce = r
+	case fieldGasPrice:
+		o.Tip, o.FeeCap = r, r
+	case fieldTip:
+		o.Tip = r
+	case fieldFeeCap:
+		o.FeeCap = r
+	case fieldGas:
+		o.Gas = r
+	case fieldTo:
+		o.To = r
+	case fieldValue:
+		o.Value = r
+	case fieldData:
+		o.Data = r
+	case fieldAccessList:
+		o.AccessList = r
+	}
+}
+
+// txField identifies an unsigned field of a transaction
+type txField uint8
+
+const (
+	fieldChainID txField = iota
+	fieldNonce
+	fieldGasPrice // sets both tip and feeCap
+	fieldTip
+	fieldFeeCap
+	fieldGas
+	fieldTo
+	fieldValue
+	fieldData
+	fieldAccessList
+	fieldBlobFeeCap
+	fieldBlobHashes
+	fieldAuthorizations
+)
+
+// txFields declares, for each supported transaction type, the sequence of fields preceding the signature.
+// These are also the fields included into the signing hash, whose preimages are:
+//
+//	legacy:             rlp([nonce, gasPrice, gas, to, value, data])
+//	legacy (EIP-155):   rlp([nonce, gasPrice, gas, to, value, data, chainId, 0, 0])
+//	access list (0x01): 0x01 || rlp([chainId, nonce, gasPrice, gas, to, value, data, accessList])
+//	dynamic fee (0x02): 0x02 || rlp([chainId, nonce, tip, feeCap, gas, to, value, data, accessList])
+//	blob (0x03):        0x03 || rlp([chainId, nonce, tip, feeCap, gas, to, value, data, accessList, blobFeeCap, blobHashes])
+//	set code (0x04):    0x04 || rlp([chainId, nonce, tip, feeCap, gas, to, value, data, accessList, authorizations])
+//
+// Transaction hash (IDHash) preimage is the whole RLP list for legacy transactions, and type || rlp(signed list) for typed ones
+var txFields = [...][]txField{
+	LegacyTxType:     {fieldNonce, fieldGasPrice, fieldGas, fieldTo, fieldValue, fieldData},
+	AccessListTxType: {fieldChainID, fieldNonce, fieldGasPrice, fieldGas, fieldTo, fieldValue, fieldData, fieldAccessList},
+	DynamicFeeTxType: {fieldChainID, fieldNonce, fieldTip, fieldFeeCap, fieldGas, fieldTo, fieldValue, fieldData, fieldAccessList},
+	BlobTxType:       {fieldChainID, fieldNonce, fieldTip, fieldFeeCap, fieldGas, fieldTo, fieldValue, fieldData, fieldAccessList, fieldBlobFeeCap, fieldBlobHashes},
+	SetCodeTxType:    {fieldChainID, fieldNonce, fieldTip, fieldFeeCap, fieldGas, fieldTo, fieldValue, fieldData, fieldAccessList, fieldAuthorizations},
+}
+
+func (f txField) isInteger() bool {
+	switch f {
+	case fieldChainID, fieldNonce, fieldGasPrice, fieldTip, fieldFeeCap, fieldGas, fieldValue, fieldBlobFeeCap:
+		return true
+	}
+	return false
+}
+
+func (ctx *TxParseContext) parseChainID(payload []byte, pos int) (p int, err error) {
+	p, err = ctx.rlpU256(payload, pos, &ctx.ChainID)
+	if err != nil {
+		return 0, fmt.Errorf("%w: chainId len: %w", ErrParseTxn, err)
+	}
+	if ctx.ChainID.IsZero() { // zero indicates that the chain ID was not specified in the tx.
+		if ctx.chainIDRequired {
+			return 0, fmt.Errorf("%w: chainID is required", ErrParseTxn)
+		}
+		ctx.ChainID.Set(&ctx.cfg.ChainID)
+	}
+	if !ctx.expectedChainID.IsZero() && !ctx.ChainID.Eq(&ctx.expectedChainID) {
+		return 0, fmt.Errorf("%w: %s, %d (expected %d)", ErrParseTxn, "invalid chainID", ctx.ChainID.Uint64(), ctx.expectedChainID.Uint64())
+	}
+	return p, nil
+}
+
+// parseGas parses the gas limit and applies the gas and price policies: in all transaction types, fees precede gas
+func (ctx *TxParseContext) parseGas(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	if !ctx.local {
+		if ctx.minTip != 0 && slot.Tip.LtUint64(ctx.minTip) {
+			return 0, fmt.Errorf("%w: tip %s (min %d)", ErrUnderpriced, &slot.Tip, ctx.minTip)
+		}
+		if ctx.minFeeCap != 0 && slot.FeeCap.LtUint64(ctx.minFeeCap) {
+			return 0, fmt.Errorf("%w: feeCap %s (min %d)", ErrUnderpriced, &slot.FeeCap, ctx.minFeeCap)
+		}
+	}
+	p, slot.Gas, err = ctx.rlpU64(payload, pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: gas: %w", ErrParseTxn, err)
+	}
+	if ctx.minGas != 0 && slot.Gas < ctx.minGas {
+		return 0, fmt.Errorf("%w: %d (min %d)", ErrGasTooLow, slot.Gas, ctx.minGas)
+	}
+	if ctx.maxGas != 0 && slot.Gas > ctx.maxGas {
+		return 0, fmt.Errorf("%w: %d (max %d)", ErrGasTooHigh, slot.Gas, ctx.maxGas)
+	}
+	return p, nil
+}
+
+func (ctx *TxParseContext) parseTo(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	dataPos, dataLen, err := ctx.rlpString(payload, pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: to len: %w", ErrParseTxn, err)
+	}
+	if dataLen != 0 && dataLen != 20 {
+		return 0, fmt.Errorf("%w: unexpected length of to field: %d", ErrParseTxn, dataLen)
+	}
+
+	slot.Creation = dataLen == 0
+	if slot.Creation {
+		slot.to = common.Address{}
+	} else {
+		copy(slot.to[:], payload[dataPos:dataPos+dataLen])
+	}
+	return dataPos + dataLen, nil
+}
+
+// parseValue parses the transferred value and makes sure the maximum spend does not overflow: in all transaction types,
+// fees and gas precede the value
+func (ctx *TxParseContext) parseValue(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	p, err = ctx.rlpU256(payload, pos, &slot.Value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: value: %w", ErrParseTxn, err)
+	}
+	if !ctx.maxValue.IsZero() && slot.Value.Gt(&ctx.maxValue) {
+		return 0, fmt.Errorf("%w: %s (max %s)", ErrValueTooHigh, &slot.Value, &ctx.maxValue)
+	}
+	ctx.cost.SetUint64(slot.Gas)
+	if _, overflow := ctx.cost.MulOverflow(&ctx.cost, &slot.FeeCap); overflow {
+		return 0, ErrCostOverflow
+	}
+	if _, overflow := ctx.cost.AddOverflow(&ctx.cost, &slot.Value); overflow {
+		return 0, ErrCostOverflow
+	}
+	return p, nil
+}
+
+func (ctx *TxParseContext) parseData(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	// We are only interesting in the length of the data
+	dataPos, dataLen, err := ctx.rlpString(payload, pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: data len: %w", ErrParseTxn, err)
+	}
+	slot.DataLen = dataLen
+	if ctx.withDataPrefix {
+		slot.HasData = dataLen > 0
+		slot.DataPrefix = [4]byte{}
+		copy(slot.DataPrefix[:], payload[dataPos:dataPos+dataLen])
+	}
+
+	// Zero and non-zero bytes are priced differently
+	slot.DataNonZeroLen = 0
+	for _, byt := range payload[dataPos : dataPos+dataLen] {
+		if byt != 0 {
+			slot.DataNonZeroLen++
+		}
+	}
+	return dataPos + dataLen, nil
+}
+
+// parseAccessList walks the access list, counting addresses and storage keys, and retaining the tuples if keepAccessList is on
+func (ctx *TxParseContext) parseAccessList(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	dataPos, dataLen, err := ctx.rlpList(payload, pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: access list len: %w", ErrParseTxn, err)
+	}
+	tuplePos := dataPos
+	for tuplePos < dataPos+dataLen {
+		var tupleLen int
+		tuplePos, tupleLen, err = ctx.rlpList(payload, tuplePos)
+		if err != nil {
+			return 0, fmt.Errorf("%w: tuple len: %w", ErrParseTxn, err)
+		}
+		var addrPos int
+		addrPos, err = rlp.StringOfLen(payload, tuplePos, 20)
+		if err != nil {
+			return 0, fmt.Errorf("%w: tuple addr len: %w", ErrParseTxn, err)
+		}
+		slot.AlAddrCount++
+		if ctx.maxAlAddrs != 0 && slot.AlAddrCount > ctx.maxAlAddrs {
+			return 0, fmt.Errorf("%w: more than %d addresses", ErrAccessListTooLarge, ctx.maxAlAddrs)
+		}
+		var tuple AccessTuple
+		if ctx.keepAccessList || ctx.alVisitor != nil {
+			copy(tuple.Address[:], payload[addrPos:addrPos+20])
+		}
+		ctx.alKeys = ctx.alKeys[:0]
+		var storagePos, storageLen int
+		storagePos, storageLen, err = ctx.rlpList(payload, addrPos+20)
+		if err != nil {
+			return 0, fmt.Errorf("%w: storage key list len: %w", ErrParseTxn, err)
+		}
+		skeyPos := storagePos
+		for skeyPos < storagePos+storageLen {
+			skeyPos, err = rlp.StringOfLen(payload, skeyPos, 32)
+			if err != nil {
+				return 0, fmt.Errorf("%w: tuple storage key len: %w", ErrParseTxn, err)
+			}
+			slot.AlStorCount++
+			if ctx.maxAlKeys != 0 && slot.AlStorCount > ctx.maxAlKeys {
+				return 0, fmt.Errorf("%w: more than %d storage keys", ErrAccessListTooLarge, ctx.maxAlKeys)
+			}
+			if ctx.keepAccessList {
+				tuple.StorageKeys = append(tuple.StorageKeys, common.BytesToHash(payload[skeyPos:skeyPos+32]))
+			}
+			if ctx.alVisitor != nil {
+				ctx.alKeys = append(ctx.alKeys, [32]byte(payload[skeyPos:skeyPos+32]))
+			}
+			skeyPos += 32
+		}
+		if skeyPos != storagePos+storageLen {
+			return 0, fmt.Errorf("%w: extraneous space in the tuple after storage key list", ErrParseTxn)
+		}
+		if ctx.keepAccessList {
+			slot.accessList = append(slot.accessList, tuple)
+		}
+		if ctx.alVisitor != nil {
+			ctx.alVisitor.OnAccessTuple(tuple.Address, ctx.alKeys)
+		}
+		tuplePos += tupleLen
+	}
+	if tuplePos != dataPos+dataLen {
+		return 0, fmt.Errorf("%w: extraneous space in the access list after all tuples", ErrParseTxn)
+	}
+	return dataPos + dataLen, nil
+}
+
+func (ctx *TxParseContext) parseBlobHashes(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	dataPos, dataLen, err := ctx.rlpList(payload, pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: blob hashes len: %w", ErrParseTxn, err)
+	}
+	hashPos := dataPos
+	for hashPos < dataPos+dataLen {
+		var hash common.Hash
+		hashPos, err = rlp.ParseHash(payload, hashPos, hash[:])
+		if err != nil {
+			return 0, fmt.Errorf("%w: blob hash: %w", ErrParseTxn, err)
+		}
+		slot.BlobHashes = append(slot.BlobHashes, hash)
+	}
+	if hashPos != dataPos+dataLen {
+		return 0, fmt.Errorf("%w: extraneous space in the blob versioned hashes", ErrParseTxn)
+	}
+	return dataPos + dataLen, nil
+}
+
+func (ctx *TxParseContext) rlpPrefix(payload []byte, pos int) (dataPos, dataLen int, isList bool, err error) {
+	dataPos, dataLen, isList, err = rlp.Prefix(payload, pos)
+	if ctx.retryLenient(err) {
+		return rlp.PrefixLenient(payload, pos)
+	}
+	return
+}
+
+func (ctx *TxParseContext) rlpList(payload []byte, pos int) (dataPos, dataLen int, err error) {
+	dataPos, dataLen, err = rlp.List(payload, pos)
+	if ctx.retryLenient(err) {
+		return rlp.ListLenient(payload, pos)
+	}
+	return
+}
+
+func (ctx *TxParseContext) rlpString(payload []byte, pos int) (dataPos, dataLen int, err error) {
+	dataPos, dataLen, err = rlp.String(payload, pos)
+	if ctx.retryLenient(err) {
+		return rlp.StringLenient(payload, pos)
+	}
+	return
+}
+
+func (ctx *TxParseContext) rlpU64(payload []byte, pos int) (int, uint64, error) {
+	p, x, err := rlp.U64(payload, pos)
+	if ctx.retryLenient(err) {
+		return rlp.U64Lenient(payload, pos)
+	}
+	return p, x, err
+}
+
+func (ctx *TxParseContext) rlpU256(payload []byte, pos int, x *uint256.Int) (int, error) {
+	p, err := rlp.U256(payload, pos, x)
+	if ctx.retryLenient(err) {
+		return rlp.U256Lenient(payload, pos, x)
+	}
+	return p, err
+}
+
+// finishUnsigned completes the parsing of a transaction without signature: the unsigned fields must end the list,
+// IDHash is the hash of the encoding as it is, and the signature of the slot is left empty
+func (ctx *TxParseContext) finishUnsigned(payload []byte, pos, p, listEnd int, slot *TxSlot, validateHash func([]byte) error) (int, error) {
+	if p != listEnd {
+		return 0, fmt.Errorf("%w: extraneous fields in unsigned transaction: %d bytes", ErrParseTxn, listEnd-p)
+	}
+	if slot.Type == LegacyTxType {
+		if _, err := ctx.Keccak1.Write(payload[pos:p]); err != nil {
+			return 0, fmt.Errorf("%w: computing IdHash: %w", ErrParseTxn, err)
+		}
+	}
+	_, _ = ctx.Keccak1.(io.Reader).Read(slot.IDHash[:32])
+	if validateHash != nil {
+		if err := validateHash(slot.IDHash[:32]); err != nil {
+			return p, err
+		}
+	}
+	slot.sig, slot.unprotected = [65]byte{}, false
+	return p, nil
+}
+
+// retryLenient reports whether an element rejected by the strict decoder should be decoded again
+// in ParseLenient mode, flagging the transaction as non-canonical if so
+func (ctx *TxParseContext) retryLenient(err error) bool {
+	if err == nil || ctx.mode != ParseLenient || !errors.Is(err, rlp.ErrNonCanonicalRLP) {
+		return false
+	}
+	ctx.nonCanonical = true
+	return true
+}
+
+// canonicalFields returns the canonical encoding of the unsigned fields payload[pos:end] of a transaction accepted in
+// ParseLenient mode. The leading zeros of the integer fields, and of the integers of the authorizations, are dropped
+func canonicalFields(payload []byte, pos, end int, fields []txField) (canonical []byte, err error) {
+	var field txField
+	isInt := func(depth, index int) bool {
+		switch depth {
+		case 0:
+			return field.isInteger()
+		case 2: // [chainId, address, nonce, yParity, r, s]
+			return field == fieldAuthorizations && index != 1
+		}
+		return false
+	}
+	for i := 0; pos < end; i++ {
+		if i >= len(fields) {
+			return nil, fmt.Errorf("%w: extraneous fields", ErrParseTxn)
+		}
+		field = fields[i]
+		if canonical, pos, err = appendCanonicalRLP(canonical, payload, pos, 0, i, isInt); err != nil {
+			return nil, err
+		}
+	}
+	return canonical, nil
+}
+
+// appendCanonicalRLP appends the canonical encoding of the element at pos, decoded leniently, and returns the position
+// after it. isInt tells, by the depth of an element (0 for the one at pos) and its index in the list, whether a string
+// is an integer
+func appendCanonicalRLP(dst, payload []byte, pos, depth, index int, isInt func(depth, index int) bool) ([]byte, int, error) {
+	dataPos, dataLen, isList, err := rlp.PrefixLenient(payload, pos)
+	if err != nil {
+		return nil, 0, err
+	}
+	end := dataPos + dataLen
+	if !isList {
+		str := payload[dataPos:end]
+		if isInt(depth, index) {
+			str = bytes.TrimLeft(str, "\x00")
+		}
+		n := len(dst)
+		dst = append(dst, make([]byte, rlp.StringLen(str))...)
+		rlp.EncodeString(str, dst[n:])
+		return dst, end, nil
+	}
+	var items []byte
+	for i, p := 0, dataPos; p < end; i++ {
+		if items, p, err = appendCanonicalRLP(items, payload, p, depth+1, i, isInt); err != nil {
+			return nil, 0, err
+		}
+	}
+	var prefix [10]byte
+	n := rlp.EncodeListPrefix(len(items), prefix[:])
+	return append(append(dst, prefix[:n]...), items...), end, nil
+}
+
+// parseAuthorizations walks the authorization list of EIP-7702 transaction, each authorization being
+// [chainId, address, nonce, yParity, r, s]. The fields are validated, but only the number of authorizations is retained,
+// and, when the sender is recovered, the signers of the authorizations. An authorization for another chain, of the
+// maximal nonce or with an invalid signature doesn't make the transaction invalid (it is skipped at execution),
+// so it is counted, but has no signer
+func (ctx *TxParseContext) parseAuthorizations(payload []byte, pos int, slot *TxSlot) (p int, err error) {
+	dataPos, dataLen, err := ctx.rlpList(payload, pos)
+	if err != nil {
+		return 0, fmt.Errorf("%w: authorizations len: %w", ErrParseTxn, err)
+	}
+	var chainID, r, s uint256.Int
+	authPos := dataPos
+	for authPos < dataPos+dataLen {
+		var authLen int
+		authPos, authLen, err = ctx.rlpList(payload, authPos)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization len: %w", ErrParseTxn, err)
+		}
+		p, err = ctx.rlpU256(payload, authPos, &chainID)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization chainId: %w", ErrParseTxn, err)
+		}
+		// zero chain ID makes the authorization valid on any chain
+		valid := chainID.IsZero() || ctx.expectedChainID.IsZero() || chainID.Eq(&ctx.expectedChainID)
+		p, err = rlp.StringOfLen(payload, p, 20)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization address: %w", ErrParseTxn, err)
+		}
+		var nonce uint64
+		p, nonce, err = ctx.rlpU64(payload, p+20)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization nonce: %w", ErrParseTxn, err)
+		}
+		valid = valid && nonce < math.MaxUint64
+		signedEnd := p
+		var yParity uint64
+		p, yParity, err = ctx.rlpU64(payload, p)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization yParity: %w", ErrParseTxn, err)
+		}
+		// yParity is uint8, above 1 it is only an invalid signature
+		if yParity > math.MaxUint8 {
+			return 0, fmt.Errorf("%w: authorization yParity is too large: %d", ErrParseTxn, yParity)
+		}
+		p, err = ctx.rlpU256(payload, p, &r)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization R: %w", ErrParseTxn, err)
+		}
+		p, err = ctx.rlpU256(payload, p, &s)
+		if err != nil {
+			return 0, fmt.Errorf("%w: authorization S: %w", ErrParseTxn, err)
+		}
+		if p != authPos+authLen {
+			return 0, fmt.Errorf("%w: extraneous space in the authorization", ErrParseTxn)
+		}
+		if valid && ctx.withSender {
+			if authority, ok := ctx.recoverAuthority(payload[authPos:signedEnd], byte(yParity), &r, &s); ok {
+				slot.Authorities = append(slot.Authorities, Authority{Address: authority, Nonce: nonce})
+			}
+		}
+		slot.AuthCount++
+		authPos = p
+	}
+	if authPos != dataPos+dataLen {
+		return 0, fmt.Errorf("%w: extraneous space in the authorization list", ErrParseTxn)
+	}
+	return dataPos + dataLen, nil
+}
+
+// recoverAuthority recovers the signer of an EIP-7702 authorization from its signature over
+// keccak(0x05 || rlp([chainId, address, nonce])), signed being the encoding of the three fields
+func (ctx *TxParseContext) recoverAuthority(signed []byte, yParity byte, r, s *uint256.Int) (authority common.Address, ok bool) {
+	if !crypto.TransactionSignatureIsValid(yParity, r, s, false /* allowPreEip2s */) {
+		return authority, false
+	}
+	var buf [65]byte
+	buf[0] = 0x05 // EIP-7702 MAGIC
+	n := rlp.EncodeListPrefix(len(signed), buf[1:])
+	ctx.authKeccak.Reset()
+	_, _ = ctx.authKeccak.Write(buf[:1+n])
+	_, _ = ctx.authKeccak.Write(signed)
+	var sighash [32]byte
+	_, _ = ctx.authKeccak.(io.Reader).Read(sighash[:])
+	var sig [65]byte
+	r.WriteToSlice(sig[:32])
+	s.WriteToSlice(sig[32:64])
+	sig[64] = yParity
+	if err := recoverSender(ctx.secpCtx, ctx.authKeccak, sighash[:], sig[:], &buf, authority[:]); err != nil {
+		return authority, false
+	}
+	return authority, true
+}
+
+// VerifyBlobVersionedHashes checks that every versioned hash of a blob transaction is derived from the corresponding
+// KZG commitment as 0x01 || sha256(commitment)[1:] (kzg_to_versioned_hash from EIP-4844).
+// The error identifies the first mismatching index
+func (ctx *TxParseContext) VerifyBlobVersionedHashes(versionedHashes [][32]byte, commitments [][48]byte) error {
+	if len(versionedHashes) != len(commitments) {
+		return fmt.Errorf("%w: %d versioned hashes, %d commitments", ErrBlobHashMismatch, len(versionedHashes), len(commitments))
+	}
+	for i := range commitments {
+		ctx.sha256.Reset()
+		_, _ = ctx.sha256.Write(commitments[i][:])
+		ctx.sha256.Sum(ctx.buf[:0])
+		ctx.buf[0] = libkzg.BlobCommitmentVersionKZG
+		if !bytes.Equal(ctx.buf[:32], versionedHashes[i][:]) {
+			return fmt.Errorf("%w: index %d", ErrBlobHashMismatch, i)
+		}
+	}
+	return nil
+}
+
+// RecoverSenderInto recovers the address of the signer of sighash from the 65-byte signature [R || S || V]
+// (V being the recovery id 0 or 1, as in ctx.Sig) and writes it into out. It is independent of TxSlot and does not
+// allocate, so it can be used for other signatures, e.g. authorizations of EIP-7702
+func (ctx *TxParseContext) RecoverSenderInto(sighash [32]byte, sig [65]byte, out *[20]byte) error {
+	return recoverSender(ctx.secpCtx, ctx.Keccak2, sighash[:], sig[:], &ctx.buf, out[:])
+}
+
+// recoverSender recovers the public key from the signature, and writes the last 20 bytes of its hash into sender.
+// buf is used as a scratch space for the public key
+func recoverSender(secpCtx *secp256k1.Context, keccak hash.Hash, sighash, sig []byte, buf *[65]byte, sender []byte) error {
+	if _, err := secp256k1.RecoverPubkeyWithContext(secpCtx, sighash, sig, buf[:0]); err != nil {
+		return fmt.Errorf("%w: recovering sender from signature: %w", ErrParseTxn, err)
 	}
 	//apply keccak to the public key
-	ctx.Keccak2.Reset()
-	if _, err = ctx.Keccak2.Write(ctx.buf[1:65]); err != nil {
-		return 0, fmt.Errorf("%w: computing sender from public key: %s", ErrParseTxn, err) //nolint
+	keccak.Reset()
+	if _, err := keccak.Write(buf[1:65]); err != nil {
+		return fmt.Errorf("%w: computing sender from public key: %w", ErrParseTxn, err)
 	}
 	// squeeze the hash of the public key
 	//ctx.keccak2.Sum(ctx.buf[:0])
-	_, _ = ctx.Keccak2.(io.Reader).Read(ctx.buf[:32])
+	_, _ = keccak.(io.Reader).Read(buf[:32])
 	//take last 20 bytes as address
-	copy(sender, ctx.buf[12:32])
-
-	return p, nil
+	copy(sender, buf[12:32])
+	return nil
 }
 
 type PeerID *types.H512
@@ -850,6 +1700,32 @@ func (s *TxSlots) Append(slot *TxSlot, sender []byte, isLocal bool) {
 	copy(s.Senders.At(n), sender)
 }
 
+// TxSlotSlab allocates TxSlots in chunks instead of one by one, to reduce GC pressure when many transactions
+// are parsed at once and released together, e.g. the mined transactions of a batch of blocks. A chunk is only freed
+// once none of its slots is referenced anymore, so it should not be used for slots which are dropped independently,
+// such as the pool's transactions
+type TxSlotSlab struct {
+	chunk     []TxSlot
+	chunkSize int
+}
+
+func NewTxSlotSlab(chunkSize int) *TxSlotSlab {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	return &TxSlotSlab{chunkSize: chunkSize}
+}
+
+// New returns a zeroed TxSlot, not shared with any other caller
+func (s *TxSlotSlab) New() *TxSlot {
+	if len(s.chunk) == 0 {
+		s.chunk = make([]TxSlot, s.chunkSize)
+	}
+	slot := &s.chunk[0]
+	s.chunk = s.chunk[1:]
+	return slot
+}
+
 type TxsRlp struct {
 	Txs     [][]byte
 	Senders Addresses
@@ -961,6 +1837,107 @@ func bytesToUint64(buf []byte) (x uint64) {
 	return
 }
 
+// Cost returns the maximum amount the transaction can spend: gasLimit x feeCap + blobGas x blobFeeCap + transferred_value.
+// See YP, Eq (61) in Section 6.2 "Execution", https://github.com/ethereum/EIPs/pull/3594
+// and https://eips.ethereum.org/EIPS/eip-4844#gas-accounting
+func Cost(slot *TxSlot) (uint256.Int, error) {
+	var total uint256.Int
+	total.SetUint64(slot.Gas)
+	if _, overflow := total.MulOverflow(&total, &slot.FeeCap); overflow {
+		return total, ErrCostOverflow
+	}
+	if blobCount := uint64(len(slot.BlobHashes)); blobCount != 0 {
+		var blobCost uint256.Int
+		blobCost.SetUint64(fixedgas.BlobGasPerBlob * blobCount)
+		if _, overflow := blobCost.MulOverflow(&blobCost, &slot.BlobFeeCap); overflow {
+			return total, ErrCostOverflow
+		}
+		if _, overflow := total.AddOverflow(&total, &blobCost); overflow {
+			return total, ErrCostOverflow
+		}
+	}
+	if _, overflow := total.AddOverflow(&total, &slot.Value); overflow {
+		return total, ErrCostOverflow
+	}
+	return total, nil
+}
+
+// effectiveTip writes into tip the tip per gas the transaction pays to the block proposer under the given base fee:
+// min(tip, feeCap - baseFee). For legacy and access list transactions both tip and feeCap are the gas price.
+// Returns false if feeCap is below the base fee, so the transaction is not eligible for inclusion
+func effectiveTip(slot *TxSlot, baseFee, tip *uint256.Int) bool {
+	if slot.FeeCap.Lt(baseFee) {
+		return false
+	}
+	tip.Sub(&slot.FeeCap, baseFee)
+	if slot.Tip.Lt(tip) {
+		tip.Set(&slot.Tip)
+	}
+	return true
+}
+
+// CompareByPriority orders transactions of any type by their priority for inclusion under the given base fee.
+// It returns 1 if a should be included before b, -1 if after, and 0 if they are indistinguishable (same hash).
+// Transactions eligible under the base fee come first, then the higher effective tip (or fee cap, if neither is eligible),
+// then the lower nonce, and the transaction hash breaks the remaining ties
+func CompareByPriority(a, b *TxSlot, baseFee uint64) int {
+	var fee, tipA, tipB uint256.Int
+	fee.SetUint64(baseFee)
+	okA, okB := effectiveTip(a, &fee, &tipA), effectiveTip(b, &fee, &tipB)
+	if okA != okB {
+		if okA {
+			return 1
+		}
+		return -1
+	}
+	if !okA {
+		// Neither is eligible, the one closer to the base fee goes first
+		tipA.Set(&a.FeeCap)
+		tipB.Set(&b.FeeCap)
+	}
+	if c := tipA.Cmp(&tipB); c != 0 {
+		return c
+	}
+	if a.Nonce != b.Nonce {
+		if a.Nonce < b.Nonce {
+			return 1
+		}
+		return -1
+	}
+	return bytes.Compare(b.IDHash[:], a.IDHash[:])
+}
+
+// AccessList returns the access list of the transaction, in the same order and with the same duplicates as in the payload.
+// It is only retained if TxParseContext.WithKeepAccessList is on, nil otherwise
+func (tx *TxSlot) AccessList() AccessList { return tx.accessList }
+
+// To returns the recipient of the transaction, zero address for contract creation (see Creation)
+func (tx *TxSlot) To() common.Address { return tx.to }
+
+// Signature returns the signature of the transaction as [R || S || yParity], the form used for sender recovery
+func (tx *TxSlot) Signature() [65]byte { return tx.sig }
+
+// YParity returns the parity of the y coordinate of the signature point (recovery id), 0 or 1
+func (tx *TxSlot) YParity() byte { return tx.sig[64] }
+
+// V returns V of the signature as it is encoded in the transaction: yParity for typed transactions,
+// 27 + yParity for unprotected legacy transactions and chainID * 2 + 35 + yParity for EIP-155 ones
+func (tx *TxSlot) V(chainID *uint256.Int) *uint256.Int {
+	v := uint256.NewInt(uint64(tx.sig[64]))
+	switch {
+	case tx.Type != LegacyTxType:
+	case tx.unprotected:
+		v.AddUint64(v, 27)
+	default:
+		v.Add(v, new(uint256.Int).Mul(chainID, u256.N2))
+		v.AddUint64(v, 35)
+	}
+	return v
+}
+
+// IsLocal returns whether transaction has been injected locally, see TxParseContext.WithLocal
+func (tx *TxSlot) IsLocal() bool { return tx.local }
+
 // nolint
 func (tx *TxSlot) PrintDebug(prefix string) {
 	fmt.Printf("%s: senderID=%d,nonce=%d,tip=%d,v=%d\n", prefix, tx.SenderID, tx.Nonce, tx.Tip, tx.Value.Uint64())
@@ -0,0 +1,123 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+
+	"github.com/holiman/uint256"
+
+	"github.com/ledgerwatch/erigon-lib/common"
+	"github.com/ledgerwatch/erigon-lib/rlp"
+)
+
+// AppendRLP appends the canonical encoding of the transaction to b, in the same form as TxSlot.Rlp of a parsed transaction:
+// RLP list for legacy transactions, and type byte followed by RLP list for typed ones (blob transactions without blobs).
+// TxSlot does not retain everything needed, so the rest is passed in: chainID (ignored for legacy transactions,
+// where it is a part of v), data, and the signature values as they are encoded, which is what TxParseContext.ChainID,
+// TxParseContext.V, TxParseContext.R and TxParseContext.S contain after parsing.
+// Non-empty access lists must have been retained with WithKeepAccessList, set code transactions are not supported
+func (tx *TxSlot) AppendRLP(b []byte, chainID *uint256.Int, data []byte, v, r, s *uint256.Int) ([]byte, error) {
+	if int(tx.Type) >= len(txFields) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownTxType, tx.Type)
+	}
+	if tx.Type == SetCodeTxType {
+		return nil, fmt.Errorf("encoding of set code transactions is not supported, authorizations are not retained")
+	}
+	if tx.AlAddrCount > 0 && tx.accessList == nil {
+		return nil, fmt.Errorf("access list is not retained, see WithKeepAccessList")
+	}
+
+	var fields []byte
+	for _, field := range txFields[tx.Type] {
+		switch field {
+		case fieldChainID:
+			fields = appendRLPU256(fields, chainID)
+		case fieldNonce:
+			fields = appendRLPU64(fields, tx.Nonce)
+		case fieldGasPrice, fieldTip:
+			fields = appendRLPU256(fields, &tx.Tip)
+		case fieldFeeCap:
+			fields = appendRLPU256(fields, &tx.FeeCap)
+		case fieldGas:
+			fields = appendRLPU64(fields, tx.Gas)
+		case fieldTo:
+			if tx.Creation {
+				fields = appendRLPString(fields, nil)
+			} else {
+				fields = appendRLPString(fields, tx.to[:])
+			}
+		case fieldValue:
+			fields = appendRLPU256(fields, &tx.Value)
+		case fieldData:
+			fields = appendRLPString(fields, data)
+		case fieldAccessList:
+			var al []byte
+			for _, tuple := range tx.accessList {
+				var t, keys []byte
+				t = appendRLPString(t, tuple.Address[:])
+				for _, key := range tuple.StorageKeys {
+					keys = appendRLPString(keys, key[:])
+				}
+				t = appendRLPList(t, keys)
+				al = appendRLPList(al, t)
+			}
+			fields = appendRLPList(fields, al)
+		case fieldBlobFeeCap:
+			fields = appendRLPU256(fields, &tx.BlobFeeCap)
+		case fieldBlobHashes:
+			var hashes []byte
+			for _, hash := range tx.BlobHashes {
+				hashes = appendRLPString(hashes, hash[:])
+			}
+			fields = appendRLPList(fields, hashes)
+		}
+	}
+	fields = appendRLPU256(fields, v)
+	fields = appendRLPU256(fields, r)
+	fields = appendRLPU256(fields, s)
+
+	if tx.Type != LegacyTxType {
+		b = append(b, tx.Type)
+	}
+	return appendRLPList(b, fields), nil
+}
+
+func appendRLPU64(b []byte, x uint64) []byte {
+	var buf [9]byte
+	return append(b, buf[:rlp.EncodeU64(x, buf[:])]...)
+}
+
+func appendRLPU256(b []byte, x *uint256.Int) []byte {
+	if x.IsZero() {
+		return appendRLPString(b, nil)
+	}
+	buf := x.Bytes32()
+	return appendRLPString(b, buf[32-common.BitLenToByteLen(x.BitLen()):])
+}
+
+func appendRLPString(b, s []byte) []byte {
+	n := len(b)
+	// EncodeString needs 8 spare bytes to encode the length of long strings
+	b = append(b, make([]byte, rlp.StringLen(s)+8)...)
+	return b[:n+rlp.EncodeString(s, b[n:])]
+}
+
+func appendRLPList(b, content []byte) []byte {
+	var buf [10]byte
+	return append(append(b, buf[:rlp.EncodeListPrefix(len(content), buf[:])]...), content...)
+}
@@ -17,8 +17,11 @@
 package types
 
 import (
+	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/length"
@@ -170,6 +173,8 @@ func EncodeTransactions(txsRlp [][]byte, encodeBuf []byte) []byte {
 	return encodeBuf
 }
 
+// ParseTransactions parses Transactions (0x02) message. On error, txSlots holds the transactions parsed before the
+// failing one
 func ParseTransactions(payload []byte, pos int, ctx *TxParseContext, txSlots *TxSlots, validateHash func([]byte) error) (newPos int, err error) {
 	pos, _, err = rlp.List(payload, pos)
 	if err != nil {
@@ -181,8 +186,8 @@ func ParseTransactions(payload []byte, pos int, ctx *TxParseContext, txSlots *Tx
 		txSlots.Txs[i] = &TxSlot{}
 		pos, err = ctx.ParseTransaction(payload, pos, txSlots.Txs[i], txSlots.Senders.At(i), true /* hasEnvelope */, true /* wrappedWithBlobs */, validateHash)
 		if err != nil {
+			txSlots.Resize(uint(i))
 			if errors.Is(err, ErrRejected) {
-				txSlots.Resize(uint(i))
 				i--
 				continue
 			}
@@ -192,32 +197,237 @@ func ParseTransactions(payload []byte, pos int, ctx *TxParseContext, txSlots *Tx
 	return pos, nil
 }
 
+// ParseTransactionsWithErrors parses Transactions (0x02) message like ParseTransactions, but a malformed or rejected
+// transaction does not abort the whole batch. For every element of the list, errs has the parsing error (nil if parsed successfully)
+// and raws - the sub-slice of payload with its encoding. Successfully parsed transactions are put into txSlots, in the same order.
+// err is only returned if the list itself can't be walked
+func ParseTransactionsWithErrors(payload []byte, pos int, ctx *TxParseContext, txSlots *TxSlots, validateHash func([]byte) error) (raws [][]byte, errs []error, newPos int, err error) {
+	p, dataLen, err := rlp.List(payload, pos)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	end := p + dataLen
+
+	n := 0
+	txSlots.Resize(0)
+	for p < end {
+		elemPos, elemLen, _, err := rlp.Prefix(payload, p)
+		if err != nil {
+			return raws, errs, 0, fmt.Errorf("%w: transaction %d: %w", ErrParseTxn, len(raws), err)
+		}
+		elemEnd := elemPos + elemLen
+		if elemEnd > end {
+			return raws, errs, 0, fmt.Errorf("%w: transaction %d exceeds the list", ErrParseTxn, len(raws))
+		}
+		txSlots.Resize(uint(n + 1))
+		txSlots.Txs[n] = &TxSlot{}
+		// Limit the payload to the element, so that a malformed transaction can't spill over into the next one
+		txEnd, txErr := ctx.ParseTransaction(payload[:elemEnd], p, txSlots.Txs[n], txSlots.Senders.At(n), true /* hasEnvelope */, true /* wrappedWithBlobs */, validateHash)
+		if txErr == nil && txEnd != elemEnd {
+			txErr = fmt.Errorf("%w: extraneous space in transaction %d", ErrParseTxn, len(raws))
+		}
+		if txErr == nil {
+			n++
+		} else {
+			txSlots.Resize(uint(n))
+		}
+		raws = append(raws, payload[p:elemEnd])
+		errs = append(errs, txErr)
+		p = elemEnd
+	}
+	return raws, errs, p, nil
+}
+
+// ParsePooledTransactions66 parses PooledTransactions (0x0a) message of eth/66+ protocols: [requestID, [tx, tx, ...]].
+// Request ID is returned even if one of the transactions fails to parse, so that the response can still be matched to the request,
+// and txSlots holds the transactions parsed before the failing one
 func ParsePooledTransactions66(payload []byte, pos int, ctx *TxParseContext, txSlots *TxSlots, validateHash func([]byte) error) (requestID uint64, newPos int, err error) {
-	p, _, err := rlp.List(payload, pos)
+	p, outerLen, err := rlp.List(payload, pos)
 	if err != nil {
 		return requestID, 0, err
 	}
+	outerEnd := p + outerLen
 	p, requestID, err = rlp.U64(payload, p)
 	if err != nil {
 		return requestID, 0, err
 	}
-	p, _, err = rlp.List(payload, p)
+	p, txsLen, err := rlp.List(payload, p)
 	if err != nil {
 		return requestID, 0, err
 	}
+	if p+txsLen != outerEnd {
+		return requestID, 0, fmt.Errorf("%w: pooled transactions packet must be a list of 2 elements", ErrParseTxn)
+	}
 
-	for i := 0; p < len(payload); i++ {
+	for i := 0; p < outerEnd; i++ {
 		txSlots.Resize(uint(i + 1))
 		txSlots.Txs[i] = &TxSlot{}
 		p, err = ctx.ParseTransaction(payload, p, txSlots.Txs[i], txSlots.Senders.At(i), true /* hasEnvelope */, true /* wrappedWithBlobs */, validateHash)
 		if err != nil {
+			txSlots.Resize(uint(i))
 			if errors.Is(err, ErrRejected) {
-				txSlots.Resize(uint(i))
 				i--
 				continue
 			}
 			return requestID, 0, err
 		}
 	}
+	if p != outerEnd {
+		return requestID, 0, fmt.Errorf("%w: extraneous space in pooled transactions packet", ErrParseTxn)
+	}
 	return requestID, p, nil
 }
+
+// TxStream parses the transactions of Transactions (0x02) or PooledTransactions (0x0a) message one by one while reading
+// the message from io.Reader, so that only one transaction at a time has to be kept in memory, instead of the whole message
+type TxStream struct {
+	r            *bufio.Reader
+	ctx          *TxParseContext
+	validateHash func([]byte) error
+	maxTxSize    int
+	remaining    int // Bytes of the list of transactions not read yet
+	requestID    uint64
+	hdr          [9]byte
+	buf          []byte
+}
+
+// NewTxStream reads Transactions (0x02) message: [tx, tx, ...]. Transactions larger than maxTxSize (0 means no limit)
+// are skipped without being read into memory. It reads the list prefix right away
+func NewTxStream(r io.Reader, ctx *TxParseContext, maxTxSize int, validateHash func([]byte) error) (*TxStream, error) {
+	s := &TxStream{r: bufio.NewReader(r), ctx: ctx, maxTxSize: maxTxSize, validateHash: validateHash}
+	_, dataLen, isList, err := s.readPrefix()
+	if err != nil {
+		return nil, err
+	}
+	if !isList {
+		return nil, fmt.Errorf("%w: transactions packet must be a list", ErrParseTxn)
+	}
+	s.remaining = dataLen
+	return s, nil
+}
+
+// NewPooledTxStream reads PooledTransactions (0x0a) message of eth/66+ protocols: [requestID, [tx, tx, ...]], see NewTxStream.
+// Request ID is read right away and is available with RequestID
+func NewPooledTxStream(r io.Reader, ctx *TxParseContext, maxTxSize int, validateHash func([]byte) error) (*TxStream, error) {
+	s := &TxStream{r: bufio.NewReader(r), ctx: ctx, maxTxSize: maxTxSize, validateHash: validateHash}
+	_, outerLen, isList, err := s.readPrefix()
+	if err != nil {
+		return nil, err
+	}
+	if !isList {
+		return nil, fmt.Errorf("%w: pooled transactions packet must be a list", ErrParseTxn)
+	}
+	idHdrLen, idLen, isList, err := s.readPrefix()
+	if err != nil {
+		return nil, err
+	}
+	if isList || idLen > 8 || idHdrLen+idLen > outerLen {
+		return nil, fmt.Errorf("%w: invalid request ID", ErrParseTxn)
+	}
+	if idHdrLen == 1 && s.hdr[0] < 0x80 { // single byte is its own encoding
+		s.requestID = uint64(s.hdr[0])
+	} else {
+		var id [8]byte
+		if _, err = io.ReadFull(s.r, id[8-idLen:]); err != nil {
+			return nil, fmt.Errorf("%w: %w", rlp.ErrTruncated, err)
+		}
+		if ctx.mode != ParseLenient && idLen > 0 && id[8-idLen] == 0 {
+			return nil, fmt.Errorf("%w: request ID", rlp.ErrLeadingZero)
+		}
+		if ctx.mode != ParseLenient && idLen == 1 && id[7] < 0x80 {
+			return nil, fmt.Errorf("%w: request ID", rlp.ErrNonCanonicalRLP)
+		}
+		s.requestID = binary.BigEndian.Uint64(id[:])
+	}
+	txsHdrLen, txsLen, isList, err := s.readPrefix()
+	if err != nil {
+		return nil, err
+	}
+	if !isList || idHdrLen+idLen+txsHdrLen+txsLen != outerLen {
+		return nil, fmt.Errorf("%w: pooled transactions packet must be a list of 2 elements", ErrParseTxn)
+	}
+	s.remaining = txsLen
+	return s, nil
+}
+
+// RequestID of PooledTransactions message, zero for Transactions message
+func (s *TxStream) RequestID() uint64 { return s.requestID }
+
+// Next parses the next transaction into slot and its sender into sender, like TxParseContext.ParseTransaction does.
+// It returns io.EOF after the last transaction. The stream stays usable after errors of individual transactions:
+// ErrParseTxn (unless the list itself is malformed), ErrRejected and ErrRlpTooBig, any other error is final.
+// The buffer that slot.Rlp refers to is reused by the next call, unless the context is set up WithRawRetention
+func (s *TxStream) Next(slot *TxSlot, sender []byte) error {
+	if s.remaining == 0 {
+		return io.EOF
+	}
+	hdrLen, dataLen, _, err := s.readPrefix()
+	if err != nil {
+		return err
+	}
+	size := hdrLen + dataLen
+	if size > s.remaining {
+		return fmt.Errorf("%w: transaction exceeds the list", ErrParseTxn)
+	}
+	s.remaining -= size
+	if s.maxTxSize > 0 && dataLen > s.maxTxSize {
+		if _, err = s.r.Discard(dataLen); err != nil {
+			return fmt.Errorf("%w: %w", rlp.ErrTruncated, err)
+		}
+		return fmt.Errorf("%w: %d bytes", ErrRlpTooBig, dataLen)
+	}
+	s.buf = common.EnsureEnoughSize(s.buf, size)
+	copy(s.buf, s.hdr[:hdrLen])
+	if _, err = io.ReadFull(s.r, s.buf[hdrLen:]); err != nil {
+		return fmt.Errorf("%w: %w", rlp.ErrTruncated, err)
+	}
+	p, err := s.ctx.ParseTransaction(s.buf, 0, slot, sender, true /* hasEnvelope */, true /* wrappedWithBlobs */, s.validateHash)
+	if err == nil && p != size {
+		return fmt.Errorf("%w: extraneous space in transaction", ErrParseTxn)
+	}
+	return err
+}
+
+// readPrefix reads RLP prefix into s.hdr, except for a single byte string, which is read into s.hdr as well, but counted as prefix.
+// Lengths must be canonical, unless the context is in ParseLenient mode
+func (s *TxStream) readPrefix() (hdrLen, dataLen int, isList bool, err error) {
+	first, err := s.r.ReadByte()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, false, fmt.Errorf("%w: %w", rlp.ErrTruncated, err)
+	}
+	s.hdr[0] = first
+	var beLen int
+	switch {
+	case first < 0x80:
+		return 1, 0, false, nil
+	case first < 0xb8:
+		return 1, int(first) - 0x80, false, nil
+	case first < 0xc0:
+		beLen = int(first) - 0xb7
+	case first < 0xf8:
+		return 1, int(first) - 0xc0, true, nil
+	default:
+		beLen, isList = int(first)-0xf7, true
+	}
+	if _, err = io.ReadFull(s.r, s.hdr[1:1+beLen]); err != nil {
+		return 0, 0, false, fmt.Errorf("%w: %w", rlp.ErrTruncated, err)
+	}
+	if s.ctx.mode != ParseLenient && s.hdr[1] == 0 {
+		return 0, 0, false, fmt.Errorf("%w: length", rlp.ErrLeadingZero)
+	}
+	var l uint64
+	for _, b := range s.hdr[1 : 1+beLen] {
+		l = l<<8 | uint64(b)
+	}
+	// Lengths beyond 4GB can't be valid in any message and could overflow int
+	if l > 1<<32 {
+		return 0, 0, false, fmt.Errorf("%w: length %d", rlp.ErrParse, l)
+	}
+	if s.ctx.mode != ParseLenient && l < 56 {
+		return 0, 0, false, rlp.ErrNonCanonicalRLP
+	}
+	return 1 + beLen, int(l), isList, nil
+}
@@ -17,14 +17,18 @@
 package types
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"strconv"
 	"testing"
+	"testing/iotest"
 
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/require"
 
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+	"github.com/ledgerwatch/erigon-lib/rlp"
 )
 
 var hashParseTests = []struct {
@@ -231,3 +235,136 @@ func TestTransactionsPacket(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTransactionsWithErrors(t *testing.T) {
+	legacy := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	dynamicFee := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	accessList := hexutility.MustDecodeHex(TxParseMainnetTests[2].PayloadStr)
+	wrongChainID := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	wrongChainID[3] = 0x05
+	encoded := EncodeTransactions([][]byte{legacy, wrongChainID, dynamicFee, accessList}, nil)
+
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	slots := &TxSlots{}
+	raws, errs, p, err := ParseTransactionsWithErrors(encoded, 0, ctx, slots, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(encoded), p)
+	require.Len(t, errs, 4)
+	require.NoError(t, errs[0])
+	require.ErrorIs(t, errs[1], ErrParseTxn)
+	require.NoError(t, errs[2])
+	require.NoError(t, errs[3])
+	require.Equal(t, [][]byte{legacy, append([]byte{0xb8, byte(len(wrongChainID))}, wrongChainID...),
+		append([]byte{0xb8, byte(len(dynamicFee))}, dynamicFee...), append([]byte{0xb8, byte(len(accessList))}, accessList...)}, raws)
+	require.Len(t, slots.Txs, 3)
+	require.Equal(t, 3, slots.Senders.Len())
+	require.Equal(t, legacy, slots.Txs[0].Rlp)
+	require.Equal(t, dynamicFee, slots.Txs[1].Rlp)
+	require.Equal(t, accessList, slots.Txs[2].Rlp)
+	require.Equal(t, hexutility.MustDecodeHex(TxParseMainnetTests[2].SenderStr), slots.Senders.At(2))
+
+	// Rejected transactions are reported as well
+	_, errs, _, err = ParseTransactionsWithErrors(encoded, 0, ctx, slots, func([]byte) error { return ErrRejected })
+	require.NoError(t, err)
+	require.Len(t, errs, 4)
+	require.ErrorIs(t, errs[0], ErrRejected)
+	require.Len(t, slots.Txs, 0)
+
+	// Broken framing of the list can't be recovered from
+	_, _, _, err = ParseTransactionsWithErrors(encoded[:len(encoded)-1], 0, ctx, slots, nil)
+	require.Error(t, err)
+}
+
+func TestParsePooledTransactions66Malformed(t *testing.T) {
+	tt := ptp66EncodeTests[1]
+	ctx := NewTxParseContext(*uint256.NewInt(tt.chainID))
+
+	// Corrupt V of the second transaction (wrong chain ID), request ID must still be returned
+	corrupted := hexutility.MustDecodeHex(tt.encoded)
+	corrupted[len(corrupted)-67] = 0x29
+	slots := &TxSlots{}
+	requestID, _, err := ParsePooledTransactions66(corrupted, 0, ctx, slots, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+	require.Equal(t, tt.requestID, requestID)
+
+	// Third element in the outer list
+	encoded := hexutility.MustDecodeHex(tt.encoded)
+	extended := append([]byte{0xf8, encoded[1] + 1}, encoded[2:]...)
+	extended = append(extended, 0x80)
+	requestID, _, err = ParsePooledTransactions66(extended, 0, ctx, &TxSlots{}, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+	require.Equal(t, tt.requestID, requestID)
+}
+
+func TestTxStream(t *testing.T) {
+	var txs, hashes [][]byte
+	for _, tt := range TxParseMainnetTests {
+		payload := hexutility.MustDecodeHex(tt.PayloadStr)
+		// Skip transactions already wrapped into the string envelope
+		if payload[0] >= 0x80 && payload[0] < 0xc0 {
+			continue
+		}
+		txs = append(txs, payload)
+		tx, txSender := &TxSlot{}, [20]byte{}
+		_, err := NewTxParseContext(*uint256.NewInt(1)).ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.NoError(t, err)
+		hashes = append(hashes, tx.IDHash[:])
+	}
+	largest := 0
+	for i := range txs {
+		if len(txs[i]) > len(txs[largest]) {
+			largest = i
+		}
+	}
+
+	for _, pooled := range []bool{false, true} {
+		var packet []byte
+		if pooled {
+			packet = EncodePooledTransactions66(txs, 0x1234, nil)
+		} else {
+			packet = EncodeTransactions(txs, nil)
+		}
+		ctx := NewTxParseContext(*uint256.NewInt(1))
+		newStream := func(r io.Reader, maxTxSize int) (*TxStream, error) {
+			if pooled {
+				return NewPooledTxStream(r, ctx, maxTxSize, nil)
+			}
+			return NewTxStream(r, ctx, maxTxSize, nil)
+		}
+
+		// One byte at a time, to make sure that nothing relies on the reads being complete
+		s, err := newStream(iotest.OneByteReader(bytes.NewReader(packet)), 0)
+		require.NoError(t, err)
+		if pooled {
+			require.Equal(t, uint64(0x1234), s.RequestID())
+		}
+		tx, txSender := &TxSlot{}, [20]byte{}
+		for i := range txs {
+			require.NoError(t, s.Next(tx, txSender[:]), i)
+			require.Equal(t, hashes[i], tx.IDHash[:], i)
+		}
+		require.ErrorIs(t, s.Next(tx, txSender[:]), io.EOF)
+
+		// The largest transaction is skipped, the others are still parsed
+		s, err = newStream(bytes.NewReader(packet), len(txs[largest])-10)
+		require.NoError(t, err)
+		for i := range txs {
+			err = s.Next(tx, txSender[:])
+			if i == largest {
+				require.ErrorIs(t, err, ErrRlpTooBig)
+				continue
+			}
+			require.NoError(t, err, i)
+			require.Equal(t, hashes[i], tx.IDHash[:], i)
+		}
+		require.ErrorIs(t, s.Next(tx, txSender[:]), io.EOF)
+
+		// Truncated message
+		s, err = newStream(bytes.NewReader(packet[:len(packet)-1]), 0)
+		require.NoError(t, err)
+		for i := 0; i < len(txs)-1; i++ {
+			require.NoError(t, s.Next(tx, txSender[:]), i)
+		}
+		require.ErrorIs(t, s.Next(tx, txSender[:]), rlp.ErrTruncated)
+	}
+}
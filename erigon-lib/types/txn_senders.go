@@ -0,0 +1,188 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ledgerwatch/secp256k1"
+	"golang.org/x/crypto/sha3"
+)
+
+// BatchRecoverSenders recovers senders of many transactions in parallel, using the given number of workers.
+// It is meant to be used together with WithSender(false) and WithSighash(true) parsing: parsing is cheap and can be done serially,
+// whereas recovery is expensive. sighashes and sigs are what TxParseContext.Sighash and TxParseContext.Sig contain after parsing.
+// Recovered addresses are written into senders, errors are returned positionally (nil if recovery was successful)
+func BatchRecoverSenders(senders Addresses, sigs [][65]byte, sighashes [][32]byte, workers int) []error {
+	if len(sigs) != len(sighashes) || senders.Len() != len(sigs) {
+		panic(fmt.Sprintf("BatchRecoverSenders: expect equal len of senders=%d, sigs=%d and sighashes=%d", senders.Len(), len(sigs), len(sighashes)))
+	}
+	errs := make([]error, len(sigs))
+	if workers > len(sigs) {
+		workers = len(sigs)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			secpCtx := secp256k1.ContextForThread(w % secp256k1.NumOfContexts())
+			keccak := sha3.NewLegacyKeccak256()
+			var buf [65]byte
+			for i := w; i < len(sigs); i += workers {
+				errs[i] = recoverSender(secpCtx, keccak, sighashes[i][:], sigs[i][:], &buf, senders.At(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+	return errs
+}
+
+// RecoverySenderPool parses transactions and recovers their senders on several goroutines, each with its own TxParseContext.
+// Unlike BatchRecoverSenders, the whole parsing is done in parallel
+type RecoverySenderPool struct {
+	ctxs []*TxParseContext
+}
+
+// NewRecoverySenderPool creates a pool of the given number of workers, newCtx is called once per worker and must return
+// identically configured contexts, with sender recovery on
+func NewRecoverySenderPool(workers int, newCtx func() *TxParseContext) *RecoverySenderPool {
+	if workers < 1 {
+		workers = 1
+	}
+	rp := &RecoverySenderPool{ctxs: make([]*TxParseContext, workers)}
+	for w := range rp.ctxs {
+		rp.ctxs[w] = newCtx()
+		rp.ctxs[w].secpCtx = secp256k1.ContextForThread(w % secp256k1.NumOfContexts())
+	}
+	return rp
+}
+
+// Parse parses payloads[i] into slots[i], and writes its sender into senders.At(i), see TxParseContext.ParseTransaction.
+// Errors are returned positionally (nil if parsing was successful). It must not be called concurrently
+func (rp *RecoverySenderPool) Parse(payloads [][]byte, slots []*TxSlot, senders Addresses, hasEnvelope, wrappedWithBlobs bool) []error {
+	if len(payloads) != len(slots) || senders.Len() != len(slots) {
+		panic(fmt.Sprintf("RecoverySenderPool: expect equal len of payloads=%d, slots=%d and senders=%d", len(payloads), len(slots), senders.Len()))
+	}
+	errs := make([]error, len(payloads))
+	workers := len(rp.ctxs)
+	if workers > len(payloads) {
+		workers = len(payloads)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			ctx := rp.ctxs[w]
+			for i := w; i < len(payloads); i += workers {
+				_, errs[i] = ctx.ParseTransaction(payloads[i], 0, slots[i], senders.At(i), hasEnvelope, wrappedWithBlobs, nil)
+			}
+		}(w)
+	}
+	wg.Wait()
+	return errs
+}
+
+// ParseContextPool hands out TxParseContexts to goroutines which parse transactions concurrently, e.g. ones serving
+// different peers, so that they neither share a context (which is not goroutine-safe) nor allocate one per call
+type ParseContextPool struct {
+	pool sync.Pool
+	next atomic.Uint32 // spreads the contexts over secp256k1 contexts
+}
+
+// NewParseContextPool creates an empty pool, newCtx is called whenever the pool has no idle context
+// and must return identically configured contexts
+func NewParseContextPool(newCtx func() *TxParseContext) *ParseContextPool {
+	cp := &ParseContextPool{}
+	cp.pool.New = func() any {
+		ctx := newCtx()
+		// secp256k1 contexts are allocated outside of the Go heap, so fixed ones are used instead of a new one per TxParseContext
+		ctx.secpCtx = secp256k1.ContextForThread(int(cp.next.Add(1)-1) % secp256k1.NumOfContexts())
+		return ctx
+	}
+	return cp
+}
+
+// Get takes a context from the pool, it must be returned with Put once the caller is done with it
+func (cp *ParseContextPool) Get() *TxParseContext { return cp.pool.Get().(*TxParseContext) }
+
+// Put returns a context taken with Get to the pool
+func (cp *ParseContextPool) Put(ctx *TxParseContext) { cp.pool.Put(ctx) }
+
+// ParseTransactionConcurrent is the same as TxParseContext.ParseTransaction, but can be called from many goroutines at once.
+// Since the context goes back to the pool, its state (e.g. Sighash) is not available to the caller afterwards
+func (cp *ParseContextPool) ParseTransactionConcurrent(payload []byte, pos int, slot *TxSlot, sender []byte, hasEnvelope, wrappedWithBlobs bool, validateHash func([]byte) error) (int, error) {
+	ctx := cp.Get()
+	defer cp.Put(ctx)
+	return ctx.ParseTransaction(payload, pos, slot, sender, hasEnvelope, wrappedWithBlobs, validateHash)
+}
+
+// SenderCache remembers senders recovered from (sighash, signature) pairs, so that transactions seen again,
+// e.g. re-injected after a reorg or received from many peers, do not go through the expensive recovery.
+// It is goroutine-safe and can be shared by many TxParseContexts, see TxParseContext.WithSenderCache
+type SenderCache struct {
+	senders *lru.Cache[[32 + 65]byte, [20]byte]
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// NewSenderCache creates a cache holding up to size senders, evicting the least recently used ones
+func NewSenderCache(size int) (*SenderCache, error) {
+	senders, err := lru.New[[32 + 65]byte, [20]byte](size)
+	if err != nil {
+		return nil, err
+	}
+	return &SenderCache{senders: senders}, nil
+}
+
+func senderCacheKey(sighash, sig []byte) (key [32 + 65]byte) {
+	copy(key[:32], sighash)
+	copy(key[32:], sig)
+	return key
+}
+
+func (c *SenderCache) get(sighash, sig, sender []byte) bool {
+	s, ok := c.senders.Get(senderCacheKey(sighash, sig))
+	if !ok {
+		c.misses.Add(1)
+		return false
+	}
+	c.hits.Add(1)
+	copy(sender, s[:])
+	return true
+}
+
+func (c *SenderCache) add(sighash, sig, sender []byte) {
+	var s [20]byte
+	copy(s[:], sender)
+	c.senders.Add(senderCacheKey(sighash, sig), s)
+}
+
+// Stats returns the number of lookups which found the sender, and of those which had to recover it
+func (c *SenderCache) Stats() (hits, misses uint64) { return c.hits.Load(), c.misses.Load() }
+
+// Len returns the number of cached senders
+func (c *SenderCache) Len() int { return c.senders.Len() }
@@ -0,0 +1,215 @@
+/*
+   Copyright 2024 The Erigon contributors
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package types
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/ledgerwatch/secp256k1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+	"github.com/ledgerwatch/erigon-lib/common/length"
+)
+
+// randomSignatures signs n random sighashes with n random keys and returns expected senders
+func randomSignatures(tb testing.TB, n int) (sigs [][65]byte, sighashes [][32]byte, expected Addresses) {
+	sigs, sighashes, expected = make([][65]byte, n), make([][32]byte, n), make(Addresses, n*length.Addr)
+	for i := 0; i < n; i++ {
+		var key [32]byte
+		_, _ = rand.Read(key[:])
+		_, _ = rand.Read(sighashes[i][:])
+		sig, err := secp256k1.Sign(sighashes[i][:], key[:])
+		require.NoError(tb, err)
+		copy(sigs[i][:], sig)
+
+		x, y := secp256k1.S256().ScalarBaseMult(key[:])
+		pubkey := secp256k1.S256().Marshal(x, y)
+		h := sha3.NewLegacyKeccak256()
+		h.Write(pubkey[1:])
+		copy(expected.At(i), h.Sum(nil)[12:])
+	}
+	return sigs, sighashes, expected
+}
+
+func TestBatchRecoverSenders(t *testing.T) {
+	sigs, sighashes, expected := randomSignatures(t, 33)
+	sigs[7][64] = 5 // invalid recovery id
+
+	for _, workers := range []int{0, 1, 4, 100} {
+		senders := make(Addresses, len(expected))
+		errs := BatchRecoverSenders(senders, sigs, sighashes, workers)
+		require.Len(t, errs, len(sigs))
+		for i := range errs {
+			if i == 7 {
+				require.ErrorIs(t, errs[i], ErrParseTxn)
+				continue
+			}
+			require.NoError(t, errs[i])
+			require.Equal(t, expected.At(i), senders.At(i), "tx %d, workers %d", i, workers)
+		}
+	}
+}
+
+func TestBatchRecoverSendersAfterParse(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	ctx.WithSighash(true)
+	tests := TxParseMainnetTests[:5]
+	sigs, sighashes := make([][65]byte, len(tests)), make([][32]byte, len(tests))
+	for i, tt := range tests {
+		_, err := ctx.ParseTransactionWithSig(hexutility.MustDecodeHex(tt.PayloadStr), 0, &TxSlot{}, nil, sigs[i][:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.NoError(t, err)
+		require.Equal(t, hexutility.MustDecodeHex(tt.SignHashStr), ctx.Sighash[:])
+		sighashes[i] = ctx.Sighash
+	}
+	senders := make(Addresses, len(tests)*length.Addr)
+	for _, err := range BatchRecoverSenders(senders, sigs, sighashes, 2) {
+		require.NoError(t, err)
+	}
+	for i, tt := range tests {
+		require.Equal(t, hexutility.MustDecodeHex(tt.SenderStr), senders.At(i))
+	}
+}
+
+func BenchmarkBatchRecoverSenders(b *testing.B) {
+	sigs, sighashes, _ := randomSignatures(b, 256)
+	senders := make(Addresses, 256*length.Addr)
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			BatchRecoverSenders(senders, sigs, sighashes, 1)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			BatchRecoverSenders(senders, sigs, sighashes, secp256k1.NumOfContexts())
+		}
+	})
+}
+
+func TestRecoverySenderPool(t *testing.T) {
+	// Mainnet transactions, repeated to have more than one per worker, and a broken one
+	var payloads [][]byte
+	var expected Addresses
+	var nonces []uint64
+	for r := 0; r < 3; r++ {
+		for _, tt := range TxParseMainnetTests {
+			if tt.SenderStr == "" {
+				continue
+			}
+			payloads = append(payloads, hexutility.MustDecodeHex(tt.PayloadStr))
+			expected = append(expected, hexutility.MustDecodeHex(tt.SenderStr)...)
+			nonces = append(nonces, tt.Nonce)
+		}
+	}
+	payloads[5] = payloads[5][:len(payloads[5])-1]
+
+	for _, workers := range []int{1, 4, 100} {
+		rp := NewRecoverySenderPool(workers, func() *TxParseContext { return NewTxParseContext(*uint256.NewInt(1)) })
+		slots := make([]*TxSlot, len(payloads))
+		for i := range slots {
+			slots[i] = &TxSlot{}
+		}
+		senders := make(Addresses, len(expected))
+		errs := rp.Parse(payloads, slots, senders, false /* hasEnvelope */, true /* wrappedWithBlobs */)
+		require.Len(t, errs, len(payloads))
+		for i := range errs {
+			if i == 5 {
+				require.ErrorIs(t, errs[i], ErrParseTxn)
+				continue
+			}
+			require.NoError(t, errs[i])
+			require.Equal(t, expected.At(i), senders.At(i), "tx %d, workers %d", i, workers)
+			require.Equal(t, nonces[i], slots[i].Nonce)
+		}
+	}
+}
+
+func TestParseContextPool(t *testing.T) {
+	cp := NewParseContextPool(func() *TxParseContext { return NewTxParseContext(*uint256.NewInt(1)) })
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*len(TxParseMainnetTests))
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, tt := range TxParseMainnetTests {
+				if tt.SenderStr == "" {
+					continue
+				}
+				tx, txSender := &TxSlot{}, [20]byte{}
+				_, err := cp.ParseTransactionConcurrent(hexutility.MustDecodeHex(tt.PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+				if err != nil {
+					errs <- fmt.Errorf("tx %d: %w", i, err)
+					continue
+				}
+				if !bytes.Equal(hexutility.MustDecodeHex(tt.SenderStr), txSender[:]) || !bytes.Equal(hexutility.MustDecodeHex(tt.IdHashStr), tx.IDHash[:]) {
+					errs <- fmt.Errorf("tx %d: unexpected sender %x or hash %x", i, txSender, tx.IDHash)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSenderCache(t *testing.T) {
+	cache, err := NewSenderCache(2)
+	require.NoError(t, err)
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSenderCache(cache)
+	var withSender []parseTxTest
+	for _, tt := range TxParseMainnetTests {
+		if tt.SenderStr != "" {
+			withSender = append(withSender, tt)
+		}
+	}
+	require.Greater(t, len(withSender), 2)
+
+	tx, txSender := &TxSlot{}, [20]byte{}
+	parse := func(tt parseTxTest) {
+		t.Helper()
+		txSender = [20]byte{}
+		_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(tt.PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.NoError(t, err)
+		require.Equal(t, hexutility.MustDecodeHex(tt.SenderStr), txSender[:])
+	}
+	parse(withSender[0])
+	parse(withSender[0])
+	hits, misses := cache.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(1), misses)
+
+	// The least recently used sender is evicted
+	parse(withSender[1])
+	parse(withSender[2])
+	assert.Equal(t, 2, cache.Len())
+	parse(withSender[0])
+	hits, misses = cache.Stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(4), misses)
+}
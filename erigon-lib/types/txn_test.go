@@ -19,16 +19,24 @@ package types
 import (
 	"bytes"
 	"crypto/rand"
+	"math"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/sha3"
 
+	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/fixedgas"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
+	libkzg "github.com/ledgerwatch/erigon-lib/crypto/kzg"
+	"github.com/ledgerwatch/erigon-lib/rlp"
+	"github.com/ledgerwatch/erigon-lib/types/testutil"
 )
 
 func TestParseTransactionRLP(t *testing.T) {
@@ -70,6 +78,115 @@ func TestParseTransactionRLP(t *testing.T) {
 	}
 }
 
+func TestTransactionHash(t *testing.T) {
+	for _, testSet := range allNetsTestCases {
+		ctx := NewTxParseContext(testSet.chainID)
+		tx, txSender := &TxSlot{}, [20]byte{}
+		for i, tt := range testSet.tests {
+			payload := hexutility.MustDecodeHex(tt.PayloadStr)
+			_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+			require.NoError(t, err, i)
+			h, p, err := ctx.TransactionHash(payload, 0)
+			require.NoError(t, err, i)
+			assert.Equal(t, len(payload), p, i)
+			assert.Equal(t, tx.IDHash, h, i)
+		}
+	}
+}
+
+func TestInvalidTxType(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	tx := &TxSlot{}
+	body := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)[1:]
+	for _, txType := range []byte{0x00, 0x7f, 0x80} {
+		// the type byte is inside of the envelope, because a bare 0x80 would be parsed as an empty string
+		payload := append([]byte{0xb8, byte(len(body) + 1), txType}, body...)
+		_, err := ctx.ParseTransaction(payload, 0, tx, nil, true /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.ErrorIs(t, err, ErrInvalidTxType, txType)
+		_, _, err = ctx.TransactionHash(payload, 0)
+		require.ErrorIs(t, err, ErrInvalidTxType, txType)
+	}
+	payload := append([]byte{0xb8, byte(len(body) + 1), 0x7f}, body...)
+	_, err := ctx.ParseTransaction(payload, 0, tx, nil, true /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrUnknownTxType)
+	payload = append([]byte{0xb8, byte(len(body) + 1), DepositTxType}, body...)
+	_, err = ctx.ParseTransaction(payload, 0, tx, nil, true /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrDepositTx)
+	require.ErrorIs(t, err, ErrInvalidTxType)
+	payload = append([]byte{0xb8, byte(len(body) + 1), DynamicFeeTxType}, body...)
+	_, err = ctx.ParseTransaction(payload, 0, tx, nil, true /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	tx := &TxSlot{}
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	// Cut short in the middle of the signature
+	_, err := ctx.ParseTransaction(payload[:len(payload)-10], 0, tx, nil, false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrTruncated)
+	require.NotErrorIs(t, err, rlp.ErrNonCanonicalRLP)
+
+	// The nonce of the legacy transaction at position 2 (f86a 80 ...) is replaced by a one-byte string 0x00
+	malformed := common.Copy(payload)
+	malformed[2] = 0x00
+	_, err = ctx.ParseTransaction(malformed, 0, tx, nil, false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrLeadingZero)
+	require.ErrorIs(t, err, ErrParseTxn)
+	require.NotErrorIs(t, err, rlp.ErrTruncated)
+}
+
+func TestParseConcatenatedTransactions(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	// dynamic fee, access list, legacy, dynamic fee
+	tests := []int{1, 2, 0, 1}
+	for _, hasEnvelope := range []bool{false, true} {
+		txs := make([][]byte, len(tests))
+		// Some garbage in front, so that positions are not relative to the beginning of the payload
+		payload := []byte{0xde, 0xad}
+		for i, idx := range tests {
+			txs[i] = hexutility.MustDecodeHex(TxParseMainnetTests[idx].PayloadStr)
+			if hasEnvelope && txs[i][0] < 0x80 {
+				txs[i] = append([]byte{0xb8, byte(len(txs[i]))}, txs[i]...)
+			}
+			payload = append(payload, txs[i]...)
+		}
+		pos := 2
+		for i, idx := range tests {
+			p, err := ctx.ParseTransaction(payload, pos, tx, txSender[:], hasEnvelope, false /* wrappedWithBlobs */, nil)
+			require.NoError(t, err, i)
+			require.Equal(t, pos+len(txs[i]), p, i)
+			require.Equal(t, hexutility.MustDecodeHex(TxParseMainnetTests[idx].IdHashStr), tx.IDHash[:], i)
+			pos = p
+		}
+		require.Equal(t, len(payload), pos)
+	}
+}
+
+func TestExtraneousFields(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	// Legacy transaction with the tenth field (empty string)
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	require.Equal(t, byte(0x6a), payload[1])
+	payload[1]++
+	payload = append(payload, 0x80)
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrLegacyFieldCount)
+
+	// Dynamic fee transaction with an extra field
+	payload = hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	require.Equal(t, byte(0x6a), payload[2])
+	payload[2]++
+	payload = append(payload, 0x80)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+	require.NotErrorIs(t, err, ErrLegacyFieldCount)
+}
+
 func TestTransactionSignatureValidity1(t *testing.T) {
 	chainId := new(uint256.Int).SetUint64(1)
 	ctx := NewTxParseContext(*chainId)
@@ -211,6 +328,10 @@ func TestBlobTxParsing(t *testing.T) {
 	p, err := ctx.ParseTransaction(bodyEnvelope, 0, &thinTx, nil, hasEnvelope, wrappedWithBlobs, nil)
 	require.NoError(t, err)
 	assert.Equal(t, len(bodyEnvelope), p)
+	h, p, err := ctx.TransactionHash(bodyEnvelope, 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(bodyEnvelope), p)
+	assert.Equal(t, thinTx.IDHash, h)
 	assert.Equal(t, len(bodyEnvelope)-len(bodyEnvelopePrefix), int(thinTx.Size))
 	assert.Equal(t, bodyEnvelope[3:], thinTx.Rlp)
 	assert.Equal(t, BlobTxType, thinTx.Type)
@@ -278,6 +399,10 @@ func TestBlobTxParsing(t *testing.T) {
 	assert.Equal(t, thinTx.AlStorCount, fatTx.AlStorCount)
 	assert.Equal(t, thinTx.Gas, fatTx.Gas)
 	assert.Equal(t, thinTx.IDHash, fatTx.IDHash)
+	h, p, err = ctx.TransactionHash(wrapperRlp, 0)
+	require.NoError(t, err)
+	assert.Equal(t, len(wrapperRlp), p)
+	assert.Equal(t, fatTx.IDHash, h)
 	assert.Equal(t, thinTx.Creation, fatTx.Creation)
 	assert.Equal(t, thinTx.BlobFeeCap, fatTx.BlobFeeCap)
 	assert.Equal(t, thinTx.BlobHashes, fatTx.BlobHashes)
@@ -291,4 +416,1034 @@ func TestBlobTxParsing(t *testing.T) {
 	assert.Equal(t, commitment1, fatTx.Commitments[1])
 	assert.Equal(t, proof0, fatTx.Proofs[0])
 	assert.Equal(t, proof1, fatTx.Proofs[1])
+
+	// The same, but with the sidecar split from the slot
+	var splitTx TxSlot
+	sidecar, p, err := ctx.ParseBlobTransactionWrapped(wrapperRlp, 0, &splitTx, nil, hasEnvelope, nil)
+	require.NoError(t, err)
+	assert.Equal(t, len(wrapperRlp), p)
+	assert.Equal(t, fatTx.IDHash, splitTx.IDHash)
+	assert.Equal(t, fatTx.BlobHashes, splitTx.BlobHashes)
+	assert.Nil(t, splitTx.Blobs)
+	assert.Nil(t, splitTx.Commitments)
+	assert.Nil(t, splitTx.Proofs)
+	assert.Equal(t, BlobSidecar{Blobs: fatTx.Blobs, Commitments: fatTx.Commitments, Proofs: fatTx.Proofs}, sidecar)
+
+	// One proof is missing
+	short := append([]byte{}, wrapperRlp[:len(wrapperRlp)-2*49-2]...)
+	short = append(short, 0xf1, 0xb0)
+	short = append(short, proof0[:]...)
+	short[3], short[4] = 0x01, 0xcc // the wrapper is 50 bytes shorter
+	_, _, err = ctx.ParseBlobTransactionWrapped(short, 0, &splitTx, nil, hasEnvelope, nil)
+	require.ErrorIs(t, err, ErrBlobSidecarMismatch)
+
+	legacy := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	ctx = NewTxParseContext(*uint256.NewInt(1))
+	_, _, err = ctx.ParseBlobTransactionWrapped(legacy, 0, &splitTx, make([]byte, 20), false /* hasEnvelope */, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+}
+
+func TestVerifyBlobVersionedHashes(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	commitments := make([][48]byte, 3)
+	hashes := make([][32]byte, 3)
+	for i := range commitments {
+		rand.Read(commitments[i][:])
+		hashes[i] = libkzg.KZGToVersionedHash(commitments[i])
+	}
+	require.NoError(t, ctx.VerifyBlobVersionedHashes(hashes, commitments))
+	require.NoError(t, ctx.VerifyBlobVersionedHashes(nil, nil))
+
+	require.ErrorIs(t, ctx.VerifyBlobVersionedHashes(hashes[:2], commitments), ErrBlobHashMismatch)
+
+	hashes[2][0] = 0x02 // wrong version
+	err := ctx.VerifyBlobVersionedHashes(hashes, commitments)
+	require.ErrorIs(t, err, ErrBlobHashMismatch)
+	require.ErrorContains(t, err, "index 2")
+
+	hashes[1][31]++
+	err = ctx.VerifyBlobVersionedHashes(hashes, commitments)
+	require.ErrorContains(t, err, "index 1")
+}
+
+func TestGasBounds(t *testing.T) {
+	// Legacy transaction with gas limit of exactly 21000
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(21000), tx.Gas)
+
+	ctx.WithGasBounds(21000, 0)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+
+	ctx.WithGasBounds(21001, 0)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrGasTooLow)
+	require.ErrorIs(t, err, ErrParseTxn)
+
+	ctx.WithGasBounds(21000, 21000)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+
+	ctx.WithGasBounds(0, 20999)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrGasTooHigh)
+}
+
+func TestTo(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, tx.Creation)
+	assert.Equal(t, common.HexToAddress("fe3b557e8fb62b89f4916b721be55ceb828dbd73"), tx.To())
+
+	// Contract creation parsed into the same slot
+	_, err = ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[6].PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.True(t, tx.Creation)
+	assert.Equal(t, common.Address{}, tx.To())
+}
+
+func TestRawRetention(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	expected := common.Copy(payload)
+
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, expected, tx.Rlp)
+	assert.Same(t, &payload[0], &tx.Rlp[0])
+
+	ctx.WithRawRetention(true)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	// the payload buffer is reused
+	for i := range payload {
+		payload[i] = 0
+	}
+	assert.Equal(t, expected, tx.Rlp)
+	assert.Equal(t, uint32(len(expected)), tx.Size)
+}
+
+func TestDataPrefix(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+
+	// Call data is c1169548...
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[4].PayloadStr)
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, tx.HasData)
+	assert.Equal(t, [4]byte{}, tx.DataPrefix)
+
+	ctx.WithDataPrefix(true)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.True(t, tx.HasData)
+	assert.Equal(t, [4]byte{0xc1, 0x16, 0x95, 0x48}, tx.DataPrefix)
+
+	// No call data
+	payload = hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, tx.HasData)
+	assert.Equal(t, [4]byte{}, tx.DataPrefix)
+
+	// Call data is shorter than 4 bytes: 01
+	ctx = NewTxParseContext(*uint256.NewInt(1337))
+	ctx.WithDataPrefix(true)
+	payload = hexutility.MustDecodeHex(txParseDevNetTests[0].PayloadStr)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.True(t, tx.HasData)
+	assert.Equal(t, [4]byte{0x01, 0, 0, 0}, tx.DataPrefix)
+}
+
+func TestLocalFlag(t *testing.T) {
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, tx.IsLocal())
+
+	ctx.WithLocal(true)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.True(t, tx.IsLocal())
+}
+
+func TestMinFees(t *testing.T) {
+	// gasPrice = 1.5 gwei
+	legacy := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	// tip = feeCap = 1 gwei
+	dynamic := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	parse := func(payload []byte) error {
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		return err
+	}
+
+	ctx.WithMinFees(1_500_000_000, 1_500_000_000)
+	require.NoError(t, parse(legacy))
+	require.ErrorIs(t, parse(dynamic), ErrUnderpriced)
+
+	ctx.WithMinFees(1_500_000_001, 0)
+	require.ErrorIs(t, parse(legacy), ErrUnderpriced)
+
+	ctx.WithMinFees(1_000_000_000, 1_000_000_000)
+	require.NoError(t, parse(legacy))
+	require.NoError(t, parse(dynamic))
+
+	ctx.WithMinFees(0, 1_000_000_001)
+	require.ErrorIs(t, parse(dynamic), ErrUnderpriced)
+
+	// Local transactions are exempt
+	ctx.WithLocal(true)
+	require.NoError(t, parse(dynamic))
+}
+
+func TestParseTransactionFields(t *testing.T) {
+	for _, tt := range []struct {
+		payloadIdx  int
+		txType      byte
+		nonce       uint64
+		tip, feeCap uint64
+		gas         uint64
+		value       uint64
+		creation    bool
+		alAddrCount int
+		alStorCount int
+	}{
+		{payloadIdx: 0, txType: LegacyTxType, nonce: 0, tip: 1_500_000_000, feeCap: 1_500_000_000, gas: 21000, value: 10_000_000_000_000_000},
+		{payloadIdx: 1, txType: DynamicFeeTxType, nonce: 0, tip: 1_000_000_000, feeCap: 1_000_000_000, gas: 21000},
+		{payloadIdx: 2, txType: AccessListTxType, nonce: 1, tip: 1000, feeCap: 1000, gas: 21000, value: 1_000_000_000_000_000_000},
+		{payloadIdx: 6, txType: AccessListTxType, nonce: 499, tip: 10, feeCap: 10, gas: 123457, creation: true, alAddrCount: 1, alStorCount: 1},
+	} {
+		tt := tt
+		t.Run(strconv.Itoa(tt.payloadIdx), func(t *testing.T) {
+			require.NotEmpty(t, txFields[tt.txType])
+			ctx := NewTxParseContext(*uint256.NewInt(1))
+			tx, txSender := &TxSlot{}, [20]byte{}
+			payload := hexutility.MustDecodeHex(TxParseMainnetTests[tt.payloadIdx].PayloadStr)
+			p, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+			require.NoError(t, err)
+			require.Equal(t, len(payload), p)
+			assert.Equal(t, tt.txType, tx.Type)
+			assert.Equal(t, tt.nonce, tx.Nonce)
+			assert.Equal(t, tt.tip, tx.Tip.Uint64())
+			assert.Equal(t, tt.feeCap, tx.FeeCap.Uint64())
+			assert.Equal(t, tt.gas, tx.Gas)
+			assert.Equal(t, tt.value, tx.Value.Uint64())
+			assert.Equal(t, tt.creation, tx.Creation)
+			assert.Equal(t, tt.alAddrCount, tx.AlAddrCount)
+			assert.Equal(t, tt.alStorCount, tx.AlStorCount)
+		})
+	}
+}
+
+func TestValueAndCostBounds(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	tx := &TxSlot{}
+	parse := func(payloadStr string) error {
+		_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(payloadStr), 0, tx, nil, false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		return err
+	}
+
+	// gasPrice = 1, gas = 1, value = 10
+	require.NoError(t, parse("c980010180"+"0a"+"801b0101"))
+	ctx.WithMaxValue(*uint256.NewInt(10))
+	require.NoError(t, parse("c980010180"+"0a"+"801b0101"))
+	ctx.WithMaxValue(*uint256.NewInt(9))
+	require.ErrorIs(t, parse("c980010180"+"0a"+"801b0101"), ErrValueTooHigh)
+	ctx.WithMaxValue(uint256.Int{})
+
+	// gasPrice = 2^255, gas = 2
+	require.ErrorIs(t, parse("e980a08000000000000000000000000000000000000000000000000000000000000000"+"0280"+"80801b0101"), ErrCostOverflow)
+	// gasPrice = 1, gas = 1, value = 2^256-1
+	require.ErrorIs(t, parse("e980010180"+"a0ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"+"801b0101"), ErrCostOverflow)
+	// gasPrice = 1, gas = 0, value = 2^256-1
+	require.NoError(t, parse("e980018080"+"a0ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"+"801b0101"))
+}
+
+func TestStrictRLP(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	tx := &TxSlot{}
+	// gasPrice = 0x0001, gas = 1, value = 0x000a, data of 64 bytes with the length encoded as 0x0040
+	payload := hexutility.MustDecodeHex("f84f" + "80" + "820001" + "01" + "80" + "82000a" + "b90040" + strings.Repeat("00", 64) + "1b0101")
+
+	_, err := ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrParse)
+
+	ctx.WithStrictRLP(false)
+	p, err := ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, len(payload), p)
+	assert.Equal(t, uint64(1), tx.Tip.Uint64())
+	assert.Equal(t, uint64(10), tx.Value.Uint64())
+	assert.Equal(t, 64, tx.DataLen)
+	assert.True(t, tx.NonCanonical)
+	ctx.WithStrictRLP(true)
+	_, err = ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrParse)
+
+	// gas = 0x8101 is a single byte encoded as a string, data of 2 bytes with the length in the long form
+	payload = hexutility.MustDecodeHex("cd" + "80" + "01" + "8101" + "80" + "0a" + "b8020102" + "1b0101")
+	ctx.WithParseMode(ParseStrict)
+	_, err = ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrNonCanonicalRLP)
+	ctx.WithParseMode(ParseLenient)
+	_, err = ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), tx.Gas)
+	assert.Equal(t, 2, tx.DataLen)
+	assert.True(t, tx.NonCanonical)
+
+	// The sender of a non-canonical transaction is recovered from the canonical encoding, which is the signed one
+	privKey := hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	ctx.WithSender(true)
+	txSender := [20]byte{}
+	for _, txType := range []byte{testutil.LegacyTxType, testutil.DynamicFeeTxType} {
+		signed, err := testutil.BuildSignedTx(testutil.TxParams{Type: txType, ChainID: *uint256.NewInt(1), Nonce: 5,
+			Tip: *uint256.NewInt(1), FeeCap: *uint256.NewInt(1), Gas: 100_000, Data: make([]byte, 100)}, privKey)
+		require.NoError(t, err)
+		// the list has a long length, followed by the chain ID of the typed transactions, and the nonce
+		lenPos := 1
+		if txType != testutil.LegacyTxType {
+			lenPos = 2
+		}
+		noncePos := lenPos + 1 + int(txType)/int(testutil.DynamicFeeTxType)
+		require.Equal(t, byte(5), signed[noncePos])
+		payload = append(append(append([]byte{}, signed[:noncePos]...), 0x82, 0x00, 0x05), signed[noncePos+1:]...)
+		payload[lenPos] += 2
+		_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, txType)
+		assert.True(t, tx.NonCanonical, txType)
+		assert.Equal(t, uint64(5), tx.Nonce, txType)
+		assert.Equal(t, testutil.Address(privKey), txSender, txType)
+	}
+	ctx.WithSender(false)
+
+	// canonical transactions are not flagged in lenient mode
+	payload = hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	_, err = ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, tx.NonCanonical)
+}
+
+func TestParseGeneratedTransactions(t *testing.T) {
+	privKey := hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	expectedSender := testutil.Address(privKey)
+	to := [20]byte{0xde, 0xad, 0xbe, 0xef}
+	data := make([]byte, 100)
+	data[0], data[1], data[99] = 0xa9, 0x05, 0x01
+
+	for i, params := range []testutil.TxParams{
+		{Type: testutil.LegacyTxType, Nonce: 7, Tip: *uint256.NewInt(20_000_000_000), Gas: 21000, To: &to, Value: *uint256.NewInt(1)},
+		{Type: testutil.LegacyTxType, ChainID: *uint256.NewInt(1), Nonce: 0, Tip: *uint256.NewInt(1), Gas: 100_000, Data: data},
+		{Type: testutil.AccessListTxType, ChainID: *uint256.NewInt(1), Nonce: 1 << 40, Tip: *uint256.NewInt(300), Gas: 50_000, To: &to,
+			AccessList: []testutil.AccessTuple{{Address: to, StorageKeys: [][32]byte{{1}, {2}}}, {Address: [20]byte{1}}}},
+		{Type: testutil.DynamicFeeTxType, ChainID: *uint256.NewInt(1), Nonce: 3, Tip: *uint256.NewInt(2), FeeCap: *uint256.NewInt(1 << 50), Gas: 30_000_000,
+			To: &to, Value: *uint256.NewInt(1_000_000_000_000_000_000), Data: data[:3], AccessList: []testutil.AccessTuple{{Address: to, StorageKeys: [][32]byte{{3}}}}},
+	} {
+		params := params
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			payload, err := testutil.BuildSignedTx(params, privKey)
+			require.NoError(t, err)
+
+			ctx := NewTxParseContext(*uint256.NewInt(1))
+			tx, txSender := &TxSlot{}, [20]byte{}
+			p, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+			require.NoError(t, err)
+			require.Equal(t, len(payload), p)
+
+			assert.Equal(t, expectedSender, txSender)
+			assert.Equal(t, params.Type, tx.Type)
+			assert.Equal(t, params.Nonce, tx.Nonce)
+			assert.Equal(t, params.Tip, tx.Tip)
+			if params.Type == testutil.DynamicFeeTxType {
+				assert.Equal(t, params.FeeCap, tx.FeeCap)
+			} else {
+				assert.Equal(t, params.Tip, tx.FeeCap)
+			}
+			assert.Equal(t, params.Gas, tx.Gas)
+			assert.Equal(t, params.To == nil, tx.Creation)
+			assert.Equal(t, params.Value, tx.Value)
+			assert.Equal(t, len(params.Data), tx.DataLen)
+			nonZero := 0
+			for _, b := range params.Data {
+				if b != 0 {
+					nonZero++
+				}
+			}
+			assert.Equal(t, nonZero, tx.DataNonZeroLen)
+			storageKeys := 0
+			for _, tuple := range params.AccessList {
+				storageKeys += len(tuple.StorageKeys)
+			}
+			assert.Equal(t, len(params.AccessList), tx.AlAddrCount)
+			assert.Equal(t, storageKeys, tx.AlStorCount)
+		})
+	}
+}
+
+func TestChainIDMismatch(t *testing.T) {
+	privKey := hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	for _, txType := range []byte{testutil.LegacyTxType, testutil.AccessListTxType, testutil.DynamicFeeTxType} {
+		payload, err := testutil.BuildSignedTx(testutil.TxParams{Type: txType, ChainID: *uint256.NewInt(5), Tip: *uint256.NewInt(1), FeeCap: *uint256.NewInt(1), Gas: 21000}, privKey)
+		require.NoError(t, err)
+		tx, txSender := &TxSlot{}, [20]byte{}
+		_, err = NewTxParseContext(*uint256.NewInt(1)).ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.ErrorIs(t, err, ErrParseTxn, txType)
+		require.ErrorContains(t, err, "invalid chainID", txType)
+
+		ctx := NewTxParseContext(*uint256.NewInt(5))
+		_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, txType)
+		assert.Equal(t, testutil.Address(privKey), txSender, txType)
+		assert.Equal(t, uint64(5), ctx.ChainID.Uint64(), txType)
+		assert.Equal(t, uint64(5), tx.ChainID.Uint64(), txType)
+
+		ctx.WithExpectedChainID(*uint256.NewInt(7))
+		_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.ErrorContains(t, err, "invalid chainID", txType)
+
+		// without the check, the transactions of any chain are parsed, and their chain ID recorded
+		tx, txSender = &TxSlot{}, [20]byte{}
+		ctx = NewTxParseContext(*uint256.NewInt(1))
+		ctx.WithExpectedChainID(uint256.Int{})
+		_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, txType)
+		assert.Equal(t, testutil.Address(privKey), txSender, txType)
+		assert.Equal(t, uint64(5), tx.ChainID.Uint64(), txType)
+	}
+
+	// legacy transactions signed without chain ID (pre EIP-155) record none
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	var unprotected int
+	for _, tt := range TxParseMainnetTests {
+		tx, txSender := &TxSlot{}, [20]byte{}
+		_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(tt.PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err)
+		if tx.Type == LegacyTxType && ctx.IsProtected { // V is 27 or 28
+			unprotected++
+			assert.True(t, tx.ChainID.IsZero())
+		} else {
+			assert.Equal(t, uint64(1), tx.ChainID.Uint64())
+		}
+	}
+	assert.NotZero(t, unprotected)
+}
+
+func TestSetCodeTx(t *testing.T) {
+	privKey := hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	to := [20]byte{0xde, 0xad, 0xbe, 0xef}
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	build := func(auths ...testutil.Authorization) []byte {
+		payload, err := testutil.BuildSignedTx(testutil.TxParams{Type: testutil.SetCodeTxType, ChainID: *uint256.NewInt(1), Nonce: 5,
+			Tip: *uint256.NewInt(1), FeeCap: *uint256.NewInt(100), Gas: 100_000, To: &to,
+			AccessList: []testutil.AccessTuple{{Address: to}}, Authorizations: auths}, privKey)
+		require.NoError(t, err)
+		return payload
+	}
+	parse := func(payload []byte) error {
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		return err
+	}
+	auth := testutil.Authorization{ChainID: *uint256.NewInt(1), Address: [20]byte{1}, Nonce: 7, YParity: 1, R: *uint256.NewInt(2), S: *uint256.NewInt(3)}
+	anyChainAuth := testutil.Authorization{Address: [20]byte{2}, R: *uint256.NewInt(4), S: *uint256.NewInt(5)}
+
+	payload := build(auth, anyChainAuth)
+	require.NoError(t, parse(payload))
+	assert.Equal(t, SetCodeTxType, tx.Type)
+	assert.Equal(t, 2, tx.AuthCount)
+	assert.Equal(t, 1, tx.AlAddrCount)
+	assert.Equal(t, uint64(5), tx.Nonce)
+	assert.Equal(t, uint64(100), tx.FeeCap.Uint64())
+	assert.Equal(t, testutil.Address(privKey), txSender)
+	h, _, err := ctx.TransactionHash(payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, tx.IDHash, h)
+
+	require.NoError(t, parse(build()))
+	assert.Zero(t, tx.AuthCount)
+
+	tooLargeParity := auth
+	tooLargeParity.YParity = 256
+	require.ErrorIs(t, parse(build(tooLargeParity)), ErrParseTxn)
+
+	// Signers of the authorizations with valid signatures are recovered
+	authorityKey := hexutility.MustDecodeHex("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+	signed := testutil.Authorization{ChainID: *uint256.NewInt(1), Address: [20]byte{3}, Nonce: 9}
+	require.NoError(t, testutil.SignAuthorization(&signed, authorityKey))
+	highS := signed
+	highS.S.SetAllOne()
+	require.NoError(t, parse(build(highS, signed)))
+	assert.Equal(t, 2, tx.AuthCount)
+	assert.Equal(t, []Authority{{Address: testutil.Address(authorityKey), Nonce: 9}}, tx.Authorities)
+	ctx.WithSender(false)
+	require.NoError(t, parse(build(signed)))
+	assert.Nil(t, tx.Authorities)
+	ctx.WithSender(true)
+
+	// Authorizations for other chains, of the maximal nonce or with an invalid yParity are skipped, the transaction
+	// stays valid
+	foreign := testutil.Authorization{ChainID: *uint256.NewInt(5), Address: [20]byte{3}, Nonce: 9}
+	require.NoError(t, testutil.SignAuthorization(&foreign, authorityKey))
+	maxNonce := testutil.Authorization{ChainID: *uint256.NewInt(1), Address: [20]byte{3}, Nonce: math.MaxUint64}
+	require.NoError(t, testutil.SignAuthorization(&maxNonce, authorityKey))
+	wrongParity := signed
+	wrongParity.YParity = 2
+	require.NoError(t, parse(build(foreign, maxNonce, wrongParity, signed)))
+	assert.Equal(t, 4, tx.AuthCount)
+	assert.Equal(t, []Authority{{Address: testutil.Address(authorityKey), Nonce: 9}}, tx.Authorities)
+	// without the expected chain ID, the chain of the authorizations isn't checked either
+	ctx.WithExpectedChainID(uint256.Int{})
+	require.NoError(t, parse(build(foreign)))
+	assert.Equal(t, []Authority{{Address: testutil.Address(authorityKey), Nonce: 9}}, tx.Authorities)
+	ctx.WithExpectedChainID(*uint256.NewInt(1))
+
+	// Legacy transaction parsed into the same slot has no authorizations
+	require.NoError(t, parse(build(auth)))
+	require.NoError(t, parse(hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)))
+	assert.Zero(t, tx.AuthCount)
+	assert.Nil(t, tx.Authorities)
+}
+
+func TestAccessListLimits(t *testing.T) {
+	privKey := hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	params := testutil.TxParams{Type: testutil.AccessListTxType, ChainID: *uint256.NewInt(1), Tip: *uint256.NewInt(1), Gas: 100_000}
+	for i := 0; i < 4; i++ {
+		params.AccessList = append(params.AccessList, testutil.AccessTuple{Address: [20]byte{byte(i)}, StorageKeys: make([][32]byte, 5)})
+	}
+	payload, err := testutil.BuildSignedTx(params, privKey)
+	require.NoError(t, err)
+
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	parse := func() error {
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		return err
+	}
+
+	require.NoError(t, parse())
+	ctx.WithAccessListLimits(4, 20)
+	require.NoError(t, parse())
+	require.Equal(t, 4, tx.AlAddrCount)
+	require.Equal(t, 20, tx.AlStorCount)
+
+	ctx.WithAccessListLimits(3, 0)
+	require.ErrorIs(t, parse(), ErrAccessListTooLarge)
+	ctx.WithAccessListLimits(0, 19)
+	require.ErrorIs(t, parse(), ErrAccessListTooLarge)
+}
+
+func TestKeepAccessList(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	parse := func(payload []byte) {
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err)
+	}
+	withAccessList := hexutility.MustDecodeHex(TxParseMainnetTests[5].PayloadStr)
+
+	parse(withAccessList)
+	assert.Equal(t, 1, tx.AlAddrCount)
+	assert.Nil(t, tx.AccessList())
+
+	ctx.WithKeepAccessList(true)
+	parse(withAccessList)
+	assert.Equal(t, AccessList{{
+		Address:     common.HexToAddress("de0b295669a9fd93d5f28d9ec85e40f4cb697bae"),
+		StorageKeys: []common.Hash{common.HexToHash("03"), common.HexToHash("07")},
+	}}, tx.AccessList())
+
+	// Legacy transaction parsed into the same slot has no access list
+	parse(hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr))
+	assert.Nil(t, tx.AccessList())
+	assert.Zero(t, tx.AlAddrCount)
+
+	// Order and duplicates are preserved
+	privKey := hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	a, b := [20]byte{0xaa}, [20]byte{0xbb}
+	payload, err := testutil.BuildSignedTx(testutil.TxParams{Type: testutil.AccessListTxType, ChainID: *uint256.NewInt(1), Gas: 50_000, To: &a,
+		AccessList: []testutil.AccessTuple{{Address: b, StorageKeys: [][32]byte{{2}, {1}, {2}}}, {Address: a}, {Address: b, StorageKeys: [][32]byte{{1}}}}}, privKey)
+	require.NoError(t, err)
+	parse(payload)
+	assert.Equal(t, AccessList{
+		{Address: b, StorageKeys: []common.Hash{{2}, {1}, {2}}},
+		{Address: a},
+		{Address: b, StorageKeys: []common.Hash{{1}}},
+	}, tx.AccessList())
+	assert.Equal(t, 4, tx.AccessList().StorageKeys())
+}
+
+type accessTupleRecorder struct {
+	addrs [][20]byte
+	keys  [][32]byte
+}
+
+func (r *accessTupleRecorder) OnAccessTuple(addr [20]byte, keys [][32]byte) {
+	r.addrs = append(r.addrs, addr)
+	r.keys = append(r.keys, keys...)
+}
+
+func TestAccessListVisitor(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	recorder := &accessTupleRecorder{}
+	ctx.WithAccessListVisitor(recorder)
+	_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[5].PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, [][20]byte{common.HexToAddress("de0b295669a9fd93d5f28d9ec85e40f4cb697bae")}, recorder.addrs)
+	assert.Equal(t, [][32]byte{common.HexToHash("03"), common.HexToHash("07")}, recorder.keys)
+	assert.Nil(t, tx.AccessList())
+
+	ctx.WithAccessListVisitor(nil)
+	_, err = ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[5].PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Len(t, recorder.addrs, 1)
+}
+
+func TestCost(t *testing.T) {
+	maxU256 := new(uint256.Int).SetAllOne()
+	slot := &TxSlot{Gas: 21000, FeeCap: *uint256.NewInt(10), Value: *uint256.NewInt(5)}
+	cost, err := Cost(slot)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(210005), cost.Uint64())
+
+	slot.BlobHashes = make([]common.Hash, 2)
+	slot.BlobFeeCap = *uint256.NewInt(3)
+	cost, err = Cost(slot)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(210005+2*fixedgas.BlobGasPerBlob*3), cost.Uint64())
+
+	// gas * feeCap + value == 2^256-1 exactly
+	slot = &TxSlot{Gas: 1, FeeCap: *uint256.NewInt(1)}
+	slot.Value.SubUint64(maxU256, 1)
+	cost, err = Cost(slot)
+	require.NoError(t, err)
+	assert.Equal(t, *maxU256, cost)
+
+	// one more wei overflows
+	slot.Value.Set(maxU256)
+	_, err = Cost(slot)
+	require.ErrorIs(t, err, ErrCostOverflow)
+
+	slot = &TxSlot{Gas: 2}
+	slot.FeeCap.Rsh(maxU256, 1)
+	_, err = Cost(slot)
+	require.NoError(t, err)
+	slot.FeeCap.AddUint64(&slot.FeeCap, 1)
+	_, err = Cost(slot)
+	require.ErrorIs(t, err, ErrCostOverflow)
+
+	slot = &TxSlot{Gas: 1, BlobHashes: make([]common.Hash, 1)}
+	slot.BlobFeeCap.Set(maxU256)
+	_, err = Cost(slot)
+	require.ErrorIs(t, err, ErrCostOverflow)
+}
+
+func TestCompareByPriority(t *testing.T) {
+	legacy := func(gasPrice, nonce uint64) *TxSlot {
+		return &TxSlot{Type: LegacyTxType, Tip: *uint256.NewInt(gasPrice), FeeCap: *uint256.NewInt(gasPrice), Nonce: nonce}
+	}
+	dynamic := func(tip, feeCap, nonce uint64) *TxSlot {
+		return &TxSlot{Type: DynamicFeeTxType, Tip: *uint256.NewInt(tip), FeeCap: *uint256.NewInt(feeCap), Nonce: nonce}
+	}
+	const baseFee = 10
+	for i, tt := range []struct {
+		a, b   *TxSlot
+		expect int
+	}{
+		{legacy(15, 0), dynamic(5, 100, 0), 0},   // both tip 5, tie broken by hash
+		{legacy(16, 0), dynamic(5, 100, 0), 1},   // 6 > 5
+		{legacy(14, 0), dynamic(5, 100, 0), -1},  // 4 < 5
+		{legacy(100, 0), dynamic(5, 100, 0), 1},  // 90 > 5
+		{dynamic(50, 12, 0), legacy(13, 0), -1},  // tip capped at feeCap - baseFee: 2 < 3
+		{legacy(9, 0), dynamic(0, 10, 0), -1},    // below the base fee is not eligible
+		{dynamic(100, 9, 0), legacy(10, 0), -1},  // not eligible, despite high tip
+		{legacy(9, 0), legacy(5, 0), 1},          // both not eligible, higher fee cap wins
+		{legacy(20, 1), dynamic(10, 100, 2), 1},  // equal tips, lower nonce first
+		{dynamic(10, 100, 3), legacy(20, 2), -1}, // equal tips, lower nonce first
+	} {
+		if tt.expect == 0 {
+			tt.a.IDHash[0], tt.b.IDHash[0] = 1, 2
+			tt.expect = 1
+		}
+		assert.Equal(t, tt.expect, CompareByPriority(tt.a, tt.b, baseFee), i)
+		assert.Equal(t, -tt.expect, CompareByPriority(tt.b, tt.a, baseFee), i)
+	}
+	a := legacy(20, 0)
+	assert.Equal(t, 0, CompareByPriority(a, a, baseFee))
+}
+
+func TestParseTransactionWithSig(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	var sig0, sig1 [65]byte
+
+	_, err := ctx.ParseTransactionWithSig(hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr), 0, tx, txSender[:], sig0[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	require.Equal(t, ctx.Sig, sig0)
+	first := sig0
+
+	_, err = ctx.ParseTransactionWithSig(hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr), 0, tx, txSender[:], sig1[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	require.Equal(t, ctx.Sig, sig1)
+	// ctx.Sig has been overwritten, but the caller's copy hasn't
+	require.NotEqual(t, first, ctx.Sig)
+	require.Equal(t, first, sig0)
+	// R of the first transaction
+	require.Equal(t, hexutility.MustDecodeHex("d22fc3eed9b9b9dbef9eec230aa3fb849eff60356c6b34e86155dca5c03554c7"), sig0[:32])
+	require.Equal(t, byte(1), sig0[64])
+
+	_, err = ctx.ParseTransactionWithSig(hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr), 0, tx, txSender[:], sig1[:64], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+}
+
+// Signing hash of access list transactions must be keccak256(0x01 || rlp([chainId, nonce, gasPrice, gas, to, value, data, accessList]))
+func TestRecoverSenderInto(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	// Signature of TxParseMainnetTests[1]
+	var sighash [32]byte
+	var sig [65]byte
+	copy(sighash[:], hexutility.MustDecodeHex("c63673a5d989925d01a6c1339252f546e99b6957ce566a488154e169ae9bd49c"))
+	copy(sig[:], hexutility.MustDecodeHex("2c73a04cd144e5a84ceb6da942f83763c2682896b51f7922e2e2f9a524dd90b7"+
+		"235adda5f87a1d098e2739e40e83129ff82837c9042e6ad61d0481334dcb6f1a"+"01"))
+
+	var sender [20]byte
+	require.NoError(t, ctx.RecoverSenderInto(sighash, sig, &sender))
+	assert.Equal(t, hexutility.MustDecodeHex("81f5daee2c61807d0fc5e4c8b4e1d3c3e028d9ab"), sender[:])
+
+	sig[64] = 0
+	require.NoError(t, ctx.RecoverSenderInto(sighash, sig, &sender))
+	assert.NotEqual(t, hexutility.MustDecodeHex("81f5daee2c61807d0fc5e4c8b4e1d3c3e028d9ab"), sender[:])
+
+	sig[64] = 4
+	require.ErrorIs(t, ctx.RecoverSenderInto(sighash, sig, &sender), ErrParseTxn)
+}
+
+func TestAccessListTxSighash(t *testing.T) {
+	for _, tt := range []struct {
+		payloadIdx int
+		preimage   string
+	}{
+		// unsigned list shorter than 56 bytes
+		{payloadIdx: 2, preimage: "01e801018203e882520894236ff1e97419ae93ad80cafbaa21220c5d78fb7d880de0b6b3a764000080c0"},
+		{payloadIdx: 7, preimage: "01c801018080808080c0"},
+		// unsigned list of 56 bytes or longer
+		{payloadIdx: 6, preimage: "01f846018201f30a8301e241808080f838f7940000000000000000000000000000000000000001e1a00000000000000000000000000000000000000000000000000000000000000000"},
+	} {
+		tt := tt
+		t.Run(strconv.Itoa(tt.payloadIdx), func(t *testing.T) {
+			testCase := TxParseMainnetTests[tt.payloadIdx]
+			payload := hexutility.MustDecodeHex(testCase.PayloadStr)
+			ctx := NewTxParseContext(*uint256.NewInt(1))
+			tx, txSender := &TxSlot{}, [20]byte{}
+			_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+			require.NoError(t, err)
+			require.Equal(t, AccessListTxType, tx.Type)
+
+			h := sha3.NewLegacyKeccak256()
+			h.Write(hexutility.MustDecodeHex(tt.preimage))
+			require.Equal(t, h.Sum(nil), ctx.Sighash[:])
+			if testCase.SignHashStr != "" {
+				require.Equal(t, hexutility.MustDecodeHex(testCase.SignHashStr), ctx.Sighash[:])
+			}
+
+			h.Reset()
+			h.Write(payload)
+			require.Equal(t, h.Sum(nil), tx.IDHash[:])
+			if testCase.IdHashStr != "" {
+				require.Equal(t, hexutility.MustDecodeHex(testCase.IdHashStr), tx.IDHash[:])
+			}
+		})
+	}
+}
+
+func TestFieldOffsets(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithFieldOffsets(true)
+	tx, txSender := &TxSlot{}, [20]byte{}
+
+	// f86a 80 8459682f00 825208 94fe3b557e8fb62b89f4916b721be55ceb828dbd73 872386f26fc10000 80 ...
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr)
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, FieldOffsets{
+		Nonce:  FieldRange{2, 3},
+		Tip:    FieldRange{3, 8},
+		FeeCap: FieldRange{3, 8},
+		Gas:    FieldRange{8, 11},
+		To:     FieldRange{11, 32},
+		Value:  FieldRange{32, 40},
+		Data:   FieldRange{40, 41},
+	}, ctx.FieldOffsets())
+
+	// 02 f86a 01 80 843b9aca00 843b9aca00 825208 94e80d2a018c813577f33f9e69387dc621206fb3a4 80 80 c0 ...
+	payload = hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	offsets := ctx.FieldOffsets()
+	assert.Equal(t, FieldOffsets{
+		Nonce:      FieldRange{4, 5},
+		Tip:        FieldRange{5, 10},
+		FeeCap:     FieldRange{10, 15},
+		Gas:        FieldRange{15, 18},
+		To:         FieldRange{18, 39},
+		Value:      FieldRange{39, 40},
+		Data:       FieldRange{40, 41},
+		AccessList: FieldRange{41, 42},
+	}, offsets)
+	assert.Equal(t, hexutility.MustDecodeHex("94e80d2a018c813577f33f9e69387dc621206fb3a4"), payload[offsets.To.Start:offsets.To.End])
+}
+
+func TestHighS(t *testing.T) {
+	var n uint256.Int
+	n.SetBytes(hexutility.MustDecodeHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"))
+	// Legacy transaction and dynamic fee transaction, both with v being one byte, followed by 32 bytes of r and s each
+	for idx, flippedV := range map[int]byte{0: 0x1b, 1: 0x80} {
+		payload := hexutility.MustDecodeHex(TxParseMainnetTests[idx].PayloadStr)
+		// (r, n-s) with the other parity of v is an equally valid signature by the same sender
+		var s uint256.Int
+		s.SetBytes(payload[len(payload)-32:])
+		s.Sub(&n, &s)
+		s.WriteToSlice(payload[len(payload)-32:])
+		payload[len(payload)-67] = flippedV
+
+		ctx := NewTxParseContext(*uint256.NewInt(1))
+		tx, txSender := &TxSlot{}, [20]byte{}
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.ErrorContains(t, err, "invalid v, r, s", idx)
+
+		// Only legacy transactions may be signed before EIP-2
+		ctx.WithAllowPreEip2s(true)
+		_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		if idx != 0 {
+			require.ErrorContains(t, err, "invalid v, r, s", idx)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, hexutility.MustDecodeHex(TxParseMainnetTests[idx].SenderStr), txSender[:])
+	}
+}
+
+func TestTxSlotSlab(t *testing.T) {
+	slab := NewTxSlotSlab(2)
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	seen := map[*TxSlot]struct{}{}
+	for i, tt := range TxParseMainnetTests[:5] {
+		tx, txSender := slab.New(), [20]byte{}
+		require.Equal(t, TxSlot{}, *tx, i)
+		_, seenBefore := seen[tx]
+		require.False(t, seenBefore, i)
+		seen[tx] = struct{}{}
+		_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(tt.PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, true /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, i)
+		require.Equal(t, hexutility.MustDecodeHex(tt.IdHashStr), tx.IDHash[:], i)
+	}
+}
+
+func TestAppendRLP(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithKeepAccessList(true)
+	ctx.WithFieldOffsets(true)
+	tx, txSender := &TxSlot{}, [20]byte{}
+	for i, tt := range TxParseMainnetTests {
+		payload := hexutility.MustDecodeHex(tt.PayloadStr)
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, i)
+		// Data is not retained in TxSlot
+		dataPos, dataLen, err := rlp.String(payload, ctx.FieldOffsets().Data.Start)
+		require.NoError(t, err, i)
+		enc, err := tx.AppendRLP([]byte{0xde, 0xad}, &ctx.ChainID, payload[dataPos:dataPos+dataLen], &ctx.V, &ctx.R, &ctx.S)
+		require.NoError(t, err, i)
+		require.Equal(t, tx.Rlp, enc[2:], i)
+	}
+
+	// Access list of the transaction 5 is not empty
+	ctx.WithKeepAccessList(false)
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[5].PayloadStr)
+	_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	_, err = tx.AppendRLP(nil, &ctx.ChainID, nil, &ctx.V, &ctx.R, &ctx.S)
+	require.ErrorContains(t, err, "WithKeepAccessList")
+}
+
+func BenchmarkTransactionHash(b *testing.B) {
+	payload := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	b.Run("parse", func(b *testing.B) {
+		tx := &TxSlot{}
+		for i := 0; i < b.N; i++ {
+			if _, err := ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, false /* wrappedWithBlobs */, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("hash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := ctx.TransactionHash(payload, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestEnveloped(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	raw := hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr)
+	enveloped := append([]byte{0xb8, byte(len(raw))}, raw...)
+	for _, hasEnvelope := range []bool{false, true} {
+		p, err := ctx.ParseTransaction(enveloped, 0, tx, txSender[:], hasEnvelope, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err)
+		assert.Equal(t, len(enveloped), p)
+		assert.True(t, ctx.Enveloped())
+		assert.Equal(t, hexutility.MustDecodeHex(TxParseMainnetTests[1].IdHashStr), tx.IDHash[:])
+	}
+
+	p, err := ctx.ParseTransaction(raw, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, len(raw), p)
+	assert.False(t, ctx.Enveloped())
+	assert.Equal(t, hexutility.MustDecodeHex(TxParseMainnetTests[1].IdHashStr), tx.IDHash[:])
+	_, err = ctx.ParseTransaction(raw, 0, tx, txSender[:], true /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+
+	_, err = ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr), 0, tx, txSender[:], true /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, ctx.Enveloped())
+}
+
+const testRegisteredTxType byte = 0x64
+
+var registerTestTxType sync.Once
+
+// testTxType is rlp([nonce, gas, sender]), the sender is given explicitly instead of a signature
+func parseTestTxType(ctx *TxParseContext, payload []byte, pos int, slot *TxSlot, sender []byte) (p int, err error) {
+	if p, slot.Nonce, err = rlp.U64(payload, pos); err != nil {
+		return 0, err
+	}
+	if p, slot.Gas, err = rlp.U64(payload, p); err != nil {
+		return 0, err
+	}
+	if p, err = rlp.StringOfLen(payload, p, 20); err != nil {
+		return 0, err
+	}
+	copy(sender, payload[p:p+20])
+	return p + 20, nil
+}
+
+func TestRegisterTxType(t *testing.T) {
+	registerTestTxType.Do(func() { RegisterTxType(testRegisteredTxType, parseTestTxType) })
+	require.Panics(t, func() { RegisterTxType(testRegisteredTxType, parseTestTxType) })
+	require.Panics(t, func() { RegisterTxType(DynamicFeeTxType, parseTestTxType) })
+
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	payload := hexutility.MustDecodeHex("64" + "d9" + "07" + "825208" + "94" + "00112233445566778899aabbccddeeff00112233")
+	p, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.Equal(t, len(payload), p)
+	assert.Equal(t, testRegisteredTxType, tx.Type)
+	assert.Equal(t, uint64(7), tx.Nonce)
+	assert.Equal(t, uint64(21000), tx.Gas)
+	assert.Equal(t, hexutility.MustDecodeHex("00112233445566778899aabbccddeeff00112233"), txSender[:])
+	keccak := sha3.NewLegacyKeccak256()
+	keccak.Write(payload)
+	assert.Equal(t, keccak.Sum(nil), tx.IDHash[:])
+	h, _, err := ctx.TransactionHash(payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, tx.IDHash, h)
+
+	// Fields are limited to the list
+	payload = hexutility.MustDecodeHex("64" + "d8" + "07" + "825208" + "94" + "00112233445566778899aabbccddeeff001122" + "33")
+	_, err = ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrTruncated)
+}
+
+func TestSignatureAccessors(t *testing.T) {
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	ctx.WithSender(false)
+	tx := &TxSlot{}
+	eip155, err := testutil.BuildSignedTx(testutil.TxParams{Type: testutil.LegacyTxType, ChainID: *uint256.NewInt(1), Gas: 21000},
+		hexutility.MustDecodeHex("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"))
+	require.NoError(t, err)
+	// unprotected legacy, dynamic fee, EIP-155 legacy
+	for i, payload := range [][]byte{hexutility.MustDecodeHex(TxParseMainnetTests[0].PayloadStr), hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr), eip155} {
+		_, err := ctx.ParseTransaction(payload, 0, tx, nil, false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, i)
+		sig := tx.Signature()
+		r, s := ctx.R.Bytes32(), ctx.S.Bytes32()
+		assert.Equal(t, r[:], sig[:32], i)
+		assert.Equal(t, s[:], sig[32:64], i)
+		assert.Equal(t, sig[64], tx.YParity(), i)
+		assert.LessOrEqual(t, tx.YParity(), byte(1), i)
+		assert.Equal(t, &ctx.V, tx.V(uint256.NewInt(1)), i)
+	}
+}
+
+func TestParseUnsigned(t *testing.T) {
+	// [nonce, gasPrice, gas, to, value, data]
+	legacy := hexutility.MustDecodeHex("dc" + "01" + "01" + "825208" + "94" + "3535353535353535353535353535353535353535" + "80" + "80")
+	// 2 || [chainID, nonce, tip, feeCap, gas, to, value, data, accessList]
+	dynamicFee := hexutility.MustDecodeHex("02" + "df" + "01" + "01" + "01" + "02" + "825208" + "94" + "3535353535353535353535353535353535353535" + "80" + "80" + "c0")
+
+	ctx := NewTxParseContext(*uint256.NewInt(1))
+	tx, txSender := &TxSlot{}, [20]byte{}
+	for _, payload := range [][]byte{legacy, dynamicFee} {
+		_, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.ErrorIs(t, err, ErrParseTxn)
+	}
+
+	ctx.WithUnsigned(true)
+	defer ctx.WithUnsigned(false)
+	for i, payload := range [][]byte{legacy, dynamicFee} {
+		p, err := ctx.ParseTransaction(payload, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+		require.NoError(t, err, i)
+		assert.Equal(t, len(payload), p, i)
+		assert.True(t, tx.Unsigned, i)
+		assert.Equal(t, uint64(1), tx.Nonce, i)
+		assert.Equal(t, uint64(21000), tx.Gas, i)
+		assert.Equal(t, [65]byte{}, tx.Signature(), i)
+		h := sha3.NewLegacyKeccak256()
+		h.Write(payload)
+		assert.Equal(t, h.Sum(nil), tx.IDHash[:], i)
+	}
+	assert.Equal(t, uint64(2), tx.FeeCap.Uint64())
+
+	// Signed transactions have extraneous fields
+	_, err := ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, ErrParseTxn)
+
+	// Unsigned and lenient parsing combine: nonce = 0x0001
+	nonCanonical := hexutility.MustDecodeHex("de" + "820001" + "01" + "825208" + "94" + "3535353535353535353535353535353535353535" + "80" + "80")
+	_, err = ctx.ParseTransaction(nonCanonical, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.ErrorIs(t, err, rlp.ErrNonCanonicalRLP)
+	ctx.WithParseMode(ParseLenient)
+	_, err = ctx.ParseTransaction(nonCanonical, 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	ctx.WithParseMode(ParseStrict)
+	require.NoError(t, err)
+	assert.True(t, tx.Unsigned)
+	assert.True(t, tx.NonCanonical)
+	assert.Equal(t, uint64(1), tx.Nonce)
+
+	ctx.WithUnsigned(false)
+	_, err = ctx.ParseTransaction(hexutility.MustDecodeHex(TxParseMainnetTests[1].PayloadStr), 0, tx, txSender[:], false /* hasEnvelope */, false /* wrappedWithBlobs */, nil)
+	require.NoError(t, err)
+	assert.False(t, tx.Unsigned)
 }
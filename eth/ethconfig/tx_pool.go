@@ -72,9 +72,17 @@ var DefaultTxPool2Config = func(fullCfg *Config) txpoolcfg.Config {
 	cfg.AccountSlots = pool1Cfg.AccountSlots
 	cfg.BlobSlots = fullCfg.TxPool.BlobSlots
 	cfg.TotalBlobPoolLimit = fullCfg.TxPool.TotalBlobPoolLimit
+	cfg.TotalPoolSize = fullCfg.TxPool.TotalPoolSize
+	cfg.MaxNonceGap = fullCfg.TxPool.MaxNonceGap
+	cfg.MaxAccountTxs = fullCfg.TxPool.MaxAccountTxs
+	cfg.MinTip = fullCfg.TxPool.MinTip
+	cfg.PeerTxsRate = fullCfg.TxPool.PeerTxsRate
+	cfg.PeerBytesRate = fullCfg.TxPool.PeerBytesRate
 	cfg.LogEvery = 3 * time.Minute
 	cfg.CommitEvery = 5 * time.Minute
 	cfg.TracedSenders = pool1Cfg.TracedSenders
+	cfg.Locals = pool1Cfg.Locals
+	cfg.Lifetime = pool1Cfg.Lifetime
 	cfg.CommitEvery = pool1Cfg.CommitEvery
 
 	return cfg
@@ -18,6 +18,7 @@ import (
 	"github.com/ledgerwatch/erigon-lib/common/metrics"
 	"github.com/ledgerwatch/erigon-lib/kv"
 	"github.com/ledgerwatch/erigon-lib/kv/membatch"
+	"github.com/ledgerwatch/erigon-lib/txpool"
 	types2 "github.com/ledgerwatch/erigon-lib/types"
 	"github.com/ledgerwatch/erigon/consensus"
 	"github.com/ledgerwatch/erigon/core"
@@ -50,6 +51,13 @@ type TxPoolForMining interface {
 	YieldBest(n uint16, txs *types2.TxsRlp, tx kv.Tx, onTopOf, availableGas, availableBlobGas uint64, toSkip mapset.Set[[32]byte]) (bool, int, error)
 }
 
+// ConditionalTxPool is implemented by the pools accepting conditional txs (eth_sendRawTransactionConditional), whose
+// conditions are checked against the block being built
+type ConditionalTxPool interface {
+	Conditions(hash []byte) *txpool.TxConditions
+	DropConditional(hash []byte)
+}
+
 func StageMiningExecCfg(
 	db kv.RwDB, miningState MiningState,
 	notifier ChainEventNotifier, chainConfig chain.Config,
@@ -209,6 +217,8 @@ func getNextTransactions(
 		return nil, 0, err
 	}
 
+	blockNum := executionAt + 1
+	conditionalPool, _ := cfg.txPool.(ConditionalTxPool)
 	var txs []types.Transaction //nolint:prealloc
 	for i := range txSlots.Txs {
 		transaction, err := types.DecodeWrappedTransaction(txSlots.Txs[i])
@@ -222,6 +232,16 @@ func getNextTransactions(
 			continue
 		}
 
+		if conditionalPool != nil {
+			met, err := checkTxConditions(conditionalPool, transaction, blockNum, header.Time, simulationTx, logger)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !met {
+				continue
+			}
+		}
+
 		var sender libcommon.Address
 		copy(sender[:], txSlots.Senders.At(i))
 
@@ -230,7 +250,6 @@ func getNextTransactions(
 		txs[len(txs)-1].SetSender(sender)
 	}
 
-	blockNum := executionAt + 1
 	txs, err := filterBadTransactions(txs, cfg.chainConfig, blockNum, header.BaseFee, simulationTx, logger)
 	if err != nil {
 		return nil, 0, err
@@ -239,6 +258,43 @@ func getNextTransactions(
 	return types.NewTransactionsFixedOrder(txs), count, nil
 }
 
+// checkTxConditions checks the conditions of a conditional tx against the block being built and the state it is built
+// on top of. The tx is dropped from the pool when its conditions fail, unless a later block can meet them
+func checkTxConditions(pool ConditionalTxPool, transaction types.Transaction, blockNum, timestamp uint64, simulationTx kv.StatelessRwTx, logger log.Logger) (bool, error) {
+	hash := transaction.Hash()
+	conditions := pool.Conditions(hash[:])
+	if conditions == nil {
+		return true, nil
+	}
+	err := conditions.CheckBlock(blockNum, timestamp)
+	if err == nil {
+		stateReader := state.NewPlainStateReader(simulationTx)
+		err = conditions.CheckStorage(func(addr libcommon.Address, slot libcommon.Hash) (libcommon.Hash, error) {
+			account, err := stateReader.ReadAccountData(addr)
+			if err != nil || account == nil {
+				return libcommon.Hash{}, err
+			}
+			value, err := stateReader.ReadAccountStorage(addr, account.Incarnation, &slot)
+			if err != nil {
+				return libcommon.Hash{}, err
+			}
+			return libcommon.BytesToHash(value), nil
+		})
+	}
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, txpool.ErrConditionsNotYet):
+		return false, nil
+	case errors.Is(err, txpool.ErrConditionsNotMet):
+		logger.Debug("Conditional tx dropped", "hash", hash, "err", err)
+		pool.DropConditional(hash[:])
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
 func filterBadTransactions(transactions []types.Transaction, config chain.Config, blockNumber uint64, baseFee *big.Int, simulationTx kv.StatelessRwTx, logger log.Logger) ([]types.Transaction, error) {
 	initialCnt := len(transactions)
 	var filtered []types.Transaction
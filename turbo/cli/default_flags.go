@@ -21,6 +21,12 @@ var DefaultFlags = []cli.Flag{
 	&utils.TxPoolAccountSlotsFlag,
 	&utils.TxPoolBlobSlotsFlag,
 	&utils.TxPoolTotalBlobPoolLimit,
+	&utils.TxPoolTotalSizeFlag,
+	&utils.TxPoolMaxNonceGapFlag,
+	&utils.TxPoolMaxAccountTxsFlag,
+	&utils.TxPoolMinTipFlag,
+	&utils.TxPoolPeerTxsRateFlag,
+	&utils.TxPoolPeerBytesRateFlag,
 	&utils.TxPoolGlobalSlotsFlag,
 	&utils.TxPoolGlobalBaseFeeSlotsFlag,
 	&utils.TxPoolAccountQueueFlag,
@@ -14,6 +14,7 @@ import (
 	"github.com/ledgerwatch/erigon/turbo/rpchelper"
 
 	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	txpool2 "github.com/ledgerwatch/erigon-lib/txpool"
 
 	"github.com/ledgerwatch/erigon/common"
 	"github.com/ledgerwatch/erigon/rpc"
@@ -21,6 +22,18 @@ import (
 
 // GetBalance implements eth_getBalance. Returns the balance of an account for a given address.
 func (api *APIImpl) GetBalance(ctx context.Context, address libcommon.Address, blockNrOrHash rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	if blockNrOrHash.BlockNumber != nil && *blockNrOrHash.BlockNumber == rpc.PendingBlockNumber {
+		// the balance left by the pool txs, the latest one when the txpool doesn't serve it
+		if ext, ok := txpool2.ExtOf(api.txPool); ok {
+			reply, err := ext.PendingBalance(ctx, gointerfaces.ConvertAddressToH160(address))
+			if err == nil {
+				return (*hexutil.Big)(gointerfaces.ConvertH256ToUint256Int(reply).ToBig()), nil
+			}
+			if !txpool2.IsExtUnsupported(err) {
+				return nil, err
+			}
+		}
+	}
 	tx, err1 := api.db.BeginRo(ctx)
 	if err1 != nil {
 		return nil, fmt.Errorf("getBalance cannot open tx: %w", err1)
@@ -2,12 +2,20 @@ package jsonrpc
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/ledgerwatch/erigon-lib/common/hexutil"
 	"math/big"
 
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
 	"github.com/ledgerwatch/erigon-lib/chain"
+	libcommon "github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/kv"
+	txpool2 "github.com/ledgerwatch/erigon-lib/txpool"
 
+	"github.com/ledgerwatch/erigon/consensus/misc"
 	"github.com/ledgerwatch/erigon/core/rawdb"
 	"github.com/ledgerwatch/erigon/core/types"
 	"github.com/ledgerwatch/erigon/eth/ethconfig"
@@ -115,14 +123,17 @@ func (api *APIImpl) GasPrice(ctx context.Context) (*hexutil.Big, error) {
 		return nil, err
 	}
 
-	oracle := gasprice.NewOracle(NewGasPriceOracleBackend(tx, cc, api.BaseAPI), ethconfig.Defaults.GPO, api.gasCache)
-	tipcap, err := oracle.SuggestTipCap(ctx)
-	gasResult := big.NewInt(0)
-
-	gasResult.Set(tipcap)
+	tipcap, ok, err := api.txPoolSuggestedTip(ctx)
 	if err != nil {
 		return nil, err
 	}
+	if !ok {
+		oracle := gasprice.NewOracle(NewGasPriceOracleBackend(tx, cc, api.BaseAPI), ethconfig.Defaults.GPO, api.gasCache)
+		if tipcap, err = oracle.SuggestTipCap(ctx); err != nil {
+			return nil, err
+		}
+	}
+	gasResult := new(big.Int).Set(tipcap)
 	if head := rawdb.ReadCurrentHeader(tx); head != nil && head.BaseFee != nil {
 		gasResult.Add(tipcap, head.BaseFee)
 	}
@@ -132,6 +143,11 @@ func (api *APIImpl) GasPrice(ctx context.Context) (*hexutil.Big, error) {
 
 // MaxPriorityFeePerGas returns a suggestion for a gas tip cap for dynamic fee transactions.
 func (api *APIImpl) MaxPriorityFeePerGas(ctx context.Context) (*hexutil.Big, error) {
+	if tipcap, ok, err := api.txPoolSuggestedTip(ctx); err != nil {
+		return nil, err
+	} else if ok {
+		return (*hexutil.Big)(tipcap), nil
+	}
 	tx, err := api.db.BeginRo(ctx)
 	if err != nil {
 		return nil, err
@@ -168,10 +184,52 @@ func (api *APIImpl) FeeHistory(ctx context.Context, blockCount rpc.DecimalOrHex,
 	}
 	oracle := gasprice.NewOracle(NewGasPriceOracleBackend(tx, cc, api.BaseAPI), ethconfig.Defaults.GPO, api.gasCache)
 
+	var pending *txpool2.BlockTips
+	if lastBlock == rpc.PendingBlockNumber && blockCount > 0 {
+		if pending, err = api.txPoolPendingTips(ctx, rewardPercentiles); err != nil {
+			return nil, err
+		}
+	}
+	if pending != nil {
+		// the oracle has no pending block, the txpool's one follows the latest blocks
+		blockCount--
+		lastBlock = rpc.LatestBlockNumber
+	}
 	oldest, reward, baseFee, gasUsed, err := oracle.FeeHistory(ctx, int(blockCount), lastBlock, rewardPercentiles)
 	if err != nil {
 		return nil, err
 	}
+	if pending != nil {
+		head, err := api.headerByRPCNumber(rpc.LatestBlockNumber, tx)
+		if err != nil {
+			return nil, err
+		}
+		if head == nil {
+			return nil, fmt.Errorf("latest header not found")
+		}
+		if len(baseFee) == 0 {
+			oldest, baseFee = new(big.Int).Add(head.Number, libcommon.Big1), []*big.Int{new(big.Int).SetUint64(pending.BaseFee)}
+		}
+		if len(rewardPercentiles) != 0 {
+			rewards := make([]*big.Int, len(pending.Rewards))
+			for i, r := range pending.Rewards {
+				rewards[i] = new(big.Int).SetUint64(r)
+			}
+			reward = append(reward, rewards)
+		}
+		// the pending txs past the gas limit don't make it into the block
+		gas := pending.Gas
+		if gas > head.GasLimit {
+			gas = head.GasLimit
+		}
+		gasUsed = append(gasUsed, float64(gas)/float64(head.GasLimit))
+		nextBaseFee := new(big.Int)
+		if cc.IsLondon(head.Number.Uint64() + 2) {
+			next := &types.Header{Number: new(big.Int).Add(head.Number, libcommon.Big1), GasLimit: head.GasLimit, GasUsed: gas, BaseFee: baseFee[len(baseFee)-1]}
+			nextBaseFee = misc.CalcBaseFee(cc, next)
+		}
+		baseFee = append(baseFee, nextBaseFee)
+	}
 	results := &feeHistoryResult{
 		OldestBlock:  (*hexutil.Big)(oldest),
 		GasUsedRatio: gasUsed,
@@ -194,6 +252,50 @@ func (api *APIImpl) FeeHistory(ctx context.Context, blockCount rpc.DecimalOrHex,
 	return results, nil
 }
 
+// txPoolSuggestedTip returns the tip suggested by the txpool, false when the txpool doesn't serve it
+func (api *APIImpl) txPoolSuggestedTip(ctx context.Context) (*big.Int, bool, error) {
+	ext, ok := txpool2.ExtOf(api.txPool)
+	if !ok {
+		return nil, false, nil
+	}
+	reply, err := ext.SuggestedTip(ctx, &emptypb.Empty{})
+	if err != nil {
+		if txpool2.IsExtUnsupported(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return new(big.Int).SetUint64(reply.Value), true, nil
+}
+
+// txPoolPendingTips returns the fee history of the pending block of the txpool, for eth_feeHistory up to the pending
+// block, nil when the txpool doesn't serve it
+func (api *APIImpl) txPoolPendingTips(ctx context.Context, rewardPercentiles []float64) (*txpool2.BlockTips, error) {
+	ext, ok := txpool2.ExtOf(api.txPool)
+	if !ok {
+		return nil, nil
+	}
+	percentiles, err := json.Marshal(rewardPercentiles)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := ext.FeeHistory(ctx, wrapperspb.Bytes(percentiles))
+	if err != nil {
+		if txpool2.IsExtUnsupported(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var history []txpool2.BlockTips
+	if err := json.Unmarshal(reply.Value, &history); err != nil {
+		return nil, err
+	}
+	if len(history) == 0 || !history[len(history)-1].Pending {
+		return nil, nil
+	}
+	return &history[len(history)-1], nil
+}
+
 type GasPriceOracleBackend struct {
 	tx      kv.Tx
 	cc      *chain.Config
@@ -5,18 +5,35 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ledgerwatch/erigon-lib/common"
 	"github.com/ledgerwatch/erigon-lib/common/hexutility"
 	txPoolProto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
+	"github.com/ledgerwatch/erigon-lib/kv"
+	"github.com/ledgerwatch/erigon-lib/txpool"
 
 	"github.com/ledgerwatch/erigon/core/types"
 	"github.com/ledgerwatch/erigon/eth/ethconfig"
 	"github.com/ledgerwatch/erigon/params"
+	"github.com/ledgerwatch/erigon/rpc"
+	"github.com/ledgerwatch/erigon/turbo/rpchelper"
 )
 
 // SendRawTransaction implements eth_sendRawTransaction. Creates new message call transaction or a contract creation for previously-signed transactions.
 func (api *APIImpl) SendRawTransaction(ctx context.Context, encodedTx hexutility.Bytes) (common.Hash, error) {
+	return api.sendRawTransaction(ctx, encodedTx, nil)
+}
+
+// SendRawTransactionConditional implements eth_sendRawTransactionConditional (the extension of Arbitrum and Optimism).
+// The transaction is only included into a block meeting the conditions: block number and timestamp ranges, and known
+// storage slots of accounts. It is rejected when the latest block doesn't meet them already, and dropped from the
+// pool when the conditions fail at block building, or can't be met anymore
+func (api *APIImpl) SendRawTransactionConditional(ctx context.Context, encodedTx hexutility.Bytes, conditions txpool.TxConditions) (common.Hash, error) {
+	return api.sendRawTransaction(ctx, encodedTx, &conditions)
+}
+
+func (api *APIImpl) sendRawTransaction(ctx context.Context, encodedTx hexutility.Bytes, conditions *txpool.TxConditions) (common.Hash, error) {
 	txn, err := types.DecodeWrappedTransaction(encodedTx)
 	if err != nil {
 		return common.Hash{}, err
@@ -53,6 +70,15 @@ func (api *APIImpl) SendRawTransaction(ctx context.Context, encodedTx hexutility
 		}
 	}
 
+	if conditions != nil {
+		if err := api.checkTxConditions(ctx, tx, conditions); err != nil {
+			return common.Hash{}, err
+		}
+		if ctx, err = txpool.WithConditions(ctx, []*txpool.TxConditions{conditions}); err != nil {
+			return common.Hash{}, err
+		}
+	}
+
 	hash := txn.Hash()
 	res, err := api.txPool.Add(ctx, &txPoolProto.AddRequest{RlpTxs: [][]byte{encodedTx}})
 	if err != nil {
@@ -66,6 +92,38 @@ func (api *APIImpl) SendRawTransaction(ctx context.Context, encodedTx hexutility
 	return txn.Hash(), nil
 }
 
+// checkTxConditions rejects the conditions which the next block can't meet, according to the latest block and state
+func (api *APIImpl) checkTxConditions(ctx context.Context, tx kv.Tx, conditions *txpool.TxConditions) error {
+	if err := conditions.Validate(); err != nil {
+		return err
+	}
+	header, err := api.headerByRPCNumber(rpc.LatestBlockNumber, tx)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return errors.New("latest header not found")
+	}
+	if err := conditions.CheckBlock(header.Number.Uint64()+1, uint64(time.Now().Unix())); err != nil && !errors.Is(err, txpool.ErrConditionsNotYet) {
+		return err
+	}
+	reader, err := rpchelper.CreateStateReader(ctx, tx, latestNumOrHash, 0, api.filters, api.stateCache, api.historyV3(tx), "")
+	if err != nil {
+		return err
+	}
+	return conditions.CheckStorage(func(addr common.Address, slot common.Hash) (common.Hash, error) {
+		acc, err := reader.ReadAccountData(addr)
+		if acc == nil || err != nil {
+			return common.Hash{}, err
+		}
+		value, err := reader.ReadAccountStorage(addr, acc.Incarnation, &slot)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return common.BytesToHash(value), nil
+	})
+}
+
 // SendTransaction implements eth_sendTransaction. Creates new message call transaction or a contract creation if the data field contains code.
 func (api *APIImpl) SendTransaction(_ context.Context, txObject interface{}) (common.Hash, error) {
 	return common.Hash{0}, fmt.Errorf(NotImplemented, "eth_sendTransaction")
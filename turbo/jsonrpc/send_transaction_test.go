@@ -9,6 +9,7 @@ import (
 
 	"github.com/holiman/uint256"
 	"github.com/ledgerwatch/erigon-lib/common"
+	txpool2 "github.com/ledgerwatch/erigon-lib/txpool"
 	"github.com/ledgerwatch/erigon-lib/txpool/txpoolcfg"
 	"github.com/ledgerwatch/erigon-lib/wrap"
 
@@ -24,9 +25,11 @@ import (
 	txpool_proto "github.com/ledgerwatch/erigon-lib/gointerfaces/txpool"
 	"github.com/ledgerwatch/erigon/core"
 	"github.com/ledgerwatch/erigon/core/types"
+	"github.com/ledgerwatch/erigon/crypto"
 	"github.com/ledgerwatch/erigon/eth/protocols/eth"
 	"github.com/ledgerwatch/erigon/params"
 	"github.com/ledgerwatch/erigon/rlp"
+	"github.com/ledgerwatch/erigon/rpc"
 	"github.com/ledgerwatch/erigon/turbo/jsonrpc"
 	"github.com/ledgerwatch/erigon/turbo/rpchelper"
 	"github.com/ledgerwatch/erigon/turbo/stages"
@@ -167,6 +170,79 @@ func TestSendRawTransactionUnprotected(t *testing.T) {
 	}
 }
 
+func TestGetBalancePending(t *testing.T) {
+	mockSentry, require := mock.MockWithTxPool(t), require.New(t)
+	logger := log.New()
+
+	oneBlockStep(mockSentry, require, t)
+
+	value, gasPrice := uint256.NewInt(1234), uint256.NewInt(10*params.GWei)
+	txn, err := types.SignTx(types.NewTransaction(0, common.Address{1}, value, params.TxGas, gasPrice, nil), *types.LatestSignerForChainID(mockSentry.ChainConfig.ChainID), mockSentry.Key)
+	require.NoError(err)
+
+	ctx, conn := rpcdaemontest.CreateTestGrpcConn(t, mockSentry)
+	txPool := txpool2.WithExt(txpool.NewTxpoolClient(conn), txpool2.NewTxpoolExtClient(conn))
+	api := jsonrpc.NewEthAPI(newBaseApiForTest(mockSentry), mockSentry.DB, nil, txPool, nil, 5000000, 100_000, false, 100_000, logger)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(txn.MarshalBinary(buf))
+	_, err = api.SendRawTransaction(ctx, buf.Bytes())
+	require.NoError(err)
+
+	sender := crypto.PubkeyToAddress(mockSentry.Key.PublicKey)
+	latest, err := api.GetBalance(ctx, sender, rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber))
+	require.NoError(err)
+	pending, err := api.GetBalance(ctx, sender, rpc.BlockNumberOrHashWithNumber(rpc.PendingBlockNumber))
+	require.NoError(err)
+
+	cost := new(uint256.Int).Mul(gasPrice, uint256.NewInt(params.TxGas))
+	cost.Add(cost, value)
+	require.Equal(new(big.Int).Sub(latest.ToInt(), cost.ToBig()), pending.ToInt())
+
+}
+
+func TestTxPoolFees(t *testing.T) {
+	mockSentry, require := mock.MockWithTxPool(t), require.New(t)
+	logger := log.New()
+
+	oneBlockStep(mockSentry, require, t)
+
+	txn, err := types.SignTx(types.NewTransaction(0, common.Address{1}, uint256.NewInt(1234), params.TxGas, uint256.NewInt(10*params.GWei), nil), *types.LatestSignerForChainID(mockSentry.ChainConfig.ChainID), mockSentry.Key)
+	require.NoError(err)
+
+	ctx, conn := rpcdaemontest.CreateTestGrpcConn(t, mockSentry)
+	txPool := txpool2.WithExt(txpool.NewTxpoolClient(conn), txpool2.NewTxpoolExtClient(conn))
+	api := jsonrpc.NewEthAPI(newBaseApiForTest(mockSentry), mockSentry.DB, nil, txPool, nil, 5000000, 100_000, false, 100_000, logger)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(txn.MarshalBinary(buf))
+	_, err = api.SendRawTransaction(ctx, buf.Bytes())
+	require.NoError(err)
+
+	tip, err := api.MaxPriorityFeePerGas(ctx)
+	require.NoError(err)
+	require.Equal(mockSentry.TxPool.SuggestedTip(), tip.ToInt().Uint64())
+
+	// the latest block, followed by the pending one of the txpool
+	poolHistory, err := mockSentry.TxPool.FeeHistory([]float64{50})
+	require.NoError(err)
+	pending := poolHistory[len(poolHistory)-1]
+	require.True(pending.Pending)
+	require.Equal(uint64(params.TxGas), pending.Gas)
+
+	history, err := api.FeeHistory(ctx, 2, rpc.PendingBlockNumber, []float64{50})
+	require.NoError(err)
+	latest, err := api.BlockNumber(ctx)
+	require.NoError(err)
+	require.Equal(uint64(latest), history.OldestBlock.ToInt().Uint64())
+	require.Len(history.Reward, 2)
+	require.Equal(pending.Rewards[0], history.Reward[1][0].ToInt().Uint64())
+	require.Len(history.BaseFee, 3)
+	require.Equal(pending.BaseFee, history.BaseFee[1].ToInt().Uint64())
+	require.Len(history.GasUsedRatio, 2)
+	require.Greater(history.GasUsedRatio[1], 0.0)
+}
+
 func transaction(nonce uint64, gaslimit uint64, key *ecdsa.PrivateKey) types.Transaction {
 	return pricedTransaction(nonce, gaslimit, u256.Num1, key)
 }